@@ -0,0 +1,146 @@
+package nramqp
+
+import (
+	"context"
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// Outcomes recorded as the AttributeRabbitMQDeliveryOutcome attribute.
+const (
+	outcomeAck     = "ack"
+	outcomeNack    = "nack"
+	outcomeRequeue = "requeue"
+	outcomeReject  = "reject"
+)
+
+// DeliveryHandler processes a delivery consumed by ConsumeWithHandler.  The
+// context contains the transaction of the delivery.  The returned error
+// determines how the delivery is settled:
+//
+//   - nil acknowledges the delivery.
+//   - An error returned by Requeue negatively acknowledges the delivery so that
+//     it is delivered again.
+//   - An error returned by Reject rejects the delivery.
+//   - Any other error negatively acknowledges the delivery without requeueing
+//     it, so that it is dead lettered if the queue has a dead letter exchange.
+type DeliveryHandler func(ctx context.Context, delivery amqp.Delivery) error
+
+type settleError struct {
+	err     error
+	reject  bool
+	requeue bool
+}
+
+func (e *settleError) Error() string { return e.err.Error() }
+func (e *settleError) Unwrap() error { return e.err }
+
+// Requeue wraps err so that a DeliveryHandler returning it negatively
+// acknowledges the delivery and requeues it.  The error is still noticed.
+func Requeue(err error) error {
+	return &settleError{err: err, requeue: true}
+}
+
+// Reject wraps err so that a DeliveryHandler returning it rejects the
+// delivery without requeueing it.  The error is still noticed.
+func Reject(err error) error {
+	return &settleError{err: err, reject: true}
+}
+
+// ConsumeWithHandler consumes the queue and calls handler for each delivery
+// until ctx is done or the deliveries channel is closed.  It returns the
+// error of the consume request, ctx.Err() if ctx is done, and nil once the
+// channel is closed.
+//
+// Each delivery is processed within a transaction which is started as by the
+// function returned by Consume, and ended when the handler returns.  An error
+// returned by the handler is noticed, and the delivery is settled as
+// described by DeliveryHandler.  The outcome and whether the delivery was
+// redelivered are recorded as the AttributeRabbitMQDeliveryOutcome and
+// AttributeRabbitMQRedelivered attributes, and the x-delivery-count header
+// set by quorum queues as AttributeRabbitMQDeliveryCount.  Deliveries are
+// consumed without automatic acknowledgement.  If app is nil, the handler is
+// called without a transaction.
+func ConsumeWithHandler(ctx context.Context, app *newrelic.Application, ch *amqp.Channel, queue, consumer string, exclusive, noLocal, noWait bool, args amqp.Table, handler DeliveryHandler) error {
+	deliveries, err := ch.ConsumeWithContext(ctx, queue, consumer, false, exclusive, noLocal, noWait, args)
+	if err != nil {
+		return err
+	}
+	return consumeDeliveries(ctx, app, queue, deliveries, handler)
+}
+
+func consumeDeliveries(ctx context.Context, app *newrelic.Application, queue string, deliveries <-chan amqp.Delivery, handler DeliveryHandler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return nil
+			}
+			handleDelivery(ctx, app, queue, delivery, handler)
+		}
+	}
+}
+
+func handleDelivery(ctx context.Context, app *newrelic.Application, queue string, delivery amqp.Delivery, handler DeliveryHandler) {
+	if app == nil {
+		settle(delivery, handler(ctx, delivery))
+		return
+	}
+	count, hasCount := deliveryCount(delivery.Headers)
+	txn := startConsumeTransaction(app, queue, delivery)
+	defer txn.End()
+
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeRabbitMQRedelivered, "", delivery.Redelivered)
+	if hasCount {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeRabbitMQDeliveryCount, "", count)
+	}
+
+	err := handler(newrelic.NewContext(ctx, txn), delivery)
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	outcome, settleErr := settle(delivery, err)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeRabbitMQDeliveryOutcome, outcome, nil)
+	if settleErr != nil {
+		txn.NoticeError(settleErr)
+	}
+}
+
+// settle acknowledges, negatively acknowledges, or rejects the delivery
+// according to the error returned by its handler.
+func settle(delivery amqp.Delivery, err error) (string, error) {
+	if err == nil {
+		return outcomeAck, delivery.Ack(false)
+	}
+	var se *settleError
+	if errors.As(err, &se) {
+		if se.reject {
+			return outcomeReject, delivery.Reject(false)
+		}
+		if se.requeue {
+			return outcomeRequeue, delivery.Nack(false, true)
+		}
+	}
+	return outcomeNack, delivery.Nack(false, false)
+}
+
+// deliveryCount returns the x-delivery-count header which quorum queues add
+// to redelivered messages.
+func deliveryCount(headers amqp.Table) (int64, bool) {
+	switch v := headers["x-delivery-count"].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
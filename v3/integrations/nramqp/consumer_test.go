@@ -0,0 +1,234 @@
+package nramqp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingAcknowledger records how deliveries are settled.
+type recordingAcknowledger struct {
+	settled []string
+	err     error
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.settled = append(a.settled, "ack")
+	return a.err
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		a.settled = append(a.settled, "requeue")
+	} else {
+		a.settled = append(a.settled, "nack")
+	}
+	return a.err
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.settled = append(a.settled, "reject")
+	return a.err
+}
+
+func deliveries(ack amqp.Acknowledger, ds ...amqp.Delivery) <-chan amqp.Delivery {
+	ch := make(chan amqp.Delivery, len(ds))
+	for _, d := range ds {
+		d.Acknowledger = ack
+		ch <- d
+	}
+	close(ch)
+	return ch
+}
+
+func TestConsumeDeliveriesOutcomes(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	testcases := []struct {
+		err     error
+		outcome string
+		errors  bool
+	}{
+		{err: nil, outcome: "ack"},
+		{err: errHandler, outcome: "nack", errors: true},
+		{err: Requeue(errHandler), outcome: "requeue", errors: true},
+		{err: Reject(errHandler), outcome: "reject", errors: true},
+	}
+	for _, tc := range testcases {
+		app := createTestApp()
+		ack := &recordingAcknowledger{}
+		var handled *newrelic.Transaction
+		err := consumeDeliveries(context.Background(), app.Application, "orders",
+			deliveries(ack, amqp.Delivery{Exchange: "exchange", RoutingKey: "key", Redelivered: true}),
+			func(ctx context.Context, d amqp.Delivery) error {
+				handled = newrelic.FromContext(ctx)
+				return tc.err
+			})
+		if err != nil {
+			t.Error(err)
+		}
+		if handled == nil {
+			t.Error("handler context does not contain a transaction")
+		}
+		if len(ack.settled) != 1 || ack.settled[0] != tc.outcome {
+			t.Errorf("expected %s, got %v", tc.outcome, ack.settled)
+		}
+
+		app.ExpectTxnEvents(t, []internal.WantEvent{{
+			Intrinsics: map[string]interface{}{
+				"name":     "OtherTransaction/Go/Message/RabbitMQ/Exchange/Named/orders",
+				"guid":     internal.MatchAnything,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+				"traceId":  internal.MatchAnything,
+				"error":    tc.errors,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageQueueName:                "orders",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingDestinationPublishName: "exchange",
+				newrelic.AttributeMessageRoutingKey:               "key",
+				newrelic.AttributeRabbitMQRedelivered:             true,
+				newrelic.AttributeRabbitMQDeliveryOutcome:         tc.outcome,
+			},
+			UserAttributes: map[string]interface{}{},
+		}})
+	}
+}
+
+func TestConsumeDeliveriesDeliveryCount(t *testing.T) {
+	app := createTestApp()
+	ack := &recordingAcknowledger{}
+	consumeDeliveries(context.Background(), app.Application, "orders",
+		deliveries(ack, amqp.Delivery{Headers: amqp.Table{"x-delivery-count": int64(3)}}),
+		func(ctx context.Context, d amqp.Delivery) error { return nil })
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/Message/RabbitMQ/Exchange/Named/orders",
+			"guid":     internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			newrelic.AttributeSpanKind:                "consumer",
+			newrelic.AttributeMessageQueueName:        "orders",
+			newrelic.AttributeMessageDestinationName:  "orders",
+			newrelic.AttributeMessageHeaders:          `{"x-delivery-count":3}`,
+			newrelic.AttributeRabbitMQRedelivered:     false,
+			newrelic.AttributeRabbitMQDeliveryCount:   int64(3),
+			newrelic.AttributeRabbitMQDeliveryOutcome: "ack",
+		},
+		UserAttributes: map[string]interface{}{},
+	}})
+}
+
+func TestConsumeDeliveriesSettleError(t *testing.T) {
+	app := createTestApp()
+	ack := &recordingAcknowledger{err: amqp.ErrClosed}
+	consumeDeliveries(context.Background(), app.Application, "orders",
+		deliveries(ack, amqp.Delivery{}),
+		func(ctx context.Context, d amqp.Delivery) error { return nil })
+
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/Message/RabbitMQ/Exchange/Named/orders",
+		Msg:     amqp.ErrClosed.Error(),
+		Klass:   "*amqp091.Error",
+	}})
+}
+
+func TestConsumeDeliveriesNilApp(t *testing.T) {
+	ack := &recordingAcknowledger{}
+	err := consumeDeliveries(context.Background(), nil, "orders",
+		deliveries(ack, amqp.Delivery{}, amqp.Delivery{}),
+		func(ctx context.Context, d amqp.Delivery) error {
+			if txn := newrelic.FromContext(ctx); txn != nil {
+				t.Error(txn)
+			}
+			return Reject(errors.New("invalid"))
+		})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(ack.settled) != 2 || ack.settled[0] != "reject" || ack.settled[1] != "reject" {
+		t.Error(ack.settled)
+	}
+}
+
+func TestConsumeDeliveriesContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := consumeDeliveries(ctx, nil, "orders", make(chan amqp.Delivery),
+		func(ctx context.Context, d amqp.Delivery) error { return nil })
+	if err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestSettleErrorUnwrap(t *testing.T) {
+	err := Requeue(context.DeadlineExceeded)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error(err)
+	}
+	if err.Error() != context.DeadlineExceeded.Error() {
+		t.Error(err.Error())
+	}
+}
+
+type testConfirmation struct {
+	acked bool
+	err   error
+}
+
+func (c testConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	return c.acked, c.err
+}
+
+func TestWaitForConfirm(t *testing.T) {
+	testcases := []struct {
+		confirmation testConfirmation
+		attribute    string
+	}{
+		{confirmation: testConfirmation{acked: true}, attribute: "ack"},
+		{confirmation: testConfirmation{acked: false}, attribute: "nack"},
+		{confirmation: testConfirmation{err: context.DeadlineExceeded}, attribute: "timeout"},
+	}
+	for _, tc := range testcases {
+		app := createTestApp()
+		txn := app.StartTransaction("publish")
+		s := createProducerSegment("exchange", "key")
+		s.StartTime = txn.StartSegmentNow()
+		acked, err := waitForConfirm(context.Background(), s, tc.confirmation)
+		if acked != tc.confirmation.acked || err != tc.confirmation.err {
+			t.Error(acked, err)
+		}
+		txn.End()
+
+		app.ExpectSpanEvents(t, []internal.WantEvent{
+			{
+				Intrinsics: map[string]interface{}{
+					"category": "generic",
+					"name":     "MessageBroker/RabbitMQ/Exchange/Produce/Named/exchange",
+					"parentId": internal.MatchAnything,
+				},
+				UserAttributes:  map[string]interface{}{ConfirmAttribute: tc.attribute},
+				AgentAttributes: map[string]interface{}{},
+			},
+			{
+				Intrinsics: map[string]interface{}{
+					"category":         "generic",
+					"name":             "OtherTransaction/Go/publish",
+					"transaction.name": "OtherTransaction/Go/publish",
+					"nr.entryPoint":    true,
+				},
+				UserAttributes:  map[string]interface{}{},
+				AgentAttributes: map[string]interface{}{},
+			},
+		})
+	}
+}
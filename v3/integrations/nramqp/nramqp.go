@@ -57,33 +57,13 @@ func GetHostAndPortFromURL(url string) (string, string) {
 // PublishedWithContext looks for a newrelic transaction in the context object, and if found, creates a message producer segment.
 // It will also inject distributed tracing headers into the message.
 func PublishWithContext(ch *amqp.Channel, ctx context.Context, exchange, key, url string, mandatory, immediate bool, msg amqp.Publishing) error {
-	host, port := GetHostAndPortFromURL(url)
 	txn := newrelic.FromContext(ctx)
 	if txn != nil {
-		// generate message broker segment
-		s := createProducerSegment(exchange, key)
-
-		// capture telemetry for AMQP producer
-		if msg.Headers != nil && len(msg.Headers) > 0 {
-			hdrStr, err := getHeadersAttributeString(msg.Headers)
-			if err != nil {
-				return err
-			}
-			integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageHeaders, hdrStr)
+		s, err := startPublishSegment(txn, exchange, key, url, &msg)
+		if err != nil {
+			return err
 		}
-		s.StartTime = txn.StartSegmentNow()
-
-		// inject DT headers into headers object
-		msg.Headers = injectDtHeaders(txn, msg.Headers)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerAddress, host)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerPort, port)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, exchange)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageRoutingKey, key)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageCorrelationID, msg.CorrelationId)
-		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageReplyTo, msg.ReplyTo)
-
-		err := ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		err = ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
 		s.End()
 		return err
 	} else {
@@ -91,6 +71,83 @@ func PublishWithContext(ch *amqp.Channel, ctx context.Context, exchange, key, ur
 	}
 }
 
+// PublishWithConfirm publishes a message in the same way as PublishWithContext on a channel in confirm mode (see
+// amqp.Channel.Confirm), and waits for the broker to confirm it.  It returns true if the broker acknowledged the
+// message and false if it negatively acknowledged it.  When the context contains a transaction, the message producer
+// segment ends once the confirmation is received, and the outcome is recorded as the ConfirmAttribute attribute of
+// the segment.  If the channel is not in confirm mode, the message is treated as acknowledged once it is published.
+func PublishWithConfirm(ch *amqp.Channel, ctx context.Context, exchange, key, url string, mandatory, immediate bool, msg amqp.Publishing) (bool, error) {
+	txn := newrelic.FromContext(ctx)
+	var s *newrelic.MessageProducerSegment
+	if txn != nil {
+		var err error
+		s, err = startPublishSegment(txn, exchange, key, url, &msg)
+		if err != nil {
+			return false, err
+		}
+	}
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil || dc == nil {
+		s.End()
+		return err == nil, err
+	}
+	return waitForConfirm(ctx, s, dc)
+}
+
+// ConfirmAttribute is the attribute of the message producer segment recorded by PublishWithConfirm.  It is "ack" if
+// the broker acknowledged the message, "nack" if it negatively acknowledged it, and "timeout" if the context was done
+// before the confirmation was received.
+const ConfirmAttribute = "messaging.rabbitmq.confirm"
+
+// confirmation is implemented by amqp.DeferredConfirmation.
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+func waitForConfirm(ctx context.Context, s *newrelic.MessageProducerSegment, dc confirmation) (bool, error) {
+	acked, err := dc.WaitContext(ctx)
+	switch {
+	case err != nil:
+		s.AddAttribute(ConfirmAttribute, "timeout")
+	case acked:
+		s.AddAttribute(ConfirmAttribute, "ack")
+	default:
+		s.AddAttribute(ConfirmAttribute, "nack")
+	}
+	s.End()
+	return acked, err
+}
+
+// startPublishSegment starts the message producer segment of a publish, and injects distributed tracing headers into
+// the message.
+func startPublishSegment(txn *newrelic.Transaction, exchange, key, url string, msg *amqp.Publishing) (*newrelic.MessageProducerSegment, error) {
+	host, port := GetHostAndPortFromURL(url)
+
+	// generate message broker segment
+	s := createProducerSegment(exchange, key)
+
+	// capture telemetry for AMQP producer
+	if msg.Headers != nil && len(msg.Headers) > 0 {
+		hdrStr, err := getHeadersAttributeString(msg.Headers)
+		if err != nil {
+			return nil, err
+		}
+		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageHeaders, hdrStr)
+	}
+	s.StartTime = txn.StartSegmentNow()
+
+	// inject DT headers into headers object
+	msg.Headers = injectDtHeaders(txn, msg.Headers)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerAddress, host)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerPort, port)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, exchange)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageRoutingKey, key)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageCorrelationID, msg.CorrelationId)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageReplyTo, msg.ReplyTo)
+	return s, nil
+}
+
 // Consume performs a consume request on the provided amqp Channel, and returns a consume function, a consumer channel, and an error.
 // The consumer function should be applied to each amqp Delivery that is read from the consume Channel, in order to collect tracing data
 // on that message. The consume function will then return a transaction for that message.
@@ -98,36 +155,40 @@ func Consume(app *newrelic.Application, ch *amqp.Channel, queue, consumer string
 	var handler func(amqp.Delivery) *newrelic.Transaction
 	if app != nil {
 		handler = func(delivery amqp.Delivery) *newrelic.Transaction {
-			namer := internal.MessageMetricKey{
-				Library:         RabbitMQLibrary,
-				DestinationType: string(newrelic.MessageExchange),
-				DestinationName: queue,
-				Consumer:        true,
-			}
-
-			txn := app.StartTransaction(namer.Name())
-
-			hdrs := toHeader(delivery.Headers)
-			txn.AcceptDistributedTraceHeaders(newrelic.TransportAMQP, hdrs)
-
-			if delivery.Headers != nil && len(delivery.Headers) > 0 {
-				hdrStr, err := getHeadersAttributeString(delivery.Headers)
-				if err == nil {
-					integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageHeaders, hdrStr, nil)
-				}
-			}
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageQueueName, queue, nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, queue, nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPublishName, delivery.Exchange, nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageRoutingKey, delivery.RoutingKey, nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageCorrelationID, delivery.CorrelationId, nil)
-			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageReplyTo, delivery.ReplyTo, nil)
-
-			return txn
+			return startConsumeTransaction(app, queue, delivery)
 		}
 	}
 
 	msgChan, err := ch.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
 	return handler, msgChan, err
 }
+
+func startConsumeTransaction(app *newrelic.Application, queue string, delivery amqp.Delivery) *newrelic.Transaction {
+	namer := internal.MessageMetricKey{
+		Library:         RabbitMQLibrary,
+		DestinationType: string(newrelic.MessageExchange),
+		DestinationName: queue,
+		Consumer:        true,
+	}
+
+	txn := app.StartTransaction(namer.Name())
+
+	hdrs := toHeader(delivery.Headers)
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportAMQP, hdrs)
+
+	if delivery.Headers != nil && len(delivery.Headers) > 0 {
+		hdrStr, err := getHeadersAttributeString(delivery.Headers)
+		if err == nil {
+			integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageHeaders, hdrStr, nil)
+		}
+	}
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageQueueName, queue, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, queue, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPublishName, delivery.Exchange, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageRoutingKey, delivery.RoutingKey, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageCorrelationID, delivery.CorrelationId, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageReplyTo, delivery.ReplyTo, nil)
+
+	return txn
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrawssdk
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const messageDestinationKey contextKey = "MessageDestination"
+
+// messageDestination describes the destination of an SNS or Kinesis
+// operation which is recorded as a message segment rather than an external
+// segment.
+type messageDestination struct {
+	library string
+	system  string
+	// name is the name of the topic or stream, or "" if it is unknown.
+	name string
+	// arn is the ARN of the topic or stream, if it is known.
+	arn string
+	// temporary is true for SNS messages published directly to an endpoint
+	// or phone number rather than to a topic.
+	temporary bool
+	consumer  bool
+}
+
+// getMessageDestination returns the destination of the operation with the
+// given input parameters, if the operation publishes or consumes messages.
+func getMessageDestination(params interface{}) (messageDestination, bool) {
+	switch params := params.(type) {
+	case *sns.PublishInput:
+		return snsDestination(aws.ToString(params.TopicArn)), true
+	case *sns.PublishBatchInput:
+		return snsDestination(aws.ToString(params.TopicArn)), true
+	case *kinesis.PutRecordInput:
+		return kinesisDestination(aws.ToString(params.StreamName), aws.ToString(params.StreamARN), false), true
+	case *kinesis.PutRecordsInput:
+		return kinesisDestination(aws.ToString(params.StreamName), aws.ToString(params.StreamARN), false), true
+	case *kinesis.GetRecordsInput:
+		// The stream of a shard iterator is only known when the ARN is
+		// provided.
+		return kinesisDestination("", aws.ToString(params.StreamARN), true), true
+	default:
+		return messageDestination{}, false
+	}
+}
+
+func snsDestination(topicARN string) messageDestination {
+	d := messageDestination{
+		library: "SNS",
+		system:  "aws_sns",
+		arn:     topicARN,
+	}
+	if topicARN == "" {
+		d.temporary = true
+	} else {
+		d.name = arnResource(topicARN)
+	}
+	return d
+}
+
+func kinesisDestination(name, streamARN string, consumer bool) messageDestination {
+	if name == "" && streamARN != "" {
+		// Example resource: stream/{stream.name}
+		name = strings.TrimPrefix(arnResource(streamARN), "stream/")
+	}
+	return messageDestination{
+		library:  "Kinesis",
+		system:   "aws_kinesis",
+		name:     name,
+		arn:      streamARN,
+		consumer: consumer,
+	}
+}
+
+// arnResource returns the resource of an ARN of the format
+// arn:{partition}:{service}:{region}:{account.id}:{resource}.
+func arnResource(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[5]
+}
+
+// arnAccountID returns the account ID of an ARN.
+func arnAccountID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// startSegment starts a newrelic.MessageProducerSegment for operations which
+// publish messages, and a segment named like the consumer metrics of message
+// transactions for operations which read messages.
+func (d messageDestination) startSegment(txn *newrelic.Transaction) endable {
+	if d.consumer {
+		name := d.name
+		if name == "" {
+			name = "Unknown"
+		}
+		s := txn.StartSegment("MessageBroker/" + d.library + "/Topic/Consume/Named/" + name)
+		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "consumer")
+		return s
+	}
+	s := &newrelic.MessageProducerSegment{
+		StartTime:            txn.StartSegmentNow(),
+		Library:              d.library,
+		DestinationType:      newrelic.MessageTopic,
+		DestinationName:      d.name,
+		DestinationTemporary: d.temporary,
+	}
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	return s
+}
+
+// addSpanAttributes adds the messaging attributes of the destination to the
+// span of the current segment.
+func (d messageDestination) addSpanAttributes(txn *newrelic.Transaction, region string) {
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageSystem, d.system)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeCloudRegion, region)
+	if d.name != "" {
+		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, d.name)
+	}
+	if accountID := arnAccountID(d.arn); accountID != "" {
+		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeCloudAccountID, accountID)
+	}
+}
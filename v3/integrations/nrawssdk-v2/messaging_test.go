@@ -0,0 +1,177 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrawssdk
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const (
+	topicARN  = "arn:aws:sns:us-west-2:123456789012:orders"
+	streamARN = "arn:aws:kinesis:us-west-2:123456789012:stream/clicks"
+)
+
+func messageSpan(name, category, kind, system, destination, operation string) internal.WantEvent {
+	agentAttributes := map[string]interface{}{
+		"aws.operation":    operation,
+		"aws.region":       awsRegion,
+		"aws.requestId":    requestID,
+		"http.statusCode":  "200",
+		"cloud.region":     awsRegion,
+		"cloud.account.id": "123456789012",
+		"messaging.system": system,
+		"span.kind":        kind,
+	}
+	if destination != "" {
+		agentAttributes["message.destination.name"] = destination
+	}
+	return internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"name":          name,
+			"sampled":       true,
+			"category":      category,
+			"priority":      internal.MatchAnything,
+			"guid":          internal.MatchAnything,
+			"transactionId": internal.MatchAnything,
+			"traceId":       internal.MatchAnything,
+			"parentId":      internal.MatchAnything,
+		},
+		UserAttributes:  map[string]interface{}{},
+		AgentAttributes: agentAttributes,
+	}
+}
+
+func TestMessagingMiddleware(t *testing.T) {
+	testcases := []struct {
+		name   string
+		call   func(ctx context.Context, cfg aws.Config)
+		metric string
+		span   internal.WantEvent
+	}{
+		{
+			name: "SNS Publish",
+			call: func(ctx context.Context, cfg aws.Config) {
+				sns.NewFromConfig(cfg).Publish(ctx, &sns.PublishInput{
+					TopicArn: aws.String(topicARN),
+					Message:  aws.String("hello"),
+				})
+			},
+			metric: "MessageBroker/SNS/Topic/Produce/Named/orders",
+			span: messageSpan("MessageBroker/SNS/Topic/Produce/Named/orders", "generic", "producer",
+				"aws_sns", "orders", "Publish"),
+		},
+		{
+			name: "SNS PublishBatch",
+			call: func(ctx context.Context, cfg aws.Config) {
+				sns.NewFromConfig(cfg).PublishBatch(ctx, &sns.PublishBatchInput{
+					TopicArn: aws.String(topicARN),
+					PublishBatchRequestEntries: []snstypes.PublishBatchRequestEntry{
+						{Id: aws.String("1"), Message: aws.String("hello")},
+					},
+				})
+			},
+			metric: "MessageBroker/SNS/Topic/Produce/Named/orders",
+			span: messageSpan("MessageBroker/SNS/Topic/Produce/Named/orders", "generic", "producer",
+				"aws_sns", "orders", "PublishBatch"),
+		},
+		{
+			name: "Kinesis PutRecord",
+			call: func(ctx context.Context, cfg aws.Config) {
+				kinesis.NewFromConfig(cfg).PutRecord(ctx, &kinesis.PutRecordInput{
+					StreamARN:    aws.String(streamARN),
+					PartitionKey: aws.String("key"),
+					Data:         []byte("hello"),
+				})
+			},
+			metric: "MessageBroker/Kinesis/Topic/Produce/Named/clicks",
+			span: messageSpan("MessageBroker/Kinesis/Topic/Produce/Named/clicks", "generic", "producer",
+				"aws_kinesis", "clicks", "PutRecord"),
+		},
+		{
+			name: "Kinesis PutRecords",
+			call: func(ctx context.Context, cfg aws.Config) {
+				kinesis.NewFromConfig(cfg).PutRecords(ctx, &kinesis.PutRecordsInput{
+					StreamARN: aws.String(streamARN),
+					Records: []kinesistypes.PutRecordsRequestEntry{
+						{PartitionKey: aws.String("key"), Data: []byte("hello")},
+					},
+				})
+			},
+			metric: "MessageBroker/Kinesis/Topic/Produce/Named/clicks",
+			span: messageSpan("MessageBroker/Kinesis/Topic/Produce/Named/clicks", "generic", "producer",
+				"aws_kinesis", "clicks", "PutRecords"),
+		},
+		{
+			name: "Kinesis GetRecords",
+			call: func(ctx context.Context, cfg aws.Config) {
+				kinesis.NewFromConfig(cfg).GetRecords(ctx, &kinesis.GetRecordsInput{
+					StreamARN:     aws.String(streamARN),
+					ShardIterator: aws.String("iterator"),
+				})
+			},
+			metric: "Custom/MessageBroker/Kinesis/Topic/Consume/Named/clicks",
+			span: messageSpan("Custom/MessageBroker/Kinesis/Topic/Consume/Named/clicks", "generic", "consumer",
+				"aws_kinesis", "clicks", "GetRecords"),
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := testApp()
+			txn := app.StartTransaction(txnName)
+			ctx := newrelic.NewContext(context.Background(), txn)
+			tc.call(ctx, newConfig(ctx, nil))
+			txn.End()
+
+			app.ExpectMetricsPresent(t, []internal.WantMetric{
+				{Name: tc.metric, Scope: "OtherTransaction/Go/" + txnName, Forced: false, Data: nil},
+			})
+			app.ExpectSpanEvents(t, []internal.WantEvent{tc.span, genericSpan})
+		})
+	}
+}
+
+func TestGetMessageDestination(t *testing.T) {
+	testcases := []struct {
+		params interface{}
+		ok     bool
+		want   messageDestination
+	}{
+		{
+			params: &sns.PublishInput{PhoneNumber: aws.String("+15555555555")},
+			ok:     true,
+			want:   messageDestination{library: "SNS", system: "aws_sns", temporary: true},
+		},
+		{
+			params: &kinesis.PutRecordInput{StreamName: aws.String("clicks")},
+			ok:     true,
+			want:   messageDestination{library: "Kinesis", system: "aws_kinesis", name: "clicks"},
+		},
+		{
+			params: &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator")},
+			ok:     true,
+			want:   messageDestination{library: "Kinesis", system: "aws_kinesis", consumer: true},
+		},
+		{
+			params: &kinesis.ListStreamsInput{},
+			ok:     false,
+		},
+	}
+	for _, tc := range testcases {
+		got, ok := getMessageDestination(tc.params)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%T: got %+v, %t", tc.params, got, ok)
+		}
+	}
+}
@@ -18,8 +18,11 @@
 // For most operations, external segments and spans are automatically created
 // for display in the New Relic UI on the External services section. For
 // DynamoDB operations, datastore segements and spans are created and will be
-// displayed on the Databases page. All operations will also be displayed on
-// transaction traces and distributed traces.
+// displayed on the Databases page. SNS Publish and PublishBatch and Kinesis
+// PutRecord and PutRecords operations are recorded as message producer
+// segments, and Kinesis GetRecords operations as consumer spans. All
+// operations will also be displayed on transaction traces and distributed
+// traces.
 //
 // To use this integration, simply apply the AppendMiddlewares fuction to the apiOptions in
 // your AWS Config object before performing any AWS operations. See
@@ -74,8 +77,11 @@ func (m nrMiddleware) deserializeMiddleware(stack *smithymiddle.Stack) error {
 		region := awsmiddle.GetRegion(ctx)
 
 		var segment endable
+		dest, isMessage := ctx.Value(messageDestinationKey).(messageDestination)
 		// Service name capitalization is different for v1 and v2.
-		if serviceName == "dynamodb" || serviceName == "DynamoDB" {
+		if isMessage {
+			segment = dest.startSegment(txn)
+		} else if serviceName == "dynamodb" || serviceName == "DynamoDB" {
 			segment = &newrelic.DatastoreSegment{
 				Product:            newrelic.DatastoreDynamoDB,
 				Collection:         "", // AWS SDK V2 doesn't expose TableName
@@ -116,6 +122,9 @@ func (m nrMiddleware) deserializeMiddleware(stack *smithymiddle.Stack) error {
 
 				}
 			}
+			if isMessage {
+				dest.addSpanAttributes(txn, region)
+			}
 			// Set additional span attributes
 			integrationsupport.AddAgentSpanAttribute(txn,
 				newrelic.AttributeResponseCode, strconv.Itoa(response.StatusCode))
@@ -140,6 +149,9 @@ func (m nrMiddleware) serializeMiddleware(stack *middleware.Stack) error {
 		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
 		out middleware.InitializeOutput, metadata middleware.Metadata, err error) {
 
+		if dest, ok := getMessageDestination(in.Parameters); ok {
+			ctx = context.WithValue(ctx, messageDestinationKey, dest)
+		}
 		serviceName := awsmiddle.GetServiceID(ctx)
 		if serviceName == "sqs" || serviceName == "SQS" {
 			QueueURL := ""
@@ -0,0 +1,10 @@
+module github.com/newrelic/go-agent/v3/integrations/nrerrgroup
+
+go 1.22
+
+require (
+	github.com/newrelic/go-agent/v3 v3.38.0
+	golang.org/x/sync v0.8.0
+)
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,121 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrerrgroup instruments golang.org/x/sync/errgroup.
+//
+// Use this package in place of errgroup to run each function of a group in
+// its own goroutine reference of the transaction in the group's context.
+// Each function is timed by a segment, and the errors functions return are
+// noticed:
+//
+//	g, ctx := nrerrgroup.WithContext(newrelic.NewContext(ctx, txn))
+//	for _, url := range urls {
+//		url := url
+//		g.Go("fetch", func(ctx context.Context) error {
+//			return fetch(ctx, url)
+//		})
+//	}
+//	err := g.Wait()
+//
+// Functions receive a context containing the Transaction returned by
+// Transaction.NewGoroutine, so Transaction.NewGoroutine does not need to be
+// called by hand.
+package nrerrgroup
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func init() { internal.TrackUsage("integration", "errgroup") }
+
+// Group is an errgroup.Group whose functions are instrumented with
+// newrelic.NewGoroutineTask.
+type Group struct {
+	group *errgroup.Group
+	ctx   context.Context
+	// sem limits the number of active goroutines.  The limit is kept here
+	// rather than by the errgroup.Group so that TryGo can check it before
+	// creating the goroutine's transaction reference.
+	sem chan struct{}
+}
+
+// WithContext returns a new Group and an associated context derived from
+// ctx, as errgroup.WithContext does.  The functions of the group are given
+// the transaction in ctx.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	g, ctx := errgroup.WithContext(ctx)
+	return &Group{group: g, ctx: ctx}, ctx
+}
+
+// New returns a Group whose functions are given the transaction in ctx.
+// Unlike WithContext, the context is not canceled when a function returns
+// an error.
+func New(ctx context.Context) *Group {
+	return &Group{group: &errgroup.Group{}, ctx: ctx}
+}
+
+// Go calls fn in a new goroutine, as errgroup.Group.Go does.  fn is timed by
+// a segment with the given name, or the name of fn if name is empty, and an
+// error returned by fn is noticed.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(newrelic.NewGoroutineTask(g.ctx, name, fn))
+}
+
+// TryGo calls fn in a new goroutine only if the number of active goroutines
+// is below the limit set with SetLimit, as errgroup.Group.TryGo does.  The
+// return value reports whether fn was started.
+func (g *Group) TryGo(name string, fn func(ctx context.Context) error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(newrelic.NewGoroutineTask(g.ctx, name, fn))
+	return true
+}
+
+// start runs the task in a new goroutine of the group, once the goroutine
+// has been counted against the limit.
+func (g *Group) start(task func() error) {
+	g.group.Go(func() error {
+		defer g.done()
+		return task()
+	})
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+}
+
+// SetLimit limits the number of active goroutines in the group, as
+// errgroup.Group.SetLimit does.  A negative value indicates no limit.  The
+// limit must not be modified while any goroutines in the group are active.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("nrerrgroup: modify limit while %v goroutines in the group are still active", len(g.sem)))
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Wait blocks until all functions have returned and returns the first
+// non-nil error returned by them.
+func (g *Group) Wait() error {
+	return g.group.Wait()
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrerrgroup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func TestGroup(t *testing.T) {
+	app := integrationsupport.NewBasicTestApp()
+	txn := app.StartTransaction("job")
+	g, ctx := WithContext(newrelic.NewContext(context.Background(), txn))
+	errFetch := errors.New("fetch failed")
+	for i := 0; i < 3; i++ {
+		i := i
+		g.Go("fetch", func(ctx context.Context) error {
+			if newrelic.FromContext(ctx) == nil {
+				t.Error("function context does not contain a transaction")
+			}
+			if i == 2 {
+				return errFetch
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != errFetch {
+		t.Error(err)
+	}
+	if ctx.Err() == nil {
+		t.Error("group context not canceled")
+	}
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/fetch", Scope: "OtherTransaction/Go/job", Forced: false, Data: []float64{3}},
+	})
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/job",
+		Msg:     "fetch failed",
+		Klass:   "*errors.errorString",
+	}})
+}
+
+func TestGroupTryGo(t *testing.T) {
+	app := integrationsupport.NewBasicTestApp()
+	txn := app.StartTransaction("job")
+	g := New(newrelic.NewContext(context.Background(), txn))
+	g.SetLimit(1)
+	release := make(chan struct{})
+	if !g.TryGo("first", func(ctx context.Context) error {
+		<-release
+		return nil
+	}) {
+		t.Fatal("first function not started")
+	}
+	if g.TryGo("second", func(ctx context.Context) error { return nil }) {
+		t.Error("second function started over the limit")
+	}
+	close(release)
+	if err := g.Wait(); err != nil {
+		t.Error(err)
+	}
+	if !g.TryGo("third", func(ctx context.Context) error { return nil }) {
+		t.Error("third function not started after the first returned")
+	}
+	if err := g.Wait(); err != nil {
+		t.Error(err)
+	}
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/first", Scope: "OtherTransaction/Go/job", Forced: false, Data: []float64{1}},
+		{Name: "Custom/third", Scope: "OtherTransaction/Go/job", Forced: false, Data: []float64{1}},
+	})
+}
+
+func TestGroupNoTransaction(t *testing.T) {
+	g := New(context.Background())
+	g.Go("", func(ctx context.Context) error {
+		if newrelic.FromContext(ctx) != nil {
+			t.Error(ctx)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		t.Error(err)
+	}
+}
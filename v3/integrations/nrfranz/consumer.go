@@ -0,0 +1,121 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrfranz
+
+import (
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// EachRecord calls fn for each record in fetches with a transaction started
+// for the record, and ends the transaction once fn returns.  The transaction
+// accepts the distributed trace headers of the record, and records its
+// partition, offset, and the consumer lag of its partition.  The consumer
+// group is recorded when cl is non-nil.  If app is nil, fn is called with a
+// nil transaction.
+func EachRecord(app *newrelic.Application, cl *kgo.Client, fetches kgo.Fetches, fn func(*newrelic.Transaction, *kgo.Record)) {
+	group := consumerGroup(cl)
+	fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+		for _, r := range p.Records {
+			txn := startRecordTransaction(app, group, r, consumerLag(p.HighWatermark, r.Offset))
+			fn(txn, r)
+			txn.End()
+		}
+	})
+}
+
+// StartRecordTransaction starts a transaction for a single record.  It is
+// like EachRecord, but the consumer lag is not recorded since the high
+// watermark of the partition is not known.  The caller must end the returned
+// transaction.  If app is nil, nil is returned.
+func StartRecordTransaction(app *newrelic.Application, cl *kgo.Client, r *kgo.Record) *newrelic.Transaction {
+	return startRecordTransaction(app, consumerGroup(cl), r, -1)
+}
+
+// StartBatchTransaction starts a single transaction for the records fetched
+// from a partition, for example within kgo.Fetches.EachPartition.  The
+// transaction accepts the distributed trace headers of the first record which
+// has them, and records the number of records, the partition, the offset of
+// the first record, and the consumer lag of the partition after the last
+// record.  The consumer group is recorded when cl is non-nil.  The caller
+// must end the returned transaction.  If app is nil, nil is returned.
+func StartBatchTransaction(app *newrelic.Application, cl *kgo.Client, p kgo.FetchTopicPartition) *newrelic.Transaction {
+	txn := startConsumeTransaction(app, consumerGroup(cl), p.Topic)
+	if nil == txn {
+		return nil
+	}
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingBatchMessageCount, "", len(p.Records))
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPartitionID, "", p.Partition)
+	if len(p.Records) == 0 {
+		return txn
+	}
+	for _, r := range p.Records {
+		hdrs := toHeader(r.Headers)
+		if hdrs.Get(newrelic.DistributedTraceW3CTraceParentHeader) != "" ||
+			hdrs.Get(newrelic.DistributedTraceNewRelicHeader) != "" {
+			txn.AcceptDistributedTraceHeaders(newrelic.TransportKafka, hdrs)
+			break
+		}
+	}
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaMessageOffset, "", p.Records[0].Offset)
+	if lag := consumerLag(p.HighWatermark, p.Records[len(p.Records)-1].Offset); lag >= 0 {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerLag, "", lag)
+	}
+	return txn
+}
+
+func startRecordTransaction(app *newrelic.Application, group string, r *kgo.Record, lag int64) *newrelic.Transaction {
+	txn := startConsumeTransaction(app, group, r.Topic)
+	if nil == txn {
+		return nil
+	}
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportKafka, toHeader(r.Headers))
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPartitionID, "", r.Partition)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaMessageOffset, "", r.Offset)
+	if lag >= 0 {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerLag, "", lag)
+	}
+	return txn
+}
+
+func startConsumeTransaction(app *newrelic.Application, group string, topic string) *newrelic.Transaction {
+	if nil == app {
+		return nil
+	}
+	namer := internal.MessageMetricKey{
+		Library:         KafkaLibrary,
+		DestinationType: string(newrelic.MessageTopic),
+		DestinationName: topic,
+		Consumer:        true,
+	}
+	txn := app.StartTransaction(namer.Name())
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, topic, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerGroup, group, nil)
+	return txn
+}
+
+// consumerGroup returns the consumer group the client was configured with.
+func consumerGroup(cl *kgo.Client) string {
+	if nil == cl {
+		return ""
+	}
+	group, _ := cl.OptValue(kgo.ConsumerGroup).(string)
+	return group
+}
+
+// consumerLag returns the number of records in the partition after the
+// record at offset, or -1 if the high watermark is not known.
+func consumerLag(highWatermark int64, offset int64) int64 {
+	if highWatermark <= 0 {
+		return -1
+	}
+	if lag := highWatermark - offset - 1; lag > 0 {
+		return lag
+	}
+	return 0
+}
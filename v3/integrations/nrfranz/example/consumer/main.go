@@ -0,0 +1,65 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrfranz"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func process(ctx context.Context, r *kgo.Record) {
+	defer newrelic.FromContext(ctx).StartSegment("process").End()
+	fmt.Printf("received %s from partition %d at offset %d\n", r.Value, r.Partition, r.Offset)
+}
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka Consumer App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers("localhost:9092"),
+		kgo.ConsumerGroup("order-processor"),
+		kgo.ConsumeTopics("orders"),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for ctx.Err() == nil {
+		fetches := cl.PollFetches(ctx)
+		// One transaction is created for each record.  Use
+		// nrfranz.StartBatchTransaction within fetches.EachPartition to
+		// create one transaction for the records of each partition
+		// instead.
+		nrfranz.EachRecord(app, cl, fetches, func(txn *newrelic.Transaction, r *kgo.Record) {
+			process(newrelic.NewContext(ctx, txn), r)
+		})
+	}
+
+	// Shut down the application to flush data to New Relic.
+	app.Shutdown(10 * time.Second)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrfranz"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka Producer App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers("localhost:9092"),
+		kgo.WithHooks(nrfranz.ProducerHook{}),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer cl.Close()
+
+	txn := app.StartTransaction("kafkaproducer")
+	ctx := newrelic.NewContext(context.Background(), txn)
+
+	// Records produced synchronously.
+	results := cl.ProduceSync(ctx,
+		&kgo.Record{Topic: "orders", Key: []byte("order-1"), Value: []byte("first")},
+		&kgo.Record{Topic: "orders", Key: []byte("order-2"), Value: []byte("second")},
+	)
+	if err := results.FirstErr(); nil != err {
+		txn.NoticeError(err)
+	}
+
+	// Records produced asynchronously.
+	done := make(chan struct{})
+	cl.Produce(ctx, &kgo.Record{Topic: "orders", Value: []byte("third")}, func(_ *kgo.Record, err error) {
+		if nil != err {
+			fmt.Println(err)
+		}
+		close(done)
+	})
+	<-done
+	txn.End()
+
+	// Shut down the application to flush data to New Relic.
+	app.Shutdown(10 * time.Second)
+}
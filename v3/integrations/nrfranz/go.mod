@@ -0,0 +1,10 @@
+module github.com/newrelic/go-agent/v3/integrations/nrfranz
+
+go 1.22
+
+require (
+	github.com/newrelic/go-agent/v3 v3.38.0
+	github.com/twmb/franz-go v1.17.1
+)
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,76 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrfranz instruments https://github.com/twmb/franz-go.
+//
+// # Kafka producers
+//
+// Add ProducerHook to the client using kgo.WithHooks.  When the context of a
+// produced record contains a transaction, the produce is recorded as a
+// newrelic.MessageProducerSegment and distributed trace headers are added to
+// the headers of the record.  Both Client.Produce and Client.ProduceSync are
+// instrumented:
+//
+//	cl, err := kgo.NewClient(
+//		kgo.SeedBrokers("localhost:9092"),
+//		kgo.WithHooks(nrfranz.ProducerHook{}),
+//	)
+//	ctx := newrelic.NewContext(context.Background(), txn)
+//	results := cl.ProduceSync(ctx, &kgo.Record{Topic: "orders", Value: []byte("hello")})
+//
+// # Kafka consumers
+//
+// EachRecord starts a transaction for each fetched record, and
+// StartBatchTransaction starts one transaction for the records fetched from
+// a partition.  Both accept the distributed trace headers added by the
+// producer and record the partition, offset, and consumer lag of the records
+// as attributes:
+//
+//	fetches := cl.PollFetches(ctx)
+//	nrfranz.EachRecord(app, cl, fetches, func(txn *newrelic.Transaction, r *kgo.Record) {
+//		process(newrelic.NewContext(ctx, txn), r)
+//	})
+//
+// Full producer and consumer examples:
+// https://github.com/newrelic/go-agent/tree/master/v3/integrations/nrfranz/example
+package nrfranz
+
+import (
+	"net/http"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// KafkaLibrary is the library name used in message metrics.
+const KafkaLibrary = "Kafka"
+
+func init() { internal.TrackUsage("integration", "messagebroker", "franz-go") }
+
+// setHeaders returns headers in which the headers in hdrs replace any
+// existing headers with the same key.
+func setHeaders(headers []kgo.RecordHeader, hdrs http.Header) []kgo.RecordHeader {
+	out := make([]kgo.RecordHeader, 0, len(headers)+len(hdrs))
+	for _, h := range headers {
+		if _, ok := hdrs[h.Key]; !ok {
+			out = append(out, h)
+		}
+	}
+	for key, vals := range hdrs {
+		for _, val := range vals {
+			out = append(out, kgo.RecordHeader{Key: key, Value: []byte(val)})
+		}
+	}
+	return out
+}
+
+// toHeader converts record headers into an http.Header so that they may be
+// passed to Transaction.AcceptDistributedTraceHeaders.
+func toHeader(headers []kgo.RecordHeader) http.Header {
+	hdrs := make(http.Header, len(headers))
+	for _, h := range headers {
+		hdrs.Add(h.Key, string(h.Value))
+	}
+	return hdrs
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrfranz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var replyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func testApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(replyFn, integrationsupport.DTEnabledCfgFn,
+		newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+var (
+	consumerTxnIntrinsics = map[string]interface{}{
+		"name":     "OtherTransaction/Go/Message/Kafka/Topic/Named/orders",
+		"guid":     internal.MatchAnything,
+		"traceId":  internal.MatchAnything,
+		"priority": internal.MatchAnything,
+		"sampled":  internal.MatchAnything,
+	}
+	linkedConsumerTxnIntrinsics = map[string]interface{}{
+		"name":                     "OtherTransaction/Go/Message/Kafka/Topic/Named/orders",
+		"guid":                     internal.MatchAnything,
+		"traceId":                  internal.MatchAnything,
+		"priority":                 internal.MatchAnything,
+		"sampled":                  internal.MatchAnything,
+		"parentId":                 internal.MatchAnything,
+		"parentSpanId":             internal.MatchAnything,
+		"parent.type":              "App",
+		"parent.app":               internal.MatchAnything,
+		"parent.account":           internal.MatchAnything,
+		"parent.transportType":     "Kafka",
+		"parent.transportDuration": internal.MatchAnything,
+	}
+	producerTxnEvent = internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/produce",
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+		},
+	}
+)
+
+func headerCount(headers []kgo.RecordHeader, key string) int {
+	var count int
+	for _, h := range headers {
+		if h.Key == key {
+			count++
+		}
+	}
+	return count
+}
+
+// produce runs the producer hooks for the record as the client would.
+func produce(r *kgo.Record, partition int32, offset int64, err error) {
+	ProducerHook{}.OnProduceRecordBuffered(r)
+	r.Partition = partition
+	r.Offset = offset
+	ProducerHook{}.OnProduceRecordUnbuffered(r, err)
+}
+
+func producedRecord(app integrationsupport.ExpectApp, r *kgo.Record) *kgo.Record {
+	txn := app.StartTransaction("produce")
+	r.Context = newrelic.NewContext(context.Background(), txn)
+	produce(r, r.Partition, r.Offset, nil)
+	txn.End()
+	return r
+}
+
+func TestProducerHook(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("produce")
+	r := &kgo.Record{
+		Topic:   "orders",
+		Headers: []kgo.RecordHeader{{Key: "custom", Value: []byte("value")}, {Key: "Traceparent", Value: []byte("stale")}},
+		Context: newrelic.NewContext(context.Background(), txn),
+	}
+	produce(r, 2, 7, nil)
+	txn.End()
+
+	if headerCount(r.Headers, "custom") != 1 ||
+		headerCount(r.Headers, newrelic.DistributedTraceW3CTraceParentHeader) != 1 ||
+		headerCount(r.Headers, newrelic.DistributedTraceNewRelicHeader) != 1 {
+		t.Error(r.Headers)
+	}
+	for _, h := range r.Headers {
+		if string(h.Value) == "stale" {
+			t.Error(r.Headers)
+		}
+	}
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/orders", Scope: "OtherTransaction/Go/produce"},
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/orders", Scope: ""},
+	})
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "MessageBroker/Kafka/Topic/Produce/Named/orders",
+				"category": "generic",
+				"parentId": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "producer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingDestinationPartitionID: "2",
+				newrelic.AttributeKafkaMessageOffset:              "7",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/produce",
+				"transaction.name": "OtherTransaction/Go/produce",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestProducerHookError(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("produce")
+	r := &kgo.Record{Topic: "orders", Context: newrelic.NewContext(context.Background(), txn)}
+	produce(r, 2, 7, errors.New("oops"))
+	txn.End()
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "MessageBroker/Kafka/Topic/Produce/Named/orders",
+				"category": "generic",
+				"parentId": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:               "producer",
+				newrelic.AttributeMessageDestinationName: "orders",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/produce",
+				"transaction.name": "OtherTransaction/Go/produce",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestProducerHookWithoutTransaction(t *testing.T) {
+	r := &kgo.Record{Topic: "orders"}
+	produce(r, 0, 0, nil)
+	r.Context = context.Background()
+	produce(r, 0, 0, nil)
+	if len(r.Headers) != 0 {
+		t.Error(r.Headers)
+	}
+}
+
+func TestEachRecord(t *testing.T) {
+	app := testApp()
+	linked := producedRecord(app, &kgo.Record{Topic: "orders", Partition: 1, Offset: 18})
+	fetches := kgo.Fetches{{Topics: []kgo.FetchTopic{{
+		Topic: "orders",
+		Partitions: []kgo.FetchPartition{{
+			Partition:     1,
+			HighWatermark: 20,
+			Records:       []*kgo.Record{linked, {Topic: "orders", Partition: 1, Offset: 19}},
+		}},
+	}}}}
+
+	var seen []int64
+	EachRecord(app.Application, nil, fetches, func(txn *newrelic.Transaction, r *kgo.Record) {
+		if nil == txn {
+			t.Error("missing transaction")
+		}
+		seen = append(seen, r.Offset)
+	})
+	if len(seen) != 2 || seen[0] != 18 || seen[1] != 19 {
+		t.Error(seen)
+	}
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		producerTxnEvent,
+		{
+			Intrinsics: linkedConsumerTxnIntrinsics,
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingDestinationPartitionID: 1,
+				newrelic.AttributeKafkaMessageOffset:              18,
+				newrelic.AttributeKafkaConsumerLag:                1,
+			},
+		},
+		{
+			Intrinsics: consumerTxnIntrinsics,
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingDestinationPartitionID: 1,
+				newrelic.AttributeKafkaMessageOffset:              19,
+				newrelic.AttributeKafkaConsumerLag:                0,
+			},
+		},
+	})
+}
+
+func TestStartRecordTransaction(t *testing.T) {
+	app := testApp()
+	cl, err := kgo.NewClient(kgo.ConsumerGroup("order-processor"), kgo.ConsumeTopics("orders"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	txn := StartRecordTransaction(app.Application, cl, &kgo.Record{Topic: "orders", Partition: 4, Offset: 2})
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: consumerTxnIntrinsics,
+		AgentAttributes: map[string]interface{}{
+			newrelic.AttributeSpanKind:                        "consumer",
+			newrelic.AttributeMessageDestinationName:          "orders",
+			newrelic.AttributeKafkaConsumerGroup:              "order-processor",
+			newrelic.AttributeMessagingDestinationPartitionID: 4,
+			newrelic.AttributeKafkaMessageOffset:              2,
+		},
+	}})
+}
+
+func TestStartBatchTransaction(t *testing.T) {
+	app := testApp()
+	linked := producedRecord(app, &kgo.Record{Topic: "orders", Partition: 3, Offset: 11})
+	txn := StartBatchTransaction(app.Application, nil, kgo.FetchTopicPartition{
+		Topic: "orders",
+		FetchPartition: kgo.FetchPartition{
+			Partition:     3,
+			HighWatermark: 25,
+			Records:       []*kgo.Record{{Topic: "orders", Partition: 3, Offset: 10}, linked, {Topic: "orders", Partition: 3, Offset: 12}},
+		},
+	})
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		producerTxnEvent,
+		{
+			Intrinsics: linkedConsumerTxnIntrinsics,
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingBatchMessageCount:      3,
+				newrelic.AttributeMessagingDestinationPartitionID: 3,
+				newrelic.AttributeKafkaMessageOffset:              10,
+				newrelic.AttributeKafkaConsumerLag:                12,
+			},
+		},
+	})
+}
+
+func TestNilApplication(t *testing.T) {
+	r := &kgo.Record{Topic: "orders"}
+	if txn := StartRecordTransaction(nil, nil, r); nil != txn {
+		t.Error(txn)
+	}
+	if txn := StartBatchTransaction(nil, nil, kgo.FetchTopicPartition{}); nil != txn {
+		t.Error(txn)
+	}
+	fetches := kgo.Fetches{{Topics: []kgo.FetchTopic{{Topic: "orders", Partitions: []kgo.FetchPartition{{Records: []*kgo.Record{r}}}}}}}
+	called := false
+	EachRecord(nil, nil, fetches, func(txn *newrelic.Transaction, _ *kgo.Record) {
+		called = true
+		if nil != txn {
+			t.Error(txn)
+		}
+	})
+	if !called {
+		t.Error("function not called")
+	}
+}
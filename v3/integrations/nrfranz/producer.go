@@ -0,0 +1,83 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrfranz
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// ProducerHook instruments the records produced by a kgo.Client.  Add it to
+// the client using kgo.WithHooks.
+//
+// When the context of a record contains a transaction, a
+// newrelic.MessageProducerSegment is started when the record is buffered and
+// ended when the broker acknowledges it, and distributed trace headers are
+// added to the headers of the record.  The partition and offset of records
+// which are produced successfully are added to the segment.
+type ProducerHook struct{}
+
+var (
+	_ kgo.HookProduceRecordBuffered   = ProducerHook{}
+	_ kgo.HookProduceRecordUnbuffered = ProducerHook{}
+)
+
+type producerSegmentKey struct{}
+
+// producerSegment is stored in the context of a record between the two
+// hooks.
+type producerSegment struct {
+	txn     *newrelic.Transaction
+	segment *newrelic.MessageProducerSegment
+}
+
+// OnProduceRecordBuffered implements kgo.HookProduceRecordBuffered.
+func (ProducerHook) OnProduceRecordBuffered(r *kgo.Record) {
+	if nil == r.Context {
+		return
+	}
+	txn := newrelic.FromContext(r.Context)
+	if nil == txn {
+		return
+	}
+	// The record is acknowledged on a goroutine owned by the client, so the
+	// segment is given its own goroutine within the transaction.
+	txn = txn.NewGoroutine()
+	s := &newrelic.MessageProducerSegment{
+		StartTime:       txn.StartSegmentNow(),
+		Library:         KafkaLibrary,
+		DestinationType: newrelic.MessageTopic,
+		DestinationName: r.Topic,
+	}
+
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	r.Headers = setHeaders(r.Headers, hdrs)
+
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, r.Topic)
+	r.Context = context.WithValue(r.Context, producerSegmentKey{}, &producerSegment{txn: txn, segment: s})
+}
+
+// OnProduceRecordUnbuffered implements kgo.HookProduceRecordUnbuffered.
+func (ProducerHook) OnProduceRecordUnbuffered(r *kgo.Record, err error) {
+	if nil == r.Context {
+		return
+	}
+	ps, ok := r.Context.Value(producerSegmentKey{}).(*producerSegment)
+	if !ok {
+		return
+	}
+	if nil == err {
+		integrationsupport.AddAgentSpanAttribute(ps.txn, newrelic.AttributeMessagingDestinationPartitionID, strconv.Itoa(int(r.Partition)))
+		integrationsupport.AddAgentSpanAttribute(ps.txn, newrelic.AttributeKafkaMessageOffset, strconv.FormatInt(r.Offset, 10))
+	}
+	ps.segment.End()
+}
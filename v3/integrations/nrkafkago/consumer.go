@@ -0,0 +1,115 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrkafkago
+
+import (
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// StartMessageTransaction starts a transaction for a message read from r
+// using FetchMessage or ReadMessage.  The transaction accepts the distributed
+// trace headers of the message, and records its partition, offset, and the
+// consumer lag of its partition.  The consumer group is recorded when r is
+// non-nil.  The caller must end the returned transaction.  If app is nil, nil
+// is returned.
+func StartMessageTransaction(app *newrelic.Application, r *kafka.Reader, msg kafka.Message) *newrelic.Transaction {
+	txn := startConsumeTransaction(app, r, msg.Topic)
+	if nil == txn {
+		return nil
+	}
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportKafka, toHeader(msg.Headers))
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPartitionID, "", msg.Partition)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaMessageOffset, "", msg.Offset)
+	if lag, ok := consumerLag(msg); ok {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerLag, "", lag)
+	}
+	return txn
+}
+
+// StartBatchTransaction starts a single transaction for a batch of messages
+// read from r.  The transaction is named for the topic of the first message
+// and accepts the distributed trace headers of the first message which has
+// them.  The number of messages and the largest consumer lag of the batch are
+// recorded, as are the partition and the offset of the first message when
+// all of the messages come from the same partition.  The consumer group is
+// recorded when r is non-nil.  The caller must end the returned transaction.
+// If app is nil, nil is returned.
+func StartBatchTransaction(app *newrelic.Application, r *kafka.Reader, msgs []kafka.Message) *newrelic.Transaction {
+	var topic string
+	if len(msgs) > 0 {
+		topic = msgs[0].Topic
+	}
+	txn := startConsumeTransaction(app, r, topic)
+	if nil == txn {
+		return nil
+	}
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingBatchMessageCount, "", len(msgs))
+	if len(msgs) == 0 {
+		return txn
+	}
+
+	samePartition := true
+	var maxLag int64 = -1
+	accepted := false
+	for _, msg := range msgs {
+		if msg.Partition != msgs[0].Partition || msg.Topic != msgs[0].Topic {
+			samePartition = false
+		}
+		if lag, ok := consumerLag(msg); ok && lag > maxLag {
+			maxLag = lag
+		}
+		if !accepted && len(msg.Headers) > 0 {
+			hdrs := toHeader(msg.Headers)
+			if hdrs.Get(newrelic.DistributedTraceW3CTraceParentHeader) != "" ||
+				hdrs.Get(newrelic.DistributedTraceNewRelicHeader) != "" {
+				txn.AcceptDistributedTraceHeaders(newrelic.TransportKafka, hdrs)
+				accepted = true
+			}
+		}
+	}
+	if samePartition {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPartitionID, "", msgs[0].Partition)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaMessageOffset, "", msgs[0].Offset)
+	}
+	if maxLag >= 0 {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerLag, "", maxLag)
+	}
+	return txn
+}
+
+func startConsumeTransaction(app *newrelic.Application, r *kafka.Reader, topic string) *newrelic.Transaction {
+	if nil == app {
+		return nil
+	}
+	namer := internal.MessageMetricKey{
+		Library:         KafkaLibrary,
+		DestinationType: string(newrelic.MessageTopic),
+		DestinationName: topic,
+		Consumer:        true,
+	}
+	txn := app.StartTransaction(namer.Name())
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, topic, nil)
+	if nil != r {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerGroup, r.Config().GroupID, nil)
+	}
+	return txn
+}
+
+// consumerLag returns the number of messages in the partition after msg.  The
+// high water mark is only known for messages read from a kafka.Reader.
+func consumerLag(msg kafka.Message) (int64, bool) {
+	if msg.HighWaterMark <= 0 {
+		return 0, false
+	}
+	lag := msg.HighWaterMark - msg.Offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, true
+}
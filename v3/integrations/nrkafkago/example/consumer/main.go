@@ -0,0 +1,64 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrkafkago"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func process(ctx context.Context, msg kafka.Message) {
+	defer newrelic.FromContext(ctx).StartSegment("process").End()
+	fmt.Printf("received %s from partition %d at offset %d\n", msg.Value, msg.Partition, msg.Offset)
+}
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka Consumer App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: []string{"localhost:9092"},
+		GroupID: "order-processor",
+		Topic:   "orders",
+	})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for {
+		msg, err := r.FetchMessage(ctx)
+		if nil != err {
+			break
+		}
+		// One transaction is created for each message.
+		txn := nrkafkago.StartMessageTransaction(app, r, msg)
+		process(newrelic.NewContext(ctx, txn), msg)
+		if err := r.CommitMessages(ctx, msg); nil != err {
+			txn.NoticeError(err)
+		}
+		txn.End()
+	}
+
+	// Shut down the application to flush data to New Relic.
+	app.Shutdown(10 * time.Second)
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrkafkago"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka Producer App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	w := &kafka.Writer{
+		Addr:  kafka.TCP("localhost:9092"),
+		Topic: "orders",
+	}
+	defer w.Close()
+
+	txn := app.StartTransaction("kafkaproducer")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	err = nrkafkago.WriteMessages(ctx, w,
+		kafka.Message{Key: []byte("order-1"), Value: []byte("first")},
+		kafka.Message{Key: []byte("order-2"), Value: []byte("second")},
+	)
+	if nil != err {
+		txn.NoticeError(err)
+	}
+	txn.End()
+
+	// Shut down the application to flush data to New Relic.
+	app.Shutdown(10 * time.Second)
+}
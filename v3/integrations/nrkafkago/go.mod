@@ -0,0 +1,10 @@
+module github.com/newrelic/go-agent/v3/integrations/nrkafkago
+
+go 1.22
+
+require (
+	github.com/newrelic/go-agent/v3 v3.38.0
+	github.com/segmentio/kafka-go v0.4.47
+)
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,83 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrkafkago instruments https://github.com/segmentio/kafka-go.
+//
+// # Kafka producers
+//
+// Use WriteMessages in place of kafka.Writer.WriteMessages.  When the context
+// contains a transaction, the write is recorded as a
+// newrelic.MessageProducerSegment and distributed trace headers are added to
+// the headers of each message:
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("localhost:9092"), Topic: "orders"}
+//	ctx := newrelic.NewContext(context.Background(), txn)
+//	err := nrkafkago.WriteMessages(ctx, w, kafka.Message{Value: []byte("hello")})
+//
+// # Kafka consumers
+//
+// StartMessageTransaction starts a transaction for a single message, and
+// StartBatchTransaction starts one transaction for a batch of messages.  Both
+// accept the distributed trace headers added by the producer and record the
+// partition, offset, and consumer lag of the messages as attributes.  The
+// caller must end the transaction once the message has been processed:
+//
+//	r := kafka.NewReader(kafka.ReaderConfig{
+//		Brokers: []string{"localhost:9092"},
+//		GroupID: "order-processor",
+//		Topic:   "orders",
+//	})
+//	for {
+//		msg, err := r.FetchMessage(ctx)
+//		if nil != err {
+//			break
+//		}
+//		txn := nrkafkago.StartMessageTransaction(app, r, msg)
+//		process(newrelic.NewContext(ctx, txn), msg)
+//		txn.End()
+//	}
+//
+// Full producer and consumer examples:
+// https://github.com/newrelic/go-agent/tree/master/v3/integrations/nrkafkago/example
+package nrkafkago
+
+import (
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// KafkaLibrary is the library name used in message metrics.
+const KafkaLibrary = "Kafka"
+
+func init() { internal.TrackUsage("integration", "messagebroker", "kafka-go") }
+
+// setHeaders returns a copy of headers in which the headers in hdrs replace
+// any existing headers with the same key.  A copy is made so that messages
+// owned by the caller are not modified.
+func setHeaders(headers []kafka.Header, hdrs http.Header) []kafka.Header {
+	out := make([]kafka.Header, 0, len(headers)+len(hdrs))
+	for _, h := range headers {
+		if _, ok := hdrs[h.Key]; !ok {
+			out = append(out, h)
+		}
+	}
+	for key, vals := range hdrs {
+		for _, val := range vals {
+			out = append(out, kafka.Header{Key: key, Value: []byte(val)})
+		}
+	}
+	return out
+}
+
+// toHeader converts message headers into an http.Header so that they may be
+// passed to Transaction.AcceptDistributedTraceHeaders.
+func toHeader(headers []kafka.Header) http.Header {
+	hdrs := make(http.Header, len(headers))
+	for _, h := range headers {
+		hdrs.Add(h.Key, string(h.Value))
+	}
+	return hdrs
+}
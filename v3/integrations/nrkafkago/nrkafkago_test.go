@@ -0,0 +1,275 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrkafkago
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type recordingWriter struct {
+	msgs []kafka.Message
+	err  error
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return w.err
+}
+
+var replyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func testApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(replyFn, integrationsupport.DTEnabledCfgFn,
+		newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+func headerValue(headers []kafka.Header, key string) (string, int) {
+	var val string
+	var count int
+	for _, h := range headers {
+		if h.Key == key {
+			val = string(h.Value)
+			count++
+		}
+	}
+	return val, count
+}
+
+func TestWriteMessages(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("produce")
+	ctx := newrelic.NewContext(context.Background(), txn)
+
+	msgs := []kafka.Message{
+		{Value: []byte("first"), Headers: []kafka.Header{{Key: "custom", Value: []byte("value")}}},
+		{Value: []byte("second"), Headers: []kafka.Header{{Key: "Traceparent", Value: []byte("stale")}}},
+	}
+	w := &recordingWriter{}
+	if err := writeMessages(ctx, w, "orders", kafka.TCP("localhost:9092"), msgs); nil != err {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	if len(w.msgs) != 2 {
+		t.Fatal(w.msgs)
+	}
+	for _, msg := range w.msgs {
+		if val, count := headerValue(msg.Headers, newrelic.DistributedTraceW3CTraceParentHeader); count != 1 || val == "stale" {
+			t.Error(msg.Headers)
+		}
+		if _, count := headerValue(msg.Headers, newrelic.DistributedTraceNewRelicHeader); count != 1 {
+			t.Error(msg.Headers)
+		}
+	}
+	if val, _ := headerValue(w.msgs[0].Headers, "custom"); val != "value" {
+		t.Error(w.msgs[0].Headers)
+	}
+	if len(msgs[0].Headers) != 1 || len(msgs[1].Headers) != 1 || string(msgs[1].Headers[0].Value) != "stale" {
+		t.Error("messages passed in were modified", msgs)
+	}
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/orders", Scope: "OtherTransaction/Go/produce"},
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/orders", Scope: ""},
+	})
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "MessageBroker/Kafka/Topic/Produce/Named/orders",
+				"category": "generic",
+				"parentId": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:               "producer",
+				newrelic.AttributeMessageDestinationName: "orders",
+				newrelic.AttributeServerAddress:          "localhost",
+				newrelic.AttributeServerPort:             "9092",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/produce",
+				"transaction.name": "OtherTransaction/Go/produce",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestWriteMessagesTopicFromMessage(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("produce")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	w := &recordingWriter{err: errors.New("oops")}
+	if err := writeMessages(ctx, w, "", nil, []kafka.Message{{Topic: "payments"}}); err != w.err {
+		t.Error(err)
+	}
+	txn.End()
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/payments", Scope: "OtherTransaction/Go/produce"},
+	})
+}
+
+func TestWriteMessagesWithoutTransaction(t *testing.T) {
+	msg := kafka.Message{Value: []byte("value")}
+	w := &recordingWriter{}
+	if err := writeMessages(context.Background(), w, "orders", nil, []kafka.Message{msg}); nil != err {
+		t.Fatal(err)
+	}
+	if len(w.msgs) != 1 || len(w.msgs[0].Headers) != 0 {
+		t.Error(w.msgs)
+	}
+}
+
+func producedMessage(t *testing.T, app integrationsupport.ExpectApp, msg kafka.Message) kafka.Message {
+	txn := app.StartTransaction("produce")
+	w := &recordingWriter{}
+	if err := writeMessages(newrelic.NewContext(context.Background(), txn), w, "orders", nil, []kafka.Message{msg}); nil != err {
+		t.Fatal(err)
+	}
+	txn.End()
+	return w.msgs[0]
+}
+
+func TestStartMessageTransaction(t *testing.T) {
+	app := testApp()
+	msg := producedMessage(t, app, kafka.Message{
+		Topic:         "orders",
+		Partition:     3,
+		Offset:        40,
+		HighWaterMark: 50,
+	})
+
+	txn := StartMessageTransaction(app.Application, nil, msg)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "OtherTransaction/Go/produce",
+				"guid":     internal.MatchAnything,
+				"traceId":  internal.MatchAnything,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":                     "OtherTransaction/Go/Message/Kafka/Topic/Named/orders",
+				"guid":                     internal.MatchAnything,
+				"traceId":                  internal.MatchAnything,
+				"priority":                 internal.MatchAnything,
+				"sampled":                  internal.MatchAnything,
+				"parentId":                 internal.MatchAnything,
+				"parentSpanId":             internal.MatchAnything,
+				"parent.type":              "App",
+				"parent.app":               internal.MatchAnything,
+				"parent.account":           internal.MatchAnything,
+				"parent.transportType":     "Kafka",
+				"parent.transportDuration": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingDestinationPartitionID: 3,
+				newrelic.AttributeKafkaMessageOffset:              40,
+				newrelic.AttributeKafkaConsumerLag:                9,
+			},
+		},
+	})
+}
+
+func TestStartBatchTransaction(t *testing.T) {
+	app := testApp()
+	msgs := []kafka.Message{
+		{Topic: "orders", Partition: 1, Offset: 10, HighWaterMark: 20},
+		producedMessage(t, app, kafka.Message{Topic: "orders", Partition: 1, Offset: 11, HighWaterMark: 25}),
+		{Topic: "orders", Partition: 1, Offset: 12, HighWaterMark: 20},
+	}
+	txn := StartBatchTransaction(app.Application, nil, msgs)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "OtherTransaction/Go/produce",
+				"guid":     internal.MatchAnything,
+				"traceId":  internal.MatchAnything,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":                     "OtherTransaction/Go/Message/Kafka/Topic/Named/orders",
+				"guid":                     internal.MatchAnything,
+				"traceId":                  internal.MatchAnything,
+				"priority":                 internal.MatchAnything,
+				"sampled":                  internal.MatchAnything,
+				"parentId":                 internal.MatchAnything,
+				"parentSpanId":             internal.MatchAnything,
+				"parent.type":              "App",
+				"parent.app":               internal.MatchAnything,
+				"parent.account":           internal.MatchAnything,
+				"parent.transportType":     "Kafka",
+				"parent.transportDuration": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "orders",
+				newrelic.AttributeMessagingBatchMessageCount:      3,
+				newrelic.AttributeMessagingDestinationPartitionID: 1,
+				newrelic.AttributeKafkaMessageOffset:              10,
+				newrelic.AttributeKafkaConsumerLag:                13,
+			},
+		},
+	})
+}
+
+func TestStartBatchTransactionMixedPartitions(t *testing.T) {
+	app := testApp()
+	txn := StartBatchTransaction(app.Application, nil, []kafka.Message{
+		{Topic: "orders", Partition: 1, Offset: 10},
+		{Topic: "orders", Partition: 2, Offset: 20},
+	})
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/Message/Kafka/Topic/Named/orders",
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			newrelic.AttributeSpanKind:                   "consumer",
+			newrelic.AttributeMessageDestinationName:     "orders",
+			newrelic.AttributeMessagingBatchMessageCount: 2,
+		},
+	}})
+}
+
+func TestStartTransactionNilApp(t *testing.T) {
+	if txn := StartMessageTransaction(nil, nil, kafka.Message{}); nil != txn {
+		t.Error(txn)
+	}
+	if txn := StartBatchTransaction(nil, nil, nil); nil != txn {
+		t.Error(txn)
+	}
+}
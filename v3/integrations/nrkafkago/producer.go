@@ -0,0 +1,66 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrkafkago
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// messageWriter is implemented by *kafka.Writer.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// WriteMessages writes the messages using w.  If ctx contains a transaction,
+// the write is recorded as a newrelic.MessageProducerSegment named for the
+// writer's topic, or for the topic of the first message if the writer does
+// not have one, and distributed trace headers are added to the headers of
+// each message.  The messages passed in are not modified.
+func WriteMessages(ctx context.Context, w *kafka.Writer, msgs ...kafka.Message) error {
+	return writeMessages(ctx, w, w.Topic, w.Addr, msgs)
+}
+
+func writeMessages(ctx context.Context, w messageWriter, topic string, addr net.Addr, msgs []kafka.Message) error {
+	txn := newrelic.FromContext(ctx)
+	if nil == txn {
+		return w.WriteMessages(ctx, msgs...)
+	}
+	if topic == "" && len(msgs) > 0 {
+		topic = msgs[0].Topic
+	}
+	s := newrelic.MessageProducerSegment{
+		StartTime:       txn.StartSegmentNow(),
+		Library:         KafkaLibrary,
+		DestinationType: newrelic.MessageTopic,
+		DestinationName: topic,
+	}
+
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	withHeaders := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		msg.Headers = setHeaders(msg.Headers, hdrs)
+		withHeaders[i] = msg
+	}
+
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, topic)
+	if nil != addr {
+		if host, port, err := net.SplitHostPort(addr.String()); nil == err {
+			integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerAddress, host)
+			integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeServerPort, port)
+		}
+	}
+
+	err := w.WriteMessages(ctx, withHeaders...)
+	s.End()
+	return err
+}
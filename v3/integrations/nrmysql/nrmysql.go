@@ -44,6 +44,11 @@
 //	ctx := newrelic.NewContext(context.Background(), txn)
 //	row := db.QueryRowContext(ctx, "SELECT count(*) from tables")
 //
+// To report the statistics of the connection pool of the sql.DB, register it
+// with the application:
+//
+//	defer app.RegisterSQLDB(newrelic.DatastoreMySQL, db)()
+//
 // A working example is shown here:
 // https://github.com/newrelic/go-agent/tree/master/v3/integrations/nrmysql/example/main.go
 package nrmysql
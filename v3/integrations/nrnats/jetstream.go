@@ -0,0 +1,256 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrnats
+
+import (
+	"net/http"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// JSPublish publishes a message to JetStream using js.PublishMsg.  If txn is
+// non-nil, the publish is recorded as a newrelic.MessageProducerSegment and
+// distributed trace headers are added to the headers of the message.
+func JSPublish(txn *newrelic.Transaction, js nats.JetStreamContext, subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	return JSPublishMsg(txn, js, &nats.Msg{Subject: subj, Data: data}, opts...)
+}
+
+// JSPublishMsg publishes msg to JetStream.  If txn is non-nil, the publish is
+// recorded as a newrelic.MessageProducerSegment and distributed trace headers
+// are added to the headers of the published message.  msg is not modified.
+func JSPublishMsg(txn *newrelic.Transaction, js nats.JetStreamContext, msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	if nil == txn {
+		return js.PublishMsg(msg, opts...)
+	}
+	s := startJSPublishSegment(txn, msg.Subject)
+	ack, err := js.PublishMsg(withDistributedTraceHeaders(txn, msg), opts...)
+	s.End()
+	return ack, err
+}
+
+// JSPublishAsync publishes a message to JetStream using js.PublishMsgAsync.
+// If txn is non-nil, distributed trace headers are added to the headers of the
+// message and a newrelic.MessageProducerSegment records the time taken to
+// queue the message.  Since the acknowledgement is received by the caller, the
+// segment does not include the wait for it.
+func JSPublishAsync(txn *newrelic.Transaction, js nats.JetStreamContext, subj string, data []byte, opts ...nats.PubOpt) (nats.PubAckFuture, error) {
+	return JSPublishMsgAsync(txn, js, &nats.Msg{Subject: subj, Data: data}, opts...)
+}
+
+// JSPublishMsgAsync publishes msg to JetStream using js.PublishMsgAsync.  It
+// is recorded in the same way as JSPublishAsync.  msg is not modified.
+func JSPublishMsgAsync(txn *newrelic.Transaction, js nats.JetStreamContext, msg *nats.Msg, opts ...nats.PubOpt) (nats.PubAckFuture, error) {
+	if nil == txn {
+		return js.PublishMsgAsync(msg, opts...)
+	}
+	s := startJSPublishSegment(txn, msg.Subject)
+	future, err := js.PublishMsgAsync(withDistributedTraceHeaders(txn, msg), opts...)
+	s.End()
+	return future, err
+}
+
+func startJSPublishSegment(txn *newrelic.Transaction, subject string) *newrelic.MessageProducerSegment {
+	return &newrelic.MessageProducerSegment{
+		StartTime:       txn.StartSegmentNow(),
+		Library:         "NATS",
+		DestinationType: newrelic.MessageTopic,
+		DestinationName: subject,
+	}
+}
+
+// withDistributedTraceHeaders returns a copy of msg whose headers include
+// the distributed trace headers of txn.  A copy is made so that messages
+// owned by the caller are not modified.
+func withDistributedTraceHeaders(txn *newrelic.Transaction, msg *nats.Msg) *nats.Msg {
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	if len(hdrs) == 0 {
+		return msg
+	}
+	out := &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    msg.Data,
+		Header:  make(nats.Header, len(msg.Header)+len(hdrs)),
+	}
+	for key, vals := range msg.Header {
+		out.Header[key] = vals
+	}
+	for key, vals := range hdrs {
+		out.Header[key] = vals
+	}
+	return out
+}
+
+// JSSubWrapper wraps a handler for a JetStream push subscription created
+// using js.Subscribe or js.QueueSubscribe.  If app is non-nil, a transaction
+// is started for each message as by StartJSMessageTransaction, passed to f,
+// and ended when f returns.  If app is nil, f is called with a nil
+// transaction.  The transaction can be used to record the acknowledgement of
+// the message using Ack:
+//
+//	js.Subscribe("orders.*", nrnats.JSSubWrapper(app, func(txn *newrelic.Transaction, msg *nats.Msg) {
+//		process(msg)
+//		nrnats.Ack(txn, msg)
+//	}), nats.ManualAck())
+func JSSubWrapper(app *newrelic.Application, f func(txn *newrelic.Transaction, msg *nats.Msg)) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		txn := StartJSMessageTransaction(app, msg)
+		if nil != txn {
+			defer txn.End()
+		}
+		f(txn, msg)
+	}
+}
+
+// StartJSMessageTransaction starts a transaction for a JetStream message
+// received from a push or pull subscription.  The transaction accepts the
+// distributed trace headers of the message, and records its stream,
+// consumer, sequence numbers, delivery count, and the number of messages
+// pending for the consumer.  The caller must end the returned transaction.
+// If app is nil, nil is returned.
+func StartJSMessageTransaction(app *newrelic.Application, msg *nats.Msg) *newrelic.Transaction {
+	if nil == app {
+		return nil
+	}
+	txn := startJSConsumeTransaction(app, msg.Subject)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageReplyTo, msg.Reply, nil)
+	if nil != msg.Sub {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageQueueName, msg.Sub.Queue, nil)
+	}
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportQueue, toHeader(msg.Header))
+	if md, err := msg.Metadata(); nil == err {
+		addMetadataAttributes(txn, md)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSStreamSequence, "", md.Sequence.Stream)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSConsumerSequence, "", md.Sequence.Consumer)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSDeliveredCount, "", md.NumDelivered)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSConsumerPending, "", md.NumPending)
+	}
+	return txn
+}
+
+// StartJSBatchTransaction starts a single transaction for a batch of
+// JetStream messages returned by the Fetch method of a pull subscription.
+// The transaction is named for the subject of the first message and accepts
+// the distributed trace headers of the first message which has them.  The
+// number of messages, the stream and consumer, the stream sequence number of
+// the first message, and the number of messages pending for the consumer
+// after the last message are recorded.  The caller must end the returned
+// transaction.  If app is nil, nil is returned.
+func StartJSBatchTransaction(app *newrelic.Application, msgs []*nats.Msg) *newrelic.Transaction {
+	if nil == app {
+		return nil
+	}
+	var subject string
+	if len(msgs) > 0 {
+		subject = msgs[0].Subject
+	}
+	txn := startJSConsumeTransaction(app, subject)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingBatchMessageCount, "", len(msgs))
+	if len(msgs) == 0 {
+		return txn
+	}
+
+	for _, msg := range msgs {
+		hdrs := toHeader(msg.Header)
+		if hdrs.Get(newrelic.DistributedTraceW3CTraceParentHeader) != "" ||
+			hdrs.Get(newrelic.DistributedTraceNewRelicHeader) != "" {
+			txn.AcceptDistributedTraceHeaders(newrelic.TransportQueue, hdrs)
+			break
+		}
+	}
+	if md, err := msgs[0].Metadata(); nil == err {
+		addMetadataAttributes(txn, md)
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSStreamSequence, "", md.Sequence.Stream)
+	}
+	if md, err := msgs[len(msgs)-1].Metadata(); nil == err {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSConsumerPending, "", md.NumPending)
+	}
+	return txn
+}
+
+func startJSConsumeTransaction(app *newrelic.Application, subject string) *newrelic.Transaction {
+	namer := internal.MessageMetricKey{
+		Library:         "NATS",
+		DestinationType: string(newrelic.MessageTopic),
+		DestinationName: subject,
+		Consumer:        true,
+	}
+	txn := app.StartTransaction(namer.Name())
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageSystem, "nats", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, subject, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageRoutingKey, subject, nil)
+	return txn
+}
+
+// toHeader converts message headers into an http.Header so that they may be
+// passed to Transaction.AcceptDistributedTraceHeaders.  Since nats.Header
+// keys are case sensitive, the keys are canonicalized.
+func toHeader(header nats.Header) http.Header {
+	hdrs := make(http.Header, len(header))
+	for key, vals := range header {
+		for _, val := range vals {
+			hdrs.Add(key, val)
+		}
+	}
+	return hdrs
+}
+
+func addMetadataAttributes(txn *newrelic.Transaction, md *nats.MsgMetadata) {
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSStream, md.Stream, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeNATSConsumer, md.Consumer, nil)
+}
+
+// Ack acknowledges a JetStream message using msg.Ack.  If txn is non-nil,
+// the acknowledgement is recorded as a segment named for the stream of the
+// message, and an error is noticed if it fails.
+func Ack(txn *newrelic.Transaction, msg *nats.Msg, opts ...nats.AckOpt) error {
+	return ack(txn, msg, "Ack", func() error { return msg.Ack(opts...) })
+}
+
+// AckSync acknowledges a JetStream message using msg.AckSync, which waits
+// for the server to confirm the acknowledgement.  It is recorded in the same
+// way as Ack.
+func AckSync(txn *newrelic.Transaction, msg *nats.Msg, opts ...nats.AckOpt) error {
+	return ack(txn, msg, "AckSync", func() error { return msg.AckSync(opts...) })
+}
+
+// Nak negatively acknowledges a JetStream message using msg.Nak so that it
+// is redelivered.  It is recorded in the same way as Ack.
+func Nak(txn *newrelic.Transaction, msg *nats.Msg, opts ...nats.AckOpt) error {
+	return ack(txn, msg, "Nak", func() error { return msg.Nak(opts...) })
+}
+
+// Term terminates the delivery of a JetStream message using msg.Term.  It is
+// recorded in the same way as Ack.
+func Term(txn *newrelic.Transaction, msg *nats.Msg, opts ...nats.AckOpt) error {
+	return ack(txn, msg, "Term", func() error { return msg.Term(opts...) })
+}
+
+// InProgress resets the redelivery timer of a JetStream message using
+// msg.InProgress.  It is recorded in the same way as Ack.
+func InProgress(txn *newrelic.Transaction, msg *nats.Msg, opts ...nats.AckOpt) error {
+	return ack(txn, msg, "InProgress", func() error { return msg.InProgress(opts...) })
+}
+
+func ack(txn *newrelic.Transaction, msg *nats.Msg, kind string, fn func() error) error {
+	if nil == txn {
+		return fn()
+	}
+	stream := "Unknown"
+	if md, err := msg.Metadata(); nil == err && md.Stream != "" {
+		stream = md.Stream
+	}
+	s := txn.StartSegment("MessageBroker/NATS/Stream/Named/" + stream + "/" + kind)
+	err := fn()
+	s.End()
+	if nil != err {
+		txn.NoticeError(err)
+	}
+	return err
+}
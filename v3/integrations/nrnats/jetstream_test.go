@@ -0,0 +1,212 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrnats
+
+import (
+	"testing"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// recordingJetStream records the messages published to it.
+type recordingJetStream struct {
+	nats.JetStreamContext
+	msgs []*nats.Msg
+}
+
+func (js *recordingJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	js.msgs = append(js.msgs, m)
+	return &nats.PubAck{Stream: "ORDERS", Sequence: uint64(len(js.msgs))}, nil
+}
+
+func (js *recordingJetStream) PublishMsgAsync(m *nats.Msg, opts ...nats.PubOpt) (nats.PubAckFuture, error) {
+	js.msgs = append(js.msgs, m)
+	return nil, nil
+}
+
+var jsReplyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func jsTestApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(jsReplyFn, integrationsupport.DTEnabledCfgFn,
+		newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+// jsMsg returns a message with the reply subject JetStream uses to carry
+// the metadata of delivered messages.
+func jsMsg(subject string, header nats.Header) *nats.Msg {
+	return &nats.Msg{
+		Subject: subject,
+		Reply:   "$JS.ACK.ORDERS.processor.2.42.7.1700000000000000000.3",
+		Header:  header,
+		Sub:     &nats.Subscription{},
+	}
+}
+
+func TestJSPublish(t *testing.T) {
+	app := jsTestApp()
+	js := &recordingJetStream{}
+	txn := app.StartTransaction("hello")
+	orig := &nats.Msg{Subject: "orders.new", Data: []byte("a"), Header: nats.Header{"key": []string{"value"}}}
+	if _, err := JSPublishMsg(txn, js, orig); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := JSPublishAsync(txn, js, "orders.new", []byte("b")); nil != err {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	if len(js.msgs) != 2 {
+		t.Fatal(js.msgs)
+	}
+	for _, m := range js.msgs {
+		if m.Header.Get(newrelic.DistributedTraceNewRelicHeader) == "" ||
+			m.Header.Get(newrelic.DistributedTraceW3CTraceParentHeader) == "" {
+			t.Error("missing distributed trace headers", m.Header)
+		}
+	}
+	if v := js.msgs[0].Header.Get("key"); v != "value" {
+		t.Error(v)
+	}
+	if len(orig.Header) != 1 {
+		t.Error("original message modified", orig.Header)
+	}
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/NATS/Topic/Produce/Named/orders.new", Scope: "", Forced: false, Data: []float64{2}},
+		{Name: "MessageBroker/NATS/Topic/Produce/Named/orders.new", Scope: "OtherTransaction/Go/hello", Forced: false, Data: []float64{2}},
+	})
+}
+
+func TestJSPublishNilTxn(t *testing.T) {
+	js := &recordingJetStream{}
+	orig := &nats.Msg{Subject: "orders.new"}
+	if _, err := JSPublishMsg(nil, js, orig); nil != err {
+		t.Fatal(err)
+	}
+	if len(js.msgs) != 1 || js.msgs[0] != orig {
+		t.Error(js.msgs)
+	}
+}
+
+func TestStartJSMessageTransaction(t *testing.T) {
+	producer := jsTestApp()
+	js := &recordingJetStream{}
+	txn := producer.StartTransaction("producer")
+	JSPublish(txn, js, "orders.new", []byte("a"))
+	txn.End()
+
+	app := jsTestApp()
+	msg := jsMsg("orders.new", js.msgs[0].Header)
+	txn = StartJSMessageTransaction(app.Application, msg)
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "OtherTransaction/Go/Message/NATS/Topic/Named/orders.new", Scope: "", Forced: true, Data: nil},
+		{Name: "Supportability/TraceContext/Accept/Success", Scope: "", Forced: true, Data: nil},
+	})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":                     "OtherTransaction/Go/Message/NATS/Topic/Named/orders.new",
+			"guid":                     internal.MatchAnything,
+			"priority":                 internal.MatchAnything,
+			"sampled":                  internal.MatchAnything,
+			"traceId":                  internal.MatchAnything,
+			"parentId":                 internal.MatchAnything,
+			"parentSpanId":             internal.MatchAnything,
+			"parent.type":              "App",
+			"parent.account":           "123",
+			"parent.app":               "456",
+			"parent.transportType":     "Queue",
+			"parent.transportDuration": internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"message.routingKey":                       "orders.new",
+			"message.destination.name":                 "orders.new",
+			"messaging.system":                         "nats",
+			"messaging.nats.stream":                    "ORDERS",
+			"messaging.nats.consumer":                  "processor",
+			"messaging.nats.message.stream_sequence":   uint64(42),
+			"messaging.nats.message.consumer_sequence": uint64(7),
+			"messaging.nats.message.delivered_count":   uint64(2),
+			"messaging.nats.consumer.pending":          uint64(3),
+			"span.kind":                                "consumer",
+		},
+		UserAttributes: map[string]interface{}{},
+	}})
+}
+
+func TestStartJSBatchTransaction(t *testing.T) {
+	app := jsTestApp()
+	msgs := []*nats.Msg{
+		jsMsg("orders.new", nil),
+		{Subject: "orders.new", Header: nats.Header{"traceparent": []string{"00-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-bbbbbbbbbbbbbbbb-01"}}},
+	}
+	txn := StartJSBatchTransaction(app.Application, msgs)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":                 "OtherTransaction/Go/Message/NATS/Topic/Named/orders.new",
+			"guid":                 internal.MatchAnything,
+			"priority":             internal.MatchAnything,
+			"sampled":              internal.MatchAnything,
+			"traceId":              "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			"parentSpanId":         "bbbbbbbbbbbbbbbb",
+			"parent.transportType": "Queue",
+		},
+		AgentAttributes: map[string]interface{}{
+			"message.routingKey":                     "orders.new",
+			"message.destination.name":               "orders.new",
+			"messaging.system":                       "nats",
+			"messaging.batch.message_count":          2,
+			"messaging.nats.stream":                  "ORDERS",
+			"messaging.nats.consumer":                "processor",
+			"messaging.nats.message.stream_sequence": uint64(42),
+			"span.kind":                              "consumer",
+		},
+		UserAttributes: map[string]interface{}{},
+	}})
+}
+
+func TestStartJSTransactionNilApp(t *testing.T) {
+	if txn := StartJSMessageTransaction(nil, jsMsg("orders.new", nil)); nil != txn {
+		t.Error(txn)
+	}
+	if txn := StartJSBatchTransaction(nil, nil); nil != txn {
+		t.Error(txn)
+	}
+	called := false
+	JSSubWrapper(nil, func(txn *newrelic.Transaction, msg *nats.Msg) {
+		called = true
+		if nil != txn {
+			t.Error(txn)
+		}
+	})(jsMsg("orders.new", nil))
+	if !called {
+		t.Error("handler not called")
+	}
+}
+
+func TestAck(t *testing.T) {
+	app := jsTestApp()
+	txn := app.StartTransaction("consume")
+	// The message was not received from a subscription, so it cannot be
+	// acknowledged.
+	if err := Ack(txn, &nats.Msg{Subject: "orders.new"}); err != nats.ErrMsgNotBound {
+		t.Error(err)
+	}
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/MessageBroker/NATS/Stream/Named/Unknown/Ack", Scope: "OtherTransaction/Go/consume", Forced: false, Data: nil},
+		{Name: "Errors/OtherTransaction/Go/consume", Scope: "", Forced: true, Data: nil},
+	})
+}
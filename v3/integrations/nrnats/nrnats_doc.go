@@ -3,9 +3,9 @@
 
 // Package nrnats instruments https://github.com/nats-io/nats.go.
 //
-// This package can be used to simplify instrumenting NATS publishers and subscribers. Currently due to the nature of
-// the NATS framework we are limited to two integration points: `StartPublishSegment` for publishers, and `SubWrapper`
-// for subscribers.
+// This package can be used to simplify instrumenting NATS publishers and subscribers. Core NATS is instrumented
+// using `StartPublishSegment` for publishers and `SubWrapper` for subscribers. JetStream is instrumented using the
+// functions described in the JetStream section below.
 //
 // NATS publishers
 //
@@ -57,6 +57,31 @@
 //	subject := "testing.subject"
 //	nc.Subscribe(subject, nrnats.SubWrapper(app, myMessageHandler))
 //
+// JetStream
+//
+// `JSPublish`, `JSPublishMsg`, `JSPublishAsync` and `JSPublishMsgAsync` publish to JetStream and record a
+// `newrelic.MessageProducerSegment`. They add distributed trace headers to the `nats.Header` of the published
+// message.
+//
+//	js, _ := nc.JetStream()
+//	txn := currentTransaction()  // current newrelic.Transaction
+//	ack, err := nrnats.JSPublish(txn, js, "orders.new", []byte("Hello World"))
+//
+// Consumer transactions accept those headers. They record the stream, consumer, sequence numbers, delivery count
+// and pending count of the message as attributes. Use `JSSubWrapper` for push subscriptions. For pull
+// subscriptions, use `StartJSMessageTransaction` for each message or `StartJSBatchTransaction` for each fetched
+// batch. `Ack`, `AckSync`, `Nak`, `Term` and `InProgress` record the acknowledgement of a message as a segment
+// of the transaction.
+//
+//	sub, _ := js.PullSubscribe("orders.*", "processor")
+//	msgs, _ := sub.Fetch(10)
+//	txn := nrnats.StartJSBatchTransaction(app, msgs)
+//	for _, msg := range msgs {
+//		process(msg)
+//		nrnats.Ack(txn, msg)
+//	}
+//	txn.End()
+//
 // Full Publisher/Subscriber example:
 // https://github.com/newrelic/go-agent/blob/master/v3/integrations/nrnats/examples/main.go
 package nrnats
@@ -0,0 +1,68 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrotel"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("example")
+
+func queryUsers(ctx context.Context) {
+	_, span := tracer.Start(ctx, "SELECT users", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", "SELECT"),
+		attribute.String("db.collection.name", "users"),
+	))
+	defer span.End()
+	time.Sleep(5 * time.Millisecond)
+}
+
+func users(w http.ResponseWriter, r *http.Request) {
+	// Continue the trace of the caller using its traceparent header.
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "GET /users", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+	))
+	defer span.End()
+
+	queryUsers(ctx)
+
+	// The Transaction is available to New Relic instrumentation.
+	newrelic.FromContext(ctx).AddAttribute("user.count", 2)
+
+	span.SetAttributes(attribute.Int("http.response.status_code", http.StatusOK))
+	io.WriteString(w, "alice, bob")
+}
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("OpenTelemetry App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	otel.SetTracerProvider(nrotel.NewTracerProvider(app))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	http.HandleFunc("/users", users)
+	http.ListenAndServe(":8000", nil)
+}
@@ -0,0 +1,11 @@
+module github.com/newrelic/go-agent/v3/integrations/nrotel
+
+go 1.22
+
+require (
+	github.com/newrelic/go-agent/v3 v3.38.0
+	go.opentelemetry.io/otel v1.31.0
+	go.opentelemetry.io/otel/trace v1.31.0
+)
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,594 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrotel records spans created with the OpenTelemetry tracing API as
+// New Relic transactions and segments.
+//
+// Use this package when libraries are already instrumented with
+// go.opentelemetry.io/otel/trace.  Create a TracerProvider backed by your
+// newrelic.Application and register it as the global provider:
+//
+//	otel.SetTracerProvider(nrotel.NewTracerProvider(app))
+//
+// Spans started without a parent become Transactions.  Spans started with a
+// parent become segments of the parent's Transaction, chosen using the
+// OpenTelemetry semantic conventions: spans with a "db.system" attribute
+// become DatastoreSegments, client spans with an HTTP method attribute
+// become ExternalSegments, and all other spans become Segments.  A
+// Transaction added to the context by other New Relic instrumentation, such
+// as newrelic.WrapHandle, is used as the parent of spans started with that
+// context.  The context returned by Tracer.Start contains the Transaction, so
+// newrelic.FromContext works inside spans.
+//
+// Span contexts carry the distributed tracing identifiers of the
+// Transaction.  A root span started with a remote parent span context, such
+// as one extracted by the W3C trace context propagator, continues the remote
+// trace just as if its traceparent header had been passed to
+// Transaction.AcceptDistributedTraceHeaders.  Span links are recorded using
+// Transaction.AddSpanLinkFromTraceMetadata.
+//
+// The "db.query.text" or "db.statement" attribute of SQL databases is
+// obfuscated with sqlparse before it is recorded, and the attribute itself is
+// not copied to the segment.  Queries of other databases are only recorded
+// when Config.DatastoreTracer.RawQuery.Enabled is set.
+//
+// Example: https://github.com/newrelic/go-agent/tree/master/v3/integrations/nrotel/example/main.go
+package nrotel
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/newrelic/go-agent/v3/newrelic/sqlparse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+func init() { internal.TrackUsage("integration", "otel", "trace") }
+
+// Attribute keys of the OpenTelemetry semantic conventions used to create
+// segments.  Both the current names and the names used before the HTTP and
+// database conventions were stabilized are recognized.
+const (
+	keyHTTPMethod          = "http.request.method"
+	keyHTTPMethodOld       = "http.method"
+	keyHTTPStatusCode      = "http.response.status_code"
+	keyHTTPStatusCodeOld   = "http.status_code"
+	keyURLFull             = "url.full"
+	keyURLFullOld          = "http.url"
+	keyURLScheme           = "url.scheme"
+	keyURLPath             = "url.path"
+	keyServerAddress       = "server.address"
+	keyServerAddressOld    = "net.peer.name"
+	keyServerPort          = "server.port"
+	keyServerPortOld       = "net.peer.port"
+	keyDBSystem            = "db.system"
+	keyDBOperation         = "db.operation.name"
+	keyDBOperationOld      = "db.operation"
+	keyDBCollection        = "db.collection.name"
+	keyDBCollectionOld     = "db.sql.table"
+	keyDBCollectionMongoDB = "db.mongodb.collection"
+	keyDBQuery             = "db.query.text"
+	keyDBQueryOld          = "db.statement"
+	keyDBNamespace         = "db.namespace"
+	keyDBNamespaceOld      = "db.name"
+)
+
+// datastoreProducts maps "db.system" values to the products New Relic
+// recognizes.  Other values are used as the product unchanged.
+var datastoreProducts = map[string]newrelic.DatastoreProduct{
+	"cassandra":     newrelic.DatastoreCassandra,
+	"couchdb":       newrelic.DatastoreCouchDB,
+	"derby":         newrelic.DatastoreDerby,
+	"dynamodb":      newrelic.DatastoreDynamoDB,
+	"elasticsearch": newrelic.DatastoreElasticsearch,
+	"firebird":      newrelic.DatastoreFirebird,
+	"db2":           newrelic.DatastoreIBMDB2,
+	"informix":      newrelic.DatastoreInformix,
+	"memcached":     newrelic.DatastoreMemcached,
+	"mongodb":       newrelic.DatastoreMongoDB,
+	"mssql":         newrelic.DatastoreMSSQL,
+	"mysql":         newrelic.DatastoreMySQL,
+	"neptune":       newrelic.DatastoreNeptune,
+	"oracle":        newrelic.DatastoreOracle,
+	"postgresql":    newrelic.DatastorePostgres,
+	"redis":         newrelic.DatastoreRedis,
+	"riak":          newrelic.DatastoreRiak,
+	"snowflake":     newrelic.DatastoreSnowflake,
+	"solr":          newrelic.DatastoreSolr,
+	"sqlite":        newrelic.DatastoreSQLite,
+	"tarantool":     newrelic.DatastoreTarantool,
+	"voltdb":        newrelic.DatastoreVoltDB,
+	"aerospike":     newrelic.DatastoreAerospike,
+}
+
+// sqlSystems are the "db.system" values of SQL databases, whose queries can
+// be obfuscated by sqlparse.
+var sqlSystems = map[string]bool{
+	"db2":        true,
+	"derby":      true,
+	"firebird":   true,
+	"informix":   true,
+	"mariadb":    true,
+	"mssql":      true,
+	"mysql":      true,
+	"oracle":     true,
+	"postgresql": true,
+	"snowflake":  true,
+	"sqlite":     true,
+}
+
+// TracerProvider is an OpenTelemetry trace.TracerProvider which records
+// spans using a newrelic.Application.
+type TracerProvider struct {
+	embedded.TracerProvider
+	app *newrelic.Application
+}
+
+// NewTracerProvider creates a TracerProvider which records spans using the
+// Application provided.
+func NewTracerProvider(app *newrelic.Application) *TracerProvider {
+	return &TracerProvider{app: app}
+}
+
+// Tracer implements trace.TracerProvider.  All tracers record to the same
+// Application, so the name and options are not used.
+func (tp *TracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return &tracer{provider: tp}
+}
+
+type tracer struct {
+	embedded.Tracer
+	provider *TracerProvider
+}
+
+// segment is implemented by newrelic.Segment, newrelic.DatastoreSegment,
+// and newrelic.ExternalSegment.
+type segment interface {
+	AddAttribute(key string, val interface{})
+	End()
+}
+
+type span struct {
+	embedded.Span
+	provider    *TracerProvider
+	spanContext trace.SpanContext
+	kind        trace.SpanKind
+
+	// txn is the Transaction reference used by this span.  It belongs to
+	// the goroutine which started the span.
+	txn *newrelic.Transaction
+	// segment is nil for root spans.
+	segment segment
+	// parent is set when the span shares the Transaction reference of its
+	// parent span.
+	parent *span
+	// activeChildren is set while a child span shares txn.
+	activeChildren int32
+
+	sync.Mutex
+	name          string
+	attributes    map[attribute.Key]attribute.Value
+	statusCode    codes.Code
+	statusMessage string
+	errorNoticed  bool
+	ended         bool
+}
+
+// Start implements trace.Tracer.
+func (t *tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &span{
+		provider:   t.provider,
+		kind:       cfg.SpanKind(),
+		name:       name,
+		attributes: make(map[attribute.Key]attribute.Value),
+	}
+	s.setAttributes(cfg.Attributes())
+
+	var parentSpan *span
+	var parentTxn *newrelic.Transaction
+	if !cfg.NewRoot() {
+		if p, ok := trace.SpanFromContext(ctx).(*span); ok && p.IsRecording() {
+			parentSpan = p
+		} else {
+			parentTxn = newrelic.FromContext(ctx)
+		}
+	}
+
+	switch {
+	case nil != parentSpan:
+		txn := parentSpan.childTransaction()
+		if txn == parentSpan.txn {
+			s.parent = parentSpan
+		}
+		s.startSegment(txn)
+		s.spanContext = spanContext(txn, parentSpan.spanContext)
+	case nil != parentTxn:
+		s.startSegment(parentTxn)
+		s.spanContext = spanContext(parentTxn, trace.SpanContext{})
+	default:
+		remote := trace.SpanContextFromContext(ctx)
+		if cfg.NewRoot() {
+			remote = trace.SpanContext{}
+		}
+		s.startTransaction(remote)
+		s.spanContext = spanContext(s.txn, remote)
+	}
+
+	for _, link := range cfg.Links() {
+		s.addLink(link)
+	}
+
+	ctx = trace.ContextWithSpan(ctx, s)
+	ctx = newrelic.NewContext(ctx, s.txn)
+	return ctx, s
+}
+
+// childTransaction returns the Transaction reference used to start a child
+// span.  A segment can only be the parent of one active segment on the same
+// goroutine Transaction reference, so the first active child shares the
+// parent's reference and concurrent children get a new one.  Segments
+// started on a new reference are children of the Transaction's root span.
+func (s *span) childTransaction() *newrelic.Transaction {
+	if atomic.CompareAndSwapInt32(&s.activeChildren, 0, 1) {
+		return s.txn
+	}
+	return s.txn.NewGoroutine()
+}
+
+func (s *span) startTransaction(remote trace.SpanContext) {
+	s.txn = s.provider.app.StartTransaction(s.name)
+	if remote.IsValid() {
+		s.txn.AcceptDistributedTraceHeaders(s.transportType(), traceContextHeaders(remote))
+	}
+	if s.kind == trace.SpanKindServer {
+		if req, ok := s.webRequest(); ok {
+			s.txn.SetWebRequest(req)
+		}
+	}
+}
+
+func (s *span) startSegment(txn *newrelic.Transaction) {
+	s.txn = txn
+	start := txn.StartSegmentNow()
+	if _, ok := s.attribute(keyDBSystem); ok {
+		s.segment = &newrelic.DatastoreSegment{StartTime: start}
+	} else if _, ok := s.attribute(keyHTTPMethod, keyHTTPMethodOld); ok && s.kind == trace.SpanKindClient {
+		s.segment = &newrelic.ExternalSegment{StartTime: start}
+	} else {
+		s.segment = &newrelic.Segment{StartTime: start}
+	}
+}
+
+// traceContextHeaders creates the W3C trace context headers of a span
+// context so that it can be accepted by the Transaction.
+func traceContextHeaders(sc trace.SpanContext) http.Header {
+	hdrs := http.Header{}
+	hdrs.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags()))
+	if ts := sc.TraceState().String(); ts != "" {
+		hdrs.Set("tracestate", ts)
+	}
+	return hdrs
+}
+
+// spanContext creates the span context of a span from the distributed
+// tracing identifiers of its Transaction.  Identifiers which are not
+// available, for example because distributed tracing is disabled, are taken
+// from the parent span context or generated.
+func spanContext(txn *newrelic.Transaction, parent trace.SpanContext) trace.SpanContext {
+	md := txn.GetTraceMetadata()
+	var cfg trace.SpanContextConfig
+	if id, err := trace.TraceIDFromHex(leftPad(md.TraceID, 32)); nil == err {
+		cfg.TraceID = id
+	} else if parent.HasTraceID() {
+		cfg.TraceID = parent.TraceID()
+	} else {
+		rand.Read(cfg.TraceID[:])
+	}
+	if id, err := trace.SpanIDFromHex(leftPad(md.SpanID, 16)); nil == err {
+		cfg.SpanID = id
+	} else {
+		rand.Read(cfg.SpanID[:])
+	}
+	if txn.IsSampled() {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	cfg.TraceState = parent.TraceState()
+	return trace.NewSpanContext(cfg)
+}
+
+func leftPad(id string, length int) string {
+	if id == "" || len(id) >= length {
+		return id
+	}
+	return strings.Repeat("0", length-len(id)) + id
+}
+
+func (s *span) setAttributes(kv []attribute.KeyValue) {
+	for _, a := range kv {
+		if a.Valid() {
+			s.attributes[a.Key] = a.Value
+		}
+	}
+}
+
+// attribute returns the value of the first of the keys present.
+func (s *span) attribute(keys ...attribute.Key) (attribute.Value, bool) {
+	for _, k := range keys {
+		if v, ok := s.attributes[k]; ok {
+			return v, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func (s *span) stringAttribute(keys ...attribute.Key) string {
+	if v, ok := s.attribute(keys...); ok {
+		return v.Emit()
+	}
+	return ""
+}
+
+func (s *span) intAttribute(keys ...attribute.Key) int {
+	if v, ok := s.attribute(keys...); ok && v.Type() == attribute.INT64 {
+		return int(v.AsInt64())
+	}
+	return 0
+}
+
+// attributeValue converts an attribute value into one accepted by
+// Transaction.AddAttribute.  Slices are converted into strings.
+func attributeValue(v attribute.Value) interface{} {
+	switch v.Type() {
+	case attribute.BOOL:
+		return v.AsBool()
+	case attribute.INT64:
+		return v.AsInt64()
+	case attribute.FLOAT64:
+		return v.AsFloat64()
+	case attribute.STRING:
+		return v.AsString()
+	default:
+		return v.Emit()
+	}
+}
+
+func (s *span) transportType() newrelic.TransportType {
+	switch strings.ToLower(s.stringAttribute(keyURLScheme)) {
+	case "http":
+		return newrelic.TransportHTTP
+	case "https":
+		return newrelic.TransportHTTPS
+	}
+	if _, ok := s.attribute(keyHTTPMethod, keyHTTPMethodOld); ok {
+		return newrelic.TransportHTTP
+	}
+	return newrelic.TransportUnknown
+}
+
+// url returns the URL of an HTTP span, either from the full URL attribute
+// or from its components.
+func (s *span) url() *url.URL {
+	if full := s.stringAttribute(keyURLFull, keyURLFullOld); full != "" {
+		if u, err := url.Parse(full); nil == err {
+			return u
+		}
+	}
+	host := s.stringAttribute(keyServerAddress, keyServerAddressOld)
+	if host == "" {
+		return nil
+	}
+	if port := s.intAttribute(keyServerPort, keyServerPortOld); port != 0 {
+		host = fmt.Sprintf("%s:%d", host, port)
+	}
+	scheme := s.stringAttribute(keyURLScheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: s.stringAttribute(keyURLPath)}
+}
+
+func (s *span) webRequest() (newrelic.WebRequest, bool) {
+	method := s.stringAttribute(keyHTTPMethod, keyHTTPMethodOld)
+	if method == "" {
+		return newrelic.WebRequest{}, false
+	}
+	return newrelic.WebRequest{
+		Method:    method,
+		URL:       s.url(),
+		Host:      s.stringAttribute(keyServerAddress, keyServerAddressOld),
+		Transport: s.transportType(),
+	}, true
+}
+
+// SpanContext implements trace.Span.
+func (s *span) SpanContext() trace.SpanContext { return s.spanContext }
+
+// IsRecording implements trace.Span.  Spans record until they are ended.
+func (s *span) IsRecording() bool {
+	s.Lock()
+	defer s.Unlock()
+	return !s.ended
+}
+
+// SetStatus implements trace.Span.  A span with an error status is reported
+// as an error unless an error has already been recorded with RecordError.
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.ended || s.statusCode == codes.Ok || code < s.statusCode {
+		return
+	}
+	s.statusCode = code
+	if code == codes.Error {
+		s.statusMessage = description
+	}
+}
+
+// SetName implements trace.Span.
+func (s *span) SetName(name string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.ended {
+		return
+	}
+	s.name = name
+	if nil == s.segment {
+		s.txn.SetName(name)
+	}
+}
+
+// SetAttributes implements trace.Span.  Attributes are added to the
+// Transaction or segment when the span ends.
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.ended {
+		s.setAttributes(kv)
+	}
+}
+
+// RecordError implements trace.Span using Transaction.NoticeError.
+func (s *span) RecordError(err error, options ...trace.EventOption) {
+	if nil == err {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.ended {
+		return
+	}
+	s.errorNoticed = true
+	s.txn.NoticeError(err)
+}
+
+// AddEvent implements trace.Span.  New Relic spans do not have events, so
+// this does nothing.
+func (s *span) AddEvent(name string, options ...trace.EventOption) {}
+
+// AddLink implements trace.Span using
+// Transaction.AddSpanLinkFromTraceMetadata.  The link belongs to the current
+// segment of the span's Transaction reference, which is the span itself
+// unless a child span sharing the reference is active.  Link attributes are
+// not recorded.
+func (s *span) AddLink(link trace.Link) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.ended {
+		s.addLink(link)
+	}
+}
+
+func (s *span) addLink(link trace.Link) {
+	if !link.SpanContext.IsValid() {
+		return
+	}
+	s.txn.AddSpanLinkFromTraceMetadata(newrelic.TraceMetadata{
+		TraceID: link.SpanContext.TraceID().String(),
+		SpanID:  link.SpanContext.SpanID().String(),
+	})
+}
+
+// TracerProvider implements trace.Span.
+func (s *span) TracerProvider() trace.TracerProvider { return s.provider }
+
+var errSpanStatus = errors.New("span status error")
+
+// End implements trace.Span.  The end timestamp option is not supported:
+// the Transaction or segment ends when End is called.
+func (s *span) End(options ...trace.SpanEndOption) {
+	s.Lock()
+	defer s.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	if s.statusCode == codes.Error && !s.errorNoticed {
+		if s.statusMessage == "" {
+			s.txn.NoticeError(errSpanStatus)
+		} else {
+			s.txn.NoticeError(errors.New(s.statusMessage))
+		}
+	}
+
+	if nil == s.segment {
+		s.endTransaction()
+	} else {
+		s.endSegment()
+	}
+	if nil != s.parent {
+		atomic.StoreInt32(&s.parent.activeChildren, 0)
+	}
+}
+
+func (s *span) endTransaction() {
+	for k, v := range s.attributes {
+		s.txn.AddAttribute(string(k), attributeValue(v))
+	}
+	if s.kind == trace.SpanKindServer {
+		if code := s.intAttribute(keyHTTPStatusCode, keyHTTPStatusCodeOld); code != 0 {
+			s.txn.SetWebResponse(nil).WriteHeader(code)
+		}
+	}
+	s.txn.End()
+}
+
+func (s *span) endSegment() {
+	switch seg := s.segment.(type) {
+	case *newrelic.DatastoreSegment:
+		system := s.stringAttribute(keyDBSystem)
+		seg.Product = datastoreProducts[system]
+		if seg.Product == "" {
+			seg.Product = newrelic.DatastoreProduct(system)
+		}
+		seg.Operation = s.stringAttribute(keyDBOperation, keyDBOperationOld)
+		seg.Collection = s.stringAttribute(keyDBCollection, keyDBCollectionOld, keyDBCollectionMongoDB)
+		if query := s.stringAttribute(keyDBQuery, keyDBQueryOld); query != "" {
+			seg.RawQuery = query
+			if sqlSystems[system] {
+				stmt := sqlparse.Parse(query, sqlparse.DialectForProduct(seg.Product))
+				if stmt.ObfuscatedQuery != "?" {
+					seg.ParameterizedQuery = stmt.ObfuscatedQuery
+				}
+			}
+		}
+		seg.DatabaseName = s.stringAttribute(keyDBNamespace, keyDBNamespaceOld)
+		seg.Host = s.stringAttribute(keyServerAddress, keyServerAddressOld)
+		if port := s.intAttribute(keyServerPort, keyServerPortOld); port != 0 {
+			seg.PortPathOrID = fmt.Sprint(port)
+		}
+	case *newrelic.ExternalSegment:
+		if u := s.url(); nil != u {
+			seg.URL = u.String()
+		}
+		seg.Procedure = s.stringAttribute(keyHTTPMethod, keyHTTPMethodOld)
+		seg.Library = "http"
+		if code := s.intAttribute(keyHTTPStatusCode, keyHTTPStatusCodeOld); code != 0 {
+			seg.SetStatusCode(code)
+		}
+	case *newrelic.Segment:
+		seg.Name = s.name
+	}
+	_, datastore := s.segment.(*newrelic.DatastoreSegment)
+	for k, v := range s.attributes {
+		// Queries are recorded by the segment once obfuscated.
+		if datastore && (k == keyDBQuery || k == keyDBQueryOld) {
+			continue
+		}
+		s.segment.AddAttribute(string(k), attributeValue(v))
+	}
+	s.segment.End()
+}
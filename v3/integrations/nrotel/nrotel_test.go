@@ -0,0 +1,414 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func testApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(replyFn, integrationsupport.ConfigFullTraces, newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+var replyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func TestRootSpanTransaction(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, root := tr.Start(context.Background(), "root")
+	if txn := newrelic.FromContext(ctx); nil == txn {
+		t.Fatal("transaction missing from context")
+	}
+	root.SetAttributes(attribute.String("zip", "zap"))
+	root.End()
+	if root.IsRecording() {
+		t.Error("span recording after end")
+	}
+	sc := root.SpanContext()
+	if !sc.IsValid() || !sc.IsSampled() || sc.IsRemote() {
+		t.Error(sc)
+	}
+
+	app.ExpectTxnMetrics(t, internal.WantTxn{Name: "root", UnknownCaller: true})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/root",
+			"guid":     internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+			"traceId":  sc.TraceID().String(),
+		},
+		UserAttributes: map[string]interface{}{"zip": "zap"},
+	}})
+}
+
+func TestChildSpanSegments(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, root := tr.Start(context.Background(), "root")
+	childCtx, child := tr.Start(ctx, "child")
+	_, db := tr.Start(childCtx, "SELECT", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", "SELECT"),
+		attribute.String("db.collection.name", "users"),
+		attribute.String("db.query.text", "SELECT * FROM users WHERE name = 'secret'"),
+	))
+	db.End()
+	_, ext := tr.Start(childCtx, "GET", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", "GET"),
+		attribute.String("url.full", "http://example.com/path"),
+	))
+	ext.SetAttributes(attribute.Int("http.response.status_code", 200))
+	ext.End()
+	child.End()
+	root.End()
+
+	if child.SpanContext().TraceID() != root.SpanContext().TraceID() {
+		t.Error(child.SpanContext(), root.SpanContext())
+	}
+	if child.SpanContext().SpanID() == root.SpanContext().SpanID() {
+		t.Error("child span has root span ID")
+	}
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/child", Scope: "OtherTransaction/Go/root"},
+		{Name: "Datastore/statement/Postgres/users/SELECT", Scope: "OtherTransaction/Go/root"},
+		{Name: "External/example.com/http/GET", Scope: "OtherTransaction/Go/root"},
+	})
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName: "OtherTransaction/Go/root",
+		Root: internal.WantTraceSegment{
+			SegmentName: "ROOT",
+			Attributes:  map[string]interface{}{},
+			Children: []internal.WantTraceSegment{{
+				SegmentName: "OtherTransaction/Go/root",
+				Attributes:  map[string]interface{}{"exclusive_duration_millis": internal.MatchAnything},
+				Children: []internal.WantTraceSegment{{
+					SegmentName: "Custom/child",
+					Attributes:  map[string]interface{}{},
+					Children: []internal.WantTraceSegment{
+						{SegmentName: "Datastore/statement/Postgres/users/SELECT", Attributes: map[string]interface{}{"db.statement": "SELECT * FROM users WHERE name = ?"}},
+						{SegmentName: "External/example.com/http/GET", Attributes: map[string]interface{}{"http.url": "http://example.com/path"}},
+					},
+				}},
+			}},
+		},
+	}})
+}
+
+func TestConcurrentChildSpans(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, root := tr.Start(context.Background(), "root")
+	_, first := tr.Start(ctx, "first")
+	_, second := tr.Start(ctx, "second")
+	first.End()
+	second.End()
+	root.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/first", Scope: "OtherTransaction/Go/root"},
+		{Name: "Custom/second", Scope: "OtherTransaction/Go/root"},
+	})
+}
+
+func TestSpanInExistingTransaction(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	txn := app.StartTransaction("existing")
+	_, s := tr.Start(newrelic.NewContext(context.Background(), txn), "child")
+	if id := txn.GetTraceMetadata().TraceID; s.SpanContext().TraceID().String() != id {
+		t.Error(s.SpanContext().TraceID(), id)
+	}
+	s.End()
+	txn.End()
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/child", Scope: "OtherTransaction/Go/existing"},
+	})
+}
+
+func TestServerSpanWebTransaction(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	_, s := tr.Start(context.Background(), "GET /hello", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", "GET"),
+		attribute.String("url.full", "http://example.com/hello"),
+	))
+	s.SetAttributes(attribute.Int("http.response.status_code", 500))
+	s.End()
+
+	app.ExpectTxnMetrics(t, internal.WantTxn{
+		Name:          "GET /hello",
+		IsWeb:         true,
+		UnknownCaller: true,
+		ErrorByCaller: true,
+		NumErrors:     1,
+	})
+}
+
+func TestRemoteParent(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+
+	_, s := tr.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "root")
+	s.End()
+
+	if s.SpanContext().TraceID() != traceID {
+		t.Error(s.SpanContext().TraceID())
+	}
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Supportability/TraceContext/Accept/Success"},
+	})
+	app.ExpectSpanEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"category":         "generic",
+			"name":             "OtherTransaction/Go/root",
+			"transaction.name": "OtherTransaction/Go/root",
+			"nr.entryPoint":    true,
+			"traceId":          "0af7651916cd43dd8448eb211c80319c",
+			"parentId":         "b7ad6b7169203331",
+		},
+		UserAttributes:  map[string]interface{}{},
+		AgentAttributes: map[string]interface{}{"parent.transportType": "Unknown"},
+	}})
+}
+
+func TestNewRootIgnoresParent(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, first := tr.Start(context.Background(), "first")
+	_, second := tr.Start(ctx, "second", trace.WithNewRoot())
+	second.End()
+	first.End()
+
+	if first.SpanContext().TraceID() == second.SpanContext().TraceID() {
+		t.Error("new root span has parent trace ID")
+	}
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "OtherTransaction/Go/first"},
+		{Name: "OtherTransaction/Go/second"},
+	})
+}
+
+func TestSpanErrors(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	_, recorded := tr.Start(context.Background(), "recorded")
+	recorded.RecordError(errors.New("oops"))
+	recorded.SetStatus(codes.Error, "oops")
+	recorded.End()
+
+	_, status := tr.Start(context.Background(), "status")
+	status.SetStatus(codes.Error, "bad status")
+	status.End()
+
+	_, ok := tr.Start(context.Background(), "ok")
+	ok.SetStatus(codes.Ok, "")
+	ok.SetStatus(codes.Error, "ignored")
+	ok.End()
+
+	app.ExpectErrorEvents(t, []internal.WantEvent{
+		{Intrinsics: map[string]interface{}{
+			"error.class":     "*errors.errorString",
+			"error.message":   "oops",
+			"transactionName": "OtherTransaction/Go/recorded",
+			"guid":            internal.MatchAnything,
+			"traceId":         internal.MatchAnything,
+			"priority":        internal.MatchAnything,
+			"sampled":         internal.MatchAnything,
+			"spanId":          internal.MatchAnything,
+		}},
+		{Intrinsics: map[string]interface{}{
+			"error.class":     "*errors.errorString",
+			"error.message":   "bad status",
+			"transactionName": "OtherTransaction/Go/status",
+			"guid":            internal.MatchAnything,
+			"traceId":         internal.MatchAnything,
+			"priority":        internal.MatchAnything,
+			"sampled":         internal.MatchAnything,
+			"spanId":          internal.MatchAnything,
+		}},
+	})
+}
+
+func TestSetName(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, root := tr.Start(context.Background(), "before")
+	root.SetName("after")
+	_, child := tr.Start(ctx, "child-before")
+	child.SetName("child-after")
+	child.End()
+	root.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "OtherTransaction/Go/after"},
+		{Name: "Custom/child-after", Scope: "OtherTransaction/Go/after"},
+	})
+}
+
+func TestTraceContextHeaders(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+	state, _ := trace.ParseTraceState("vendor=value")
+	hdrs := traceContextHeaders(trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: state,
+	}))
+	if tp := hdrs.Get("traceparent"); tp != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Error(tp)
+	}
+	if ts := hdrs.Get("tracestate"); ts != "vendor=value" {
+		t.Error(ts)
+	}
+}
+
+func TestDatastoreQueryNotSQL(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+
+	ctx, root := tr.Start(context.Background(), "root")
+	_, db := tr.Start(ctx, "GET", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation.name", "GET"),
+		attribute.String("db.statement", "GET session:secret"),
+	))
+	db.End()
+	root.End()
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":      "Datastore/operation/Redis/GET",
+				"category":  "datastore",
+				"component": "Redis",
+				"span.kind": "client",
+				"guid":      internal.MatchAnything,
+				"traceId":   internal.MatchAnything,
+				"parentId":  internal.MatchAnything,
+				"priority":  internal.MatchAnything,
+				"sampled":   internal.MatchAnything,
+			},
+			UserAttributes: map[string]interface{}{
+				"db.system":         "redis",
+				"db.operation.name": "GET",
+			},
+			AgentAttributes: map[string]interface{}{
+				"db.statement": "'GET' on 'unknown' using 'Redis'",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/root",
+				"transaction.name": "OtherTransaction/Go/root",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+				"guid":             internal.MatchAnything,
+				"traceId":          internal.MatchAnything,
+				"priority":         internal.MatchAnything,
+				"sampled":          internal.MatchAnything,
+			},
+		},
+	})
+}
+
+func TestSpanLinks(t *testing.T) {
+	app := testApp()
+	tr := NewTracerProvider(app.Application).Tracer("test")
+	linked := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		SpanID:  trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+	})
+	other := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+
+	ctx, root := tr.Start(context.Background(), "root", trace.WithLinks(trace.Link{SpanContext: linked}))
+	_, child := tr.Start(ctx, "child")
+	child.AddLink(trace.Link{SpanContext: other})
+	child.AddLink(trace.Link{})
+	child.End()
+	root.End()
+
+	rootID := root.SpanContext().SpanID().String()
+	childID := child.SpanContext().SpanID().String()
+	traceID := root.SpanContext().TraceID().String()
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "Custom/child",
+				"category": "generic",
+				"guid":     childID,
+				"parentId": rootID,
+				"traceId":  traceID,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"type":          "SpanLink",
+				"id":            childID,
+				"trace.id":      traceID,
+				"linkedTraceId": other.TraceID().String(),
+				"linkedSpanId":  other.SpanID().String(),
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/root",
+				"transaction.name": "OtherTransaction/Go/root",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+				"guid":             rootID,
+				"traceId":          traceID,
+				"priority":         internal.MatchAnything,
+				"sampled":          internal.MatchAnything,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"type":          "SpanLink",
+				"id":            rootID,
+				"trace.id":      traceID,
+				"linkedTraceId": linked.TraceID().String(),
+				"linkedSpanId":  linked.SpanID().String(),
+			},
+		},
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpgx5
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// explainRows returns a Postgres plan.  Methods which are not used when
+// explaining a query are left to the nil embedded pgx.Rows.
+type explainRows struct {
+	pgx.Rows
+	values [][]any
+	closed bool
+}
+
+func (r *explainRows) FieldDescriptions() []pgconn.FieldDescription {
+	return []pgconn.FieldDescription{{Name: "QUERY PLAN"}}
+}
+func (r *explainRows) Next() bool { return len(r.values) > 0 }
+func (r *explainRows) Err() error { return nil }
+func (r *explainRows) Close()     { r.closed = true }
+func (r *explainRows) Values() ([]any, error) {
+	row := r.values[0]
+	r.values = r.values[1:]
+	return row, nil
+}
+
+type explainQueryer struct {
+	sql  string
+	args []any
+	ctx  context.Context
+	rows *explainRows
+}
+
+func (q *explainQueryer) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	q.ctx, q.sql, q.args = ctx, sql, args
+	q.rows = &explainRows{values: [][]any{
+		{"Index Scan using mytable_pkey on mytable  (cost=0.15..8.17 rows=1 width=222)"},
+		{"  Index Cond: (id = 1)"},
+	}}
+	return q.rows, nil
+}
+
+func TestExplainQuery(t *testing.T) {
+	app := integrationsupport.NewTestApp(nil, func(cfg *newrelic.Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+	})
+	tracer := NewTracer()
+	tracer.BaseSegment = newrelic.DatastoreSegment{Product: newrelic.DatastorePostgres}
+	txn := app.StartTransaction("hello")
+	data := pgx.TraceQueryStartData{SQL: "SELECT name FROM mytable WHERE id = $1", Args: []any{1}}
+	ctx := tracer.TraceQueryStart(newrelic.NewContext(context.Background(), txn), nil, data)
+	segment := ctx.Value(querySegmentKey).(*newrelic.DatastoreSegment)
+	segment.End()
+	q := &explainQueryer{}
+	explainQuery(ctx, q, segment, data)
+	txn.End()
+
+	if q.sql != "EXPLAIN SELECT name FROM mytable WHERE id = $1" || !reflect.DeepEqual(q.args, data.Args) {
+		t.Error(q.sql, q.args)
+	}
+	if !q.rows.closed {
+		t.Error("explain rows not closed")
+	}
+	// The explain statement is not traced.
+	if explainCtx := tracer.TraceQueryStart(q.ctx, nil, pgx.TraceQueryStartData{SQL: q.sql}); explainCtx != q.ctx {
+		t.Error("explain statement traced")
+	}
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/Postgres/mytable/select",
+		Query:      "SELECT name FROM mytable WHERE id = $?",
+		TxnName:    "OtherTransaction/Go/hello",
+		Params:     map[string]interface{}{"$0": 1},
+		ExplainPlan: [][]string{
+			{"QUERY PLAN"},
+			{"Index Scan using mytable_pkey on mytable  (cost=0.15..8.17 rows=1 width=222)"},
+			{"  Index Cond: ?"},
+		},
+	}})
+}
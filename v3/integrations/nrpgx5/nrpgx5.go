@@ -67,6 +67,8 @@ const (
 	prepareSegmentKey nrPgxSegmentType = "prepareNrPgx5Segment"
 	batchSegmentKey   nrPgxSegmentType = "batchNrPgx5Segment"
 	querySecurityKey  nrPgxSegmentType = "nrPgx5SecurityToken"
+	queryDataKey      nrPgxSegmentType = "nrPgx5QueryData"
+	explainKey        nrPgxSegmentType = "nrPgx5Explain"
 )
 
 type TracerOption func(*Tracer)
@@ -137,6 +139,9 @@ func (Tracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData)
 // rest of the call and will be passed to TraceQueryEnd.
 // This starts a new datastore segment in the transaction stored in the passed context.
 func (t *Tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if nil != ctx.Value(explainKey) {
+		return ctx
+	}
 	segment := t.BaseSegment
 	segment.StartTime = newrelic.FromContext(ctx).StartSegmentNow()
 	segment.ParameterizedQuery = data.SQL
@@ -151,12 +156,18 @@ func (t *Tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.T
 		ctx = context.WithValue(ctx, querySecurityKey, stoken)
 	}
 
+	ctx = context.WithValue(ctx, queryDataKey, data)
 	return context.WithValue(ctx, querySegmentKey, &segment)
 }
 
 // TraceQueryEnd is called by pgx/v5 at the completion of Query, QueryRow, and Exec calls.
 // This will terminate the datastore segment started when the database operation was started.
+// The plan of a slow query run outside of a transaction is then captured using EXPLAIN,
+// unless disabled using Config.DatastoreTracer.SlowQuery.Explain.Enabled.
 func (t *Tracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if nil != ctx.Value(explainKey) {
+		return
+	}
 	segment, ok := ctx.Value(querySegmentKey).(*newrelic.DatastoreSegment)
 	if !ok {
 		return
@@ -168,6 +179,42 @@ func (t *Tracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.Tra
 		}
 	}
 	segment.End()
+	if nil == data.Err && nil != conn && conn.PgConn().TxStatus() == 'I' {
+		if query, ok := ctx.Value(queryDataKey).(pgx.TraceQueryStartData); ok {
+			explainQuery(ctx, conn, segment, query)
+		}
+	}
+}
+
+// queryer is implemented by *pgx.Conn.
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// explainQuery captures the plan of the query of the ended segment if it was
+// recorded as a slow query.  The explain statement is not traced.
+func explainQuery(ctx context.Context, conn queryer, segment *newrelic.DatastoreSegment, query pgx.TraceQueryStartData) {
+	segment.CaptureExplainPlan(func() ([]string, [][]interface{}, error) {
+		rows, err := conn.Query(context.WithValue(ctx, explainKey, true), "EXPLAIN "+query.SQL, query.Args...)
+		if nil != err {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		var columns []string
+		for _, field := range rows.FieldDescriptions() {
+			columns = append(columns, field.Name)
+		}
+		var values [][]interface{}
+		for rows.Next() {
+			row, err := rows.Values()
+			if nil != err {
+				return nil, nil, err
+			}
+			values = append(values, row)
+		}
+		return columns, values, rows.Err()
+	})
 }
 
 func (t *Tracer) getQueryParameters(args []interface{}) map[string]interface{} {
@@ -0,0 +1,52 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpgx5
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// RegisterPool registers the connection pool with the application so that
+// its statistics are reported as Datastore/Postgres/Pool/* metrics.  Call
+// the returned function when the pool is closed.
+//
+//	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+//	if err != nil { ... }
+//	defer nrpgx5.RegisterPool(app, pool)()
+//	defer pool.Close()
+func RegisterPool(app *newrelic.Application, pool *pgxpool.Pool) (unregister func()) {
+	if nil == pool {
+		return func() {}
+	}
+	return app.RegisterDatastorePool(newrelic.DatastorePostgres, func() newrelic.DatastorePoolStats {
+		return poolStats(pool.Stat())
+	})
+}
+
+// poolStat holds the methods of pgxpool.Stat used by poolStats.
+type poolStat interface {
+	MaxConns() int32
+	TotalConns() int32
+	AcquiredConns() int32
+	IdleConns() int32
+	EmptyAcquireCount() int64
+	AcquireDuration() time.Duration
+}
+
+// poolStats converts the pool statistics.  Since pgxpool does not report the
+// time spent waiting for a connection, the total duration of acquiring
+// connections is used as the wait duration.
+func poolStats(s poolStat) newrelic.DatastorePoolStats {
+	return newrelic.DatastorePoolStats{
+		MaxOpen:      int(s.MaxConns()),
+		Open:         int(s.TotalConns()),
+		InUse:        int(s.AcquiredConns()),
+		Idle:         int(s.IdleConns()),
+		WaitCount:    s.EmptyAcquireCount(),
+		WaitDuration: s.AcquireDuration(),
+	}
+}
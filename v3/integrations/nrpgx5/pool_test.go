@@ -0,0 +1,39 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpgx5
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type testStat struct{}
+
+func (testStat) MaxConns() int32                { return 10 }
+func (testStat) TotalConns() int32              { return 4 }
+func (testStat) AcquiredConns() int32           { return 3 }
+func (testStat) IdleConns() int32               { return 1 }
+func (testStat) EmptyAcquireCount() int64       { return 7 }
+func (testStat) AcquireDuration() time.Duration { return time.Second }
+
+func TestPoolStats(t *testing.T) {
+	got := poolStats(testStat{})
+	want := newrelic.DatastorePoolStats{
+		MaxOpen:      10,
+		Open:         4,
+		InUse:        3,
+		Idle:         1,
+		WaitCount:    7,
+		WaitDuration: time.Second,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRegisterPoolNil(t *testing.T) {
+	RegisterPool(nil, nil)()
+}
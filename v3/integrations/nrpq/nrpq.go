@@ -50,6 +50,14 @@
 // does not have ExecContext and QueryContext methods (as of June 2019, see
 // https://github.com/lib/pq/pull/768).
 //
+// Explain plans are captured for slow queries run outside of a transaction,
+// unless disabled using Config.DatastoreTracer.SlowQuery.Explain.Enabled.
+//
+// To report the statistics of the connection pool of the sql.DB, register it
+// with the application:
+//
+//	defer app.RegisterSQLDB(newrelic.DatastorePostgres, db)()
+//
 // A working example is shown here:
 // https://github.com/newrelic/go-agent/tree/master/v3/integrations/nrpq/example/main.go
 package nrpq
@@ -73,11 +81,17 @@ var (
 		BaseSegment: newrelic.DatastoreSegment{
 			Product: newrelic.DatastorePostgres,
 		},
-		ParseQuery: sqlparse.ParseQuery,
-		ParseDSN:   parseDSN(os.Getenv),
+		ParseQuery:   sqlparse.ParseQuery,
+		ParseDSN:     parseDSN(os.Getenv),
+		ExplainQuery: explainQuery,
 	}
 )
 
+// explainQuery returns the statement which explains the plan of a slow query.
+func explainQuery(query string) string {
+	return "EXPLAIN " + query
+}
+
 // NewConnector can be used in place of pq.NewConnector to get an instrumented
 // PostgreSQL connector.
 func NewConnector(dsn string) (driver.Connector, error) {
@@ -4,8 +4,15 @@
 package nrpq
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
 	"testing"
 
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
 	newrelic "github.com/newrelic/go-agent/v3/newrelic"
 )
 
@@ -252,3 +259,82 @@ func TestNewConnector(t *testing.T) {
 		t.Error("non-nil connector expected from valid dsn")
 	}
 }
+
+type explainRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *explainRows) Columns() []string { return r.columns }
+func (r *explainRows) Close() error      { return nil }
+func (r *explainRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// explainConn records the queries it runs and returns a Postgres plan for
+// explain statements.
+type explainConn struct {
+	queries *[]string
+}
+
+func (c explainConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c explainConn) Close() error                              { return nil }
+func (c explainConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+func (c explainConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.queries = append(*c.queries, query)
+	if strings.HasPrefix(query, "EXPLAIN ") {
+		return &explainRows{
+			columns: []string{"QUERY PLAN"},
+			values: [][]driver.Value{
+				{[]byte("Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)")},
+				{[]byte("  Index Cond: (id = $1)")},
+			},
+		}, nil
+	}
+	return &explainRows{columns: []string{"name"}, values: [][]driver.Value{{"gopher"}}}, nil
+}
+
+type explainConnector struct {
+	queries *[]string
+}
+
+func (c explainConnector) Connect(context.Context) (driver.Conn, error) {
+	return explainConn{queries: c.queries}, nil
+}
+func (c explainConnector) Driver() driver.Driver { return nil }
+
+func TestExplainSlowQuery(t *testing.T) {
+	app := integrationsupport.NewTestApp(nil, func(cfg *newrelic.Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+	})
+	var queries []string
+	db := sql.OpenDB(newrelic.InstrumentSQLConnector(explainConnector{queries: &queries}, baseBuilder))
+	txn := app.StartTransaction("hello")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	var name string
+	if err := db.QueryRowContext(ctx, "SELECT name FROM users WHERE id = $1", 1234).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	if len(queries) != 2 || queries[1] != "EXPLAIN SELECT name FROM users WHERE id = $1" {
+		t.Error(queries)
+	}
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/Postgres/users/select",
+		Query:      "SELECT name FROM users WHERE id = $?",
+		TxnName:    "OtherTransaction/Go/hello",
+		ExplainPlan: [][]string{
+			{"QUERY PLAN"},
+			{"Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)"},
+			{"  Index Cond: ?"},
+		},
+	}})
+}
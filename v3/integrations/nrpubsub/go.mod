@@ -0,0 +1,12 @@
+module github.com/newrelic/go-agent/v3/integrations/nrpubsub
+
+go 1.22
+
+require (
+	cloud.google.com/go/pubsub v1.42.0
+	github.com/newrelic/go-agent/v3 v3.38.0
+	google.golang.org/api v0.191.0
+	google.golang.org/grpc v1.65.0
+)
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,185 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrpubsub instruments cloud.google.com/go/pubsub.
+//
+// Use this package to record messages published to Google Cloud Pub/Sub
+// topics as message producer segments, and to start a transaction for each
+// message received from a subscription.  Distributed trace headers are
+// propagated in the attributes of the messages.
+//
+// To record a publish, pass a context containing a transaction to Publish in
+// place of calling topic.Publish directly:
+//
+//	ctx := newrelic.NewContext(context.Background(), txn)
+//	res := nrpubsub.Publish(ctx, topic, &pubsub.Message{Data: []byte("hello")})
+//
+// To start a transaction for each message received, wrap the function passed
+// to subscription.Receive with WrapReceiveHandler, or use Receive:
+//
+//	err := nrpubsub.Receive(ctx, app, sub, func(ctx context.Context, msg *pubsub.Message) {
+//		txn := newrelic.FromContext(ctx)
+//		// ...
+//		msg.Ack()
+//	})
+package nrpubsub
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func init() { internal.TrackUsage("integration", "messagebroker", "nrpubsub") }
+
+const (
+	// Library is the library name used in the metrics and transaction names
+	// of Pub/Sub messages.
+	Library = "GCPPubSub"
+	// messagingSystem is the value of the messaging.system attribute.
+	messagingSystem = "gcp_pubsub"
+)
+
+// Publish publishes msg to topic.  If ctx contains a transaction, the publish
+// is recorded as a newrelic.MessageProducerSegment named for the topic, and
+// distributed trace headers are added to the attributes of the message.  The
+// segment ends once the result is ready, and records the server generated
+// message ID.  The message passed in is not modified.
+//
+// Since the segment ends asynchronously, it is only recorded if the result
+// is ready before the transaction ends.
+func Publish(ctx context.Context, topic *pubsub.Topic, msg *pubsub.Message) *pubsub.PublishResult {
+	txn := newrelic.FromContext(ctx)
+	if nil == txn {
+		return topic.Publish(ctx, msg)
+	}
+	txn = txn.NewGoroutine()
+	s := startPublishSegment(txn, topic.ID())
+	res := topic.Publish(ctx, withDistributedTraceHeaders(txn, msg))
+	go endPublishSegment(txn, s, res)
+	return res
+}
+
+// publishResult is implemented by *pubsub.PublishResult.
+type publishResult interface {
+	Ready() <-chan struct{}
+	Get(ctx context.Context) (string, error)
+}
+
+// endPublishSegment waits for the result of the publish and ends the
+// segment, recording the message ID or noticing the error.
+func endPublishSegment(txn *newrelic.Transaction, s *newrelic.MessageProducerSegment, res publishResult) {
+	<-res.Ready()
+	id, err := res.Get(context.Background())
+	if nil != err {
+		txn.NoticeError(err)
+	} else {
+		integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessagingMessageID, id)
+	}
+	s.End()
+}
+
+func startPublishSegment(txn *newrelic.Transaction, topic string) *newrelic.MessageProducerSegment {
+	s := &newrelic.MessageProducerSegment{
+		StartTime:       txn.StartSegmentNow(),
+		Library:         Library,
+		DestinationType: newrelic.MessageTopic,
+		DestinationName: topic,
+	}
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageSystem, messagingSystem)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, topic)
+	return s
+}
+
+// withDistributedTraceHeaders returns a copy of msg whose attributes contain
+// the distributed trace headers of txn.  The header names are lower case
+// since Pub/Sub attribute keys are case sensitive.
+func withDistributedTraceHeaders(txn *newrelic.Transaction, msg *pubsub.Message) *pubsub.Message {
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	if len(hdrs) == 0 {
+		return msg
+	}
+	attrs := make(map[string]string, len(msg.Attributes)+len(hdrs))
+	for key, val := range msg.Attributes {
+		attrs[key] = val
+	}
+	for key := range hdrs {
+		attrs[strings.ToLower(key)] = hdrs.Get(key)
+	}
+	return &pubsub.Message{
+		Data:        msg.Data,
+		Attributes:  attrs,
+		OrderingKey: msg.OrderingKey,
+	}
+}
+
+// Receive calls sub.Receive with f wrapped by WrapReceiveHandler.
+func Receive(ctx context.Context, app *newrelic.Application, sub *pubsub.Subscription, f func(context.Context, *pubsub.Message)) error {
+	return sub.Receive(ctx, WrapReceiveHandler(app, sub, f))
+}
+
+// WrapReceiveHandler wraps a function passed to sub.Receive so that each
+// message is processed within a transaction started by
+// StartMessageTransaction.  The context passed to f contains the
+// transaction, which is ended when f returns.  If app is nil, f is called
+// without a transaction.
+func WrapReceiveHandler(app *newrelic.Application, sub *pubsub.Subscription, f func(context.Context, *pubsub.Message)) func(context.Context, *pubsub.Message) {
+	return func(ctx context.Context, msg *pubsub.Message) {
+		txn := StartMessageTransaction(app, sub.ID(), msg)
+		if nil == txn {
+			f(ctx, msg)
+			return
+		}
+		defer txn.End()
+		f(newrelic.NewContext(ctx, txn), msg)
+	}
+}
+
+// StartMessageTransaction starts a transaction for a message received from
+// the subscription with the given ID.  The transaction is named for the
+// subscription, accepts the distributed trace headers in the attributes of
+// the message, and records its ID, ordering key, and delivery attempt.  The
+// caller must end the returned transaction.  If app is nil, nil is returned.
+func StartMessageTransaction(app *newrelic.Application, subscription string, msg *pubsub.Message) *newrelic.Transaction {
+	if nil == app {
+		return nil
+	}
+	namer := internal.MessageMetricKey{
+		Library:         Library,
+		DestinationType: string(newrelic.MessageQueue),
+		DestinationName: subscription,
+		Consumer:        true,
+	}
+	txn := app.StartTransaction(namer.Name())
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageSystem, messagingSystem, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, subscription, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageQueueName, subscription, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingMessageID, msg.ID, nil)
+	if msg.OrderingKey != "" {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeGCPPubSubOrderingKey, msg.OrderingKey, nil)
+	}
+	if nil != msg.DeliveryAttempt {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeGCPPubSubDeliveryAttempt, "", *msg.DeliveryAttempt)
+	}
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportQueue, toHeader(msg.Attributes))
+	return txn
+}
+
+// toHeader converts the attributes of a message into headers which can be
+// passed to Transaction.AcceptDistributedTraceHeaders.
+func toHeader(attrs map[string]string) http.Header {
+	hdrs := make(http.Header, len(attrs))
+	for key, val := range attrs {
+		hdrs.Add(key, val)
+	}
+	return hdrs
+}
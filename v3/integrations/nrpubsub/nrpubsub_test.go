@@ -0,0 +1,256 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpubsub
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var replyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func testApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(replyFn, integrationsupport.DTEnabledCfgFn,
+		newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+// newTopic returns a fake Pub/Sub server, a client connected to it, and a
+// topic created with the client.
+func newTopic(t *testing.T) (*pstest.Server, *pubsub.Client, *pubsub.Topic) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	topic, err := client.CreateTopic(ctx, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(topic.Stop)
+	return srv, client, topic
+}
+
+func TestPublish(t *testing.T) {
+	app := testApp()
+	srv, _, topic := newTopic(t)
+	txn := app.StartTransaction("publish")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	orig := &pubsub.Message{Data: []byte("hello"), Attributes: map[string]string{"key": "value"}}
+	if _, err := Publish(ctx, topic, orig).Get(ctx); err != nil {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	if len(orig.Attributes) != 1 {
+		t.Error("original message modified", orig.Attributes)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatal(msgs)
+	}
+	if msgs[0].Attributes["traceparent"] == "" || msgs[0].Attributes["key"] != "value" {
+		t.Error(msgs[0].Attributes)
+	}
+}
+
+func TestPublishNoTransaction(t *testing.T) {
+	srv, _, topic := newTopic(t)
+	orig := &pubsub.Message{Data: []byte("hello")}
+	if _, err := Publish(context.Background(), topic, orig).Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := srv.Messages(); len(msgs) != 1 || len(msgs[0].Attributes) != 0 {
+		t.Error(msgs)
+	}
+}
+
+type testResult struct {
+	id  string
+	err error
+}
+
+func (r testResult) Ready() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func (r testResult) Get(ctx context.Context) (string, error) { return r.id, r.err }
+
+func TestEndPublishSegment(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("publish")
+	endPublishSegment(txn, startPublishSegment(txn, "orders"), testResult{id: "42"})
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/GCPPubSub/Topic/Produce/Named/orders", Scope: "", Forced: false, Data: nil},
+		{Name: "MessageBroker/GCPPubSub/Topic/Produce/Named/orders", Scope: "OtherTransaction/Go/publish", Forced: false, Data: nil},
+	})
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "MessageBroker/GCPPubSub/Topic/Produce/Named/orders",
+				"category": "generic",
+				"parentId": internal.MatchAnything,
+			},
+			UserAttributes: map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{
+				"span.kind":                "producer",
+				"messaging.system":         "gcp_pubsub",
+				"message.destination.name": "orders",
+				"messaging.message.id":     "42",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/publish",
+				"transaction.name": "OtherTransaction/Go/publish",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+	})
+}
+
+func TestEndPublishSegmentError(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("publish")
+	endPublishSegment(txn, startPublishSegment(txn, "orders"), testResult{err: context.DeadlineExceeded})
+	txn.End()
+
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/publish",
+		Msg:     context.DeadlineExceeded.Error(),
+		Klass:   "context.deadlineExceededError",
+	}})
+}
+
+func TestWithDistributedTraceHeaders(t *testing.T) {
+	app := testApp()
+	txn := app.StartTransaction("publish")
+	orig := &pubsub.Message{Data: []byte("hello"), Attributes: map[string]string{"key": "value"}, OrderingKey: "customer"}
+	msg := withDistributedTraceHeaders(txn, orig)
+	txn.End()
+
+	if msg.Attributes["newrelic"] == "" || msg.Attributes["traceparent"] == "" {
+		t.Error("missing distributed trace headers", msg.Attributes)
+	}
+	if msg.Attributes["key"] != "value" || msg.OrderingKey != "customer" || string(msg.Data) != "hello" {
+		t.Error(msg)
+	}
+	if len(orig.Attributes) != 1 {
+		t.Error("original message modified", orig.Attributes)
+	}
+}
+
+func TestStartMessageTransaction(t *testing.T) {
+	producer := testApp()
+	txn := producer.StartTransaction("publish")
+	published := withDistributedTraceHeaders(txn, &pubsub.Message{Data: []byte("hello")})
+	txn.End()
+
+	app := testApp()
+	attempt := 2
+	msg := &pubsub.Message{
+		ID:              "1",
+		Data:            published.Data,
+		Attributes:      published.Attributes,
+		OrderingKey:     "customer",
+		DeliveryAttempt: &attempt,
+	}
+	txn = StartMessageTransaction(app.Application, "orders-sub", msg)
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "OtherTransaction/Go/Message/GCPPubSub/Queue/Named/orders-sub", Scope: "", Forced: true, Data: nil},
+		{Name: "Supportability/TraceContext/Accept/Success", Scope: "", Forced: true, Data: nil},
+	})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":                     "OtherTransaction/Go/Message/GCPPubSub/Queue/Named/orders-sub",
+			"guid":                     internal.MatchAnything,
+			"priority":                 internal.MatchAnything,
+			"sampled":                  internal.MatchAnything,
+			"traceId":                  internal.MatchAnything,
+			"parentId":                 internal.MatchAnything,
+			"parentSpanId":             internal.MatchAnything,
+			"parent.type":              "App",
+			"parent.account":           "123",
+			"parent.app":               "456",
+			"parent.transportType":     "Queue",
+			"parent.transportDuration": internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"span.kind":                                     "consumer",
+			"messaging.system":                              "gcp_pubsub",
+			"message.destination.name":                      "orders-sub",
+			"message.queueName":                             "orders-sub",
+			"messaging.message.id":                          "1",
+			"messaging.gcp_pubsub.message.ordering_key":     "customer",
+			"messaging.gcp_pubsub.message.delivery_attempt": 2,
+		},
+		UserAttributes: map[string]interface{}{},
+	}})
+}
+
+func TestStartMessageTransactionNilApp(t *testing.T) {
+	if txn := StartMessageTransaction(nil, "orders-sub", &pubsub.Message{}); nil != txn {
+		t.Error(txn)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	app := testApp()
+	_, client, topic := newTopic(t)
+	ctx := context.Background()
+	sub, err := client.CreateSubscription(ctx, "orders-sub", pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: []byte("hello")}).Get(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var received *newrelic.Transaction
+	err = Receive(ctx, app.Application, sub, func(ctx context.Context, msg *pubsub.Message) {
+		received = newrelic.FromContext(ctx)
+		msg.Ack()
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received == nil {
+		t.Fatal("handler context does not contain a transaction")
+	}
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "OtherTransaction/Go/Message/GCPPubSub/Queue/Named/orders-sub", Scope: "", Forced: true, Data: nil},
+	})
+}
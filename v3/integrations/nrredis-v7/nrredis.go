@@ -97,3 +97,34 @@ func (h hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) erro
 	h.after(ctx)
 	return nil
 }
+
+// RegisterPool registers the connection pool of a redis.Client,
+// redis.ClusterClient, or redis.Ring with the application so that its
+// statistics are reported as Datastore/Redis/Pool/* metrics.  Call the
+// returned function when the client is closed.
+func RegisterPool(app *newrelic.Application, client interface {
+	PoolStats() *redis.PoolStats
+}) (unregister func()) {
+	if nil == client {
+		return func() {}
+	}
+	return app.RegisterDatastorePool(newrelic.DatastoreRedis, func() newrelic.DatastorePoolStats {
+		return poolStats(client.PoolStats())
+	})
+}
+
+// poolStats converts the pool statistics.  The client does not report how
+// many times or for how long a connection was waited for, so the wait count
+// and wait duration are not reported.  Misses counts the times no idle
+// connection was available rather than waits, and Timeouts only the waits
+// which failed.
+func poolStats(s *redis.PoolStats) newrelic.DatastorePoolStats {
+	if nil == s {
+		return newrelic.DatastorePoolStats{}
+	}
+	return newrelic.DatastorePoolStats{
+		Open:  int(s.TotalConns),
+		InUse: int(s.TotalConns - s.IdleConns),
+		Idle:  int(s.IdleConns),
+	}
+}
@@ -5,12 +5,30 @@
 //
 // Use this package to instrument your redis/go-redis/v9 calls without having to
 // manually create DatastoreSegments.
+//
+// Each command is recorded as a DatastoreSegment.  Keys often contain user
+// names, email addresses, or other values that should not leave the process,
+// so they are not recorded unless the hook is created with
+// WithKeyPatterns(true).  The ParameterizedQuery is then the command name
+// followed by the obfuscated pattern of its first key, for example
+// "get user:*:session".
+//
+// Each command of a pipeline is recorded as its own segment.  The commands are
+// sent together, so each segment lasts for the whole pipeline: the segments
+// overlap, and adding up their durations overstates the time spent in Redis.
+//
+// To attribute the commands of a redis.ClusterClient or redis.Ring to the
+// node they were routed to, use AddNodeHooks instead of adding a single hook
+// to the client.  The hook of a client created by redis.NewFailoverClient
+// records the address of the master it connected to.
 package nrredis
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	"github.com/newrelic/go-agent/v3/internal"
 	newrelic "github.com/newrelic/go-agent/v3/newrelic"
@@ -23,6 +41,18 @@ type contextKeyType struct{}
 
 type hook struct {
 	segment newrelic.DatastoreSegment
+	// keyPatterns is true when the obfuscated pattern of the first key is
+	// recorded.
+	keyPatterns bool
+	// dialed holds the instance of the most recently dialed connection.  It
+	// is nil when the hook was created without options.
+	dialed *atomic.Value
+}
+
+// instance is the host and port, path, or id of a datastore instance.
+type instance struct {
+	host         string
+	portPathOrID string
 }
 
 var _ redis.Hook = (*hook)(nil)
@@ -31,79 +61,275 @@ var (
 	segmentContextKey = contextKeyType(struct{}{})
 )
 
+// HookOption configures the hook created by NewHook.
+type HookOption func(*hook)
+
+// WithKeyPatterns enables or disables recording the pattern of the first key
+// of each command in the ParameterizedQuery of its segment.  It is disabled by
+// default.  Each part of the key which contains a digit is replaced with "*",
+// so that "user:42:session" is recorded as "user:*:session", but parts
+// without digits, such as user names, are recorded as they are.  Only enable
+// it when the keys of the application do not hold such values.
+func WithKeyPatterns(enabled bool) HookOption {
+	return func(h *hook) {
+		h.keyPatterns = enabled
+	}
+}
+
 // NewHook creates a redis.Hook to instrument Redis calls.  Add it to your
 // client, then ensure that all calls contain a context which includes the
 // transaction.  The options are optional.  Provide them to get instance metrics
 // broken out by host and port.  The hook returned can be used with
 // redis.Client, redis.ClusterClient, and redis.Ring.
-func NewHook(opts *redis.Options) redis.Hook {
+//
+// When options are provided, the instance is taken from the address of the
+// connections the client dials, so that the hook of a client created by
+// redis.NewFailoverClient records the current master.  Pass the options of the
+// client, for example:
+//
+//	client.AddHook(nrredis.NewHook(client.Options()))
+func NewHook(opts *redis.Options, hookOpts ...HookOption) redis.Hook {
 	h := hook{}
 	h.segment.Product = newrelic.DatastoreRedis
+	for _, opt := range hookOpts {
+		opt(&h)
+	}
 	if opts == nil {
 		return h
 	}
+	h.dialed = &atomic.Value{}
+	if inst, ok := newInstance(opts.Network, opts.Addr); ok {
+		h.segment.Host = inst.host
+		h.segment.PortPathOrID = inst.portPathOrID
+	}
+	return h
+}
 
+// AddNodeHooks adds a hook created by NewHook to each node client of a
+// redis.ClusterClient or redis.Ring, so that commands are attributed to the
+// node they were routed to.  Call it before the client is first used, and do
+// not also add a hook to the client itself.  The hook options are passed to
+// NewHook.
+func AddNodeHooks(client interface {
+	OnNewNode(fn func(rdb *redis.Client))
+}, hookOpts ...HookOption) {
+	client.OnNewNode(func(rdb *redis.Client) {
+		rdb.AddHook(NewHook(rdb.Options(), hookOpts...))
+	})
+}
+
+// newInstance returns the instance for a network and address in the format
+// of redis.Options.
+func newInstance(network, addr string) (instance, bool) {
 	// Per https://pkg.go.dev/github.com/redis/go-redis#Options the
 	// network should either be tcp or unix, and the default is tcp.
-	if opts.Network == "unix" {
-		h.segment.Host = "localhost"
-		h.segment.PortPathOrID = opts.Addr
-		return h
+	if network == "unix" {
+		return instance{host: "localhost", portPathOrID: addr}, true
 	}
-	if host, port, err := net.SplitHostPort(opts.Addr); err == nil {
-		if host == "" {
-			host = "localhost"
-		}
-		h.segment.Host = host
-		h.segment.PortPathOrID = port
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return instance{}, false
 	}
-	return h
+	if host == "" {
+		host = "localhost"
+	}
+	return instance{host: host, portPathOrID: port}, true
 }
 
-func (h hook) before(ctx context.Context, operation string) context.Context {
+func (h hook) before(ctx context.Context, cmd redis.Cmder) context.Context {
 	txn := newrelic.FromContext(ctx)
 	if txn == nil {
 		return ctx
 	}
+	ctx = context.WithValue(ctx, segmentContextKey, h.startSegment(txn, cmd))
+	return ctx
+}
+
+func (h hook) startSegment(txn *newrelic.Transaction, cmd redis.Cmder) *newrelic.DatastoreSegment {
 	s := h.segment
 	s.StartTime = txn.StartSegmentNow()
-	s.Operation = operation
-	ctx = context.WithValue(ctx, segmentContextKey, &s)
-	return ctx
+	s.Operation = cmd.Name()
+	if !h.keyPatterns {
+		return &s
+	}
+	if pattern, ok := keyPattern(cmd); ok {
+		s.ParameterizedQuery = s.Operation + " " + pattern
+	}
+	return &s
 }
 
-func (h hook) after(ctx context.Context) {
-	if segment, ok := ctx.Value(segmentContextKey).(interface{ End() }); ok {
-		segment.End()
+func (h hook) endSegment(s *newrelic.DatastoreSegment) {
+	if h.dialed != nil {
+		if inst, ok := h.dialed.Load().(instance); ok {
+			s.Host = inst.host
+			s.PortPathOrID = inst.portPathOrID
+		}
 	}
+	s.End()
 }
 
-func pipelineOperation(cmds []redis.Cmder) string {
-	operations := make([]string, 0, len(cmds))
-	for _, cmd := range cmds {
-		operations = append(operations, cmd.Name())
+func (h hook) after(ctx context.Context) {
+	if segment, ok := ctx.Value(segmentContextKey).(*newrelic.DatastoreSegment); ok {
+		h.endSegment(segment)
 	}
-	return "pipeline:" + strings.Join(operations, ",")
 }
 
 func (h hook) DialHook(next redis.DialHook) redis.DialHook {
-	return next // just continue the hook
+	if h.dialed == nil {
+		return next // just continue the hook
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err == nil {
+			if inst, ok := newInstance(network, addr); ok {
+				h.dialed.Store(inst)
+			}
+		}
+		return conn, err
+	}
 }
 
 func (h hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
 	return func(ctx context.Context, cmd redis.Cmder) error {
-		ctx = h.before(ctx, cmd.Name())
+		ctx = h.before(ctx, cmd)
 		err := next(ctx, cmd)
 		h.after(ctx)
 		return err
 	}
 }
 
+// ProcessPipelineHook records a segment for each command of the pipeline.
+// The commands are sent together and the client does not report when each
+// of them completed, so each segment lasts for the whole pipeline.  The
+// segments are recorded on their own goroutines of the transaction, so that
+// they overlap rather than being nested or counted one after another.
 func (h hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
 	return func(ctx context.Context, cmds []redis.Cmder) error {
-		ctx = h.before(ctx, pipelineOperation(cmds))
+		txn := newrelic.FromContext(ctx)
+		if txn == nil {
+			return next(ctx, cmds)
+		}
+		segments := make([]*newrelic.DatastoreSegment, 0, len(cmds))
+		for _, cmd := range cmds {
+			segments = append(segments, h.startSegment(txn.NewGoroutine(), cmd))
+		}
 		err := next(ctx, cmds)
-		h.after(ctx)
+		for _, s := range segments {
+			h.endSegment(s)
+		}
 		return err
 	}
 }
+
+// keylessCommands are the commands whose first argument is not a key.
+var keylessCommands = map[string]bool{
+	"acl": true, "auth": true, "bgrewriteaof": true, "bgsave": true,
+	"client": true, "cluster": true, "command": true, "config": true,
+	"dbsize": true, "debug": true, "discard": true, "echo": true,
+	"exec": true, "failover": true, "flushall": true, "flushdb": true,
+	"function": true, "hello": true, "info": true, "lastsave": true,
+	"latency": true, "lolwut": true, "memory": true, "module": true,
+	"monitor": true, "multi": true, "ping": true, "psubscribe": true,
+	"pubsub": true, "punsubscribe": true, "quit": true, "randomkey": true,
+	"readonly": true, "readwrite": true, "replicaof": true, "reset": true,
+	"role": true, "save": true, "scan": true, "script": true,
+	"select": true, "shutdown": true, "slaveof": true, "slowlog": true,
+	"subscribe": true, "swapdb": true, "sync": true, "time": true,
+	"unsubscribe": true, "unwatch": true, "wait": true,
+}
+
+// keyPattern returns the obfuscated pattern of the first key of cmd.
+func keyPattern(cmd redis.Cmder) (string, bool) {
+	args := cmd.Args()
+	pos := 1
+	switch name := cmd.Name(); name {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro", "fcall", "fcall_ro":
+		// The script is followed by the number of keys and the keys.
+		if len(args) < 4 || argString(args[2]) == "0" {
+			return "", false
+		}
+		pos = 3
+	default:
+		if keylessCommands[name] {
+			return "", false
+		}
+	}
+	if len(args) <= pos {
+		return "", false
+	}
+	return obfuscateKey(argString(args[pos])), true
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// obfuscateKey replaces each part of key which contains a digit with "*".
+// Parts are separated by any character other than a letter, a digit, "_", or
+// "-", so that "user:42:session" becomes "user:*:session".
+func obfuscateKey(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	part := 0
+	hasDigit := false
+	flush := func(end int) {
+		if hasDigit {
+			b.WriteByte('*')
+		} else {
+			b.WriteString(key[part:end])
+		}
+		hasDigit = false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == '-', c >= 0x80:
+		default:
+			flush(i)
+			b.WriteByte(c)
+			part = i + 1
+		}
+	}
+	flush(len(key))
+	return b.String()
+}
+
+// RegisterPool registers the connection pool of a redis.Client,
+// redis.ClusterClient, or redis.Ring with the application so that its
+// statistics are reported as Datastore/Redis/Pool/* metrics.  Call the
+// returned function when the client is closed.
+func RegisterPool(app *newrelic.Application, client interface {
+	PoolStats() *redis.PoolStats
+}) (unregister func()) {
+	if nil == client {
+		return func() {}
+	}
+	return app.RegisterDatastorePool(newrelic.DatastoreRedis, func() newrelic.DatastorePoolStats {
+		return poolStats(client.PoolStats())
+	})
+}
+
+// poolStats converts the pool statistics.  The client does not report how
+// many times or for how long a connection was waited for, so the wait count
+// and wait duration are not reported.  Misses counts the times no idle
+// connection was available rather than waits, and Timeouts only the waits
+// which failed.
+func poolStats(s *redis.PoolStats) newrelic.DatastorePoolStats {
+	if nil == s {
+		return newrelic.DatastorePoolStats{}
+	}
+	return newrelic.DatastorePoolStats{
+		Open:  int(s.TotalConns),
+		InUse: int(s.TotalConns - s.IdleConns),
+		Idle:  int(s.IdleConns),
+	}
+}
@@ -39,9 +39,33 @@ func Example_clusterClient() {
 	})
 
 	//
-	// Step 1:  Add a nrredis.NewHook() to your redis cluster client.
+	// Step 1:  Add a nrredis.NewHook() to each node of your redis cluster
+	// client, so that commands are attributed to the node they were routed
+	// to.
 	//
-	client.AddHook(nrredis.NewHook(nil))
+	nrredis.AddNodeHooks(client)
+
+	//
+	// Step 2: Ensure that all client calls contain a context with includes
+	// the transaction.
+	//
+	txn := getTransaction()
+	ctx := newrelic.NewContext(context.Background(), txn)
+	pong, err := client.Ping(ctx).Result()
+	fmt.Println(pong, err)
+}
+
+func Example_failoverClient() {
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    "master",
+		SentinelAddrs: []string{":26379", ":26380", ":26381"},
+	})
+
+	//
+	// Step 1:  Add a nrredis.NewHook() with the options of your failover
+	// client, so that commands are attributed to the current master.
+	//
+	client.AddHook(nrredis.NewHook(client.Options()))
 
 	//
 	// Step 2: Ensure that all client calls contain a context with includes
@@ -109,8 +109,10 @@ func TestPingAndHelloWithPipeline(t *testing.T) {
 		{Name: "Datastore/Redis/all", Forced: nil},
 		{Name: "Datastore/Redis/allOther", Forced: nil},
 		{Name: "Datastore/instance/Redis/myhost/myport", Forced: nil},
-		{Name: "Datastore/operation/Redis/pipeline:ping,hello", Forced: nil},
-		{Name: "Datastore/operation/Redis/pipeline:ping,hello", Scope: "OtherTransaction/Go/txnName", Forced: nil},
+		{Name: "Datastore/operation/Redis/ping", Forced: nil},
+		{Name: "Datastore/operation/Redis/ping", Scope: "OtherTransaction/Go/txnName", Forced: nil},
+		{Name: "Datastore/operation/Redis/hello", Forced: nil},
+		{Name: "Datastore/operation/Redis/hello", Scope: "OtherTransaction/Go/txnName", Forced: nil},
 		{Name: "DurationByCaller/Unknown/Unknown/Unknown/Unknown/allOther", Forced: nil},
 		{Name: "DurationByCaller/Unknown/Unknown/Unknown/Unknown/all", Forced: nil},
 	})
@@ -192,3 +194,180 @@ func TestNewHookAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyPattern(t *testing.T) {
+	ctx := context.Background()
+	testcases := []struct {
+		cmd     redis.Cmder
+		pattern string
+		ok      bool
+	}{
+		{cmd: redis.NewStringCmd(ctx, "get", "user:42:session"), pattern: "user:*:session", ok: true},
+		{cmd: redis.NewStatusCmd(ctx, "set", "cache/v2/page.html", "value"), pattern: "cache/*/page.html", ok: true},
+		{cmd: redis.NewIntCmd(ctx, "del", "{user1000}.following", "other"), pattern: "{*}.following", ok: true},
+		{cmd: redis.NewIntCmd(ctx, "incr", "counter"), pattern: "counter", ok: true},
+		{cmd: redis.NewStringCmd(ctx, "hget", "order:3f2a9c:items", "field"), pattern: "order:*:items", ok: true},
+		{cmd: redis.NewCmd(ctx, "evalsha", "sha", 1, "lock:17"), pattern: "lock:*", ok: true},
+		{cmd: redis.NewCmd(ctx, "eval", "return 1", 0), ok: false},
+		{cmd: redis.NewStatusCmd(ctx, "ping"), ok: false},
+		{cmd: redis.NewStringCmd(ctx, "client", "getname"), ok: false},
+		{cmd: redis.NewStringCmd(ctx, "get"), ok: false},
+	}
+	for _, tc := range testcases {
+		pattern, ok := keyPattern(tc.cmd)
+		if pattern != tc.pattern || ok != tc.ok {
+			t.Errorf("incorrect key pattern for %v: expect=%q actual=%q", tc.cmd.Args(), tc.pattern, pattern)
+		}
+	}
+}
+
+func TestGetWithKeyPattern(t *testing.T) {
+	opts := &redis.Options{
+		Dialer: emptyDialer,
+		Addr:   "myhost:myport",
+	}
+	client := redis.NewClient(opts)
+
+	app := integrationsupport.NewTestApp(integrationsupport.SampleEverythingReplyFn, integrationsupport.DTEnabledCfgFn)
+	txn := app.StartTransaction("txnName")
+	ctx := newrelic.NewContext(context.Background(), txn)
+
+	client.AddHook(NewHook(opts, WithKeyPatterns(true)))
+	client.Get(ctx, "user:42:session")
+	txn.End()
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":      "Datastore/operation/Redis/get",
+				"category":  "datastore",
+				"component": "Redis",
+				"span.kind": "client",
+				"parentId":  internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				"db.statement":  "get user:*:session",
+				"peer.address":  "myhost:myport",
+				"peer.hostname": "myhost",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/txnName",
+				"transaction.name": "OtherTransaction/Go/txnName",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestGetWithoutKeyPattern(t *testing.T) {
+	opts := &redis.Options{
+		Dialer: emptyDialer,
+		Addr:   "myhost:myport",
+	}
+	client := redis.NewClient(opts)
+
+	app := integrationsupport.NewTestApp(integrationsupport.SampleEverythingReplyFn, integrationsupport.DTEnabledCfgFn)
+	txn := app.StartTransaction("txnName")
+	ctx := newrelic.NewContext(context.Background(), txn)
+
+	client.AddHook(NewHook(opts))
+	client.Get(ctx, "user:alice:session")
+	txn.End()
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":      "Datastore/operation/Redis/get",
+				"category":  "datastore",
+				"component": "Redis",
+				"span.kind": "client",
+				"parentId":  internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				"db.statement":  "'get' on 'unknown' using 'Redis'",
+				"peer.address":  "myhost:myport",
+				"peer.hostname": "myhost",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/txnName",
+				"transaction.name": "OtherTransaction/Go/txnName",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestDialedInstance(t *testing.T) {
+	// A failover client dials the current master rather than its Addr.
+	h := NewHook(&redis.Options{Addr: "FailoverClient"}).(hook)
+	if h.segment.Host != "" || h.segment.PortPathOrID != "" {
+		t.Error(h.segment.Host, h.segment.PortPathOrID)
+	}
+	dial := h.DialHook(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return &net.TCPConn{}, nil
+	})
+	if _, err := dial(context.Background(), "tcp", "master:6380"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := integrationsupport.NewTestApp(nil, nil)
+	txn := app.StartTransaction("txnName")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	process := h.ProcessHook(func(context.Context, redis.Cmder) error { return nil })
+	process(ctx, redis.NewStringCmd(ctx, "get", "key"))
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Datastore/instance/Redis/master/6380", Forced: nil},
+	})
+}
+
+func TestAddNodeHooks(t *testing.T) {
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Dialer:       emptyDialer,
+		MaxRedirects: -1,
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: "node1:7000"}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: "node2:7001"}}},
+			}, nil
+		},
+	})
+	AddNodeHooks(client)
+
+	app := integrationsupport.NewTestApp(nil, nil)
+	txn := app.StartTransaction("txnName")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	// "a" hashes to slot 15495 and "b" to slot 3300.
+	client.Get(ctx, "a")
+	client.Get(ctx, "b")
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Datastore/instance/Redis/node1/7000", Forced: nil},
+		{Name: "Datastore/instance/Redis/node2/7001", Forced: nil},
+	})
+}
+
+func TestPoolStats(t *testing.T) {
+	got := poolStats(&redis.PoolStats{Hits: 9, Misses: 4, Timeouts: 1, TotalConns: 5, IdleConns: 2})
+	want := newrelic.DatastorePoolStats{Open: 5, InUse: 3, Idle: 2}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := poolStats(nil); got != (newrelic.DatastorePoolStats{}) {
+		t.Error(got)
+	}
+}
+
+func TestRegisterPool(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Dialer: emptyDialer})
+	RegisterPool(nil, client)()
+	RegisterPool(nil, nil)()
+}
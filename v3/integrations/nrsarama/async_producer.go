@@ -0,0 +1,169 @@
+package nrsarama
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// AsyncProducerWrapper instruments a sarama.AsyncProducer.  Messages sent
+// using SendMessage are recorded as a newrelic.MessageProducerSegment of the
+// transaction in the context, and distributed trace headers are added to the
+// headers of the message.  The segment ends when the message is returned on
+// the Successes or Errors channel of the wrapper, or as soon as it is sent
+// when Producer.Return.Successes is disabled.
+//
+// The Successes and Errors channels of the wrapper must be read instead of
+// those of the wrapped producer.
+type AsyncProducerWrapper struct {
+	sarama.AsyncProducer
+	returnSuccesses bool
+	returnErrors    bool
+	successes       chan *sarama.ProducerMessage
+	errors          chan *sarama.ProducerError
+}
+
+// asyncMessage replaces the Metadata of a message while it is in flight.
+type asyncMessage struct {
+	metadata interface{}
+	txn      *newrelic.Transaction
+	segment  *newrelic.MessageProducerSegment
+}
+
+// NewAsyncProducerWrapper wraps producer, which must have been created using
+// config.
+func NewAsyncProducerWrapper(producer sarama.AsyncProducer, config *sarama.Config) *AsyncProducerWrapper {
+	if nil == config {
+		config = sarama.NewConfig()
+	}
+	pw := &AsyncProducerWrapper{
+		AsyncProducer:   producer,
+		returnSuccesses: config.Producer.Return.Successes,
+		returnErrors:    config.Producer.Return.Errors,
+		successes:       make(chan *sarama.ProducerMessage, config.ChannelBufferSize),
+		errors:          make(chan *sarama.ProducerError, config.ChannelBufferSize),
+	}
+	go func() {
+		defer close(pw.successes)
+		for msg := range producer.Successes() {
+			endAsyncMessage(msg, true)
+			pw.successes <- msg
+		}
+	}()
+	go func() {
+		defer close(pw.errors)
+		for pe := range producer.Errors() {
+			endAsyncMessage(pe.Msg, false)
+			pw.errors <- pe
+		}
+	}()
+	return pw
+}
+
+// SendMessage sends msg on the Input channel of the producer.  If ctx
+// contains a transaction, the message is recorded as a producer segment.
+func (pw *AsyncProducerWrapper) SendMessage(ctx context.Context, msg *sarama.ProducerMessage) {
+	txn := newrelic.FromContext(ctx)
+	if nil == txn {
+		pw.Input() <- msg
+		return
+	}
+	// The segment is ended on the goroutine reading the Successes and Errors
+	// channels, so it is given its own goroutine within the transaction.
+	txn = txn.NewGoroutine()
+	s := &newrelic.MessageProducerSegment{
+		StartTime:       txn.StartSegmentNow(),
+		Library:         "Kafka",
+		DestinationType: newrelic.MessageTopic,
+		DestinationName: msg.Topic,
+	}
+	insertDistributedTraceHeaders(txn, msg)
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeSpanKind, "producer")
+	integrationsupport.AddAgentSpanAttribute(txn, newrelic.AttributeMessageDestinationName, msg.Topic)
+
+	if !pw.returnSuccesses {
+		pw.Input() <- msg
+		s.End()
+		return
+	}
+	msg.Metadata = &asyncMessage{metadata: msg.Metadata, txn: txn, segment: s}
+	pw.Input() <- msg
+}
+
+// Successes returns the successfully produced messages when
+// Producer.Return.Successes is enabled.
+func (pw *AsyncProducerWrapper) Successes() <-chan *sarama.ProducerMessage {
+	return pw.successes
+}
+
+// Errors returns the messages which could not be produced when
+// Producer.Return.Errors is enabled.
+func (pw *AsyncProducerWrapper) Errors() <-chan *sarama.ProducerError {
+	return pw.errors
+}
+
+// Close shuts down the producer and waits for in flight messages, like
+// sarama.AsyncProducer.Close.
+func (pw *AsyncProducerWrapper) Close() error {
+	pw.AsyncClose()
+	if pw.returnSuccesses {
+		go func() {
+			for range pw.successes {
+			}
+		}()
+	}
+	var errs sarama.ProducerErrors
+	for pe := range pw.errors {
+		errs = append(errs, pe)
+	}
+	if pw.returnErrors && len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// endAsyncMessage ends the segment of a message sent by SendMessage and
+// restores its Metadata.
+func endAsyncMessage(msg *sarama.ProducerMessage, success bool) {
+	if nil == msg {
+		return
+	}
+	am, ok := msg.Metadata.(*asyncMessage)
+	if !ok {
+		return
+	}
+	msg.Metadata = am.metadata
+	if success {
+		integrationsupport.AddAgentSpanAttribute(am.txn, newrelic.AttributeMessagingDestinationPartitionID, strconv.Itoa(int(msg.Partition)))
+		integrationsupport.AddAgentSpanAttribute(am.txn, newrelic.AttributeKafkaMessageOffset, strconv.FormatInt(msg.Offset, 10))
+	}
+	am.segment.End()
+}
+
+// insertDistributedTraceHeaders adds the distributed trace headers of txn to
+// msg, replacing any headers with the same keys.
+func insertDistributedTraceHeaders(txn *newrelic.Transaction, msg *sarama.ProducerMessage) {
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	if len(hdrs) == 0 {
+		return
+	}
+	headers := msg.Headers[:0:0]
+	for _, h := range msg.Headers {
+		if _, ok := hdrs[http.CanonicalHeaderKey(string(h.Key))]; !ok {
+			headers = append(headers, h)
+		}
+	}
+	msg.Headers = headers
+	carrier := &KafkaMessageCarrier{Header: make(http.Header), msg: msg}
+	for key, vals := range hdrs {
+		for _, val := range vals {
+			carrier.Set(key, val)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package nrsarama
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// BatchConsumerHandler is a sarama.ConsumerGroupHandler which consumes the
+// messages of a claim in batches, with one transaction per batch.
+type BatchConsumerHandler struct {
+	app           *newrelic.Application
+	topic         string
+	clientID      string
+	saramaConfig  *sarama.Config
+	batchSize     int
+	flushInterval time.Duration
+	batchHandler  func(ctx context.Context, messages []*sarama.ConsumerMessage)
+}
+
+// NewBatchConsumerHandler creates a handler which calls batchHandler once
+// batchSize messages of a claim have been received, or once flushInterval
+// has passed since the first message of an incomplete batch was received.
+// A flushInterval of zero waits for a full batch.  The context passed to
+// batchHandler contains the transaction of the batch.  Transactions are
+// named after the topic of the claim; topic is used only for claims which
+// have none.
+func NewBatchConsumerHandler(app *newrelic.Application, topic string, clientID string, saramaConfig *sarama.Config, batchSize int, flushInterval time.Duration, batchHandler func(ctx context.Context, messages []*sarama.ConsumerMessage)) *BatchConsumerHandler {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &BatchConsumerHandler{
+		app:           app,
+		topic:         topic,
+		clientID:      clientID,
+		saramaConfig:  saramaConfig,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batchHandler:  batchHandler,
+	}
+}
+
+// Setup is ran at the beginning of a new session
+func (bh *BatchConsumerHandler) Setup(_ sarama.ConsumerGroupSession) error {
+	if nil == bh.app || nil == bh.saramaConfig {
+		return nil
+	}
+	bh.app.RecordCustomMetric("MessageBroker/Kafka/Heartbeat/SessionTimeout", bh.saramaConfig.Consumer.Group.Session.Timeout.Seconds())
+	bh.app.RecordCustomMetric("MessageBroker/Kafka/Heartbeat/PollTimeout", bh.saramaConfig.Consumer.Group.Heartbeat.Interval.Seconds())
+	return nil
+}
+
+// Cleanup is ran at the end of a new session
+func (bh *BatchConsumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim consumes the messages of the claim in batches until the claim
+// is closed or the session ends.  Messages of an incomplete batch are
+// processed when the claim is closed, and left unmarked when the session
+// ends.
+func (bh *BatchConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	batch := make([]*sarama.ConsumerMessage, 0, bh.batchSize)
+	var timer *time.Timer
+	var flush <-chan time.Time
+	process := func() {
+		if nil != timer {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+		if len(batch) > 0 {
+			bh.processBatch(session, claim, batch)
+			batch = make([]*sarama.ConsumerMessage, 0, bh.batchSize)
+		}
+	}
+	var done <-chan struct{}
+	if ctx := session.Context(); nil != ctx {
+		done = ctx.Done()
+	}
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				process()
+				return nil
+			}
+			batch = append(batch, message)
+			if len(batch) >= bh.batchSize {
+				process()
+			} else if nil == timer && bh.flushInterval > 0 {
+				timer = time.NewTimer(bh.flushInterval)
+				flush = timer.C
+			}
+		case <-flush:
+			timer, flush = nil, nil
+			process()
+		case <-done:
+			if nil != timer {
+				timer.Stop()
+			}
+			return nil
+		}
+	}
+}
+
+// claimTopic returns the topic of the claim, or the topic given to
+// NewBatchConsumerHandler if the claim has none.
+func (bh *BatchConsumerHandler) claimTopic(claim sarama.ConsumerGroupClaim) string {
+	if topic := claim.Topic(); topic != "" {
+		return topic
+	}
+	return bh.topic
+}
+
+func (bh *BatchConsumerHandler) processBatch(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, messages []*sarama.ConsumerMessage) {
+	topic := bh.claimTopic(claim)
+	txn := bh.startBatchTransaction(topic, claim, messages)
+	ctx := newrelic.NewContext(context.Background(), txn)
+
+	segment := txn.StartSegment("Message/Kafka/Topic/Consume/Named/" + topic + "/MessageProcessing/")
+	bh.batchHandler(ctx, messages)
+	segment.End()
+
+	var byteCount float64
+	for _, message := range messages {
+		byteCount += float64(len(message.Value))
+		session.MarkMessage(message, "")
+	}
+	if nil != txn {
+		txn.AddAttribute("kafka.consume.byteCount", byteCount)
+		txn.AddAttribute("kafka.consume.ClientID", bh.clientID)
+		app := txn.Application()
+		app.RecordCustomMetric("Message/Kafka/Topic/Named/"+topic+"/Received/Bytes", byteCount)
+		app.RecordCustomMetric("Message/Kafka/Topic/Named/"+topic+"/Received/Messages", float64(len(messages)))
+		app.RecordCustomMetric("MessageBroker/Kafka/Heartbeat/Receive", 1.0)
+	}
+	txn.End()
+}
+
+// messageHeaders returns the headers of a consumed message.
+func messageHeaders(message *sarama.ConsumerMessage) http.Header {
+	hdrs := http.Header{}
+	for _, hdr := range message.Headers {
+		if nil != hdr {
+			hdrs.Add(string(hdr.Key), string(hdr.Value))
+		}
+	}
+	return hdrs
+}
+
+func hasTraceHeaders(hdrs http.Header) bool {
+	return hdrs.Get(newrelic.DistributedTraceW3CTraceParentHeader) != "" ||
+		hdrs.Get(newrelic.DistributedTraceNewRelicHeader) != ""
+}
+
+// startBatchTransaction starts the transaction of a batch.  The transaction
+// accepts the distributed trace headers of the first message which has them,
+// and adds a span link to the upstream trace of each other message which has
+// them, so that the lineage of every message is kept.
+func (bh *BatchConsumerHandler) startBatchTransaction(topic string, claim sarama.ConsumerGroupClaim, messages []*sarama.ConsumerMessage) *newrelic.Transaction {
+	if nil == bh.app {
+		return nil
+	}
+	namer := internal.MessageMetricKey{
+		Library:         "Kafka",
+		DestinationType: string(newrelic.MessageTopic),
+		DestinationName: topic,
+		Consumer:        true,
+	}
+	txn := bh.app.StartTransaction(namer.Name())
+	accepted := false
+	for _, message := range messages {
+		hdrs := messageHeaders(message)
+		if !hasTraceHeaders(hdrs) {
+			continue
+		}
+		if accepted {
+			txn.AddSpanLink(hdrs)
+		} else {
+			txn.AcceptDistributedTraceHeaders(newrelic.TransportKafka, hdrs)
+			accepted = true
+		}
+	}
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeSpanKind, "consumer", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessageDestinationName, topic, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingBatchMessageCount, "", len(messages))
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeMessagingDestinationPartitionID, "", claim.Partition())
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaMessageOffset, "", messages[0].Offset)
+	if hwm := claim.HighWaterMarkOffset(); hwm > 0 {
+		lag := hwm - messages[len(messages)-1].Offset - 1
+		if lag < 0 {
+			lag = 0
+		}
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeKafkaConsumerLag, "", lag)
+	}
+	return txn
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/go-agent/v3/integrations/nrsarama"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var brokers = []string{"localhost:9092"}
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigDebugLogger(os.Stdout),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	// Producer segments end when the message is returned on the Successes
+	// or Errors channel.
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	pw := nrsarama.NewAsyncProducerWrapper(producer, config)
+
+	txn := app.StartTransaction("kafkaproducer")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	numMessages := 10
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			pw.SendMessage(ctx, &sarama.ProducerMessage{
+				Topic: "topicName",
+				Value: sarama.StringEncoder("test Message " + strconv.Itoa(i)),
+			})
+		}
+	}()
+	for i := 0; i < numMessages; i++ {
+		select {
+		case msg := <-pw.Successes():
+			fmt.Printf("Sent to partition %v and the offset is %v\n", msg.Partition, msg.Offset)
+		case perr := <-pw.Errors():
+			fmt.Println(perr)
+		}
+	}
+	txn.End()
+	pw.Close()
+
+	app.Shutdown(10 * time.Second)
+}
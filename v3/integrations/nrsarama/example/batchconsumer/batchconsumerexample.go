@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/go-agent/v3/integrations/nrsarama"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var brokers = []string{"localhost:9092"}
+
+// Custom batch handler that controls what happens when a batch of messages is received by the consumer
+func batchHandler(ctx context.Context, msgs []*sarama.ConsumerMessage) {
+	defer newrelic.FromContext(ctx).StartSegment("processBatch").End()
+	log.Printf("received %d messages\n", len(msgs))
+}
+
+func main() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Kafka App"),
+		newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+		newrelic.ConfigDebugLogger(os.Stdout),
+		newrelic.ConfigDistributedTracerEnabled(true),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Wait for the application to connect.
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		fmt.Println(err)
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "CustomClientID"
+
+	consumerGroup, err := sarama.NewConsumerGroup(brokers, "test-group", config)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer consumerGroup.Close()
+	kafkaTopicName := "topicName"
+
+	// Each transaction covers up to 50 messages, or the messages received
+	// within a second.
+	handler := nrsarama.NewBatchConsumerHandler(app, kafkaTopicName, config.ClientID, config, 50, time.Second, batchHandler)
+	for {
+		if err := consumerGroup.Consume(context.Background(), []string{kafkaTopicName}, handler); nil != err {
+			fmt.Println(err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,7 +27,7 @@ func (m *MockConsumerGroupSession) MarkOffset(topic string, partition int32, off
 }
 func (m *MockConsumerGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
 }
-func (m *MockConsumerGroupSession) Context() context.Context   { return nil }
+func (m *MockConsumerGroupSession) Context() context.Context   { return context.Background() }
 func (m *MockConsumerGroupSession) Claims() map[string][]int32 { return nil }
 func (m *MockConsumerGroupSession) MemberID() string           { return "" }
 func (m *MockConsumerGroupSession) GenerationID() int32        { return 0 }
@@ -170,3 +171,339 @@ func TestConsumerHandlerFromTxn(t *testing.T) {
 	})
 
 }
+
+type MockConsumerGroupClaim struct {
+	topic         string
+	partition     int32
+	highWaterMark int64
+	messages      chan *sarama.ConsumerMessage
+}
+
+func newMockConsumerGroupClaim(topic string, partition int32, highWaterMark int64, msgs ...*sarama.ConsumerMessage) *MockConsumerGroupClaim {
+	claim := &MockConsumerGroupClaim{
+		topic:         topic,
+		partition:     partition,
+		highWaterMark: highWaterMark,
+		messages:      make(chan *sarama.ConsumerMessage, len(msgs)),
+	}
+	for _, msg := range msgs {
+		claim.messages <- msg
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (m *MockConsumerGroupClaim) Topic() string                            { return m.topic }
+func (m *MockConsumerGroupClaim) Partition() int32                         { return m.partition }
+func (m *MockConsumerGroupClaim) InitialOffset() int64                     { return 0 }
+func (m *MockConsumerGroupClaim) HighWaterMarkOffset() int64               { return m.highWaterMark }
+func (m *MockConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return m.messages }
+
+var replyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func dtTestApp() integrationsupport.ExpectApp {
+	return integrationsupport.NewTestApp(replyFn, integrationsupport.DTEnabledCfgFn,
+		newrelic.ConfigCodeLevelMetricsEnabled(false))
+}
+
+func headerValue(headers []sarama.RecordHeader, key string) (string, int) {
+	var val string
+	var count int
+	for _, h := range headers {
+		if string(h.Key) == key {
+			val = string(h.Value)
+			count++
+		}
+	}
+	return val, count
+}
+
+func TestProducerSendMessageHeaders(t *testing.T) {
+	app := dtTestApp()
+	var sent *sarama.ProducerMessage
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		sent = msg
+		return nil
+	})
+	txn := app.StartTransaction("kafkaproducer")
+	if err := NewProducerWrapper(producer, txn).SendMessage("topicName", []byte("key"), []byte("value")); nil != err {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	if _, n := headerValue(sent.Headers, newrelic.DistributedTraceW3CTraceParentHeader); n != 1 {
+		t.Error(sent.Headers)
+	}
+}
+
+func TestAsyncProducerWrapper(t *testing.T) {
+	app := dtTestApp()
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	pw := NewAsyncProducerWrapper(producer, config)
+
+	txn := app.StartTransaction("kafkaproducer")
+	ctx := newrelic.NewContext(context.Background(), txn)
+	pw.SendMessage(ctx, &sarama.ProducerMessage{
+		Topic:    "topicName",
+		Value:    sarama.StringEncoder("first"),
+		Headers:  []sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("stale")}},
+		Metadata: 1,
+	})
+	pw.SendMessage(ctx, &sarama.ProducerMessage{Topic: "topicName", Value: sarama.StringEncoder("second"), Metadata: 2})
+
+	success := <-pw.Successes()
+	if success.Metadata != 1 {
+		t.Error(success.Metadata)
+	}
+	if val, n := headerValue(success.Headers, newrelic.DistributedTraceW3CTraceParentHeader); n != 1 || val == "stale" {
+		t.Error(success.Headers)
+	}
+	if _, n := headerValue(success.Headers, "traceparent"); n != 0 {
+		t.Error(success.Headers)
+	}
+	pe := <-pw.Errors()
+	if pe.Err != sarama.ErrOutOfBrokers || pe.Msg.Metadata != 2 {
+		t.Error(pe)
+	}
+	if err := pw.Close(); nil != err {
+		t.Error(err)
+	}
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/topicName", Scope: "OtherTransaction/Go/kafkaproducer", Forced: false, Data: []float64{2}},
+		{Name: "MessageBroker/Kafka/Topic/Produce/Named/topicName", Scope: "", Forced: false, Data: []float64{2}},
+	})
+}
+
+func TestAsyncProducerWrapperWithoutSuccesses(t *testing.T) {
+	app := dtTestApp()
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = false
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	pw := NewAsyncProducerWrapper(producer, config)
+
+	txn := app.StartTransaction("kafkaproducer")
+	msg := &sarama.ProducerMessage{Topic: "topicName", Value: sarama.StringEncoder("value"), Metadata: "meta"}
+	pw.SendMessage(newrelic.NewContext(context.Background(), txn), msg)
+	txn.End()
+	if err := pw.Close(); nil != err {
+		t.Error(err)
+	}
+	if msg.Metadata != "meta" {
+		t.Error(msg.Metadata)
+	}
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "MessageBroker/Kafka/Topic/Produce/Named/topicName",
+				"category": "generic",
+				"parentId": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:               "producer",
+				newrelic.AttributeMessageDestinationName: "topicName",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/kafkaproducer",
+				"transaction.name": "OtherTransaction/Go/kafkaproducer",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestAsyncProducerWrapperWithoutTransaction(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	producer.ExpectInputAndSucceed()
+	pw := NewAsyncProducerWrapper(producer, nil)
+	msg := &sarama.ProducerMessage{Topic: "topicName", Value: sarama.StringEncoder("value")}
+	pw.SendMessage(context.Background(), msg)
+	if err := pw.Close(); nil != err {
+		t.Error(err)
+	}
+	if len(msg.Headers) != 0 {
+		t.Error(msg.Headers)
+	}
+}
+
+func upstreamMessage(app integrationsupport.ExpectApp, offset int64) *sarama.ConsumerMessage {
+	txn := app.StartTransaction("upstream")
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	txn.End()
+	msg := &sarama.ConsumerMessage{Topic: "topicName", Partition: 3, Offset: offset, Value: []byte("value")}
+	for key := range hdrs {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(hdrs.Get(key))})
+	}
+	return msg
+}
+
+// traceParentIDs returns the trace and span IDs of the traceparent header of
+// a message.
+func traceParentIDs(t *testing.T, msg *sarama.ConsumerMessage) (string, string) {
+	for _, hdr := range msg.Headers {
+		if string(hdr.Key) != newrelic.DistributedTraceW3CTraceParentHeader {
+			continue
+		}
+		parts := strings.Split(string(hdr.Value), "-")
+		if len(parts) != 4 {
+			t.Fatal(string(hdr.Value))
+		}
+		return parts[1], parts[2]
+	}
+	t.Fatal("missing traceparent header")
+	return "", ""
+}
+
+func TestBatchConsumerHandler(t *testing.T) {
+	app := dtTestApp()
+	first := upstreamMessage(app, 10)
+	second := upstreamMessage(app, 11)
+	firstTraceID, _ := traceParentIDs(t, first)
+	secondTraceID, secondSpanID := traceParentIDs(t, second)
+	claim := newMockConsumerGroupClaim("topicName", 3, 20,
+		first,
+		second,
+		&sarama.ConsumerMessage{Topic: "topicName", Partition: 3, Offset: 12, Value: []byte("value")},
+	)
+
+	var batches [][]int64
+	// The transactions are named after the topic of the claim rather than
+	// the topic given here.
+	bh := NewBatchConsumerHandler(app.Application, "otherTopic", "CustomClientID", sarama.NewConfig(), 2, 0,
+		func(ctx context.Context, msgs []*sarama.ConsumerMessage) {
+			if nil == newrelic.FromContext(ctx) {
+				t.Error("missing transaction")
+			}
+			var offsets []int64
+			for _, msg := range msgs {
+				offsets = append(offsets, msg.Offset)
+			}
+			batches = append(batches, offsets)
+		})
+	if err := bh.ConsumeClaim(new(MockConsumerGroupSession), claim); nil != err {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(batches, [][]int64{{10, 11}, {12}}) {
+		t.Error(batches)
+	}
+
+	upstreamEvent := internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/upstream",
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+		},
+	}
+	batchIntrinsics := map[string]interface{}{
+		"name":     "OtherTransaction/Go/Message/Kafka/Topic/Named/topicName",
+		"guid":     internal.MatchAnything,
+		"traceId":  internal.MatchAnything,
+		"priority": internal.MatchAnything,
+		"sampled":  internal.MatchAnything,
+	}
+	// The first batch continues the trace of its first message.
+	acceptedIntrinsics := map[string]interface{}{
+		"name":                     "OtherTransaction/Go/Message/Kafka/Topic/Named/topicName",
+		"guid":                     internal.MatchAnything,
+		"traceId":                  firstTraceID,
+		"priority":                 internal.MatchAnything,
+		"sampled":                  internal.MatchAnything,
+		"parent.type":              "App",
+		"parent.account":           internal.MatchAnything,
+		"parent.app":               internal.MatchAnything,
+		"parent.transportType":     "Kafka",
+		"parent.transportDuration": internal.MatchAnything,
+		"parentId":                 internal.MatchAnything,
+		"parentSpanId":             internal.MatchAnything,
+	}
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		upstreamEvent,
+		upstreamEvent,
+		{
+			Intrinsics: acceptedIntrinsics,
+			UserAttributes: map[string]interface{}{
+				"kafka.consume.byteCount": 10,
+				"kafka.consume.ClientID":  "CustomClientID",
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "topicName",
+				newrelic.AttributeMessagingBatchMessageCount:      2,
+				newrelic.AttributeMessagingDestinationPartitionID: 3,
+				newrelic.AttributeKafkaMessageOffset:              10,
+				newrelic.AttributeKafkaConsumerLag:                8,
+			},
+		},
+		{
+			Intrinsics: batchIntrinsics,
+			UserAttributes: map[string]interface{}{
+				"kafka.consume.byteCount": 5,
+				"kafka.consume.ClientID":  "CustomClientID",
+			},
+			AgentAttributes: map[string]interface{}{
+				newrelic.AttributeSpanKind:                        "consumer",
+				newrelic.AttributeMessageDestinationName:          "topicName",
+				newrelic.AttributeMessagingBatchMessageCount:      1,
+				newrelic.AttributeMessagingDestinationPartitionID: 3,
+				newrelic.AttributeKafkaMessageOffset:              12,
+				newrelic.AttributeKafkaConsumerLag:                7,
+			},
+		},
+	})
+	// The upstream transactions and the batch transactions each have a root
+	// span, and the batch transactions have a message processing span.  The
+	// root span of the first batch links to the trace of its second message.
+	spans := make([]internal.WantEvent, 2+2*2+1)
+	spans[4] = internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"type":          "SpanLink",
+			"id":            internal.MatchAnything,
+			"trace.id":      firstTraceID,
+			"linkedTraceId": secondTraceID,
+			"linkedSpanId":  secondSpanID,
+			"timestamp":     internal.MatchAnything,
+		},
+	}
+	app.ExpectSpanEvents(t, spans)
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/Message/Kafka/Topic/Named/topicName/Received/Messages", Scope: "", Forced: false, Data: []float64{2, 3, 3, 1, 2, 5}},
+	})
+}
+
+func TestBatchConsumerHandlerFlushInterval(t *testing.T) {
+	app := dtTestApp()
+	claim := &MockConsumerGroupClaim{topic: "topicName", messages: make(chan *sarama.ConsumerMessage)}
+	flushed := make(chan []*sarama.ConsumerMessage)
+	bh := NewBatchConsumerHandler(app.Application, "topicName", "CustomClientID", sarama.NewConfig(), 10, 10*time.Millisecond,
+		func(ctx context.Context, msgs []*sarama.ConsumerMessage) { flushed <- msgs })
+
+	done := make(chan error)
+	go func() { done <- bh.ConsumeClaim(new(MockConsumerGroupSession), claim) }()
+	claim.messages <- &sarama.ConsumerMessage{Topic: "topicName", Offset: 1}
+	if msgs := <-flushed; len(msgs) != 1 || msgs[0].Offset != 1 {
+		t.Error(msgs)
+	}
+	close(claim.messages)
+	if err := <-done; nil != err {
+		t.Error(err)
+	}
+}
@@ -60,8 +60,7 @@ func (pw *ProducerWrapper) SendMessage(topic string, key []byte, value []byte) e
 		Value: encodedValue,
 	}
 	// DT Headers
-	carrier := pw.carrier(msg)
-	pw.txn.InsertDistributedTraceHeaders(carrier.Header)
+	insertDistributedTraceHeaders(pw.txn, msg)
 
 	// Send message using kafka producer
 	producerSegment := pw.txn.StartSegment("MessageBroker/Kafka/Topic/Produce/Named/" + topic)
@@ -95,6 +95,9 @@ type WantSlowQuery struct {
 	Host         string
 	PortPathOrID string
 	Params       map[string]interface{}
+	// ExplainPlan holds the columns followed by the rows of the explain
+	// plan of the query.  It is not checked if nil.
+	ExplainPlan [][]string
 }
 
 // HarvestTestinger is implemented by the app.  It sets an empty test harvest
@@ -112,6 +115,22 @@ func HarvestTesting(app interface{}, replyfn func(*ConnectReply)) {
 	ta.HarvestTesting(replyfn)
 }
 
+// HarvestTestPayloader is implemented by the app.  It returns the data in the
+// test harvest encoded as it would be sent to each collector endpoint.
+type HarvestTestPayloader interface {
+	HarvestTestPayloads() map[string][]byte
+}
+
+// HarvestTestPayloads allows the newrelictest package to read the data in the
+// test harvest.
+func HarvestTestPayloads(app interface{}) map[string][]byte {
+	tp, ok := app.(HarvestTestPayloader)
+	if !ok {
+		panic("HarvestTestPayloads type assertion failure")
+	}
+	return tp.HarvestTestPayloads()
+}
+
 // WantTxn provides the expectation parameters to ExpectTxnMetrics.
 type WantTxn struct {
 	Name          string
@@ -0,0 +1,487 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package yaml decodes the subset of YAML used by New Relic agent
+// configuration files.  It exists so that the agent can read newrelic.yml
+// files without depending on a third party YAML library.
+//
+// The supported subset is block mappings, block sequences, flow sequences and
+// mappings of scalars, comments, plain, single quoted, and double quoted
+// scalars, and anchors, aliases, and merge keys, which newrelic.yml files use
+// to share a common section between environments.  Tags, block scalars, and
+// multiple documents are not supported and result in an error.
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type line struct {
+	num    int
+	indent int
+	text   string
+}
+
+// SyntaxError describes a line which could not be decoded.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("yaml: line %d: %s", e.Line, e.Msg)
+}
+
+func errorf(l line, format string, args ...interface{}) error {
+	return &SyntaxError{Line: l.num, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Unmarshal decodes a YAML document.  Mappings are returned as
+// map[string]interface{}, sequences as []interface{}, and scalars as string,
+// bool, int64, float64, or nil.  Integers with leading zeros or which do not
+// fit in an int64 are returned as strings.
+func Unmarshal(data []byte) (interface{}, error) {
+	var lines []line
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		l := line{num: i + 1, indent: len(text) - len(trimmed), text: trimmed}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, errorf(l, "tabs cannot be used for indentation")
+		}
+		if trimmed == "---" && len(lines) == 0 {
+			continue
+		}
+		if trimmed == "---" || trimmed == "..." {
+			return nil, errorf(l, "multiple documents are not supported")
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &parser{lines: lines, anchors: map[string]interface{}{}}
+	v, err := p.block(lines[0].indent)
+	if nil != err {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, errorf(p.lines[p.pos], "unexpected indentation")
+	}
+	return v, nil
+}
+
+// stripComment removes a comment which is not within quotes.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+type parser struct {
+	lines []line
+	pos   int
+	// anchors holds the values recorded by anchors, by name.
+	anchors map[string]interface{}
+}
+
+// mergeKey is the key whose value, a mapping or a sequence of mappings, is
+// merged into the mapping containing it.  Keys of the mapping take precedence
+// over merged keys, and earlier mappings of a sequence take precedence over
+// later ones.
+const mergeKey = "<<"
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *parser) block(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// nested decodes the value of a mapping key or sequence item which has no
+// value on its own line.
+func (p *parser) nested(indent int, allowSequence bool) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent {
+		return p.block(next.indent)
+	}
+	// Sequences may have the same indentation as their mapping key.
+	if allowSequence && next.indent == indent && isSequenceItem(next.text) {
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+func (p *parser) sequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isSequenceItem(l.text)) {
+			break
+		}
+		if l.indent > indent || !isSequenceItem(l.text) {
+			return nil, errorf(l, "unexpected indentation")
+		}
+		p.pos++
+		item := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		if _, _, ok := splitKey(item); ok {
+			return nil, errorf(l, "mappings within sequences are not supported")
+		}
+		v, err := p.anchored(l, item, func() (interface{}, error) {
+			return p.nested(indent, false)
+		})
+		if nil != err {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+func (p *parser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	var merges []map[string]interface{}
+	merged := false
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorf(l, "unexpected indentation")
+		}
+		if isSequenceItem(l.text) {
+			break
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, errorf(l, "expected a key and value")
+		}
+		k, err := keyString(l, key)
+		if nil != err {
+			return nil, err
+		}
+		isMerge := key == mergeKey
+		if _, dup := m[k]; (dup && !isMerge) || (isMerge && merged) {
+			return nil, errorf(l, "duplicate key %q", k)
+		}
+		p.pos++
+		v, err := p.anchored(l, rest, func() (interface{}, error) {
+			return p.nested(indent, true)
+		})
+		if nil != err {
+			return nil, err
+		}
+		if isMerge {
+			if merges, err = mergedMappings(l, v); nil != err {
+				return nil, err
+			}
+			merged = true
+			continue
+		}
+		m[k] = v
+	}
+	for _, mm := range merges {
+		for k, v := range mm {
+			if _, ok := m[k]; !ok {
+				m[k] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+// mergedMappings returns the mappings given as the value of a merge key.
+func mergedMappings(l line, v interface{}) ([]map[string]interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{x}, nil
+	case []interface{}:
+		ms := make([]map[string]interface{}, 0, len(x))
+		for _, item := range x {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errorf(l, "merge key values must be mappings")
+			}
+			ms = append(ms, m)
+		}
+		return ms, nil
+	}
+	return nil, errorf(l, "merge key values must be mappings")
+}
+
+// anchored decodes the value of a mapping key or sequence item, which may be
+// preceded by an anchor recording the value.  nested decodes the value when
+// it is not written on the same line.
+func (p *parser) anchored(l line, text string, nested func() (interface{}, error)) (interface{}, error) {
+	var name string
+	if strings.HasPrefix(text, "&") {
+		name = text[1:]
+		text = ""
+		if idx := strings.IndexAny(name, " \t"); idx >= 0 {
+			name, text = name[:idx], strings.TrimSpace(name[idx:])
+		}
+		if name == "" {
+			return nil, errorf(l, "anchors must have a name")
+		}
+	}
+	var v interface{}
+	var err error
+	if text == "" {
+		v, err = nested()
+	} else {
+		v, err = p.value(l, text)
+	}
+	if nil != err {
+		return nil, err
+	}
+	if name != "" {
+		p.anchors[name] = v
+	}
+	return v, nil
+}
+
+// alias returns the value recorded by the anchor named by the alias.
+func (p *parser) alias(l line, text string) (interface{}, error) {
+	name := text[1:]
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return nil, errorf(l, "invalid alias %s", text)
+	}
+	v, ok := p.anchors[name]
+	if !ok {
+		return nil, errorf(l, "unknown anchor %q", name)
+	}
+	return v, nil
+}
+
+// flowItem decodes an item of a flow collection, which may be an alias.
+func (p *parser) flowItem(l line, text string) (interface{}, error) {
+	if strings.HasPrefix(text, "*") {
+		return p.alias(l, text)
+	}
+	return scalar(l, text)
+}
+
+// splitKey splits "key: value" into its key and value.
+func splitKey(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func keyString(l line, key string) (string, error) {
+	v, err := scalar(l, key)
+	if nil != err {
+		return "", err
+	}
+	if nil == v {
+		return "", errorf(l, "mapping keys cannot be null")
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return key, nil
+}
+
+// value decodes a value written on the same line as its key or sequence
+// indicator.
+func (p *parser) value(l line, text string) (interface{}, error) {
+	switch text[0] {
+	case '*':
+		return p.alias(l, text)
+	case '&':
+		return nil, errorf(l, "values cannot have several anchors")
+	case '!':
+		return nil, errorf(l, "tags are not supported")
+	case '|', '>':
+		return nil, errorf(l, "block scalars are not supported")
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, errorf(l, "unterminated flow sequence")
+		}
+		items, err := splitFlow(l, text[1:len(text)-1])
+		if nil != err {
+			return nil, err
+		}
+		seq := []interface{}{}
+		for _, item := range items {
+			v, err := p.flowItem(l, item)
+			if nil != err {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case '{':
+		if !strings.HasSuffix(text, "}") {
+			return nil, errorf(l, "unterminated flow mapping")
+		}
+		items, err := splitFlow(l, text[1:len(text)-1])
+		if nil != err {
+			return nil, err
+		}
+		m := map[string]interface{}{}
+		for _, item := range items {
+			key, rest, ok := splitKey(item)
+			if !ok {
+				return nil, errorf(l, "expected a key and value in flow mapping")
+			}
+			k, err := keyString(l, key)
+			if nil != err {
+				return nil, err
+			}
+			v, err := p.flowItem(l, rest)
+			if nil != err {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+	return scalar(l, text)
+}
+
+// splitFlow splits the contents of a flow collection on commas which are not
+// within quotes.
+func splitFlow(l line, text string) ([]string, error) {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			return nil, errorf(l, "nested flow collections are not supported")
+		case c == ',':
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		items = append(items, last)
+	} else if len(items) > 0 {
+		// A trailing comma is allowed.
+		return items, nil
+	}
+	for _, item := range items {
+		if item == "" {
+			return nil, errorf(l, "empty flow collection entry")
+		}
+	}
+	return items, nil
+}
+
+func scalar(l line, text string) (interface{}, error) {
+	if text == "" {
+		return nil, nil
+	}
+	switch text[0] {
+	case '"':
+		s, err := strconv.Unquote(text)
+		if nil != err {
+			return nil, errorf(l, "invalid double quoted string %s", text)
+		}
+		return s, nil
+	case '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return nil, errorf(l, "invalid single quoted string %s", text)
+		}
+		inner := text[1 : len(text)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return nil, errorf(l, "invalid single quoted string %s", text)
+		}
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	case '&', '*', '!', '|', '>', '[', '{':
+		return nil, errorf(l, "unsupported value %s", text)
+	}
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	// Integers which have leading zeros or which are too large, such as
+	// license keys, are kept as strings so that no digits are lost.
+	if isInteger(text) {
+		if i, err := strconv.ParseInt(text, 10, 64); nil == err && !hasLeadingZero(text) {
+			return i, nil
+		}
+		return text, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); nil == err && !strings.ContainsAny(text, "xXpP_") {
+		return f, nil
+	}
+	return text, nil
+}
+
+func isInteger(text string) bool {
+	text = strings.TrimLeft(text, "+-")
+	if text == "" {
+		return false
+	}
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLeadingZero(text string) bool {
+	text = strings.TrimLeft(text, "+-")
+	return len(text) > 1 && text[0] == '0'
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package yaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	input := `---
+# comment
+app_name: "My App"   # trailing comment
+license_key: 'it''s'
+enabled: true
+port: 443
+zeros: 007
+big: 123456789012345678901234567890
+rate: 0.5
+empty:
+none: ~
+hash: a#b
+url: http://example.com
+labels:
+  Server: One
+  "Data Center": Primary
+transaction_tracer:
+  segments:
+    threshold: 2ms
+exclude:
+- zip
+- "zap"
+include:
+  - one
+  -
+codes: [404, "409", 500,]
+inline: {a: 1, b: two}
+nothing: []
+`
+	v, err := Unmarshal([]byte(input))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"app_name":    "My App",
+		"license_key": "it's",
+		"enabled":     true,
+		"port":        int64(443),
+		"zeros":       "007",
+		"big":         "123456789012345678901234567890",
+		"rate":        0.5,
+		"empty":       nil,
+		"none":        nil,
+		"hash":        "a#b",
+		"url":         "http://example.com",
+		"labels": map[string]interface{}{
+			"Server":      "One",
+			"Data Center": "Primary",
+		},
+		"transaction_tracer": map[string]interface{}{
+			"segments": map[string]interface{}{
+				"threshold": "2ms",
+			},
+		},
+		"exclude": []interface{}{"zip", "zap"},
+		"include": []interface{}{"one", nil},
+		"codes":   []interface{}{int64(404), "409", int64(500)},
+		"inline":  map[string]interface{}{"a": int64(1), "b": "two"},
+		"nothing": []interface{}{},
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Errorf("got %#v", v)
+	}
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	for _, input := range []string{"", "\n", "# only a comment\n", "---\n"} {
+		if v, err := Unmarshal([]byte(input)); nil != v || nil != err {
+			t.Error(input, v, err)
+		}
+	}
+}
+
+func TestUnmarshalTopLevelSequence(t *testing.T) {
+	v, err := Unmarshal([]byte("- a\n- 1\n"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []interface{}{"a", int64(1)}) {
+		t.Error(v)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	testcases := []struct {
+		input string
+		line  int
+		msg   string
+	}{
+		{input: "a: 1\n  b: 2\n", line: 2, msg: "unexpected indentation"},
+		{input: "a: 1\na: 2\n", line: 2, msg: "duplicate key"},
+		{input: "a: 1\nnot a mapping\n", line: 2, msg: "expected a key and value"},
+		{input: "a: *alias\n", line: 1, msg: "unknown anchor"},
+		{input: "a: & 1\n", line: 1, msg: "anchors must have a name"},
+		{input: "a: &x &y 1\n", line: 1, msg: "several anchors"},
+		{input: "a: !!str 1\n", line: 1, msg: "tags"},
+		{input: "a: &x 1\nb:\n  <<: *x\n", line: 3, msg: "merge key values must be mappings"},
+		{input: "a: &x {c: 1}\nb:\n  <<: *x\n  <<: *x\n", line: 4, msg: "duplicate key"},
+		{input: "a: |\n  text\n", line: 1, msg: "block scalars"},
+		{input: "a:\n  - b: 1\n", line: 2, msg: "mappings within sequences"},
+		{input: "a: [1, [2]]\n", line: 1, msg: "nested flow collections"},
+		{input: "a: [1, 2\n", line: 1, msg: "unterminated"},
+		{input: "a: \"unterminated\n", line: 1, msg: "double quoted"},
+		{input: "a: 1\n---\nb: 2\n", line: 2, msg: "multiple documents"},
+		{input: "a:\n\t- b\n", line: 2, msg: "tabs"},
+	}
+	for _, tc := range testcases {
+		_, err := Unmarshal([]byte(tc.input))
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: expected a SyntaxError, got %v", tc.input, err)
+			continue
+		}
+		if serr.Line != tc.line || !strings.Contains(serr.Msg, tc.msg) {
+			t.Errorf("%q: got %v", tc.input, serr)
+		}
+	}
+}
+
+// stockConfig has the layout of the newrelic.yml files generated for other New
+// Relic agents, whose environment sections merge a common section.
+const stockConfig = `#
+# This file configures the New Relic Agent.
+#
+common: &default_settings
+  # Required license key associated with your New Relic account.
+  license_key: '0123456789abcdef0123456789abcdef01234567'
+
+  # Your application name.
+  app_name: My Application
+
+  labels:
+    Team: Go
+
+# Environment-specific settings are in this section.
+development:
+  <<: *default_settings
+  app_name: My Application (Development)
+
+test:
+  <<: *default_settings
+  # It doesn't make sense to report to New Relic from automated test runs.
+  monitor_mode: false
+
+production:
+  <<: *default_settings
+`
+
+func TestUnmarshalStockConfig(t *testing.T) {
+	v, err := Unmarshal([]byte(stockConfig))
+	if nil != err {
+		t.Fatal(err)
+	}
+	common := map[string]interface{}{
+		"license_key": "0123456789abcdef0123456789abcdef01234567",
+		"app_name":    "My Application",
+		"labels":      map[string]interface{}{"Team": "Go"},
+	}
+	expect := map[string]interface{}{
+		"common": common,
+		"development": map[string]interface{}{
+			"license_key": "0123456789abcdef0123456789abcdef01234567",
+			"app_name":    "My Application (Development)",
+			"labels":      map[string]interface{}{"Team": "Go"},
+		},
+		"test": map[string]interface{}{
+			"license_key":  "0123456789abcdef0123456789abcdef01234567",
+			"app_name":     "My Application",
+			"labels":       map[string]interface{}{"Team": "Go"},
+			"monitor_mode": false,
+		},
+		"production": common,
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Errorf("got %#v", v)
+	}
+}
+
+func TestUnmarshalAnchors(t *testing.T) {
+	input := `
+name: &name gopher
+names: [*name, other]
+list: &list
+  - a
+  - &item b
+items:
+  - *item
+  - *list
+base: &base
+  a: 1
+  b: 2
+other: &other {b: 3, c: 4}
+merged:
+  b: 5
+  <<: [*base, *other]
+quoted:
+  "<<": literal
+`
+	v, err := Unmarshal([]byte(input))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"name":   "gopher",
+		"names":  []interface{}{"gopher", "other"},
+		"list":   []interface{}{"a", "b"},
+		"items":  []interface{}{"b", []interface{}{"a", "b"}},
+		"base":   map[string]interface{}{"a": int64(1), "b": int64(2)},
+		"other":  map[string]interface{}{"b": int64(3), "c": int64(4)},
+		"merged": map[string]interface{}{"a": int64(1), "b": int64(5), "c": int64(4)},
+		"quoted": map[string]interface{}{"<<": "literal"},
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Errorf("got %#v", v)
+	}
+}
@@ -149,6 +149,9 @@ func newAppRun(config config, reply *internal.ConnectReply) *appRun {
 		MaxErrorEvents:  run.MaxErrorEvents(),
 		MaxSpanEvents:   run.MaxSpanEvents(),
 		LoggingConfig:   run.LoggingConfig(),
+
+		MaxDimensionalMetricSeries:  run.Config.DimensionalMetrics.MaxTimeSeries,
+		DimensionalMetricBoundaries: run.Config.DimensionalMetrics.HistogramBoundaries,
 	}
 
 	return run
@@ -262,7 +265,7 @@ func (run *appRun) limit(dflt int, field func() *uint) int {
 }
 
 func (run *appRun) ReportPeriods() map[harvestTypes]time.Duration {
-	fixed := harvestMetricsTraces
+	fixed := harvestMetricsTraces | harvestProfiles | harvestDimensionalMetrics
 	configurable := harvestTypes(0)
 
 	for tp, fn := range map[harvestTypes]func() *uint{
@@ -200,8 +200,8 @@ func TestEventHarvestFieldsAllPopulated(t *testing.T) {
 		maxErrorEvents:  4,
 		maxSpanEvents:   5,
 		periods: map[harvestTypes]time.Duration{
-			harvestMetricsTraces: 60 * time.Second,
-			harvestTypesEvents:   5 * time.Second,
+			harvestMetricsTraces | harvestProfiles | harvestDimensionalMetrics: 60 * time.Second,
+			harvestTypesEvents: 5 * time.Second,
 		},
 	})
 }
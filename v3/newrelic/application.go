@@ -5,6 +5,7 @@ package newrelic
 
 import (
 	"os"
+	"sync"
 	"time"
 )
 
@@ -56,7 +57,7 @@ func (app *Application) GetLinkingMetadata() LinkingMetadata {
 	}
 
 	md := LinkingMetadata{
-		EntityName: app.app.config.AppName,
+		EntityName: reply.Config.AppName,
 		Hostname:   app.app.config.hostname,
 		EntityGUID: reply.Reply.EntityGUID,
 	}
@@ -180,6 +181,63 @@ func (app *Application) RecordCustomMetric(name string, value float64) {
 	}
 }
 
+// RecordCounter adds value to the dimensional counter metric with the given
+// name and attributes.  Counters are summed each harvest, for each distinct
+// set of attributes.  Attribute values must be strings, numbers, or booleans.
+// Use attributes rather than the metric name to record dimensions such as
+// tenant or region:
+//
+//	app.RecordCounter("orders.placed", 1, map[string]interface{}{
+//		"tenant": tenant,
+//		"region": region,
+//	})
+//
+// Dimensional metrics are controlled by Config.DimensionalMetrics, are
+// disabled by default, and are not currently supported in serverless mode.
+func (app *Application) RecordCounter(name string, value float64, attributes map[string]interface{}) {
+	app.recordDimensionalMetric(dimensionalCount, name, value, attributes)
+}
+
+// RecordGauge records the current value of the dimensional gauge metric with
+// the given name and attributes.  The last value recorded each harvest is
+// sent.  See RecordCounter for more information on attributes.
+func (app *Application) RecordGauge(name string, value float64, attributes map[string]interface{}) {
+	app.recordDimensionalMetric(dimensionalGauge, name, value, attributes)
+}
+
+// RecordSummary records a value of the dimensional summary metric with the
+// given name and attributes.  The count, sum, minimum, and maximum of the
+// values recorded each harvest are sent.  See RecordCounter for more
+// information on attributes.
+func (app *Application) RecordSummary(name string, value float64, attributes map[string]interface{}) {
+	app.recordDimensionalMetric(dimensionalSummary, name, value, attributes)
+}
+
+// RecordHistogram records a value of the dimensional histogram metric with
+// the given name and attributes.  A summary of the values is sent with the
+// given name, together with a count metric named name + ".bucket" for each
+// bucket of Config.DimensionalMetrics.HistogramBoundaries.  Each bucket
+// count has an "le" attribute holding the upper bound of the bucket, or
+// "+Inf" for the final bucket, and counts the values at most that bound.  See
+// RecordCounter for more information on attributes.
+func (app *Application) RecordHistogram(name string, value float64, attributes map[string]interface{}) {
+	app.recordDimensionalMetric(dimensionalHistogram, name, value, attributes)
+}
+
+func (app *Application) recordDimensionalMetric(tp dimensionalMetricType, name string, value float64, attributes map[string]interface{}) {
+	if app == nil || app.app == nil {
+		return
+	}
+	err := app.app.recordDimensionalMetric(tp, name, value, attributes)
+	if err != nil {
+		app.app.Error("unable to record dimensional metric", map[string]interface{}{
+			"metric-name": name,
+			"metric-type": tp.String(),
+			"reason":      err.Error(),
+		})
+	}
+}
+
 // RecordLog records the data from a single log line.
 // This consumes a LogData object that should be configured
 // with data taken from a logging framework.
@@ -252,7 +310,68 @@ func (app *Application) Config() (Config, bool) {
 	if app == nil || app.app == nil {
 		return defaultConfig(), false
 	}
-	return app.app.config.Config, true
+	c := app.app.getConfig().Config
+	c.Logger = unwrapLogger(c.Logger)
+	return copyConfigReferenceFields(c), true
+}
+
+// UpdateConfig changes the application's configuration without restarting
+// it.  The options are applied to the current configuration, which is then
+// validated.  If it is invalid, an error is returned and the configuration
+// is not changed.  For example, to change the attributes excluded from all
+// destinations and the log level:
+//
+//	err := app.UpdateConfig(
+//		func(cfg *newrelic.Config) {
+//			cfg.Attributes.Exclude = []string{"request.headers.*"}
+//		},
+//		newrelic.ConfigDebugLogger(os.Stdout),
+//	)
+//
+// Changes take effect for transactions started after UpdateConfig returns.
+// Changes to settings sent to New Relic when the application connects, such
+// as AppName, Labels, and HighSecurity, cause the application to connect
+// again.  Settings used when the application is created, such as License,
+// OTLP, and InfiniteTracing, cannot be changed and an error is returned if
+// they differ.  Changes to Transport are ignored.
+func (app *Application) UpdateConfig(opts ...ConfigOption) error {
+	if app == nil || app.app == nil {
+		return nil
+	}
+	return app.app.updateConfig(opts...)
+}
+
+// WatchConfigFile applies the configuration in the file at path using
+// UpdateConfig, and applies it again whenever the file changes.  The file is
+// checked for changes every interval.  The file has the same format as the
+// files read by ConfigFromFile, so the file used to create the application
+// can also be watched, and only the Config fields present in the file are
+// changed.  For example:
+//
+//	attributes:
+//	  exclude: [request.headers.*]
+//	transaction_tracer:
+//	  transaction_threshold: 0.1
+//
+// As with ConfigFromFile, environment variables read by
+// ConfigFromEnvironment take precedence over the file.  Errors reading or
+// applying the file are logged.  The Logger created from the log_file_name
+// and log_level keys is only replaced when they change, in which case the
+// previous log file is closed.
+//
+// Calling the returned function stops watching the file.  The file is also
+// no longer watched once the application is shut down.
+func (app *Application) WatchConfigFile(path string, interval time.Duration) (stop func()) {
+	if app == nil || app.app == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = defaultConfigFileWatchInterval
+	}
+	done := make(chan struct{})
+	go app.app.watchConfigFile(path, interval, os.Getenv, done)
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
 }
 func newApplication(app *app) *Application {
 	return &Application{
@@ -54,6 +54,9 @@ const (
 	AttributeCodeLineno = "code.lineno"
 	// AttributeErrorGroupName contains the error group name set by the user defined callback function.
 	AttributeErrorGroupName = "error.group.name"
+	// AttributeErrorCauses contains the chain of wrapped errors, encoded as
+	// a JSON array of objects with "type" and "message" fields.
+	AttributeErrorCauses = "error.causes"
 	// AttributeUserID tracks the user a transaction and its child events are impacting
 	AttributeUserID = "enduser.id"
 	// AttributeLLM tracks LLM transactions
@@ -152,6 +155,47 @@ const (
 const (
 	AttributeMessagingDestinationPublishName = "messaging.destination_publish.name"
 	AttributeRabbitMQDestinationRoutingKey   = "messaging.rabbitmq.destination.routing_key"
+	// How the RabbitMQ delivery was settled: "ack", "nack", "requeue", or
+	// "reject".
+	AttributeRabbitMQDeliveryOutcome = "messaging.rabbitmq.delivery.outcome"
+	// Whether the RabbitMQ delivery has been delivered before.
+	AttributeRabbitMQRedelivered = "messaging.rabbitmq.message.redelivered"
+	// The number of times the RabbitMQ delivery has been returned to a
+	// quorum queue, taken from its x-delivery-count header.
+	AttributeRabbitMQDeliveryCount = "messaging.rabbitmq.message.delivery_count"
+	// The partition of the topic the message was produced to or consumed
+	// from.
+	AttributeMessagingDestinationPartitionID = "messaging.destination.partition.id"
+	// The number of messages consumed by a transaction which consumes a batch
+	// of messages.
+	AttributeMessagingBatchMessageCount = "messaging.batch.message_count"
+	// The offset of the message within its Kafka partition.
+	AttributeKafkaMessageOffset = "messaging.kafka.message.offset"
+	// The Kafka consumer group of the consumer.
+	AttributeKafkaConsumerGroup = "messaging.kafka.consumer.group"
+	// The number of messages in the partition which had not yet been consumed
+	// when the message was received.
+	AttributeKafkaConsumerLag = "messaging.kafka.consumer.lag"
+	// The NATS JetStream stream of the message.
+	AttributeNATSStream = "messaging.nats.stream"
+	// The NATS JetStream consumer which received the message.
+	AttributeNATSConsumer = "messaging.nats.consumer"
+	// The sequence number of the message within its NATS JetStream stream.
+	AttributeNATSStreamSequence = "messaging.nats.message.stream_sequence"
+	// The sequence number of the message within its NATS JetStream consumer.
+	AttributeNATSConsumerSequence = "messaging.nats.message.consumer_sequence"
+	// The number of times the NATS JetStream message has been delivered.
+	AttributeNATSDeliveredCount = "messaging.nats.message.delivered_count"
+	// The number of messages pending for the NATS JetStream consumer when
+	// the message was received.
+	AttributeNATSConsumerPending = "messaging.nats.consumer.pending"
+	// The identifier assigned to the message by the messaging system.
+	AttributeMessagingMessageID = "messaging.message.id"
+	// The ordering key of the Google Cloud Pub/Sub message.
+	AttributeGCPPubSubOrderingKey = "messaging.gcp_pubsub.message.ordering_key"
+	// The delivery attempt of the Google Cloud Pub/Sub message, which is only
+	// known when the subscription has a dead letter policy.
+	AttributeGCPPubSubDeliveryAttempt = "messaging.gcp_pubsub.message.delivery_attempt"
 )
 
 // Attributes destined for Span Events. These attributes appear only on Span
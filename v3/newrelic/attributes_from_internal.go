@@ -66,6 +66,7 @@ var (
 		AttributeCodeNamespace:                   usualDests,
 		AttributeCodeFilepath:                    usualDests,
 		AttributeCodeLineno:                      usualDests,
+		AttributeErrorCauses:                     destError,
 		AttributeUserID:                          usualDests,
 		AttributeLLM:                             usualDests,
 		AttributeServerAddress:                   usualDests,
@@ -73,6 +74,23 @@ var (
 		AttributeSpanKind:                        usualDests,
 		AttributeMessagingDestinationPublishName: usualDests,
 		AttributeRabbitMQDestinationRoutingKey:   usualDests,
+		AttributeRabbitMQDeliveryOutcome:         usualDests,
+		AttributeRabbitMQRedelivered:             usualDests,
+		AttributeRabbitMQDeliveryCount:           usualDests,
+		AttributeMessagingDestinationPartitionID: usualDests,
+		AttributeMessagingBatchMessageCount:      usualDests,
+		AttributeKafkaMessageOffset:              usualDests,
+		AttributeKafkaConsumerGroup:              usualDests,
+		AttributeKafkaConsumerLag:                usualDests,
+		AttributeNATSStream:                      usualDests,
+		AttributeNATSConsumer:                    usualDests,
+		AttributeNATSStreamSequence:              usualDests,
+		AttributeNATSConsumerSequence:            usualDests,
+		AttributeNATSDeliveredCount:              usualDests,
+		AttributeNATSConsumerPending:             usualDests,
+		AttributeMessagingMessageID:              usualDests,
+		AttributeGCPPubSubOrderingKey:            usualDests,
+		AttributeGCPPubSubDeliveryAttempt:        usualDests,
 		// Span specific attributes
 		SpanAttributeDBStatement:             usualDests,
 		SpanAttributeDBInstance:              usualDests,
@@ -83,6 +83,29 @@ func (h *BrowserTimingHeader) WithoutTags() []byte {
 	return appendSlices([]byte(h.agentLoader), browserInfoPrefix, info)
 }
 
+// loaderWithTags returns only the agent loader portion of the browser timing
+// JavaScript enclosed in <script> tags.  It is used when the loader and the
+// info hash are inserted into different locations of a page.
+func (h *BrowserTimingHeader) loaderWithTags() []byte {
+	if nil == h || h.agentLoader == "" {
+		return nil
+	}
+	return appendSlices(browserStartTag, []byte(h.agentLoader), browserEndTag)
+}
+
+// infoWithTags returns only the info hash portion of the browser timing
+// JavaScript enclosed in <script> tags.
+func (h *BrowserTimingHeader) infoWithTags() []byte {
+	if nil == h {
+		return nil
+	}
+	info, err := json.Marshal(h.info)
+	if err != nil {
+		return nil
+	}
+	return appendSlices(browserStartTag, browserInfoPrefix, info, browserEndTag)
+}
+
 // browserAttributes returns a string with the attributes that are attached to
 // the browser destination encoded in the JSON format expected by the Browser
 // agent.
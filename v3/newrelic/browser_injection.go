@@ -0,0 +1,345 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// browserInjectionMaxBuffer is the largest response body that will be
+	// buffered for browser agent injection.  Larger responses are written
+	// through unmodified.
+	browserInjectionMaxBuffer = 2 * 1024 * 1024
+)
+
+var (
+	browserXUACompatibleRE = regexp.MustCompile(`(?is)<\s*meta[^>]+http-equiv\s*=\s*['"]x-ua-compatible['"][^>]*>`)
+	browserCharsetRE       = regexp.MustCompile(`(?is)<\s*meta[^>]+charset\s*=[^>]*>`)
+	browserHeadOpenRE      = regexp.MustCompile(`(?is)<head(?:\s[^>]*)?>`)
+	browserHeadCloseRE     = regexp.MustCompile(`(?i)</head\s*>`)
+	browserBodyOpenRE      = regexp.MustCompile(`(?i)<body`)
+	browserBodyCloseRE     = regexp.MustCompile(`(?i)</body\s*>`)
+
+	browserNREUM = []byte("NREUM")
+)
+
+// browserLoaderInsertionIndex returns the index in the HTML document where the
+// browser agent loader should be inserted, or -1 if no suitable location is
+// found.  The loader is placed after the last position-sensitive X-UA-Compatible
+// or charset <meta> tag if either is present, otherwise directly after the
+// opening <head> tag, otherwise directly before the opening <body> tag.  Only
+// <meta> tags within the <head>, before the closing </head> tag or the
+// opening <body> tag, are considered.
+//
+// These rules are described by the rum_loader_insertion_location cross agent
+// tests.
+func browserLoaderInsertionIndex(body []byte) int {
+	head := body
+	if loc := browserHeadCloseRE.FindIndex(body); loc != nil {
+		head = body[:loc[0]]
+	} else if loc := browserBodyOpenRE.FindIndex(body); loc != nil {
+		head = body[:loc[0]]
+	}
+	idx := -1
+	if loc := browserXUACompatibleRE.FindIndex(head); loc != nil {
+		idx = loc[1]
+	}
+	if loc := browserCharsetRE.FindIndex(head); loc != nil && loc[1] > idx {
+		idx = loc[1]
+	}
+	if idx >= 0 {
+		return idx
+	}
+	if loc := browserHeadOpenRE.FindIndex(body); loc != nil {
+		return loc[1]
+	}
+	if loc := browserBodyOpenRE.FindIndex(body); loc != nil {
+		return loc[0]
+	}
+	return -1
+}
+
+// browserFooterInsertionIndex returns the index in the HTML document where the
+// browser agent info hash should be inserted, or -1 if no suitable location is
+// found.  The footer is placed directly before the last closing </body> tag,
+// which correctly skips any </body> found in comments or scripts earlier in
+// the document.
+//
+// These rules are described by the rum_footer_insertion_location cross agent
+// tests.
+func browserFooterInsertionIndex(body []byte) int {
+	locs := browserBodyCloseRE.FindAllIndex(body, -1)
+	if len(locs) == 0 {
+		return -1
+	}
+	return locs[len(locs)-1][0]
+}
+
+// injectBrowserTimingHeader inserts the browser timing JavaScript into the
+// HTML document.  The loader is inserted into the <head> and the info hash
+// before the closing </body> tag if one exists, or directly after the loader
+// otherwise.  The original document is returned unchanged if no insertion
+// location can be found or if the page appears to be instrumented already.
+func injectBrowserTimingHeader(body []byte, hdr *BrowserTimingHeader) []byte {
+	loader := hdr.loaderWithTags()
+	info := hdr.infoWithTags()
+	if nil == loader || nil == info {
+		return body
+	}
+	if bytes.Contains(body, browserNREUM) {
+		return body
+	}
+	loaderIdx := browserLoaderInsertionIndex(body)
+	if loaderIdx < 0 {
+		return body
+	}
+	footerIdx := browserFooterInsertionIndex(body)
+	if footerIdx < loaderIdx {
+		return appendSlices(body[:loaderIdx], loader, info, body[loaderIdx:])
+	}
+	return appendSlices(body[:loaderIdx], loader, body[loaderIdx:footerIdx], info, body[footerIdx:])
+}
+
+// browserInjectionWriter is the http.ResponseWriter used by
+// BrowserMonitoringHandler.  It buffers HTML responses until the handler
+// returns so that the browser timing JavaScript can be inserted.  All other
+// responses are written through to the original writer without modification.
+type browserInjectionWriter struct {
+	original http.ResponseWriter
+	txn      *Transaction
+
+	code        int
+	decided     bool
+	buffering   bool
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (bw *browserInjectionWriter) Header() http.Header {
+	return bw.original.Header()
+}
+
+func (bw *browserInjectionWriter) WriteHeader(code int) {
+	if bw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// Informational responses may be followed by the final status
+		// code and are never buffered.
+		bw.original.WriteHeader(code)
+		return
+	}
+	if bw.decided {
+		if !bw.buffering {
+			bw.wroteHeader = true
+			bw.original.WriteHeader(code)
+		}
+		return
+	}
+	bw.code = code
+	if code != http.StatusOK {
+		bw.passThrough()
+		return
+	}
+	// The content type may not be set until the first write in which case it
+	// will be sniffed from the body.
+	if bw.Header().Get("Content-Type") != "" {
+		bw.decide(nil)
+	}
+}
+
+func (bw *browserInjectionWriter) Write(b []byte) (int, error) {
+	if !bw.decided {
+		bw.decide(b)
+	}
+	if !bw.buffering {
+		bw.writeHeader()
+		return bw.original.Write(b)
+	}
+	if bw.buf.Len()+len(b) > browserInjectionMaxBuffer {
+		bw.passThrough()
+		return bw.original.Write(b)
+	}
+	return bw.buf.Write(b)
+}
+
+// Flush stops buffering, since a flushing handler is streaming its response,
+// and then flushes the original writer.
+func (bw *browserInjectionWriter) Flush() {
+	bw.passThrough()
+	if f, ok := bw.original.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, after which nothing will be
+// written by the browserInjectionWriter.
+func (bw *browserInjectionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	bw.decided = true
+	bw.buffering = false
+	bw.wroteHeader = true
+	return bw.original.(http.Hijacker).Hijack()
+}
+
+// decide determines whether the response is eligible for injection using
+// the response headers and, if no content type has been set, the first bytes
+// of the body.
+func (bw *browserInjectionWriter) decide(firstWrite []byte) {
+	hdr := bw.Header()
+	if hdr.Get("Content-Type") == "" && firstWrite != nil {
+		hdr.Set("Content-Type", http.DetectContentType(firstWrite))
+	}
+	bw.decided = true
+	bw.buffering = browserInjectable(hdr)
+}
+
+// browserInjectable returns true if the response headers describe an HTML
+// document that can be modified.
+func browserInjectable(hdr http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" {
+		return false
+	}
+	if strings.HasPrefix(strings.ToLower(hdr.Get("Content-Disposition")), "attachment") {
+		return false
+	}
+	switch strings.ToLower(hdr.Get("Content-Encoding")) {
+	case "", "identity", "gzip":
+	default:
+		return false
+	}
+	if cl := hdr.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err != nil || n > browserInjectionMaxBuffer {
+			return false
+		}
+	}
+	return true
+}
+
+// passThrough stops buffering and writes anything that has been buffered so
+// far to the original writer unmodified.
+func (bw *browserInjectionWriter) passThrough() {
+	bw.decided = true
+	if !bw.buffering {
+		bw.writeHeader()
+		return
+	}
+	bw.buffering = false
+	bw.writeHeader()
+	if bw.buf.Len() > 0 {
+		bw.original.Write(bw.buf.Bytes())
+	}
+	bw.buf = bytes.Buffer{}
+}
+
+func (bw *browserInjectionWriter) writeHeader() {
+	if bw.wroteHeader {
+		return
+	}
+	bw.wroteHeader = true
+	if bw.code != 0 {
+		bw.original.WriteHeader(bw.code)
+	}
+}
+
+// finish writes the buffered response, with the browser timing JavaScript
+// inserted if possible.  It must be called once the handler has returned.
+func (bw *browserInjectionWriter) finish() {
+	if !bw.buffering {
+		bw.writeHeader()
+		return
+	}
+	body := bw.buf.Bytes()
+	if modified, ok := bw.inject(body); ok {
+		body = modified
+		hdr := bw.Header()
+		if hdr.Get("Content-Length") != "" {
+			hdr.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	bw.buffering = false
+	bw.writeHeader()
+	if len(body) > 0 {
+		bw.original.Write(body)
+	}
+	bw.buf = bytes.Buffer{}
+}
+
+// inject returns the response body with the browser timing JavaScript
+// inserted, transparently handling gzip encoded bodies.  It returns false if
+// the body was not modified.
+func (bw *browserInjectionWriter) inject(body []byte) ([]byte, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	gzipped := strings.EqualFold(bw.Header().Get("Content-Encoding"), "gzip")
+	html := body
+	if gzipped {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		html, err = io.ReadAll(r)
+		if err != nil {
+			return nil, false
+		}
+	}
+	hdr := bw.txn.BrowserTimingHeader()
+	if nil == hdr {
+		return nil, false
+	}
+	modified := injectBrowserTimingHeader(html, hdr)
+	if len(modified) == len(html) {
+		return nil, false
+	}
+	if !gzipped {
+		return modified, true
+	}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(modified); err != nil {
+		return nil, false
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+func upgradeBrowserInjectionWriter(bw *browserInjectionWriter) http.ResponseWriter {
+	_, isFlusher := bw.original.(http.Flusher)
+	_, isHijacker := bw.original.(http.Hijacker)
+	switch {
+	case isFlusher && isHijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{bw, bw, bw}
+	case isFlusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{bw, bw}
+	case isHijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{bw, bw}
+	default:
+		return struct {
+			http.ResponseWriter
+		}{bw}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal/crossagent"
+)
+
+func TestBrowserLoaderInsertionLocationCrossAgent(t *testing.T) {
+	files, err := crossagent.ReadDir("rum_loader_insertion_location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const marker = "EXPECTED_RUM_LOADER_LOCATION"
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Index(contents, []byte(marker))
+		if expected < 0 {
+			t.Fatalf("%s: marker not found", filepath.Base(file))
+		}
+		body := bytes.Replace(contents, []byte(marker), nil, 1)
+		if idx := browserLoaderInsertionIndex(body); idx != expected {
+			t.Errorf("%s: expected loader location %d, got %d", filepath.Base(file), expected, idx)
+		}
+	}
+}
+
+func TestBrowserFooterInsertionLocationCrossAgent(t *testing.T) {
+	files, err := crossagent.ReadDir("rum_footer_insertion_location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const marker = "EXPECTED_RUM_FOOTER_LOCATION"
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Index(contents, []byte(marker))
+		if expected < 0 {
+			t.Fatalf("%s: marker not found", filepath.Base(file))
+		}
+		body := bytes.Replace(contents, []byte(marker), nil, 1)
+		if idx := browserFooterInsertionIndex(body); idx != expected {
+			t.Errorf("%s: expected footer location %d, got %d", filepath.Base(file), expected, idx)
+		}
+	}
+}
+
+func TestBrowserLoaderInsertionIgnoresBodyMeta(t *testing.T) {
+	for _, page := range []string{
+		`<html><head><title>hi</title></head><body><meta charset="utf-8">hello</body></html>`,
+		`<html><head><title>hi</title></head><body><meta http-equiv="X-UA-Compatible" content="IE=edge">hello</body></html>`,
+	} {
+		if idx := browserLoaderInsertionIndex([]byte(page)); idx != len(`<html><head>`) {
+			t.Errorf("%s: got %d", page, idx)
+		}
+	}
+	page := `<html><meta charset="utf-8"><body><meta charset="utf-8">hello</body></html>`
+	if idx := browserLoaderInsertionIndex([]byte(page)); idx != len(`<html><meta charset="utf-8">`) {
+		t.Errorf("%s: got %d", page, idx)
+	}
+}
+
+const browserInjectionTestPage = `<html><head><title>hi</title></head><body>hello</body></html>`
+
+func browserInjectionTestServe(t *testing.T, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	app := testApp(browserReplyFields, nil, t)
+	if req == nil {
+		req = httptest.NewRequest("GET", "/page", nil)
+	}
+	_, h := WrapHandle(app.Application, "/page", BrowserMonitoringHandler(handler))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	app.expectNoLoggedErrors(t)
+	return w
+}
+
+func expectBrowserInjected(t *testing.T, body string) {
+	t.Helper()
+	loader := `<head><script type="text/javascript">loader</script><title>`
+	if !strings.Contains(body, loader) {
+		t.Errorf("loader not found in head: %s", body)
+	}
+	info := `hello<script type="text/javascript">window.NREUM||(NREUM={});NREUM.info={"beacon":"beacon"`
+	if !strings.Contains(body, info) {
+		t.Errorf("info not found before body close: %s", body)
+	}
+}
+
+func TestBrowserMonitoringHandlerInjectsHTML(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(browserInjectionTestPage)))
+		io.WriteString(w, browserInjectionTestPage[:20])
+		io.WriteString(w, browserInjectionTestPage[20:])
+	}, nil)
+	body := w.Body.String()
+	expectBrowserInjected(t, body)
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+		t.Errorf("content length %s does not match body length %d", cl, len(body))
+	}
+}
+
+func TestBrowserMonitoringHandlerSniffsContentType(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, browserInjectionTestPage)
+	}, nil)
+	expectBrowserInjected(t, w.Body.String())
+}
+
+func TestBrowserMonitoringHandlerGzip(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, browserInjectionTestPage)
+		zw.Close()
+	}, nil)
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBrowserInjected(t, string(body))
+}
+
+func TestBrowserMonitoringHandlerUnsupportedEncoding(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, "not really brotli")
+	}, nil)
+	if body := w.Body.String(); body != "not really brotli" {
+		t.Error(body)
+	}
+}
+
+func TestBrowserMonitoringHandlerNonHTML(t *testing.T) {
+	const js = `{"html":"<head></head><body></body>"}`
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, js)
+	}, nil)
+	if body := w.Body.String(); body != js {
+		t.Error(body)
+	}
+}
+
+func TestBrowserMonitoringHandlerStreaming(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, browserInjectionTestPage[:20])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, browserInjectionTestPage[20:])
+	}, nil)
+	if body := w.Body.String(); body != browserInjectionTestPage {
+		t.Error(body)
+	}
+	if !w.Flushed {
+		t.Error("response not flushed")
+	}
+}
+
+func TestBrowserMonitoringHandlerStatusCode(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(404)
+		io.WriteString(w, browserInjectionTestPage)
+	}, nil)
+	if w.Code != 404 {
+		t.Error(w.Code)
+	}
+	if body := w.Body.String(); body != browserInjectionTestPage {
+		t.Error(body)
+	}
+}
+
+func TestBrowserMonitoringHandlerExplicitOK(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(200)
+		io.WriteString(w, browserInjectionTestPage)
+	}, nil)
+	if w.Code != 200 {
+		t.Error(w.Code)
+	}
+	expectBrowserInjected(t, w.Body.String())
+}
+
+func TestBrowserMonitoringHandlerAlreadyInstrumented(t *testing.T) {
+	page := `<html><head><script>window.NREUM||(NREUM={})</script></head><body></body></html>`
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, page)
+	}, nil)
+	if body := w.Body.String(); body != page {
+		t.Error(body)
+	}
+}
+
+func TestBrowserMonitoringHandlerHead(t *testing.T) {
+	w := browserInjectionTestServe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, browserInjectionTestPage)
+	}, httptest.NewRequest("HEAD", "/page", nil))
+	if body := w.Body.String(); body != browserInjectionTestPage {
+		t.Error(body)
+	}
+}
+
+func TestBrowserMonitoringHandlerNoTransaction(t *testing.T) {
+	h := BrowserMonitoringHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, browserInjectionTestPage)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/page", nil))
+	if body := w.Body.String(); body != browserInjectionTestPage {
+		t.Error(body)
+	}
+}
@@ -153,6 +153,20 @@ func (resp rpmResponse) GetError() error {
 	return resp.err
 }
 
+// ShouldSpoolHarvestData indicates that the collector could not be reached or
+// could not accept the data at this time, so the data may be sent later.
+func (resp rpmResponse) ShouldSpoolHarvestData() bool {
+	if resp.forceSaveHarvestData || resp.IsRestartException() {
+		return true
+	}
+	switch resp.statusCode {
+	case 408, 429:
+		return true
+	default:
+		return resp.statusCode >= 500 && resp.statusCode < 600
+	}
+}
+
 // ShouldSaveHarvestData indicates that the agent should save the data and try
 // to send it in the next harvest.
 func (resp rpmResponse) ShouldSaveHarvestData() bool {
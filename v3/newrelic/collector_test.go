@@ -84,6 +84,34 @@ func TestCollectorResponseCodeError(t *testing.T) {
 	}
 }
 
+func TestCollectorResponseSpoolHarvestData(t *testing.T) {
+	testcases := map[int]bool{
+		200:    false,
+		202:    false,
+		400:    false,
+		401:    true,
+		404:    false,
+		408:    true,
+		409:    true,
+		410:    false,
+		413:    false,
+		429:    true,
+		500:    true,
+		502:    true,
+		503:    true,
+		504:    true,
+		999999: false,
+	}
+	for code, spool := range testcases {
+		if resp := newRPMResponse(nil).AddStatusCode(code); spool != resp.ShouldSpoolHarvestData() {
+			t.Error(code, spool)
+		}
+	}
+	if resp := newRPMResponse(errors.New("unreachable")).ForceSaveHarvestData(); !resp.ShouldSpoolHarvestData() {
+		t.Error("transport errors should be spooled")
+	}
+}
+
 func TestCollectorRequest(t *testing.T) {
 	cmd := rpmCmd{
 		Name:              "cmd_name",
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"os"
 	"regexp"
@@ -282,6 +284,37 @@ type Config struct {
 		Enabled bool
 		// Attributes controls the attributes included on Spans.
 		Attributes AttributeDestinationConfig
+		// TailSampling controls local tail-based sampling of span events.
+		// When enabled, the span events of every transaction are held in
+		// memory until the transaction ends, and the trace is then kept
+		// if the transaction was sampled or if it matches one of the
+		// rules below.  The kept span events are sent to New Relic or to
+		// the Trace Observer, and the rest are discarded.  Transactions
+		// kept by a rule are marked as sampled, however outbound
+		// distributed tracing payloads created before the transaction
+		// ended are not changed.
+		TailSampling struct {
+			// Enabled controls whether tail-based sampling is used.
+			// The default is false.
+			Enabled bool
+			// MaxBufferedSpans is the maximum number of span events
+			// held in memory by all transactions which have not yet
+			// ended.  Span events created when this limit is reached
+			// are discarded.  The default is 10,000.
+			MaxBufferedSpans int
+			// Errors keeps the traces of transactions which noticed an
+			// error that is not expected.  The default is true.
+			Errors bool
+			// DurationThreshold keeps the traces of transactions which
+			// take at least this long.  The default is zero, which
+			// disables this rule.
+			DurationThreshold time.Duration
+			// Attributes keeps the traces of transactions which have a
+			// matching attribute.  The keys are attribute names and the
+			// values are compared to the attribute value formatted
+			// with fmt.Sprint.  An empty value matches any value.
+			Attributes map[string]string
+		}
 	}
 
 	// InfiniteTracing controls behavior related to Infinite Tracing tail based
@@ -337,6 +370,18 @@ type Config struct {
 		SlowQuery struct {
 			Enabled   bool
 			Threshold time.Duration
+			// Explain controls the capture of explain plans for slow
+			// queries made using InstrumentSQLDriver or
+			// InstrumentSQLConnector with an SQLDriverSegmentBuilder
+			// whose ExplainQuery is set.  Explain plans are obfuscated
+			// and are not captured in high security mode or when the
+			// query is not recorded.  Interval is the minimum time
+			// between explain plans of the same query, since each one
+			// runs another query against the database.
+			Explain struct {
+				Enabled  bool
+				Interval time.Duration
+			}
 		}
 	}
 
@@ -350,12 +395,168 @@ type Config struct {
 	Attributes AttributeDestinationConfig
 
 	// RuntimeSampler controls the collection of runtime statistics like
-	// CPU/Memory usage, goroutine count, and GC pauses.
+	// CPU/Memory usage, goroutine count, and GC pauses.  Statistics are read
+	// using the runtime/metrics package, which does not stop the world, and
+	// include scheduler latency and GC pause distributions, memory usage by
+	// class, mutex wait time, GOMAXPROCS, GOMEMLIMIT, and cgo call counts.
 	RuntimeSampler struct {
 		// Enabled controls whether runtime statistics are captured.
 		Enabled bool
 	}
 
+	// Profiling controls the continuous collection of runtime/pprof
+	// profiles.  When enabled, the profiles selected by Types are captured
+	// every Period and written with the harvest data tagged with the entity
+	// GUID and run ID of the application.  Heap, goroutine, and mutex
+	// profiles are also captured whenever an alarm registered with
+	// Application.HeapHighWaterMarkAlarmSet fires.  New Relic does not
+	// accept profiles from the agent, so enabling Profiling without
+	// FileExport is a configuration error.  Profiling is disabled by
+	// default.
+	Profiling struct {
+		// Enabled controls whether profiles are captured.
+		Enabled bool
+		// Period is the time between captures.  The default is one
+		// minute.
+		Period time.Duration
+		// CPUDuration is the length of time that the CPU profile is
+		// recorded during each Period.  The default is ten seconds.
+		CPUDuration time.Duration
+		// Types is a combination of ProfileType values OR-ed together
+		// indicating which profiles are captured.  The default is
+		// ProfileAll.
+		Types ProfileType
+	}
+
+	// DimensionalMetrics controls the metrics recorded with
+	// Application.RecordCounter, RecordGauge, RecordSummary, and
+	// RecordHistogram.  These metrics have attributes and are aggregated by
+	// time series, the combination of name, type, and attributes, each
+	// harvest, and sent to the New Relic Metric API authenticated with the
+	// license key.  Dimensional metrics are disabled by default, and are not
+	// sent in serverless mode or to an OTLP endpoint.
+	DimensionalMetrics struct {
+		// Enabled controls whether dimensional metrics are recorded.
+		Enabled bool
+		// Endpoint is the URL of the Metric API.  The default is
+		// https://metric-api.newrelic.com/metric/v1, or
+		// https://metric-api.eu.newrelic.com/metric/v1 when the license
+		// key is for the EU region.
+		Endpoint string
+		// MaxTimeSeries limits the number of time series per harvest.
+		// Once the limit is reached, the values of new time series are
+		// aggregated into one series for each metric name and type with
+		// the attribute "newrelic.overflow" set to true.  The default is
+		// 2000.
+		MaxTimeSeries int
+		// HistogramBoundaries are the increasing upper bounds of the
+		// buckets of histograms.  A final bucket counts values greater
+		// than the last boundary.  The default is 0, 5, 10, 25, 50, 75,
+		// 100, 250, 500, 750, 1000, 2500, 5000, 7500, and 10000.
+		HistogramBoundaries []float64
+	}
+
+	// DurationDistributions controls the recording of the durations of
+	// transactions, and of datastore and external segments, in mergeable
+	// quantile sketches.  When enabled, a distribution is reported each
+	// harvest for each transaction name, such as "WebTransaction/Go/GET
+	// /users", and each datastore and external metric name, such as
+	// "Datastore/statement/Postgres/users/select", so that percentiles
+	// like p95 and p99 latency can be computed.  Durations are in seconds.
+	// Each distribution is sent with dimensional metrics as a summary with
+	// the metric name and a gauge named the metric name followed by
+	// ".quantile" for each of the 0.5, 0.75, 0.9, 0.95, and 0.99
+	// quantiles, with the quantile in the "quantile" attribute.
+	// Distributions count towards DimensionalMetrics.MaxTimeSeries and are
+	// not recorded when DimensionalMetrics is disabled, as it is by default,
+	// or in serverless mode.  Duration distributions are disabled by
+	// default.
+	DurationDistributions struct {
+		// Enabled controls whether duration distributions are recorded.
+		Enabled bool
+		// RelativeAccuracy is the maximum relative error of the
+		// percentiles computed from a distribution.  It must be between
+		// 0 and 1.  The default is 0.01.
+		RelativeAccuracy float64
+		// MaxBins limits the memory of each distribution.  Once a
+		// distribution has MaxBins bins, its lowest bins are merged,
+		// which reduces the accuracy of the lowest percentiles.  The
+		// default is 1024.
+		MaxBins int
+	}
+
+	// OTLP configures the agent to send span events, metrics, and log
+	// events to an OpenTelemetry Protocol (OTLP) endpoint using OTLP/HTTP
+	// instead of New Relic.  When enabled, the agent does not connect to
+	// New Relic, a License is not required, and other data types such as
+	// transaction events, error events, and transaction traces are
+	// discarded.  Transaction information is kept as span attributes.
+	OTLP struct {
+		// Enabled controls whether data is sent to the OTLP endpoint.
+		Enabled bool
+		// Endpoint is the base URL of the OTLP/HTTP receiver, for example
+		// "http://localhost:4318".  Data is posted to the /v1/traces,
+		// /v1/metrics, and /v1/logs paths of the endpoint.
+		Endpoint string
+		// Protocol is either OTLPProtocolProtobuf (the default) or
+		// OTLPProtocolJSON.
+		Protocol string
+		// Headers are added to each request sent to the endpoint.  They
+		// are not included in the configuration settings reported by the
+		// agent.
+		Headers map[string]string
+	}
+
+	// DiskSpool configures buffering of harvest data on disk while New
+	// Relic cannot be reached.  When enabled, payloads which fail to send
+	// and would otherwise be discarded, including those of the final
+	// harvest made by Application.Shutdown, are written to Directory.
+	// Spooled payloads are sent after the application next connects,
+	// which may be in a later process using the same Directory.  Disk
+	// spooling is disabled by default and is not used with OTLP or
+	// FileExport.
+	DiskSpool struct {
+		// Enabled controls whether payloads are spooled.
+		Enabled bool
+		// Directory contains the spooled payloads.  It is created if
+		// it does not exist.  Directory is required when DiskSpool is
+		// enabled, and should not be shared by different
+		// applications.
+		Directory string
+		// MaxBytes limits the total size of the spooled payloads.  The
+		// oldest payloads are dropped to make room for new ones.  The
+		// default is 10 MiB.
+		MaxBytes int64
+		// MaxAge is the age after which spooled payloads are dropped
+		// rather than sent.  The default is two hours.
+		MaxAge time.Duration
+	}
+
+	// FileExport configures the agent to write harvest data locally
+	// instead of sending it to New Relic, for environments without network
+	// access to New Relic.  Each harvest is written as a single line of
+	// JSON containing the same payloads that would be sent to the
+	// collector.  The application does not connect to New Relic, so
+	// server side configuration is not applied and a License is not
+	// required.  FileExport cannot be used with ServerlessMode or OTLP.
+	FileExport struct {
+		// Enabled controls whether harvest data is written locally.
+		Enabled bool
+		// Writer receives the harvest data if it is non-nil.  Otherwise
+		// the data is written to the file at Path.
+		Writer io.Writer
+		// Path is the file harvest data is appended to.  Once the file
+		// reaches MaxBytes it is renamed to Path.1, any previous Path.1
+		// is renamed to Path.2, and so on.
+		Path string
+		// MaxBytes is the size at which the file at Path is rotated.
+		// The default is 100 MiB.
+		MaxBytes int64
+		// MaxFiles is the number of rotated files kept.  The default
+		// is 5.
+		MaxFiles int
+	}
+
 	// ServerlessMode contains fields which control behavior when running in
 	// AWS Lambda.
 	//
@@ -654,6 +855,24 @@ func defaultConfig() Config {
 	c.Utilization.DetectKubernetes = true
 	c.Attributes.Enabled = true
 	c.RuntimeSampler.Enabled = true
+	c.Profiling.Enabled = false
+	c.Profiling.Period = defaultProfilingPeriod
+	c.Profiling.CPUDuration = defaultProfilingCPUDuration
+	c.Profiling.Types = ProfileAll
+	c.DimensionalMetrics.Enabled = false
+	c.DimensionalMetrics.MaxTimeSeries = defaultDimensionalMetricsMaxTimeSeries
+	c.DimensionalMetrics.HistogramBoundaries = defaultDimensionalMetricsHistogramBoundaries()
+	c.DurationDistributions.Enabled = false
+	c.DurationDistributions.RelativeAccuracy = defaultDurationDistributionsRelativeAccuracy
+	c.DurationDistributions.MaxBins = defaultDurationDistributionsMaxBins
+	c.OTLP.Enabled = false
+	c.OTLP.Protocol = OTLPProtocolProtobuf
+	c.DiskSpool.Enabled = false
+	c.DiskSpool.MaxBytes = defaultDiskSpoolMaxBytes
+	c.DiskSpool.MaxAge = defaultDiskSpoolMaxAge
+	c.FileExport.Enabled = false
+	c.FileExport.MaxBytes = defaultFileExportMaxBytes
+	c.FileExport.MaxFiles = defaultFileExportMaxFiles
 
 	c.TransactionTracer.Enabled = true
 	c.TransactionTracer.Threshold.IsApdexFailing = true
@@ -681,12 +900,16 @@ func defaultConfig() Config {
 	c.DistributedTracer.ReservoirLimit = internal.MaxSpanEvents
 	c.SpanEvents.Enabled = true
 	c.SpanEvents.Attributes.Enabled = true
+	c.SpanEvents.TailSampling.MaxBufferedSpans = defaultTailSamplingMaxBufferedSpans
+	c.SpanEvents.TailSampling.Errors = true
 
 	c.DatastoreTracer.InstanceReporting.Enabled = true
 	c.DatastoreTracer.DatabaseNameReporting.Enabled = true
 	c.DatastoreTracer.QueryParameters.Enabled = true
 	c.DatastoreTracer.SlowQuery.Enabled = true
 	c.DatastoreTracer.SlowQuery.Threshold = 10 * time.Millisecond
+	c.DatastoreTracer.SlowQuery.Explain.Enabled = true
+	c.DatastoreTracer.SlowQuery.Explain.Interval = time.Minute
 	c.DatastoreTracer.RawQuery.Enabled = false
 
 	c.ServerlessMode.ApdexThreshold = 500 * time.Millisecond
@@ -725,12 +948,24 @@ var (
 	errAppNameLimit                     = fmt.Errorf("max of %d rollup application names", appNameLimit)
 	errHighSecurityWithSecurityPolicies = errors.New("SecurityPoliciesToken and HighSecurity are incompatible; please ensure HighSecurity is set to false if SecurityPoliciesToken is a non-empty string and a security policy has been set for your account")
 	errInfTracingServerless             = errors.New("ServerlessMode cannot be used with Infinite Tracing")
+	errOTLPEndpointMissing              = errors.New("OTLP.Endpoint is required when OTLP is enabled")
+	errOTLPProtocol                     = fmt.Errorf("OTLP.Protocol must be %q or %q", OTLPProtocolProtobuf, OTLPProtocolJSON)
+	errOTLPServerless                   = errors.New("ServerlessMode cannot be used with OTLP")
+	errDiskSpoolDirectoryMissing        = errors.New("DiskSpool.Directory is required when DiskSpool is enabled")
+	errFileExportDestinationMissing     = errors.New("FileExport.Path or FileExport.Writer is required when FileExport is enabled")
+	errFileExportServerless             = errors.New("ServerlessMode cannot be used with FileExport")
+	errFileExportOTLP                   = errors.New("OTLP cannot be used with FileExport")
+	errProfilingFileExport              = errors.New("FileExport is required when Profiling is enabled")
+	errDimensionalMetricsMaxTimeSeries  = errors.New("DimensionalMetrics.MaxTimeSeries must be positive")
+	errDimensionalMetricsBoundaries     = errors.New("DimensionalMetrics.HistogramBoundaries must be finite and increasing")
+	errDurationDistributionsAccuracy    = errors.New("DurationDistributions.RelativeAccuracy must be between 0 and 1")
+	errDurationDistributionsMaxBins     = errors.New("DurationDistributions.MaxBins must be positive")
 )
 
 // validate checks the config for improper fields.  If the config is invalid,
 // newrelic.NewApplication returns an error.
 func (c Config) validate() error {
-	if c.Enabled && !c.ServerlessMode.Enabled {
+	if c.Enabled && !c.ServerlessMode.Enabled && !c.OTLP.Enabled && !c.FileExport.Enabled {
 		if len(c.License) != licenseLength {
 			return errLicenseLen
 		}
@@ -752,6 +987,52 @@ func (c Config) validate() error {
 	if c.InfiniteTracing.TraceObserver.Host != "" && c.ServerlessMode.Enabled {
 		return errInfTracingServerless
 	}
+	if c.OTLP.Enabled {
+		if c.ServerlessMode.Enabled {
+			return errOTLPServerless
+		}
+		if c.OTLP.Endpoint == "" {
+			return errOTLPEndpointMissing
+		}
+		if c.OTLP.Protocol != OTLPProtocolProtobuf && c.OTLP.Protocol != OTLPProtocolJSON {
+			return errOTLPProtocol
+		}
+	}
+	if c.DiskSpool.Enabled && c.DiskSpool.Directory == "" {
+		return errDiskSpoolDirectoryMissing
+	}
+	if c.FileExport.Enabled {
+		if c.ServerlessMode.Enabled {
+			return errFileExportServerless
+		}
+		if c.OTLP.Enabled {
+			return errFileExportOTLP
+		}
+		if c.FileExport.Path == "" && nil == c.FileExport.Writer {
+			return errFileExportDestinationMissing
+		}
+	}
+	if c.Profiling.Enabled && !c.FileExport.Enabled {
+		return errProfilingFileExport
+	}
+	if c.DimensionalMetrics.Enabled {
+		if c.DimensionalMetrics.MaxTimeSeries <= 0 {
+			return errDimensionalMetricsMaxTimeSeries
+		}
+		for i, b := range c.DimensionalMetrics.HistogramBoundaries {
+			if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= c.DimensionalMetrics.HistogramBoundaries[i-1]) {
+				return errDimensionalMetricsBoundaries
+			}
+		}
+	}
+	if c.DurationDistributions.Enabled {
+		if !(c.DurationDistributions.RelativeAccuracy > 0 && c.DurationDistributions.RelativeAccuracy < 1) {
+			return errDurationDistributionsAccuracy
+		}
+		if c.DurationDistributions.MaxBins <= 0 {
+			return errDurationDistributionsMaxBins
+		}
+	}
 
 	return nil
 }
@@ -827,6 +1108,12 @@ func copyConfigReferenceFields(cfg Config) Config {
 			cp.Labels[key] = val
 		}
 	}
+	if nil != cfg.SpanEvents.TailSampling.Attributes {
+		cp.SpanEvents.TailSampling.Attributes = make(map[string]string, len(cfg.SpanEvents.TailSampling.Attributes))
+		for key, val := range cfg.SpanEvents.TailSampling.Attributes {
+			cp.SpanEvents.TailSampling.Attributes[key] = val
+		}
+	}
 	if cfg.ErrorCollector.IgnoreStatusCodes != nil {
 		ignored := make([]int, len(cfg.ErrorCollector.IgnoreStatusCodes))
 		copy(ignored, cfg.ErrorCollector.IgnoreStatusCodes)
@@ -851,7 +1138,15 @@ func transportSetting(t http.RoundTripper) interface{} {
 	return fmt.Sprintf("%T", t)
 }
 
+func writerSetting(w io.Writer) interface{} {
+	if nil == w {
+		return nil
+	}
+	return fmt.Sprintf("%T", w)
+}
+
 func loggerSetting(lg Logger) interface{} {
+	lg = unwrapLogger(lg)
 	if nil == lg {
 		return nil
 	}
@@ -874,6 +1169,8 @@ func (s settings) MarshalJSON() ([]byte, error) {
 	c.Transport = nil
 	l := c.Logger
 	c.Logger = nil
+	fileExportWriter := c.FileExport.Writer
+	c.FileExport.Writer = nil
 
 	js, err := json.Marshal(c)
 	if err != nil {
@@ -917,6 +1214,18 @@ func (s settings) MarshalJSON() ([]byte, error) {
 		}
 	}
 
+	if fileExportConfig, ok := fields["FileExport"]; ok {
+		if fileExportMap, ok := fileExportConfig.(map[string]interface{}); ok {
+			fileExportMap["Writer"] = writerSetting(fileExportWriter)
+		}
+	}
+
+	if otlpConfig, ok := fields["OTLP"]; ok {
+		if otlpMap, ok := otlpConfig.(map[string]interface{}); ok {
+			delete(otlpMap, "Headers")
+		}
+	}
+
 	return json.Marshal(fields)
 }
 
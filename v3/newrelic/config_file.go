@@ -0,0 +1,597 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/yaml"
+)
+
+const (
+	// configFileCommonSection is the section of a configuration file which
+	// applies to every environment.
+	configFileCommonSection = "common"
+	// configFileEnvironmentEnv selects the environment section of a
+	// configuration file which is applied after the common section.
+	configFileEnvironmentEnv = "NEW_RELIC_ENVIRONMENT"
+)
+
+// configFileAliases maps the names used by the configuration files of other
+// New Relic agents to the names of the Config fields.  Names are normalized
+// using normalizeConfigKey.
+var configFileAliases = map[string]string{
+	"licensekey":           "license",
+	"monitormode":          "enabled",
+	"distributedtracing":   "distributedtracer",
+	"expectedstatuscodes":  "expectstatuscodes",
+	"transactionthreshold": "threshold",
+}
+
+var (
+	durationType  = reflect.TypeOf(time.Duration(0))
+	thresholdType = reflect.TypeOf(Config{}.TransactionTracer.Threshold)
+	profileType   = reflect.TypeOf(ProfileType(0))
+	labelsType    = reflect.TypeOf(Config{}.Labels)
+	textUnmarshal = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// ConfigFromFile populates the Config from a newrelic.yml style file.  Files
+// with a .json extension are decoded as JSON and all other files are decoded
+// as YAML.  Every Config field which can be represented in a file may be set.
+// Keys may be written either as the Config field names or in snake case, and
+// nested fields may be written as nested mappings or as dotted keys:
+//
+//	app_name: My Application
+//	license_key: __YOUR_NEW_RELIC_LICENSE_KEY__
+//	labels:
+//	  Server: One
+//	transaction_tracer:
+//	  transaction_threshold: apdex_f
+//	  segments:
+//	    stack_trace_threshold: 0.5
+//	datastore_tracer.slow_query.threshold: 10ms
+//	error_collector:
+//	  expected_status_codes: [404, 409]
+//	log_file_name: stdout
+//	log_level: debug
+//
+// Durations may be given as a number of seconds or as a string parsed by
+// time.ParseDuration.  The transaction trace threshold may also be "apdex_f".
+// Lists may be given as sequences or as comma separated strings.  Profiling
+// types are given as a list of "cpu", "heap", "goroutine", and "mutex".  The
+// log_file_name key may be "stdout", "stderr", or a file path, and log_level
+// may be "info" or "debug".  Fields which hold Go values, such as Logger,
+// Transport, and ErrorCollector.ErrorGroupCallback, must be set in code.
+//
+// As with the configuration files of other New Relic agents, the file may
+// instead contain a "common" section together with sections for each
+// environment.  The common section is applied first, followed by the section
+// named by the NEW_RELIC_ENVIRONMENT environment variable if it is set.
+// Environment sections may merge the common section using the anchors and
+// merge keys of the stock newrelic.yml:
+//
+//	common: &default_settings
+//	  app_name: My Application
+//	development:
+//	  <<: *default_settings
+//	  app_name: My Application (Development)
+//
+// Environment variables read by ConfigFromEnvironment are applied after the
+// file and take precedence over it, so ConfigFromEnvironment does not need to
+// be used in addition to ConfigFromFile.
+//
+// This function is strict and will assign Config.Error if the file cannot be
+// read or decoded, if it contains keys which do not match a Config field, or
+// if any of its values are invalid.  All problems found in the file are
+// included in the error.
+func ConfigFromFile(path string) ConfigOption {
+	return configFromFile(path, os.Getenv)
+}
+
+func configFromFile(path string, getenv func(string) string) ConfigOption {
+	return func(cfg *Config) {
+		data, err := os.ReadFile(path)
+		if nil != err {
+			cfg.Error = fmt.Errorf("unable to read configuration file: %v", err)
+			return
+		}
+		var fields interface{}
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			err = json.Unmarshal(data, &fields)
+		} else {
+			fields, err = yaml.Unmarshal(data)
+		}
+		if nil != err {
+			cfg.Error = fmt.Errorf("unable to decode configuration file %s: %v", path, err)
+			return
+		}
+		if err := applyConfigFile(cfg, fields, getenv(configFileEnvironmentEnv)); nil != err {
+			cfg.Error = fmt.Errorf("invalid configuration file %s: %v", path, err)
+			return
+		}
+		configFromEnvironment(getenv)(cfg)
+	}
+}
+
+// applyConfigFile sets the fields of the Config present in the decoded file.
+func applyConfigFile(cfg *Config, fields interface{}, environment string) error {
+	if nil == fields {
+		return nil
+	}
+	m, ok := fields.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a mapping of settings, got %s", describeConfigValue(fields))
+	}
+	d := &configDecoder{}
+	if common, ok := m[configFileCommonSection]; ok {
+		d.decodeSection(cfg, configFileCommonSection, common)
+		if env, ok := m[environment]; ok && environment != "" && environment != configFileCommonSection {
+			d.decodeSection(cfg, environment, env)
+		}
+	} else {
+		d.decodeSection(cfg, "", m)
+	}
+	if len(d.problems) > 0 {
+		sort.Strings(d.problems)
+		return fmt.Errorf("%s", strings.Join(d.problems, "; "))
+	}
+	return nil
+}
+
+// configDecoder records every problem found in the file so that they can be
+// reported together.
+type configDecoder struct {
+	problems []string
+}
+
+func (d *configDecoder) addProblem(format string, args ...interface{}) {
+	d.problems = append(d.problems, fmt.Sprintf(format, args...))
+}
+
+func (d *configDecoder) decodeSection(cfg *Config, section string, fields interface{}) {
+	if nil == fields {
+		return
+	}
+	m, ok := fields.(map[string]interface{})
+	if !ok {
+		d.addProblem("%s: expected a mapping, got %s", section, describeConfigValue(fields))
+		return
+	}
+	// The logging settings used by other agents are converted to a
+	// Logger.
+	m = d.decodeLogger(cfg, section, m)
+	d.decodeStruct(reflect.ValueOf(cfg).Elem(), section, m)
+}
+
+// configFileLogger is a Logger created from the log_file_name and log_level
+// keys.  The settings are recorded so that applying an unchanged file again,
+// as WatchConfigFile does, keeps the Logger and its file.
+type configFileLogger struct {
+	Logger
+	name  string
+	debug bool
+	// file is nil when the Logger writes to stdout or stderr.
+	file *os.File
+}
+
+// closeConfigFileLogger closes the file opened for lg if lg was created from
+// a configuration file and is not the Logger in use.
+func closeConfigFileLogger(lg, inUse Logger) {
+	fl, ok := unwrapLogger(lg).(*configFileLogger)
+	if !ok || nil == fl.file || Logger(fl) == unwrapLogger(inUse) {
+		return
+	}
+	fl.file.Close()
+}
+
+// decodeLogger creates the Logger from the log_file_name and log_level keys,
+// returning the remaining keys.  The current Logger is kept if it was created
+// with the same settings.
+func (d *configDecoder) decodeLogger(cfg *Config, prefix string, m map[string]interface{}) map[string]interface{} {
+	var dest, level interface{}
+	rest := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		switch normalizeConfigKey(k) {
+		case "logfilename":
+			dest = v
+		case "loglevel":
+			level = v
+		default:
+			rest[k] = v
+		}
+	}
+	if nil == dest {
+		if nil != level {
+			d.addProblem("%s: log_level requires log_file_name", joinConfigKey(prefix, "log_level"))
+		}
+		return rest
+	}
+	name, ok := dest.(string)
+	if !ok || name == "" {
+		d.addProblem("%s: expected a file name, got %s", joinConfigKey(prefix, "log_file_name"), describeConfigValue(dest))
+		return rest
+	}
+	var debug bool
+	switch lvl, _ := level.(string); {
+	case nil == level || strings.EqualFold(lvl, "info"):
+	case isDebugEnv(lvl):
+		debug = true
+	default:
+		d.addProblem("%s: expected info or debug, got %s", joinConfigKey(prefix, "log_level"), describeConfigValue(level))
+		return rest
+	}
+	if prev, ok := unwrapLogger(cfg.Logger).(*configFileLogger); ok && prev.name == name && prev.debug == debug {
+		return rest
+	}
+	lg := &configFileLogger{name: name, debug: debug}
+	w := getLogDest(name)
+	if nil == w {
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if nil != err {
+			d.addProblem("%s: %v", joinConfigKey(prefix, "log_file_name"), err)
+			return rest
+		}
+		lg.file = f
+		w = f
+	}
+	if debug {
+		lg.Logger = NewDebugLogger(w)
+	} else {
+		lg.Logger = NewLogger(w)
+	}
+	// A Logger created by an earlier section of the same file has not been
+	// used by an application.
+	if _, ok := cfg.Logger.(*configFileLogger); ok {
+		closeConfigFileLogger(cfg.Logger, lg)
+	}
+	cfg.Logger = lg
+	return rest
+}
+
+// normalizeConfigKey allows keys to be written either as Config field names
+// or in snake case.
+func normalizeConfigKey(key string) string {
+	key = strings.ToLower(key)
+	key = strings.ReplaceAll(key, "_", "")
+	key = strings.ReplaceAll(key, "-", "")
+	if alias, ok := configFileAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+func joinConfigKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func describeConfigValue(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// configFieldSettable returns false for fields which hold Go values and so
+// cannot be set from a file.
+func configFieldSettable(f reflect.StructField) bool {
+	if f.Tag.Get("json") == "-" {
+		return false
+	}
+	switch f.Type.Kind() {
+	case reflect.Interface, reflect.Func, reflect.Ptr, reflect.Chan:
+		return false
+	}
+	return true
+}
+
+func (d *configDecoder) decodeStruct(v reflect.Value, prefix string, m map[string]interface{}) {
+	fields := make(map[string]int, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Type().Field(i); f.IsExported() {
+			fields[strings.ToLower(f.Name)] = i
+		}
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		val := m[k]
+		name := k
+		// Dotted keys set nested fields.
+		if idx := strings.Index(k, "."); idx > 0 {
+			name = k[:idx]
+			val = map[string]interface{}{k[idx+1:]: val}
+		}
+		path := joinConfigKey(prefix, name)
+		i, ok := fields[normalizeConfigKey(name)]
+		if !ok {
+			d.addProblem("%s: unknown key", path)
+			continue
+		}
+		if !configFieldSettable(v.Type().Field(i)) {
+			d.addProblem("%s: cannot be set from a configuration file", path)
+			continue
+		}
+		if nil == val {
+			continue
+		}
+		d.decodeValue(v.Field(i), path, val)
+	}
+}
+
+func (d *configDecoder) decodeValue(v reflect.Value, path string, val interface{}) {
+	invalid := func() {
+		d.addProblem("%s: invalid value %s", path, describeConfigValue(val))
+	}
+
+	switch v.Type() {
+	case durationType:
+		if dur, ok := configDuration(val); ok {
+			v.SetInt(int64(dur))
+		} else {
+			invalid()
+		}
+		return
+	case thresholdType:
+		if s, ok := val.(string); ok && strings.EqualFold(s, "apdex_f") {
+			v.FieldByName("IsApdexFailing").SetBool(true)
+		} else if dur, ok := configDuration(val); ok {
+			v.FieldByName("IsApdexFailing").SetBool(false)
+			v.FieldByName("Duration").SetInt(int64(dur))
+		} else if m, ok := val.(map[string]interface{}); ok {
+			d.decodeStruct(v, path, m)
+		} else {
+			invalid()
+		}
+		return
+	case profileType:
+		if types, ok := configProfileTypes(val); ok {
+			v.SetUint(uint64(types))
+		} else {
+			invalid()
+		}
+		return
+	case labelsType:
+		if s, ok := val.(string); ok {
+			labels, err := getLabels(s)
+			if nil != err {
+				d.addProblem("%s: %v", path, err)
+			} else {
+				v.Set(reflect.ValueOf(labels))
+			}
+			return
+		}
+	}
+
+	if v.Addr().Type().Implements(textUnmarshal) {
+		text, ok := configString(val)
+		if !ok {
+			text, ok = configStringList(val)
+		}
+		if !ok {
+			invalid()
+		} else if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); nil != err {
+			d.addProblem("%s: %v", path, err)
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		switch b := val.(type) {
+		case bool:
+			v.SetBool(b)
+		case string:
+			if parsed, err := strconv.ParseBool(b); nil == err {
+				v.SetBool(parsed)
+			} else {
+				invalid()
+			}
+		default:
+			invalid()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := configInt(val); ok && !v.OverflowInt(i) {
+			v.SetInt(i)
+		} else {
+			invalid()
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := configInt(val); ok && i >= 0 && !v.OverflowUint(uint64(i)) {
+			v.SetUint(uint64(i))
+		} else {
+			invalid()
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := configFloat(val); ok {
+			v.SetFloat(f)
+		} else {
+			invalid()
+		}
+	case reflect.String:
+		if s, ok := configString(val); ok {
+			v.SetString(s)
+		} else {
+			invalid()
+		}
+	case reflect.Slice:
+		var items []interface{}
+		switch x := val.(type) {
+		case []interface{}:
+			items = x
+		case string:
+			for _, part := range strings.Split(x, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					items = append(items, part)
+				}
+			}
+		default:
+			invalid()
+			return
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			d.decodeValue(slice.Index(i), fmt.Sprintf("%s[%d]", path, i), item)
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m, ok := val.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			invalid()
+			return
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, item := range m {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.decodeValue(elem, joinConfigKey(path, k), item)
+			out.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+		}
+		v.Set(out)
+	case reflect.Struct:
+		if m, ok := val.(map[string]interface{}); ok {
+			d.decodeStruct(v, path, m)
+		} else {
+			invalid()
+		}
+	default:
+		d.addProblem("%s: cannot be set from a configuration file", path)
+	}
+}
+
+// configInt converts integers decoded from YAML and whole numbers decoded
+// from JSON.
+func configInt(val interface{}) (int64, bool) {
+	switch x := val.(type) {
+	case int64:
+		return x, true
+	case float64:
+		if x == math.Trunc(x) && x >= math.MinInt64 && x <= math.MaxInt64 {
+			return int64(x), true
+		}
+	case string:
+		if i, err := strconv.ParseInt(x, 10, 64); nil == err {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func configFloat(val interface{}) (float64, bool) {
+	switch x := val.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case string:
+		if f, err := strconv.ParseFloat(x, 64); nil == err {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// configString allows numbers and booleans to be used where strings are
+// expected, for example a numeric application name.
+func configString(val interface{}) (string, bool) {
+	switch x := val.(type) {
+	case string:
+		return x, true
+	case bool, int64:
+		return fmt.Sprint(x), true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// configStringList joins a list of strings with commas.
+func configStringList(val interface{}) (string, bool) {
+	items, ok := val.([]interface{})
+	if !ok {
+		return "", false
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		if parts[i], ok = configString(item); !ok {
+			return "", false
+		}
+	}
+	return strings.Join(parts, ","), true
+}
+
+// configDuration converts a number of seconds or a string parsed by
+// time.ParseDuration.
+func configDuration(val interface{}) (time.Duration, bool) {
+	if s, ok := val.(string); ok {
+		if d, err := time.ParseDuration(s); nil == err {
+			return d, true
+		}
+	}
+	if f, ok := configFloat(val); ok {
+		return time.Duration(f * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// configProfileTypes converts a list of profile type names.
+func configProfileTypes(val interface{}) (ProfileType, bool) {
+	var names []string
+	switch x := val.(type) {
+	case string:
+		names = strings.Split(x, ",")
+	case []interface{}:
+		for _, item := range x {
+			s, ok := item.(string)
+			if !ok {
+				return 0, false
+			}
+			names = append(names, s)
+		}
+	default:
+		return 0, false
+	}
+	var types ProfileType
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			types |= ProfileAll
+			continue
+		}
+		found := false
+		for tp := ProfileCPU; tp <= ProfileMutex; tp <<= 1 {
+			if tp.String() == name {
+				types |= tp
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return types, true
+}
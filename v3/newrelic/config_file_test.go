@@ -0,0 +1,269 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); nil != err {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func configFromTestFile(path string, env map[string]string) Config {
+	cfg := defaultConfig()
+	configFromFile(path, func(s string) string { return env[s] })(&cfg)
+	return cfg
+}
+
+func TestConfigFromFileYAML(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.yml", `
+app_name: My Application
+license_key: 0123456789012345678901234567890123456789
+monitor_mode: true
+labels:
+  Server: One
+attributes:
+  exclude: [zip, zap]
+distributed_tracing:
+  enabled: false
+transaction_tracer:
+  transaction_threshold: 1.5
+  segments:
+    stack_trace_threshold: 250ms
+datastore_tracer.slow_query.threshold: 0.01
+error_collector:
+  expected_status_codes:
+    - 404
+    - 409
+  ignore_status_codes: 418, 419
+code_level_metrics:
+  scope: [transaction]
+profiling:
+  types: [cpu, heap]
+Utilization:
+  TotalRAMMIB: 1024
+`)
+	cfg := configFromTestFile(path, nil)
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if cfg.AppName != "My Application" || cfg.License != "0123456789012345678901234567890123456789" || !cfg.Enabled {
+		t.Error(cfg.AppName, cfg.License, cfg.Enabled)
+	}
+	if !reflect.DeepEqual(cfg.Labels, map[string]string{"Server": "One"}) {
+		t.Error(cfg.Labels)
+	}
+	if !reflect.DeepEqual(cfg.Attributes.Exclude, []string{"zip", "zap"}) {
+		t.Error(cfg.Attributes.Exclude)
+	}
+	if cfg.DistributedTracer.Enabled {
+		t.Error("distributed tracing not disabled")
+	}
+	if th := cfg.TransactionTracer.Threshold; th.IsApdexFailing || th.Duration != 1500*time.Millisecond {
+		t.Error(th)
+	}
+	if d := cfg.TransactionTracer.Segments.StackTraceThreshold; d != 250*time.Millisecond {
+		t.Error(d)
+	}
+	if d := cfg.DatastoreTracer.SlowQuery.Threshold; d != 10*time.Millisecond {
+		t.Error(d)
+	}
+	if !reflect.DeepEqual(cfg.ErrorCollector.ExpectStatusCodes, []int{404, 409}) {
+		t.Error(cfg.ErrorCollector.ExpectStatusCodes)
+	}
+	if !reflect.DeepEqual(cfg.ErrorCollector.IgnoreStatusCodes, []int{418, 419}) {
+		t.Error(cfg.ErrorCollector.IgnoreStatusCodes)
+	}
+	if cfg.CodeLevelMetrics.Scope != TransactionCLM {
+		t.Error(cfg.CodeLevelMetrics.Scope)
+	}
+	if cfg.Profiling.Types != ProfileCPU|ProfileHeap {
+		t.Error(cfg.Profiling.Types)
+	}
+	if cfg.Utilization.TotalRAMMIB != 1024 {
+		t.Error(cfg.Utilization.TotalRAMMIB)
+	}
+	// Fields not present in the file are unchanged.
+	if !cfg.TransactionTracer.Enabled || cfg.ErrorCollector.RecordPanics {
+		t.Error(cfg.TransactionTracer.Enabled, cfg.ErrorCollector.RecordPanics)
+	}
+}
+
+func TestConfigFromFileJSON(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.json", `{
+		"AppName": "My Application",
+		"TransactionTracer": {"Threshold": "apdex_f", "Segments": {"Threshold": 1}},
+		"ErrorCollector": {"IgnoreStatusCodes": [404]},
+		"Labels": "Server:One;Data Center:Primary"
+	}`)
+	cfg := configFromTestFile(path, nil)
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if cfg.AppName != "My Application" || !cfg.TransactionTracer.Threshold.IsApdexFailing {
+		t.Error(cfg.AppName, cfg.TransactionTracer.Threshold)
+	}
+	if cfg.TransactionTracer.Segments.Threshold != time.Second {
+		t.Error(cfg.TransactionTracer.Segments.Threshold)
+	}
+	if !reflect.DeepEqual(cfg.ErrorCollector.IgnoreStatusCodes, []int{404}) {
+		t.Error(cfg.ErrorCollector.IgnoreStatusCodes)
+	}
+	if !reflect.DeepEqual(cfg.Labels, map[string]string{"Server": "One", "Data Center": "Primary"}) {
+		t.Error(cfg.Labels)
+	}
+}
+
+func TestConfigFromFileEnvironmentOverrides(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.yml", `
+app_name: From File
+high_security: true
+`)
+	cfg := configFromTestFile(path, map[string]string{
+		"NEW_RELIC_APP_NAME":      "From Environment",
+		"NEW_RELIC_HIGH_SECURITY": "false",
+	})
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if cfg.AppName != "From Environment" || cfg.HighSecurity {
+		t.Error(cfg.AppName, cfg.HighSecurity)
+	}
+}
+
+func TestConfigFromFileSections(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.yml", `
+common:
+  app_name: My Application
+  transaction_events:
+    enabled: false
+production:
+  app_name: My Application (Production)
+development:
+  monitor_mode: false
+`)
+	cfg := configFromTestFile(path, map[string]string{"NEW_RELIC_ENVIRONMENT": "production"})
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if cfg.AppName != "My Application (Production)" || cfg.TransactionEvents.Enabled || !cfg.Enabled {
+		t.Error(cfg.AppName, cfg.TransactionEvents.Enabled, cfg.Enabled)
+	}
+
+	cfg = configFromTestFile(path, nil)
+	if cfg.AppName != "My Application" || !cfg.Enabled {
+		t.Error(cfg.AppName, cfg.Enabled)
+	}
+}
+
+func TestConfigFromFileStockLayout(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.yml", `
+common: &default_settings
+  license_key: '0123456789abcdef0123456789abcdef01234567'
+  app_name: My Application
+  distributed_tracing:
+    enabled: true
+
+development:
+  <<: *default_settings
+  app_name: My Application (Development)
+
+test:
+  <<: *default_settings
+  monitor_mode: false
+
+production:
+  <<: *default_settings
+`)
+	for env, want := range map[string]struct {
+		appName string
+		enabled bool
+	}{
+		"":            {appName: "My Application", enabled: true},
+		"development": {appName: "My Application (Development)", enabled: true},
+		"test":        {appName: "My Application", enabled: false},
+		"production":  {appName: "My Application", enabled: true},
+	} {
+		cfg := configFromTestFile(path, map[string]string{"NEW_RELIC_ENVIRONMENT": env})
+		if nil != cfg.Error {
+			t.Fatal(env, cfg.Error)
+		}
+		if cfg.AppName != want.appName || cfg.Enabled != want.enabled ||
+			cfg.License != "0123456789abcdef0123456789abcdef01234567" || !cfg.DistributedTracer.Enabled {
+			t.Error(env, cfg.AppName, cfg.Enabled, cfg.License, cfg.DistributedTracer.Enabled)
+		}
+	}
+}
+
+func TestConfigFromFileLogger(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "agent.log")
+	path := writeConfigFile(t, "newrelic.yml", "log_file_name: "+logPath+"\nlog_level: debug\n")
+	cfg := configFromTestFile(path, nil)
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if nil == cfg.Logger || !cfg.Logger.DebugEnabled() {
+		t.Fatal(cfg.Logger)
+	}
+	cfg.Logger.Debug("hello", nil)
+	if data, err := os.ReadFile(logPath); nil != err || !strings.Contains(string(data), `"msg":"hello"`) {
+		t.Error(string(data), err)
+	}
+}
+
+func TestConfigFromFileErrors(t *testing.T) {
+	path := writeConfigFile(t, "newrelic.yml", `
+app_nme: typo
+transaction_tracer:
+  enabled: maybe
+  unknown_setting: 1
+logger: stdout
+error_collector:
+  ignore_status_codes: [404, four]
+log_level: verbose
+`)
+	cfg := configFromTestFile(path, nil)
+	if nil == cfg.Error {
+		t.Fatal("expected an error")
+	}
+	msg := cfg.Error.Error()
+	for _, expect := range []string{
+		path,
+		`app_nme: unknown key`,
+		`transaction_tracer.unknown_setting: unknown key`,
+		`transaction_tracer.enabled: invalid value "maybe"`,
+		`logger: cannot be set from a configuration file`,
+		`error_collector.ignore_status_codes[1]: invalid value "four"`,
+		`log_level: log_level requires log_file_name`,
+	} {
+		if !strings.Contains(msg, expect) {
+			t.Errorf("error %q does not contain %q", msg, expect)
+		}
+	}
+}
+
+func TestConfigFromFileInvalidFile(t *testing.T) {
+	cfg := configFromTestFile(filepath.Join(t.TempDir(), "missing.yml"), nil)
+	if nil == cfg.Error {
+		t.Error("expected error for missing file")
+	}
+	cfg = configFromTestFile(writeConfigFile(t, "newrelic.yml", "a: 1\n  b: 2\n"), nil)
+	if nil == cfg.Error || !strings.Contains(cfg.Error.Error(), "line 2") {
+		t.Error(cfg.Error)
+	}
+	cfg = configFromTestFile(writeConfigFile(t, "newrelic.yml", "- a\n"), nil)
+	if nil == cfg.Error {
+		t.Error("expected error for sequence")
+	}
+}
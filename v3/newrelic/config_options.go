@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -348,6 +349,117 @@ func ConfigModuleDependencyMetricsIgnoredPrefixes(prefix ...string) ConfigOption
 	}
 }
 
+// ConfigProfilingEnabled controls whether the agent continuously captures
+// runtime/pprof profiles.  Profiles are written with the exported harvest
+// data, so FileExport must also be enabled.
+func ConfigProfilingEnabled(enabled bool) ConfigOption {
+	return func(cfg *Config) {
+		cfg.Profiling.Enabled = enabled
+	}
+}
+
+// ConfigProfilingTypes sets the kinds of profiles captured when profiling is
+// enabled.  Pass any number of ProfileType values, for example:
+//
+//	newrelic.ConfigProfilingTypes(newrelic.ProfileHeap, newrelic.ProfileGoroutine)
+func ConfigProfilingTypes(types ...ProfileType) ConfigOption {
+	return func(cfg *Config) {
+		cfg.Profiling.Types = 0
+		for _, tp := range types {
+			cfg.Profiling.Types |= tp
+		}
+	}
+}
+
+// ConfigProfilingPeriod sets the time between profile captures.
+func ConfigProfilingPeriod(period time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.Profiling.Period = period
+	}
+}
+
+// ConfigDimensionalMetricsEnabled controls whether metrics recorded with
+// Application.RecordCounter, RecordGauge, RecordSummary, and RecordHistogram
+// are sent to the New Relic Metric API.
+func ConfigDimensionalMetricsEnabled(enabled bool) ConfigOption {
+	return func(cfg *Config) {
+		cfg.DimensionalMetrics.Enabled = enabled
+	}
+}
+
+// ConfigDimensionalMetricsHistogramBoundaries sets the increasing upper
+// bounds of the buckets of histograms recorded with
+// Application.RecordHistogram.
+func ConfigDimensionalMetricsHistogramBoundaries(boundaries ...float64) ConfigOption {
+	return func(cfg *Config) {
+		cfg.DimensionalMetrics.HistogramBoundaries = boundaries
+	}
+}
+
+// ConfigDurationDistributionsEnabled controls whether the durations of
+// transactions and of datastore and external segments are reported as
+// distributions from which percentiles can be computed.
+func ConfigDurationDistributionsEnabled(enabled bool) ConfigOption {
+	return func(cfg *Config) {
+		cfg.DurationDistributions.Enabled = enabled
+	}
+}
+
+// ConfigOTLPEndpoint sends span events, metrics, and log events to the OTLP/HTTP
+// endpoint given instead of New Relic.  For example:
+//
+//	newrelic.ConfigOTLPEndpoint("http://localhost:4318", newrelic.OTLPProtocolProtobuf)
+func ConfigOTLPEndpoint(endpoint string, protocol string) ConfigOption {
+	return func(cfg *Config) {
+		cfg.OTLP.Enabled = true
+		cfg.OTLP.Endpoint = endpoint
+		cfg.OTLP.Protocol = protocol
+	}
+}
+
+// ConfigDiskSpool enables buffering of harvest data in the directory given
+// while New Relic cannot be reached.  The spooled data is sent after the
+// application next connects.
+func ConfigDiskSpool(directory string) ConfigOption {
+	return func(cfg *Config) {
+		cfg.DiskSpool.Enabled = true
+		cfg.DiskSpool.Directory = directory
+	}
+}
+
+// ConfigSpanEventsTailSampling enables tail-based sampling of span events.
+// Span events are held until each transaction ends, and the trace is kept if
+// the transaction was sampled, noticed an error, or took at least
+// durationThreshold.  A durationThreshold of zero disables the duration rule.
+func ConfigSpanEventsTailSampling(durationThreshold time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.SpanEvents.TailSampling.Enabled = true
+		cfg.SpanEvents.TailSampling.DurationThreshold = durationThreshold
+	}
+}
+
+// ConfigFileExport writes harvest data to the file given as newline delimited
+// JSON instead of sending it to New Relic.  The file is rotated once it
+// reaches FileExport.MaxBytes.
+func ConfigFileExport(path string) ConfigOption {
+	return func(cfg *Config) {
+		cfg.FileExport.Enabled = true
+		cfg.FileExport.Path = path
+		cfg.FileExport.Writer = nil
+	}
+}
+
+// ConfigWriterExport writes harvest data to the writer given as newline
+// delimited JSON instead of sending it to New Relic.  For example:
+//
+//	newrelic.ConfigWriterExport(os.Stdout)
+func ConfigWriterExport(w io.Writer) ConfigOption {
+	return func(cfg *Config) {
+		cfg.FileExport.Enabled = true
+		cfg.FileExport.Writer = w
+	}
+}
+
 // ConfigSetErrorGroupCallbackFunction set a callback function of type ErrorGroupCallback that will
 // be invoked against errors at harvest time. This function overrides the default grouping behavior
 // of errors into a custom, user defined group when set. Setting this may have performance implications
@@ -415,6 +527,14 @@ func ConfigLabels(labels map[string]string) ConfigOption {
 //			NEW_RELIC_LOG                                     			sets Logger to log to either "stdout" or "stderr" (filenames are not supported)
 //			NEW_RELIC_LOG_LEVEL                               			controls the NEW_RELIC_LOG level, must be "debug" for debug, or empty for info
 //			NEW_RELIC_PROCESS_HOST_DISPLAY_NAME               			sets HostDisplayName
+//			NEW_RELIC_PROFILING_ENABLED                       			sets Profiling.Enabled using strconv.ParseBool
+//			NEW_RELIC_OTLP_ENABLED                            			sets OTLP.Enabled using strconv.ParseBool
+//			NEW_RELIC_OTLP_ENDPOINT                           			sets OTLP.Endpoint
+//			NEW_RELIC_OTLP_PROTOCOL                           			sets OTLP.Protocol
+//			NEW_RELIC_DISK_SPOOL_ENABLED                      			sets DiskSpool.Enabled using strconv.ParseBool
+//			NEW_RELIC_DISK_SPOOL_DIRECTORY                    			sets DiskSpool.Directory
+//			NEW_RELIC_SPAN_EVENTS_TAIL_SAMPLING_ENABLED       			sets SpanEvents.TailSampling.Enabled using strconv.ParseBool
+//			NEW_RELIC_FILE_EXPORT                             			sets FileExport.Enabled and FileExport.Path, or FileExport.Writer if "stdout" or "stderr"
 //			NEW_RELIC_SECURITY_POLICIES_TOKEN                 			sets SecurityPoliciesToken
 //			NEW_RELIC_UTILIZATION_BILLING_HOSTNAME            			sets Utilization.BillingHostname
 //			NEW_RELIC_UTILIZATION_LOGICAL_PROCESSORS          			sets Utilization.LogicalProcessors using strconv.Atoi
@@ -486,6 +606,13 @@ func configFromEnvironment(getenv func(string) string) ConfigOption {
 		assignString(&cfg.SecurityPoliciesToken, "NEW_RELIC_SECURITY_POLICIES_TOKEN")
 		assignString(&cfg.Host, "NEW_RELIC_HOST")
 		assignString(&cfg.HostDisplayName, "NEW_RELIC_PROCESS_HOST_DISPLAY_NAME")
+		assignBool(&cfg.Profiling.Enabled, "NEW_RELIC_PROFILING_ENABLED")
+		assignBool(&cfg.OTLP.Enabled, "NEW_RELIC_OTLP_ENABLED")
+		assignString(&cfg.OTLP.Endpoint, "NEW_RELIC_OTLP_ENDPOINT")
+		assignString(&cfg.OTLP.Protocol, "NEW_RELIC_OTLP_PROTOCOL")
+		assignBool(&cfg.DiskSpool.Enabled, "NEW_RELIC_DISK_SPOOL_ENABLED")
+		assignString(&cfg.DiskSpool.Directory, "NEW_RELIC_DISK_SPOOL_DIRECTORY")
+		assignBool(&cfg.SpanEvents.TailSampling.Enabled, "NEW_RELIC_SPAN_EVENTS_TAIL_SAMPLING_ENABLED")
 		assignString(&cfg.Utilization.BillingHostname, "NEW_RELIC_UTILIZATION_BILLING_HOSTNAME")
 		assignString(&cfg.InfiniteTracing.TraceObserver.Host, "NEW_RELIC_INFINITE_TRACING_TRACE_OBSERVER_HOST")
 		assignInt(&cfg.InfiniteTracing.TraceObserver.Port, "NEW_RELIC_INFINITE_TRACING_TRACE_OBSERVER_PORT")
@@ -546,6 +673,15 @@ func configFromEnvironment(getenv func(string) string) ConfigOption {
 			cfg.ModuleDependencyMetrics.IgnoredPrefixes = strings.Split(env, ",")
 		}
 
+		if env := getenv("NEW_RELIC_FILE_EXPORT"); env != "" {
+			cfg.FileExport.Enabled = true
+			if dest := getLogDest(env); dest != nil {
+				cfg.FileExport.Writer = dest
+			} else {
+				cfg.FileExport.Path = env
+			}
+		}
+
 		if env := getenv("NEW_RELIC_LOG"); env != "" {
 			if dest := getLogDest(env); dest != nil {
 				if isDebugEnv(getenv("NEW_RELIC_LOG_LEVEL")) {
@@ -4,6 +4,8 @@
 package newrelic
 
 import (
+	"io"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -221,6 +223,30 @@ func TestConfigFromEnvironmentInvalidLogger(t *testing.T) {
 	}
 }
 
+func TestConfigFromEnvironmentFileExport(t *testing.T) {
+	for _, tc := range []struct {
+		env    string
+		path   string
+		writer io.Writer
+	}{
+		{env: "/var/log/newrelic.json", path: "/var/log/newrelic.json"},
+		{env: "stdout", writer: os.Stdout},
+		{env: "STDERR", writer: os.Stderr},
+	} {
+		cfgOpt := configFromEnvironment(func(s string) string {
+			if s == "NEW_RELIC_FILE_EXPORT" {
+				return tc.env
+			}
+			return ""
+		})
+		cfg := defaultConfig()
+		cfgOpt(&cfg)
+		if !cfg.FileExport.Enabled || cfg.FileExport.Path != tc.path || cfg.FileExport.Writer != tc.writer {
+			t.Error(tc.env, cfg.FileExport)
+		}
+	}
+}
+
 func TestConfigFromEnvironmentInvalidLabels(t *testing.T) {
 	cfgOpt := configFromEnvironment(func(s string) string {
 		switch s {
@@ -0,0 +1,265 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/logger"
+)
+
+const defaultConfigFileWatchInterval = 10 * time.Second
+
+var (
+	errConfigUpdateShutDown = errors.New("configuration cannot be updated after shutdown")
+)
+
+// configUpdate is sent to the processor goroutine when the configuration has
+// been changed by UpdateConfig.
+type configUpdate struct {
+	// reconnect is true when the change affects the connect payload, and
+	// so the application must connect again for it to take effect.
+	reconnect bool
+}
+
+// loggerBox allows Loggers of different types to be stored in the same
+// atomic.Value.
+type loggerBox struct{ Logger }
+
+// reloadableLogger is the Logger used by the application so that the Logger
+// can be replaced by UpdateConfig, for example to change the log level.
+type reloadableLogger struct {
+	current atomic.Value
+}
+
+func newReloadableLogger(lg Logger) *reloadableLogger {
+	if r, ok := lg.(*reloadableLogger); ok {
+		return r
+	}
+	r := &reloadableLogger{}
+	r.set(lg)
+	return r
+}
+
+func (r *reloadableLogger) set(lg Logger) {
+	if nil == lg {
+		lg = logger.ShimLogger{}
+	}
+	r.current.Store(loggerBox{lg})
+}
+
+func (r *reloadableLogger) get() Logger { return r.current.Load().(loggerBox).Logger }
+
+func (r *reloadableLogger) Error(msg string, c map[string]interface{}) { r.get().Error(msg, c) }
+func (r *reloadableLogger) Warn(msg string, c map[string]interface{})  { r.get().Warn(msg, c) }
+func (r *reloadableLogger) Info(msg string, c map[string]interface{})  { r.get().Info(msg, c) }
+func (r *reloadableLogger) Debug(msg string, c map[string]interface{}) { r.get().Debug(msg, c) }
+func (r *reloadableLogger) DebugEnabled() bool                         { return r.get().DebugEnabled() }
+
+// unwrapLogger returns the Logger provided by the user.
+func unwrapLogger(lg Logger) Logger {
+	if r, ok := lg.(*reloadableLogger); ok {
+		return r.get()
+	}
+	return lg
+}
+
+// configFieldChange compares a setting of two configurations.
+type configFieldChange struct {
+	name    string
+	changed func(a, b Config) bool
+}
+
+func changed(get func(c Config) interface{}) func(a, b Config) bool {
+	return func(a, b Config) bool { return !reflect.DeepEqual(get(a), get(b)) }
+}
+
+// immutableConfigFields are used when the application is created and cannot
+// be changed by UpdateConfig.
+var immutableConfigFields = []configFieldChange{
+	{"Enabled", changed(func(c Config) interface{} { return c.Enabled })},
+	{"License", changed(func(c Config) interface{} { return c.License })},
+	{"ServerlessMode.Enabled", changed(func(c Config) interface{} { return c.ServerlessMode.Enabled })},
+	{"InfiniteTracing", changed(func(c Config) interface{} { return c.InfiniteTracing })},
+	{"RuntimeSampler", changed(func(c Config) interface{} { return c.RuntimeSampler })},
+	{"Profiling", changed(func(c Config) interface{} { return c.Profiling })},
+	{"DimensionalMetrics.MaxTimeSeries", changed(func(c Config) interface{} { return c.DimensionalMetrics.MaxTimeSeries })},
+	{"DimensionalMetrics.HistogramBoundaries", changed(func(c Config) interface{} { return c.DimensionalMetrics.HistogramBoundaries })},
+	{"DurationDistributions.RelativeAccuracy", changed(func(c Config) interface{} { return c.DurationDistributions.RelativeAccuracy })},
+	{"DurationDistributions.MaxBins", changed(func(c Config) interface{} { return c.DurationDistributions.MaxBins })},
+	{"OTLP", changed(func(c Config) interface{} { return c.OTLP })},
+	{"DiskSpool", changed(func(c Config) interface{} { return c.DiskSpool })},
+	{"FileExport", changed(func(c Config) interface{} { return c.FileExport })},
+	{"SpanEvents.TailSampling.Enabled", changed(func(c Config) interface{} { return c.SpanEvents.TailSampling.Enabled })},
+	{"SpanEvents.TailSampling.MaxBufferedSpans", changed(func(c Config) interface{} { return c.SpanEvents.TailSampling.MaxBufferedSpans })},
+	{"Heroku", changed(func(c Config) interface{} { return c.Heroku })},
+	{"Utilization", changed(func(c Config) interface{} { return c.Utilization })},
+}
+
+// reconnectConfigFields are sent to New Relic when the application connects,
+// so the application connects again when they are changed.
+var reconnectConfigFields = []configFieldChange{
+	{"AppName", changed(func(c Config) interface{} { return c.AppName })},
+	{"Labels", changed(func(c Config) interface{} { return c.Labels })},
+	{"HighSecurity", changed(func(c Config) interface{} { return c.HighSecurity })},
+	{"SecurityPoliciesToken", changed(func(c Config) interface{} { return c.SecurityPoliciesToken })},
+	{"Host", changed(func(c Config) interface{} { return c.Host })},
+	{"HostDisplayName", changed(func(c Config) interface{} { return c.HostDisplayName })},
+	{"DistributedTracer", changed(func(c Config) interface{} { return c.DistributedTracer })},
+	{"SpanEvents.Enabled", changed(func(c Config) interface{} { return c.SpanEvents.Enabled })},
+	{"TransactionEvents.MaxSamplesStored", changed(func(c Config) interface{} { return c.TransactionEvents.MaxSamplesStored })},
+	{"CustomInsightsEvents.MaxSamplesStored", changed(func(c Config) interface{} { return c.CustomInsightsEvents.MaxSamplesStored })},
+	{"ApplicationLogging.Forwarding.MaxSamplesStored", changed(func(c Config) interface{} { return c.ApplicationLogging.Forwarding.MaxSamplesStored })},
+	{"ModuleDependencyMetrics", changed(func(c Config) interface{} { return c.ModuleDependencyMetrics })},
+}
+
+// changedConfigFields returns the names of the fields which differ.
+func changedConfigFields(fields []configFieldChange, a, b Config) []string {
+	var names []string
+	for _, f := range fields {
+		if f.changed(a, b) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// getConfig returns the current configuration, which includes any changes
+// made by UpdateConfig.
+func (app *app) getConfig() config {
+	app.RLock()
+	defer app.RUnlock()
+
+	if nil != app.updatedConfig {
+		return *app.updatedConfig
+	}
+	return app.config
+}
+
+// updateConfig applies the options to the current configuration.  Changes
+// take effect for transactions started after updateConfig returns.
+func (app *app) updateConfig(opts ...ConfigOption) error {
+	app.updateConfigLock.Lock()
+	defer app.updateConfigLock.Unlock()
+
+	cur := app.getConfig()
+	// The reference fields are copied so that options which modify maps
+	// or slices in place do not change the current configuration.
+	c := copyConfigReferenceFields(cur.Config)
+	c.Error = nil
+	// A Logger created by a configuration file is closed if the update
+	// fails and it is not used.
+	defer func() { closeConfigFileLogger(c.Logger, cur.Logger) }()
+	for _, fn := range opts {
+		if fn != nil {
+			fn(&c)
+			if c.Error != nil {
+				return c.Error
+			}
+		}
+	}
+	c = copyConfigReferenceFields(c)
+	if err := c.validate(); nil != err {
+		return err
+	}
+	obsURL, err := c.validateTraceObserverConfig()
+	if err != nil {
+		return err
+	}
+	// The Transport is used by the collector client created with the
+	// application.
+	c.Transport = cur.Transport
+	if names := changedConfigFields(immutableConfigFields, cur.Config, c); len(names) > 0 {
+		return fmt.Errorf("configuration fields cannot be changed after the application is created: %v", names)
+	}
+	if r, ok := cur.Logger.(*reloadableLogger); ok && c.Logger != cur.Logger {
+		prev := r.get()
+		r.set(c.Logger)
+		c.Logger = r
+		closeConfigFileLogger(prev, r)
+	}
+
+	reconnect := changedConfigFields(reconnectConfigFields, cur.Config, c)
+	updated := config{
+		Config:           c,
+		metadata:         cur.metadata,
+		hostname:         cur.hostname,
+		traceObserverURL: obsURL,
+	}
+
+	app.Lock()
+	if errors.Is(app.err, errApplicationShutDown) {
+		app.Unlock()
+		return errConfigUpdateShutDown
+	}
+	app.updatedConfig = &updated
+	app.placeholderRun = newAppRun(updated, app.placeholderRun.Reply)
+	if app.config.ServerlessMode.Enabled && nil != app.run {
+		app.run = newAppRun(updated, newServerlessConnectReply(updated))
+	}
+	app.Unlock()
+
+	app.Info("configuration updated", map[string]interface{}{
+		"reconnect": reconnect,
+	})
+
+	if !app.config.Enabled || app.config.ServerlessMode.Enabled {
+		return nil
+	}
+	select {
+	case app.configChan <- configUpdate{reconnect: len(reconnect) > 0}:
+	case <-app.shutdownStarted:
+	}
+	return nil
+}
+
+// refreshRun creates a new run using the current configuration and the
+// connect reply of the previous run.
+func (app *app) refreshRun(run *appRun) *appRun {
+	next := newAppRun(app.getConfig(), run.Reply)
+	next.adaptiveSampler = run.adaptiveSampler
+	next.harvestConfig.CommonAttributes = run.harvestConfig.CommonAttributes
+	return next
+}
+
+// watchConfigFile applies the configuration file each time it is modified
+// until stop is closed or the application shuts down.  The file is decoded in
+// the same way as by ConfigFromFile.
+func (app *app) watchConfigFile(path string, interval time.Duration, getenv func(string) string, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastMod time.Time
+	var lastSize int64 = -1
+	for {
+		if info, err := os.Stat(path); nil != err {
+			app.Warn("unable to read configuration file", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+		} else if !info.ModTime().Equal(lastMod) || info.Size() != lastSize {
+			lastMod = info.ModTime()
+			lastSize = info.Size()
+			if err := app.updateConfig(configFromFile(path, getenv)); nil != err {
+				app.Error("unable to apply configuration file", map[string]interface{}{
+					"path":  path,
+					"error": err.Error(),
+				})
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-app.shutdownStarted:
+			return
+		}
+	}
+}
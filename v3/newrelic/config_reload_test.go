@@ -0,0 +1,281 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestUpdateConfigAttributes(t *testing.T) {
+	app := testApp(nil, nil, t)
+	err := app.UpdateConfig(func(cfg *Config) {
+		cfg.TransactionEvents.Attributes.Exclude = []string{"zip"}
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello")
+	txn.AddAttribute("zip", 1)
+	txn.AddAttribute("zap", 2)
+	txn.End()
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/hello",
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{"zap": 2},
+	}})
+	app.expectNoLoggedErrors(t)
+}
+
+func TestUpdateConfigInvalid(t *testing.T) {
+	app := testApp(nil, nil, t)
+	err := app.UpdateConfig(ConfigAppName("new name"), func(cfg *Config) {
+		cfg.HighSecurity = true
+		cfg.SecurityPoliciesToken = "token"
+	})
+	if err != errHighSecurityWithSecurityPolicies {
+		t.Error(err)
+	}
+	if err := app.UpdateConfig(ConfigLicense("0000000000000000000000000000000000000000")); nil == err || !strings.Contains(err.Error(), "License") {
+		t.Error(err)
+	}
+	if err := app.UpdateConfig(func(cfg *Config) { cfg.Error = errLicenseLen }); err != errLicenseLen {
+		t.Error(err)
+	}
+	if cfg, _ := app.Config(); cfg.AppName != "my app" || cfg.License != testLicenseKey {
+		t.Error(cfg.AppName, cfg.License)
+	}
+}
+
+func TestUpdateConfigThreshold(t *testing.T) {
+	app := testApp(nil, nil, t)
+	err := app.UpdateConfig(func(cfg *Config) {
+		cfg.TransactionTracer.Threshold.IsApdexFailing = false
+		cfg.TransactionTracer.Threshold.Duration = 0
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello")
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "OtherTransaction/Go/hello",
+		NumSegments: 0,
+	}})
+}
+
+func TestUpdateConfigIgnoreStatusCodes(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.ErrorCollector.IgnoreStatusCodes = []int{418}
+	}); nil != err {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello")
+	txn.SetWebResponse(nil).WriteHeader(418)
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantEvent{})
+
+	txn = app.StartTransaction("hello")
+	txn.SetWebResponse(nil).WriteHeader(419)
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"error.class":     "419",
+			"error.message":   "response code 419",
+			"transactionName": "OtherTransaction/Go/hello",
+			"guid":            internal.MatchAnything,
+			"traceId":         internal.MatchAnything,
+			"priority":        internal.MatchAnything,
+			"sampled":         internal.MatchAnything,
+		},
+	}})
+}
+
+func TestUpdateConfigLogger(t *testing.T) {
+	app := testApp(nil, nil, t)
+	buf := &bytes.Buffer{}
+	if err := app.UpdateConfig(ConfigDebugLogger(buf)); nil != err {
+		t.Fatal(err)
+	}
+	if !app.app.DebugEnabled() {
+		t.Error("debug logging not enabled")
+	}
+	app.app.Debug("hello", nil)
+	if !strings.Contains(buf.String(), `"msg":"hello"`) {
+		t.Error(buf.String())
+	}
+	if cfg, _ := app.Config(); nil == cfg.Logger || !cfg.Logger.DebugEnabled() {
+		t.Error(cfg.Logger)
+	}
+}
+
+func TestUpdateConfigNilApplication(t *testing.T) {
+	var app *Application
+	if err := app.UpdateConfig(ConfigAppName("zap")); nil != err {
+		t.Error(err)
+	}
+	app.WatchConfigFile("missing.yml", time.Second)()
+}
+
+func waitForRun(t *testing.T, app *Application, done func(*appRun) bool) *appRun {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if run, _ := app.app.getState(); nil != run && run.Reply.RunID != "" && done(run) {
+			return run
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for run")
+	return nil
+}
+
+func TestUpdateConfigConnectedApplication(t *testing.T) {
+	app, err := NewApplication(
+		ConfigAppName("my app"),
+		ConfigWriterExport(&bytes.Buffer{}),
+		func(cfg *Config) {
+			cfg.RuntimeSampler.Enabled = false
+		},
+	)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer app.Shutdown(10 * time.Second)
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	first, _ := app.app.getState()
+
+	// Settings not sent to New Relic are applied using the same reply.
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.TransactionTracer.Threshold.Duration = time.Minute
+	}); nil != err {
+		t.Fatal(err)
+	}
+	second := waitForRun(t, app, func(run *appRun) bool {
+		return run.Config.TransactionTracer.Threshold.Duration == time.Minute
+	})
+	if second.Reply != first.Reply {
+		t.Error("application reconnected unnecessarily")
+	}
+
+	// Changing the AppName requires connecting again.
+	if err := app.UpdateConfig(ConfigAppName("new name")); nil != err {
+		t.Fatal(err)
+	}
+	third := waitForRun(t, app, func(run *appRun) bool {
+		return run.Config.AppName == "new name"
+	})
+	if third.Reply == second.Reply {
+		t.Error("application did not reconnect")
+	}
+	if third.Config.TransactionTracer.Threshold.Duration != time.Minute {
+		t.Error(third.Config.TransactionTracer.Threshold.Duration)
+	}
+}
+
+func TestUpdateConfigAfterShutdown(t *testing.T) {
+	app, err := NewApplication(
+		ConfigAppName("my app"),
+		ConfigWriterExport(&bytes.Buffer{}),
+	)
+	if nil != err {
+		t.Fatal(err)
+	}
+	app.Shutdown(10 * time.Second)
+	if err := app.UpdateConfig(ConfigAppName("new name")); err != errConfigUpdateShutDown {
+		t.Error(err)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	app := testApp(nil, nil, t)
+	// The file has the same format as the files read by ConfigFromFile.
+	path := writeConfigFile(t, "newrelic.yml", `
+common: &default_settings
+  attributes:
+    exclude: [zip]
+production:
+  <<: *default_settings
+`)
+	stop := app.WatchConfigFile(path, time.Millisecond)
+	defer stop()
+
+	waitForConfig := func(done func(Config) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if cfg, _ := app.Config(); done(cfg) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("timed out waiting for configuration")
+	}
+	waitForConfig(func(cfg Config) bool {
+		return len(cfg.Attributes.Exclude) == 1 && cfg.Attributes.Exclude[0] == "zip"
+	})
+
+	if err := os.WriteFile(path, []byte(`
+attributes:
+  exclude: zip, zap
+transaction_tracer:
+  transaction_threshold: 0.5
+`), 0600); nil != err {
+		t.Fatal(err)
+	}
+	waitForConfig(func(cfg Config) bool {
+		return len(cfg.Attributes.Exclude) == 2 &&
+			!cfg.TransactionTracer.Threshold.IsApdexFailing &&
+			cfg.TransactionTracer.Threshold.Duration == 500*time.Millisecond
+	})
+	app.expectNoLoggedErrors(t)
+}
+
+func TestUpdateConfigFileLogger(t *testing.T) {
+	app := testApp(nil, nil, t)
+	logPath := filepath.Join(t.TempDir(), "agent.log")
+	path := writeConfigFile(t, "newrelic.yml", "log_file_name: "+logPath+"\n")
+	apply := func() *configFileLogger {
+		if err := app.app.updateConfig(configFromFile(path, func(string) string { return "" })); nil != err {
+			t.Fatal(err)
+		}
+		cfg, _ := app.Config()
+		lg, ok := cfg.Logger.(*configFileLogger)
+		if !ok {
+			t.Fatal(cfg.Logger)
+		}
+		return lg
+	}
+
+	first := apply()
+	// Applying the unchanged file keeps the Logger and its file.
+	if lg := apply(); lg != first {
+		t.Error("logger replaced")
+	}
+
+	if err := os.WriteFile(path, []byte("log_file_name: "+logPath+"\nlog_level: debug\n"), 0600); nil != err {
+		t.Fatal(err)
+	}
+	second := apply()
+	defer second.file.Close()
+	if second == first || !second.DebugEnabled() {
+		t.Error("logger not replaced")
+	}
+	if _, err := first.file.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Error("previous log file not closed", err)
+	}
+}
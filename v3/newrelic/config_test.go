@@ -4,6 +4,7 @@
 package newrelic
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -99,6 +100,7 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 	cfg.BrowserMonitoring.Attributes.Exclude = append(cfg.BrowserMonitoring.Attributes.Exclude, "10")
 	cfg.SpanEvents.Attributes.Include = append(cfg.SpanEvents.Attributes.Include, "11")
 	cfg.SpanEvents.Attributes.Exclude = append(cfg.SpanEvents.Attributes.Exclude, "12")
+	cfg.SpanEvents.TailSampling.Attributes = map[string]string{"zip": "zap"}
 	cfg.TransactionTracer.Segments.Attributes.Include = append(cfg.TransactionTracer.Segments.Attributes.Include, "13")
 	cfg.TransactionTracer.Segments.Attributes.Exclude = append(cfg.TransactionTracer.Segments.Attributes.Exclude, "14")
 	cfg.Transport = &http.Transport{}
@@ -120,6 +122,7 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 	cfg.BrowserMonitoring.Attributes.Exclude[0] = "zap"
 	cfg.SpanEvents.Attributes.Include[0] = "zap"
 	cfg.SpanEvents.Attributes.Exclude[0] = "zap"
+	cfg.SpanEvents.TailSampling.Attributes["zop"] = "zup"
 	cfg.TransactionTracer.Segments.Attributes.Include[0] = "zap"
 	cfg.TransactionTracer.Segments.Attributes.Exclude[0] = "zap"
 
@@ -178,10 +181,14 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				"RawQuery":{"Enabled":false},
 				"SlowQuery":{
 					"Enabled":true,
+					"Explain":{"Enabled":true,"Interval":60000000000},
 					"Threshold":10000000
 				}
 			},
+			"DimensionalMetrics":{"Enabled":false,"Endpoint":"","HistogramBoundaries":[0,5,10,25,50,75,100,250,500,750,1000,2500,5000,7500,10000],"MaxTimeSeries":2000},
+			"DiskSpool":{"Directory":"","Enabled":false,"MaxAge":7200000000000,"MaxBytes":10485760},
 			"DistributedTracer":{"Enabled":true,"ExcludeNewRelicHeader":false,"ReservoirLimit":%d},
+			"DurationDistributions":{"Enabled":false,"MaxBins":1024,"RelativeAccuracy":0.01},
 			"Enabled":true,
 			"Error":null,
 			"ErrorCollector":{
@@ -192,6 +199,7 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				"IgnoreStatusCodes":[0,5,404,405],
 				"RecordPanics":false
 			},
+			"FileExport":{"Enabled":false,"MaxBytes":104857600,"MaxFiles":5,"Path":"","Writer":null},
 			"Heroku":{
 				"DynoNamePrefixesToShorten":["scheduler","run"],
 				"UseDynoNames":true
@@ -209,6 +217,8 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 			"Labels":{"zip":"zap"},
 			"Logger":"*logger.logFile",
 			"ModuleDependencyMetrics":{"Enabled":true,"IgnoredPrefixes":null,"RedactIgnoredPrefixes":true},
+			"OTLP":{"Enabled":false,"Endpoint":"","Protocol":"http/protobuf"},
+			"Profiling":{"CPUDuration":10000000000,"Enabled":false,"Period":60000000000,"Types":15},
 			"RuntimeSampler":{"Enabled":true},
 			"SecurityPoliciesToken":"",
 			"ServerlessMode":{
@@ -222,7 +232,14 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				"Attributes":{
 					"Enabled":true,"Exclude":["12"],"Include":["11"]
 				},
-				"Enabled":true
+				"Enabled":true,
+				"TailSampling":{
+					"Attributes":{"zip":"zap"},
+					"DurationThreshold":0,
+					"Enabled":false,
+					"Errors":true,
+					"MaxBufferedSpans":10000
+				}
 			},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":["4"],"Include":["3"]},
@@ -394,10 +411,14 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 				"RawQuery":{"Enabled":false},
 				"SlowQuery":{
 					"Enabled":true,
+					"Explain":{"Enabled":true,"Interval":60000000000},
 					"Threshold":10000000
 				}
 			},
+			"DimensionalMetrics":{"Enabled":false,"Endpoint":"","HistogramBoundaries":[0,5,10,25,50,75,100,250,500,750,1000,2500,5000,7500,10000],"MaxTimeSeries":2000},
+			"DiskSpool":{"Directory":"","Enabled":false,"MaxAge":7200000000000,"MaxBytes":10485760},
 			"DistributedTracer":{"Enabled":true,"ExcludeNewRelicHeader":false,"ReservoirLimit":%d},
+			"DurationDistributions":{"Enabled":false,"MaxBins":1024,"RelativeAccuracy":0.01},
 			"Enabled":true,
 			"Error":null,
 			"ErrorCollector":{
@@ -408,6 +429,7 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 				"IgnoreStatusCodes":null,
 				"RecordPanics":false
 			},
+			"FileExport":{"Enabled":false,"MaxBytes":104857600,"MaxFiles":5,"Path":"","Writer":null},
 			"Heroku":{
 				"DynoNamePrefixesToShorten":["scheduler","run"],
 				"UseDynoNames":true
@@ -425,6 +447,8 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 			"Labels":null,
 			"Logger":null,
 			"ModuleDependencyMetrics":{"Enabled":true,"IgnoredPrefixes":null,"RedactIgnoredPrefixes":true},
+			"OTLP":{"Enabled":false,"Endpoint":"","Protocol":"http/protobuf"},
+			"Profiling":{"CPUDuration":10000000000,"Enabled":false,"Period":60000000000,"Types":15},
 			"RuntimeSampler":{"Enabled":true},
 			"SecurityPoliciesToken":"",
 			"ServerlessMode":{
@@ -436,7 +460,14 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 			},
 			"SpanEvents":{
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
-				"Enabled":true
+				"Enabled":true,
+				"TailSampling":{
+					"Attributes":null,
+					"DurationThreshold":0,
+					"Enabled":false,
+					"Errors":true,
+					"MaxBufferedSpans":10000
+				}
 			},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
@@ -617,6 +648,94 @@ func TestValidateWithPoliciesToken(t *testing.T) {
 	}
 }
 
+func TestValidateOTLP(t *testing.T) {
+	c := defaultConfig()
+	c.AppName = "my app"
+	c.OTLP.Enabled = true
+	c.OTLP.Endpoint = "http://localhost:4318"
+	if err := c.validate(); err != nil {
+		t.Error(err)
+	}
+	c.OTLP.Protocol = "grpc"
+	if err := c.validate(); err != errOTLPProtocol {
+		t.Error(err)
+	}
+	c.OTLP.Protocol = OTLPProtocolJSON
+	c.OTLP.Endpoint = ""
+	if err := c.validate(); err != errOTLPEndpointMissing {
+		t.Error(err)
+	}
+	c.OTLP.Endpoint = "http://localhost:4318"
+	c.ServerlessMode.Enabled = true
+	if err := c.validate(); err != errOTLPServerless {
+		t.Error(err)
+	}
+}
+
+func TestValidateDiskSpool(t *testing.T) {
+	c := defaultConfig()
+	c.AppName = "my app"
+	c.License = "0123456789012345678901234567890123456789"
+	c.DiskSpool.Enabled = true
+	if err := c.validate(); err != errDiskSpoolDirectoryMissing {
+		t.Error(err)
+	}
+	c.DiskSpool.Directory = "/tmp/spool"
+	if err := c.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateProfiling(t *testing.T) {
+	c := defaultConfig()
+	c.AppName = "my app"
+	c.License = "0123456789012345678901234567890123456789"
+	c.Profiling.Enabled = true
+	if err := c.validate(); err != errProfilingFileExport {
+		t.Error(err)
+	}
+	c.FileExport.Enabled = true
+	c.FileExport.Path = "/tmp/newrelic.json"
+	if err := c.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateFileExport(t *testing.T) {
+	c := defaultConfig()
+	c.AppName = "my app"
+	c.FileExport.Enabled = true
+	if err := c.validate(); err != errFileExportDestinationMissing {
+		t.Error(err)
+	}
+	c.FileExport.Path = "/tmp/newrelic.json"
+	if err := c.validate(); err != nil {
+		t.Error("license should not be required", err)
+	}
+	c.OTLP.Enabled = true
+	c.OTLP.Endpoint = "http://localhost:4318"
+	if err := c.validate(); err != errFileExportOTLP {
+		t.Error(err)
+	}
+	c.OTLP.Enabled = false
+	c.ServerlessMode.Enabled = true
+	if err := c.validate(); err != errFileExportServerless {
+		t.Error(err)
+	}
+}
+
+func TestFileExportWriterSetting(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.FileExport.Writer = &bytes.Buffer{}
+	js, err := json.Marshal(settings(cfg))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"Writer":"*bytes.Buffer"`) {
+		t.Error(string(js))
+	}
+}
+
 func TestGatherMetadata(t *testing.T) {
 	metadata := gatherMetadata(nil)
 	if !reflect.DeepEqual(metadata, map[string]string{}) {
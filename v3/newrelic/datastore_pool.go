@@ -0,0 +1,172 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// DatastorePoolStats describes the connections of a datastore client's
+// connection pool.  Fields which the client does not report may be left
+// zero.
+type DatastorePoolStats struct {
+	// MaxOpen is the maximum number of open connections, or 0 if it is
+	// unlimited or unknown.  It is not reported when 0.
+	MaxOpen int
+	// Open is the number of open connections, both in use and idle.
+	Open int
+	// InUse is the number of connections in use.
+	InUse int
+	// Idle is the number of idle connections.
+	Idle int
+	// WaitCount is the total number of times a connection was waited
+	// for since the pool was created.
+	WaitCount int64
+	// WaitDuration is the total time spent waiting for connections since
+	// the pool was created.
+	WaitDuration time.Duration
+}
+
+// RegisterDatastorePool registers a datastore client's connection pool.
+// While the pool is registered, its statistics are sampled with the
+// runtime statistics and reported as metrics named
+// Datastore/{product}/Pool/{statistic}.  Open, InUse, Idle and MaxOpen are
+// reported as the value of each sample, and WaitCount and WaitDuration as
+// their change since the previous sample.  The statistics of pools with the
+// same product are added together, except that MaxOpen is not reported for
+// a product if any of its pools reports 0.
+//
+// The stats function must be safe to call from another goroutine.  Calling
+// the returned function unregisters the pool, which should be done once it
+// is closed.  Integration packages provide helpers that register the pools
+// of their clients, such as nrpgx5.RegisterPool.
+func (app *Application) RegisterDatastorePool(product DatastoreProduct, stats func() DatastorePoolStats) (unregister func()) {
+	if app == nil || app.app == nil || nil == stats {
+		return func() {}
+	}
+	return app.app.datastorePools.register(string(product), stats)
+}
+
+// RegisterSQLDB registers the connection pool of a database/sql DB using
+// RegisterDatastorePool.
+func (app *Application) RegisterSQLDB(product DatastoreProduct, db *sql.DB) (unregister func()) {
+	if nil == db {
+		return func() {}
+	}
+	return app.RegisterDatastorePool(product, func() DatastorePoolStats {
+		return sqlDBPoolStats(db.Stats())
+	})
+}
+
+func sqlDBPoolStats(s sql.DBStats) DatastorePoolStats {
+	return DatastorePoolStats{
+		MaxOpen:      s.MaxOpenConnections,
+		Open:         s.OpenConnections,
+		InUse:        s.InUse,
+		Idle:         s.Idle,
+		WaitCount:    s.WaitCount,
+		WaitDuration: s.WaitDuration,
+	}
+}
+
+type datastorePool struct {
+	product string
+	stats   func() DatastorePoolStats
+	// previous is the previous sample, used to compute the change in the
+	// wait totals.  It is only accessed by the sampler goroutine.
+	previous DatastorePoolStats
+}
+
+// datastorePools holds the connection pools registered with the
+// application.
+type datastorePools struct {
+	sync.Mutex
+	pools []*datastorePool
+}
+
+func (dp *datastorePools) register(product string, stats func() DatastorePoolStats) func() {
+	pool := &datastorePool{product: product, stats: stats}
+	pool.previous = stats()
+
+	dp.Lock()
+	dp.pools = append(dp.pools, pool)
+	dp.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			dp.Lock()
+			defer dp.Unlock()
+			for i, p := range dp.pools {
+				if p == pool {
+					dp.pools = append(dp.pools[:i], dp.pools[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// sample returns the statistics of the registered pools by product, where
+// the wait totals are replaced by their change since the previous sample.
+// MaxOpen is 0 for products with a pool whose maximum is unlimited or
+// unknown, since the sum of the others would understate it.  It returns nil
+// if no pools are registered.
+func (dp *datastorePools) sample() datastorePoolSample {
+	dp.Lock()
+	pools := make([]*datastorePool, len(dp.pools))
+	copy(pools, dp.pools)
+	dp.Unlock()
+
+	if len(pools) == 0 {
+		return nil
+	}
+	sample := make(datastorePoolSample, len(pools))
+	unlimited := make(map[string]bool)
+	for _, p := range pools {
+		current := p.stats()
+		s := sample[p.product]
+		if current.MaxOpen <= 0 {
+			unlimited[p.product] = true
+		}
+		s.MaxOpen += current.MaxOpen
+		s.Open += current.Open
+		s.InUse += current.InUse
+		s.Idle += current.Idle
+		if delta := current.WaitCount - p.previous.WaitCount; delta > 0 {
+			s.WaitCount += delta
+		}
+		if delta := current.WaitDuration - p.previous.WaitDuration; delta > 0 {
+			s.WaitDuration += delta
+		}
+		sample[p.product] = s
+		p.previous = current
+	}
+	for product := range unlimited {
+		s := sample[product]
+		s.MaxOpen = 0
+		sample[product] = s
+	}
+	return sample
+}
+
+// datastorePoolSample maps products to the statistics of their pools.
+type datastorePoolSample map[string]DatastorePoolStats
+
+// MergeIntoHarvest implements Harvestable.
+func (s datastorePoolSample) MergeIntoHarvest(h *harvest) {
+	for product, stats := range s {
+		prefix := "Datastore/" + product + "/Pool/"
+		if stats.MaxOpen > 0 {
+			h.Metrics.addValue(prefix+"MaxOpen", "", float64(stats.MaxOpen), forced)
+		}
+		h.Metrics.addValue(prefix+"Open", "", float64(stats.Open), forced)
+		h.Metrics.addValue(prefix+"InUse", "", float64(stats.InUse), forced)
+		h.Metrics.addValue(prefix+"Idle", "", float64(stats.Idle), forced)
+		h.Metrics.addValue(prefix+"WaitCount", "", float64(stats.WaitCount), forced)
+		h.Metrics.addValue(prefix+"WaitDuration", "", stats.WaitDuration.Seconds(), forced)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestDatastorePoolMetrics(t *testing.T) {
+	app := testApp(nil, nil, t)
+	stats := DatastorePoolStats{MaxOpen: 10, Open: 4, InUse: 3, Idle: 1, WaitCount: 5, WaitDuration: 2 * time.Second}
+	unregister := app.RegisterDatastorePool(DatastorePostgres, func() DatastorePoolStats { return stats })
+	app.RegisterDatastorePool(DatastorePostgres, func() DatastorePoolStats {
+		return DatastorePoolStats{Open: 2, Idle: 2}
+	})
+	app.RegisterDatastorePool(DatastoreRedis, func() DatastorePoolStats {
+		return DatastorePoolStats{Open: 1, InUse: 1, WaitCount: 7}
+	})
+	for i := 0; i < 2; i++ {
+		app.RegisterDatastorePool(DatastoreMySQL, func() DatastorePoolStats {
+			return DatastorePoolStats{MaxOpen: 5, Open: 1, Idle: 1}
+		})
+	}
+
+	// The wait totals are reported as their change since the pools were
+	// registered.
+	stats.WaitCount = 8
+	stats.WaitDuration = 3500 * time.Millisecond
+	// MaxOpen is not reported for Postgres since one of its pools is
+	// unlimited.
+	app.app.datastorePools.sample().MergeIntoHarvest(app.app.testHarvest)
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{Name: "Datastore/MySQL/Pool/MaxOpen", Forced: true, Data: []float64{1, 10, 10, 10, 10, 100}},
+		{Name: "Datastore/MySQL/Pool/Open", Forced: true, Data: []float64{1, 2, 2, 2, 2, 4}},
+		{Name: "Datastore/MySQL/Pool/InUse", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Datastore/MySQL/Pool/Idle", Forced: true, Data: []float64{1, 2, 2, 2, 2, 4}},
+		{Name: "Datastore/MySQL/Pool/WaitCount", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Datastore/MySQL/Pool/WaitDuration", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Datastore/Postgres/Pool/Open", Forced: true, Data: []float64{1, 6, 6, 6, 6, 36}},
+		{Name: "Datastore/Postgres/Pool/InUse", Forced: true, Data: []float64{1, 3, 3, 3, 3, 9}},
+		{Name: "Datastore/Postgres/Pool/Idle", Forced: true, Data: []float64{1, 3, 3, 3, 3, 9}},
+		{Name: "Datastore/Postgres/Pool/WaitCount", Forced: true, Data: []float64{1, 3, 3, 3, 3, 9}},
+		{Name: "Datastore/Postgres/Pool/WaitDuration", Forced: true, Data: []float64{1, 1.5, 1.5, 1.5, 1.5, 2.25}},
+		{Name: "Datastore/Redis/Pool/Open", Forced: true, Data: []float64{1, 1, 1, 1, 1, 1}},
+		{Name: "Datastore/Redis/Pool/InUse", Forced: true, Data: []float64{1, 1, 1, 1, 1, 1}},
+		{Name: "Datastore/Redis/Pool/Idle", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Datastore/Redis/Pool/WaitCount", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Datastore/Redis/Pool/WaitDuration", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+
+	unregister()
+	unregister()
+	sample := app.app.datastorePools.sample()
+	if len(sample) != 3 || sample["Postgres"].Open != 2 || sample["Postgres"].WaitCount != 0 {
+		t.Error(sample)
+	}
+}
+
+func TestDatastorePoolNone(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if sample := app.app.datastorePools.sample(); nil != sample {
+		t.Error(sample)
+	}
+	unregister := app.RegisterDatastorePool(DatastoreMySQL, nil)
+	unregister()
+	if sample := app.app.datastorePools.sample(); nil != sample {
+		t.Error(sample)
+	}
+}
+
+func TestRegisterDatastorePoolNilApp(t *testing.T) {
+	var app *Application
+	app.RegisterDatastorePool(DatastoreMySQL, func() DatastorePoolStats { return DatastorePoolStats{} })()
+	app.RegisterSQLDB(DatastoreMySQL, &sql.DB{})()
+}
+
+func TestSQLDBPoolStats(t *testing.T) {
+	got := sqlDBPoolStats(sql.DBStats{
+		MaxOpenConnections: 20,
+		OpenConnections:    5,
+		InUse:              2,
+		Idle:               3,
+		WaitCount:          9,
+		WaitDuration:       time.Second,
+	})
+	want := DatastorePoolStats{MaxOpen: 20, Open: 5, InUse: 2, Idle: 3, WaitCount: 9, WaitDuration: time.Second}
+	if got != want {
+		t.Error(got)
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dimensionalMetricType is the kind of a dimensional metric, which determines
+// how values are aggregated within a harvest.
+type dimensionalMetricType int
+
+const (
+	// dimensionalCount values are summed.
+	dimensionalCount dimensionalMetricType = iota
+	// dimensionalGauge keeps the last value recorded.
+	dimensionalGauge
+	// dimensionalSummary keeps the count, sum, min, and max of the values.
+	dimensionalSummary
+	// dimensionalHistogram keeps a summary together with the number of
+	// values falling into each bucket of Config.DimensionalMetrics.HistogramBoundaries.
+	// The payload has no histogram type, so histograms are sent as a
+	// summary and a count series for each bucket.
+	dimensionalHistogram
+	// dimensionalDistribution merges the duration sketches of transactions
+	// and segments when Config.DurationDistributions is enabled.  It is sent
+	// as a summary and a gauge for each of the sketchQuantiles.
+	dimensionalDistribution
+)
+
+// String returns the type as used in the dimensional metric payload.
+func (tp dimensionalMetricType) String() string {
+	switch tp {
+	case dimensionalCount:
+		return "count"
+	case dimensionalGauge:
+		return "gauge"
+	case dimensionalSummary:
+		return "summary"
+	case dimensionalHistogram:
+		return "histogram"
+	default:
+		return ""
+	}
+}
+
+const (
+	defaultDimensionalMetricsMaxTimeSeries = 2000
+
+	// dimensionalMetricOverflowAttribute is the attribute of the series
+	// which aggregates the values of new series once the time series limit
+	// is reached.
+	dimensionalMetricOverflowAttribute = "newrelic.overflow"
+
+	// dimensionalHistogramBucketSuffix is appended to the name of a
+	// histogram to name the count series of its buckets.  Each bucket series
+	// has the dimensionalHistogramBucketAttribute attribute holding the
+	// upper bound of the bucket, and counts the values at most that bound
+	// as in Prometheus histograms.
+	dimensionalHistogramBucketSuffix    = ".bucket"
+	dimensionalHistogramBucketAttribute = "le"
+
+	// dimensionalDistributionQuantileSuffix is appended to the name of a
+	// distribution to name the gauges of its quantiles.  Each gauge has the
+	// dimensionalDistributionQuantileAttribute attribute holding the
+	// quantile, such as "0.95".
+	dimensionalDistributionQuantileSuffix    = ".quantile"
+	dimensionalDistributionQuantileAttribute = "quantile"
+)
+
+// defaultDimensionalMetricsHistogramBoundaries returns the default upper
+// bounds of histogram buckets.
+func defaultDimensionalMetricsHistogramBoundaries() []float64 {
+	return []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+}
+
+// dimensionalMetric is a single value recorded with one of the Application
+// dimensional metric methods.
+type dimensionalMetric struct {
+	Type  dimensionalMetricType
+	Name  string
+	Value float64
+	// Attributes is the JSON object of the attributes with keys in sorted
+	// order, so that it identifies the time series of the value.
+	Attributes string
+	Timestamp  time.Time
+}
+
+func newDimensionalMetric(tp dimensionalMetricType, name string, value float64, attributes map[string]interface{}, now time.Time) (*dimensionalMetric, error) {
+	if math.IsNaN(value) {
+		return nil, errMetricNaN
+	}
+	if math.IsInf(value, 0) {
+		return nil, errMetricInf
+	}
+	if name == "" {
+		return nil, errMetricNameEmpty
+	}
+	if len(attributes) > customEventAttributeLimit {
+		return nil, errNumAttributes
+	}
+	keys := make([]string, 0, len(attributes))
+	validated := make(map[string]interface{}, len(attributes))
+	for key, val := range attributes {
+		val, err := validateUserAttribute(key, val)
+		if nil != err {
+			return nil, err
+		}
+		keys = append(keys, key)
+		validated[key] = val
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	for _, key := range keys {
+		writeAttributeValueJSON(&w, key, validated[key])
+	}
+	buf.WriteByte('}')
+
+	return &dimensionalMetric{
+		Type:       tp,
+		Name:       name,
+		Value:      value,
+		Attributes: buf.String(),
+		Timestamp:  now,
+	}, nil
+}
+
+// MergeIntoHarvest implements harvestable.
+func (m *dimensionalMetric) MergeIntoHarvest(h *harvest) {
+	h.DimensionalMetrics.add(m)
+}
+
+type dimensionalSeriesID struct {
+	Type       dimensionalMetricType
+	Name       string
+	Attributes string
+}
+
+// dimensionalSeries is the aggregate of the values of one time series within
+// a harvest.
+type dimensionalSeries struct {
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+	// last and lastTimestamp are the most recent value of a gauge.
+	last          float64
+	lastTimestamp time.Time
+	// buckets are the histogram bucket counts.  buckets[i] counts the values
+	// greater than boundaries[i-1] and at most boundaries[i], and the final
+	// bucket counts values greater than every boundary.
+	buckets []uint64
+	// sketch holds the values of a distribution.
+	sketch *ddSketch
+}
+
+func (s *dimensionalSeries) record(value float64, now time.Time, boundaries []float64) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+	if !now.Before(s.lastTimestamp) {
+		s.last = value
+		s.lastTimestamp = now
+	}
+	if nil != s.buckets {
+		s.buckets[sort.SearchFloat64s(boundaries, value)]++
+	}
+}
+
+func (s *dimensionalSeries) aggregate(from *dimensionalSeries) {
+	if from.count == 0 {
+		return
+	}
+	if s.count == 0 || from.min < s.min {
+		s.min = from.min
+	}
+	if s.count == 0 || from.max > s.max {
+		s.max = from.max
+	}
+	s.count += from.count
+	s.sum += from.sum
+	if !from.lastTimestamp.Before(s.lastTimestamp) {
+		s.last = from.last
+		s.lastTimestamp = from.lastTimestamp
+	}
+	if len(s.buckets) == len(from.buckets) {
+		for i, n := range from.buckets {
+			s.buckets[i] += n
+		}
+	}
+	if nil != from.sketch {
+		if nil == s.sketch {
+			s.sketch = from.sketch.clone()
+		} else {
+			s.sketch.merge(from.sketch)
+		}
+	}
+}
+
+// dimensionalMetrics is the harvest data type containing dimensional metrics
+// aggregated by time series.
+type dimensionalMetrics struct {
+	periodStart    time.Time
+	failedHarvests int
+	// maxSeries limits the number of time series.  Once it is reached,
+	// values of new time series are aggregated into an overflow series for
+	// each metric name and type.  Overflow series may exceed maxSeries by
+	// up to maxSeries again, after which the values are dropped.
+	maxSeries  int
+	boundaries []float64
+	series     map[dimensionalSeriesID]*dimensionalSeries
+	numSeen    int
+	numDropped int
+}
+
+func newDimensionalMetrics(maxSeries int, boundaries []float64, now time.Time) *dimensionalMetrics {
+	return &dimensionalMetrics{
+		periodStart: now,
+		maxSeries:   maxSeries,
+		boundaries:  boundaries,
+		series:      make(map[dimensionalSeriesID]*dimensionalSeries),
+	}
+}
+
+func (dm *dimensionalMetrics) overflowID(id dimensionalSeriesID) dimensionalSeriesID {
+	id.Attributes = `{"` + dimensionalMetricOverflowAttribute + `":true}`
+	return id
+}
+
+// lookup returns the series with the given ID, creating it if the time
+// series limit allows, or nil if the value should be dropped.
+func (dm *dimensionalMetrics) lookup(id dimensionalSeriesID) *dimensionalSeries {
+	if s := dm.series[id]; nil != s {
+		return s
+	}
+	if len(dm.series) >= dm.maxSeries {
+		id = dm.overflowID(id)
+		if s := dm.series[id]; nil != s {
+			return s
+		}
+		if len(dm.series) >= 2*dm.maxSeries {
+			return nil
+		}
+	}
+	s := &dimensionalSeries{}
+	if id.Type == dimensionalHistogram {
+		s.buckets = make([]uint64, len(dm.boundaries)+1)
+	}
+	dm.series[id] = s
+	return s
+}
+
+func (dm *dimensionalMetrics) add(m *dimensionalMetric) {
+	dm.numSeen++
+	s := dm.lookup(dimensionalSeriesID{Type: m.Type, Name: m.Name, Attributes: m.Attributes})
+	if nil == s {
+		dm.numDropped++
+		return
+	}
+	s.record(m.Value, m.Timestamp, dm.boundaries)
+}
+
+// addDistribution merges a duration sketch into the distribution with the
+// given metric name.
+func (dm *dimensionalMetrics) addDistribution(name string, sketch *ddSketch) {
+	dm.numSeen++
+	s := dm.lookup(dimensionalSeriesID{Type: dimensionalDistribution, Name: name, Attributes: "{}"})
+	if nil == s {
+		dm.numDropped++
+		return
+	}
+	s.aggregate(&dimensionalSeries{
+		count:  sketch.count,
+		sum:    sketch.sum,
+		min:    sketch.min,
+		max:    sketch.max,
+		sketch: sketch,
+	})
+}
+
+func (dm *dimensionalMetrics) mergeFailed(from *dimensionalMetrics) {
+	fails := from.failedHarvests + 1
+	if fails >= failedMetricAttemptsLimit {
+		return
+	}
+	if from.periodStart.Before(dm.periodStart) {
+		dm.periodStart = from.periodStart
+	}
+	dm.failedHarvests = fails
+	for id, src := range from.series {
+		if s := dm.lookup(id); nil != s {
+			s.aggregate(src)
+		}
+	}
+}
+
+// RecordSupportabilityMetrics records the number of values recorded and the
+// number dropped because the time series limit was reached.
+func (dm *dimensionalMetrics) RecordSupportabilityMetrics(metrics *metricTable) {
+	metrics.addCount(dimensionalMetricsSeen, float64(dm.numSeen), forced)
+	if dm.numDropped > 0 {
+		metrics.addCount(dimensionalMetricsDropped, float64(dm.numDropped), forced)
+	}
+}
+
+// writeSummaryValueJSON writes the value of a summary record.
+func writeSummaryValueJSON(buf *bytes.Buffer, s *dimensionalSeries) {
+	buf.WriteByte('{')
+	vw := jsonFieldsWriter{buf: buf}
+	vw.intField("count", int64(s.count))
+	vw.floatField("sum", s.sum)
+	vw.floatField("min", s.min)
+	vw.floatField("max", s.max)
+	buf.WriteByte('}')
+}
+
+// withAttribute returns the attributes JSON object with the string
+// attribute added.
+func withAttribute(attributes string, key string, val string) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	w := jsonFieldsWriter{buf: buf}
+	w.stringField(key, val)
+	if len(attributes) > 2 {
+		buf.WriteByte(',')
+		buf.WriteString(attributes[1:])
+	} else {
+		buf.WriteByte('}')
+	}
+	return buf.String()
+}
+
+// writeHistogramJSON writes a histogram as a summary record followed by a
+// count record for each bucket.
+func (dm *dimensionalMetrics) writeHistogramJSON(buf *bytes.Buffer, id dimensionalSeriesID, s *dimensionalSeries) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.stringField("name", id.Name)
+	w.stringField("type", dimensionalSummary.String())
+	w.addKey("value")
+	writeSummaryValueJSON(buf, s)
+	w.rawField("attributes", jsonString(id.Attributes))
+	buf.WriteByte('}')
+
+	var cumulative uint64
+	for i, n := range s.buckets {
+		cumulative += n
+		bound := "+Inf"
+		if i < len(dm.boundaries) {
+			bound = strconv.FormatFloat(dm.boundaries[i], 'g', -1, 64)
+		}
+		buf.WriteByte(',')
+		buf.WriteByte('{')
+		bw := jsonFieldsWriter{buf: buf}
+		bw.stringField("name", id.Name+dimensionalHistogramBucketSuffix)
+		bw.stringField("type", dimensionalCount.String())
+		bw.intField("value", int64(cumulative))
+		bw.rawField("attributes", jsonString(withAttribute(id.Attributes, dimensionalHistogramBucketAttribute, bound)))
+		buf.WriteByte('}')
+	}
+}
+
+// writeDistributionJSON writes a distribution as a summary record followed
+// by a gauge record for each of the sketchQuantiles.
+func (dm *dimensionalMetrics) writeDistributionJSON(buf *bytes.Buffer, id dimensionalSeriesID, s *dimensionalSeries) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.stringField("name", id.Name)
+	w.stringField("type", dimensionalSummary.String())
+	w.addKey("value")
+	writeSummaryValueJSON(buf, s)
+	w.rawField("attributes", jsonString(id.Attributes))
+	buf.WriteByte('}')
+
+	for _, q := range sketchQuantiles {
+		buf.WriteByte(',')
+		buf.WriteByte('{')
+		qw := jsonFieldsWriter{buf: buf}
+		qw.stringField("name", id.Name+dimensionalDistributionQuantileSuffix)
+		qw.stringField("type", dimensionalGauge.String())
+		qw.floatField("value", s.sketch.quantile(q))
+		qw.rawField("attributes", jsonString(withAttribute(id.Attributes, dimensionalDistributionQuantileAttribute, strconv.FormatFloat(q, 'g', -1, 64))))
+		buf.WriteByte('}')
+	}
+}
+
+func (dm *dimensionalMetrics) writeSeriesJSON(buf *bytes.Buffer, id dimensionalSeriesID, s *dimensionalSeries) {
+	switch id.Type {
+	case dimensionalHistogram:
+		dm.writeHistogramJSON(buf, id, s)
+		return
+	case dimensionalDistribution:
+		dm.writeDistributionJSON(buf, id, s)
+		return
+	}
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.stringField("name", id.Name)
+	w.stringField("type", id.Type.String())
+	switch id.Type {
+	case dimensionalCount:
+		w.floatField("value", s.sum)
+	case dimensionalGauge:
+		w.floatField("value", s.last)
+		w.intField("timestamp", timeToIntMillis(s.lastTimestamp))
+	default:
+		w.addKey("value")
+		writeSummaryValueJSON(buf, s)
+	}
+	w.rawField("attributes", jsonString(id.Attributes))
+	buf.WriteByte('}')
+}
+
+// Data implements payloadCreator.
+func (dm *dimensionalMetrics) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
+	if 0 == len(dm.series) {
+		return nil, nil
+	}
+	estimatedBytesPerSeries := 256
+	buf := bytes.NewBuffer(make([]byte, 0, len(dm.series)*estimatedBytesPerSeries))
+	buf.WriteByte('[')
+	buf.WriteByte('{')
+	w := jsonFieldsWriter{buf: buf}
+	w.addKey("common")
+	buf.WriteByte('{')
+	cw := jsonFieldsWriter{buf: buf}
+	cw.intField("timestamp", timeToIntMillis(dm.periodStart))
+	cw.intField("interval.ms", harvestStart.Sub(dm.periodStart).Milliseconds())
+	buf.WriteByte('}')
+	w.addKey("metrics")
+	buf.WriteByte('[')
+	first := true
+	for id, s := range dm.series {
+		if first {
+			first = false
+		} else {
+			buf.WriteByte(',')
+		}
+		dm.writeSeriesJSON(buf, id, s)
+	}
+	buf.WriteByte(']')
+	buf.WriteByte('}')
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// MergeIntoHarvest implements payloadCreator.
+func (dm *dimensionalMetrics) MergeIntoHarvest(h *harvest) {
+	h.DimensionalMetrics.mergeFailed(dm)
+}
+
+// EndpointMethod implements payloadCreator.
+func (dm *dimensionalMetrics) EndpointMethod() string {
+	return cmdDimensionalMetrics
+}
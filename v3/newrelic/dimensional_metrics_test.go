@@ -0,0 +1,356 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+type wantDimensionalMetric struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Value      interface{}            `json:"value"`
+	Timestamp  int64                  `json:"timestamp,omitempty"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type dimensionalMetricsPayload []struct {
+	Common struct {
+		Timestamp  int64 `json:"timestamp"`
+		IntervalMS int64 `json:"interval.ms"`
+	} `json:"common"`
+	Metrics []wantDimensionalMetric `json:"metrics"`
+}
+
+func decodeDimensionalMetrics(t *testing.T, data []byte) (dimensionalMetricsPayload, map[string]wantDimensionalMetric) {
+	t.Helper()
+	var payload dimensionalMetricsPayload
+	if err := json.Unmarshal(data, &payload); nil != err {
+		t.Fatal(err, string(data))
+	}
+	if len(payload) != 1 {
+		t.Fatal(string(data))
+	}
+	byKey := make(map[string]wantDimensionalMetric)
+	for _, m := range payload[0].Metrics {
+		attrs, _ := json.Marshal(m.Attributes)
+		byKey[m.Type+" "+m.Name+" "+string(attrs)] = m
+	}
+	return payload, byKey
+}
+
+// expectMetricAPIPayload checks that data has the shape documented for the
+// dimensional metrics payload: a list of objects with common and metrics
+// fields, where each metric is a count or gauge with a numeric value, or a
+// summary whose value has exactly the count, sum, min, and max fields.
+func expectMetricAPIPayload(t *testing.T, data []byte) {
+	t.Helper()
+	var payload []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &payload); nil != err {
+		t.Fatal(err, string(data))
+	}
+	for _, batch := range payload {
+		for key := range batch {
+			if key != "common" && key != "metrics" {
+				t.Error("unexpected batch field", key)
+			}
+		}
+		var metrics []map[string]interface{}
+		if err := json.Unmarshal(batch["metrics"], &metrics); nil != err {
+			t.Fatal(err, string(data))
+		}
+		for _, m := range metrics {
+			if _, ok := m["name"].(string); !ok {
+				t.Error("metric name missing", m)
+			}
+			if _, ok := m["attributes"].(map[string]interface{}); !ok {
+				t.Error("metric attributes missing", m)
+			}
+			switch m["type"] {
+			case "count", "gauge":
+				if _, ok := m["value"].(float64); !ok {
+					t.Error("metric value is not a number", m)
+				}
+			case "summary":
+				value, ok := m["value"].(map[string]interface{})
+				if !ok || len(value) != 4 {
+					t.Error("invalid summary value", m)
+					continue
+				}
+				for _, field := range []string{"count", "sum", "min", "max"} {
+					if _, ok := value[field].(float64); !ok {
+						t.Error("summary field missing", field, m)
+					}
+				}
+			default:
+				t.Error("unsupported metric type", m)
+			}
+			for key := range m {
+				switch key {
+				case "name", "type", "value", "timestamp", "interval.ms", "attributes":
+				default:
+					t.Error("unexpected metric field", key, m)
+				}
+			}
+		}
+	}
+}
+
+func recordDimensional(t *testing.T, dm *dimensionalMetrics, tp dimensionalMetricType, name string, value float64, attrs map[string]interface{}, now time.Time) {
+	t.Helper()
+	m, err := newDimensionalMetric(tp, name, value, attrs, now)
+	if nil != err {
+		t.Fatal(err)
+	}
+	dm.add(m)
+}
+
+func TestDimensionalMetricsAggregation(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	dm := newDimensionalMetrics(10, []float64{10, 100}, start)
+	east := map[string]interface{}{"region": "east", "tenant": "acme"}
+	west := map[string]interface{}{"tenant": "acme", "region": "west"}
+
+	recordDimensional(t, dm, dimensionalCount, "orders", 1, east, start)
+	recordDimensional(t, dm, dimensionalCount, "orders", 2, map[string]interface{}{"tenant": "acme", "region": "east"}, start)
+	recordDimensional(t, dm, dimensionalCount, "orders", 5, west, start)
+	recordDimensional(t, dm, dimensionalGauge, "queue.depth", 7, nil, start.Add(2*time.Second))
+	recordDimensional(t, dm, dimensionalGauge, "queue.depth", 3, nil, start.Add(time.Second))
+	recordDimensional(t, dm, dimensionalSummary, "latency", 4, nil, start)
+	recordDimensional(t, dm, dimensionalSummary, "latency", 2, nil, start)
+	recordDimensional(t, dm, dimensionalHistogram, "size", 10, nil, start)
+	recordDimensional(t, dm, dimensionalHistogram, "size", 50, nil, start)
+	recordDimensional(t, dm, dimensionalHistogram, "size", 500, nil, start)
+
+	data, err := dm.Data("run", start.Add(60*time.Second))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expectMetricAPIPayload(t, data)
+	payload, metrics := decodeDimensionalMetrics(t, data)
+	if payload[0].Common.Timestamp != timeToIntMillis(start) || payload[0].Common.IntervalMS != 60000 {
+		t.Error(payload[0].Common)
+	}
+	if len(metrics) != 8 {
+		t.Fatal(string(data))
+	}
+	if m := metrics[`count orders {"region":"east","tenant":"acme"}`]; m.Value != 3.0 {
+		t.Error(m)
+	}
+	if m := metrics[`count orders {"region":"west","tenant":"acme"}`]; m.Value != 5.0 {
+		t.Error(m)
+	}
+	if m := metrics[`gauge queue.depth {}`]; m.Value != 7.0 || m.Timestamp != timeToIntMillis(start.Add(2*time.Second)) {
+		t.Error(m)
+	}
+	summary, _ := json.Marshal(metrics[`summary latency {}`].Value)
+	if string(summary) != `{"count":2,"max":4,"min":2,"sum":6}` {
+		t.Error(string(summary))
+	}
+	histogram, _ := json.Marshal(metrics[`summary size {}`].Value)
+	if string(histogram) != `{"count":3,"max":500,"min":10,"sum":560}` {
+		t.Error(string(histogram))
+	}
+	for le, count := range map[string]float64{"10": 1, "100": 2, "+Inf": 3} {
+		if m := metrics[`count size.bucket {"le":"`+le+`"}`]; m.Value != count {
+			t.Error(le, m)
+		}
+	}
+}
+
+func TestDimensionalMetricsHistogramAttributes(t *testing.T) {
+	now := time.Now()
+	dm := newDimensionalMetrics(10, []float64{0.5}, now)
+	recordDimensional(t, dm, dimensionalHistogram, "size", 1, map[string]interface{}{"region": "east", "zone": 2}, now)
+
+	data, _ := dm.Data("run", now)
+	expectMetricAPIPayload(t, data)
+	_, metrics := decodeDimensionalMetrics(t, data)
+	if len(metrics) != 3 {
+		t.Fatal(string(data))
+	}
+	if m := metrics[`count size.bucket {"le":"0.5","region":"east","zone":2}`]; m.Value != 0.0 {
+		t.Error(m)
+	}
+	if m := metrics[`count size.bucket {"le":"+Inf","region":"east","zone":2}`]; m.Value != 1.0 {
+		t.Error(m)
+	}
+}
+
+func TestDimensionalMetricsEmpty(t *testing.T) {
+	dm := newDimensionalMetrics(10, nil, time.Now())
+	if data, err := dm.Data("run", time.Now()); nil != data || nil != err {
+		t.Error(string(data), err)
+	}
+}
+
+func TestDimensionalMetricsOverflow(t *testing.T) {
+	now := time.Now()
+	dm := newDimensionalMetrics(2, nil, now)
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		recordDimensional(t, dm, dimensionalCount, "orders", 1, map[string]interface{}{"tenant": tenant}, now)
+	}
+	recordDimensional(t, dm, dimensionalCount, "refunds", 1, map[string]interface{}{"tenant": "a"}, now)
+	recordDimensional(t, dm, dimensionalCount, "signups", 1, map[string]interface{}{"tenant": "a"}, now)
+
+	data, _ := dm.Data("run", now)
+	_, metrics := decodeDimensionalMetrics(t, data)
+	if len(metrics) != 4 {
+		t.Fatal(string(data))
+	}
+	if m := metrics[`count orders {"newrelic.overflow":true}`]; m.Value != 2.0 {
+		t.Error(m)
+	}
+	if m := metrics[`count refunds {"newrelic.overflow":true}`]; m.Value != 1.0 {
+		t.Error(m)
+	}
+	if dm.numSeen != 6 || dm.numDropped != 1 {
+		t.Error(dm.numSeen, dm.numDropped)
+	}
+
+	mt := newMetricTable(maxMetrics, now)
+	dm.RecordSupportabilityMetrics(mt)
+	expectMetrics(t, mt, []internal.WantMetric{
+		{Name: dimensionalMetricsSeen, Scope: "", Forced: true, Data: []float64{6, 0, 0, 0, 0, 0}},
+		{Name: dimensionalMetricsDropped, Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+}
+
+func TestDimensionalMetricsMergeFailed(t *testing.T) {
+	start := time.Now()
+	boundaries := []float64{10}
+	failed := newDimensionalMetrics(10, boundaries, start)
+	recordDimensional(t, failed, dimensionalCount, "orders", 2, nil, start)
+	recordDimensional(t, failed, dimensionalHistogram, "size", 5, nil, start)
+
+	h := newDimensionalMetrics(10, boundaries, start.Add(time.Minute))
+	recordDimensional(t, h, dimensionalCount, "orders", 3, nil, start)
+	recordDimensional(t, h, dimensionalHistogram, "size", 50, nil, start)
+	h.mergeFailed(failed)
+	if h.failedHarvests != 1 || !h.periodStart.Equal(start) {
+		t.Error(h.failedHarvests, h.periodStart)
+	}
+
+	data, _ := h.Data("run", start)
+	_, metrics := decodeDimensionalMetrics(t, data)
+	if m := metrics[`count orders {}`]; m.Value != 5.0 {
+		t.Error(m)
+	}
+	histogram, _ := json.Marshal(metrics[`summary size {}`].Value)
+	if string(histogram) != `{"count":2,"max":50,"min":5,"sum":55}` {
+		t.Error(string(histogram))
+	}
+	if m := metrics[`count size.bucket {"le":"10"}`]; m.Value != 1.0 {
+		t.Error(m)
+	}
+	if m := metrics[`count size.bucket {"le":"+Inf"}`]; m.Value != 2.0 {
+		t.Error(m)
+	}
+
+	failed.failedHarvests = failedMetricAttemptsLimit
+	h.mergeFailed(failed)
+	if h.failedHarvests != 1 {
+		t.Error(h.failedHarvests)
+	}
+}
+
+func TestNewDimensionalMetricInvalid(t *testing.T) {
+	now := time.Now()
+	tooMany := make(map[string]interface{})
+	for i := 0; i <= customEventAttributeLimit; i++ {
+		tooMany[string(rune('a'+i%26))+string(rune('a'+i/26))] = i
+	}
+	for _, tc := range []struct {
+		name  string
+		value float64
+		attrs map[string]interface{}
+		err   error
+	}{
+		{name: "", value: 1, err: errMetricNameEmpty},
+		{name: "m", value: math.NaN(), err: errMetricNaN},
+		{name: "m", value: math.Inf(1), err: errMetricInf},
+		{name: "m", value: 1, attrs: tooMany, err: errNumAttributes},
+	} {
+		if _, err := newDimensionalMetric(dimensionalCount, tc.name, tc.value, tc.attrs, now); err != tc.err {
+			t.Error(tc.name, tc.value, err)
+		}
+	}
+	_, err := newDimensionalMetric(dimensionalCount, "m", 1, map[string]interface{}{"tenant": struct{}{}}, now)
+	if _, ok := err.(errInvalidAttributeType); !ok {
+		t.Error(err)
+	}
+}
+
+func TestRecordDimensionalMetrics(t *testing.T) {
+	app := testApp(nil, ConfigDimensionalMetricsEnabled(true), t)
+	attrs := map[string]interface{}{"tenant": "acme", "region": "east"}
+	app.RecordCounter("orders", 1, attrs)
+	app.RecordGauge("queue.depth", 5, attrs)
+	app.RecordSummary("latency", 2, attrs)
+	app.RecordHistogram("size", 20, attrs)
+	app.expectNoLoggedErrors(t)
+
+	payloads := internal.HarvestTestPayloads(app.Private)
+	expectMetricAPIPayload(t, payloads[cmdDimensionalMetrics])
+	_, metrics := decodeDimensionalMetrics(t, payloads[cmdDimensionalMetrics])
+	// The histogram is sent as a summary and a count series for each of the
+	// 15 default boundaries and the +Inf bucket.
+	if len(metrics) != 3+1+16 {
+		t.Fatal(string(payloads[cmdDimensionalMetrics]))
+	}
+	if m := metrics[`count orders {"region":"east","tenant":"acme"}`]; m.Value != 1.0 {
+		t.Error(m)
+	}
+}
+
+func TestRecordDimensionalMetricInvalid(t *testing.T) {
+	app := testApp(nil, ConfigDimensionalMetricsEnabled(true), t)
+	app.RecordHistogram("size", math.NaN(), nil)
+	app.expectSingleLoggedError(t, "unable to record dimensional metric", map[string]interface{}{
+		"metric-name": "size",
+		"metric-type": "histogram",
+		"reason":      errMetricNaN.Error(),
+	})
+}
+
+func TestRecordDimensionalMetricDisabled(t *testing.T) {
+	app := testApp(nil, nil, t)
+	app.RecordCounter("orders", 1, nil)
+	app.expectSingleLoggedError(t, "unable to record dimensional metric", map[string]interface{}{
+		"metric-name": "orders",
+		"metric-type": "count",
+		"reason":      errDimensionalMetricsDisabled.Error(),
+	})
+	if data := internal.HarvestTestPayloads(app.Private)[cmdDimensionalMetrics]; nil != data {
+		t.Error(string(data))
+	}
+}
+
+func TestDimensionalMetricsConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Enabled = false
+	cfg.DimensionalMetrics.Enabled = true
+	cfg.DimensionalMetrics.HistogramBoundaries = []float64{1, 1}
+	if err := cfg.validate(); err != errDimensionalMetricsBoundaries {
+		t.Error(err)
+	}
+	cfg.DimensionalMetrics.HistogramBoundaries = []float64{1, math.Inf(1)}
+	if err := cfg.validate(); err != errDimensionalMetricsBoundaries {
+		t.Error(err)
+	}
+	cfg.DimensionalMetrics.HistogramBoundaries = nil
+	cfg.DimensionalMetrics.MaxTimeSeries = 0
+	if err := cfg.validate(); err != errDimensionalMetricsMaxTimeSeries {
+		t.Error(err)
+	}
+	cfg.DimensionalMetrics.Enabled = false
+	if err := cfg.validate(); nil != err {
+		t.Error(err)
+	}
+}
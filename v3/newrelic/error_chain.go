@@ -0,0 +1,152 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// ErrorCause describes one of the errors wrapped by an error noticed with
+// Transaction.NoticeError.
+type ErrorCause struct {
+	// Type is the reflect type of the error, for example *fmt.wrapError.
+	Type string `json:"type"`
+	// Message is the result of the error's Error method, truncated to 255
+	// bytes.  It is empty when high security mode or the security policies
+	// do not allow error messages to be recorded.
+	Message string `json:"message,omitempty"`
+}
+
+// errorLayer is an error within the tree of wrapped errors together with
+// the number of times it has been wrapped.
+type errorLayer struct {
+	err   error
+	depth int
+}
+
+// errorTree returns the error and the errors it wraps in depth first order.
+// Both the Unwrap() error method and the Unwrap() []error method used by
+// errors.Join are followed.  At most errorCauseLimit errors are returned.
+func errorTree(err error) []errorLayer {
+	var layers []errorLayer
+	var walk func(e error, depth int)
+	walk = func(e error, depth int) {
+		if nil == e || len(layers) >= errorCauseLimit {
+			return
+		}
+		layers = append(layers, errorLayer{err: e, depth: depth})
+		switch u := e.(type) {
+		case interface{ Unwrap() error }:
+			walk(u.Unwrap(), depth+1)
+		case interface{ Unwrap() []error }:
+			for _, next := range u.Unwrap() {
+				walk(next, depth+1)
+			}
+		}
+	}
+	walk(err, 0)
+	return layers
+}
+
+// errorCause returns the deepest error reached using Unwrap() error.  Errors
+// combined using Unwrap() []error, as by errors.Join, are their own cause.
+func errorCause(err error) error {
+	for {
+		if unwrapper, ok := err.(interface{ Unwrap() error }); ok {
+			if next := unwrapper.Unwrap(); nil != next {
+				err = next
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// errorCauses returns the type and message of each error in the tree, or nil
+// if the error does not wrap any other errors.
+func errorCauses(layers []errorLayer) []ErrorCause {
+	if len(layers) < 2 {
+		return nil
+	}
+	causes := make([]ErrorCause, len(layers))
+	for i, l := range layers {
+		causes[i] = ErrorCause{
+			Type:    reflect.TypeOf(l.err).String(),
+			Message: truncateStringValueIfLong(l.err.Error()),
+		}
+	}
+	return causes
+}
+
+// errorCausesJSON returns the value of the AttributeErrorCauses attribute.
+// Like other attribute values it is limited to 255 bytes: the messages of the
+// last causes which fit are truncated, and the causes which do not fit at all
+// are omitted, so that the value remains a valid JSON array.
+func errorCausesJSON(causes []ErrorCause) string {
+	if len(causes) == 0 {
+		return ""
+	}
+	buf := make([]byte, 0, attributeValueLengthLimit)
+	buf = append(buf, '[')
+	for _, c := range causes {
+		// Leave room for the closing bracket and the separating comma.
+		room := attributeValueLengthLimit - len(buf) - 1
+		if len(buf) > 1 {
+			room--
+		}
+		js, ok := errorCauseJSON(c, room)
+		if !ok {
+			break
+		}
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, js...)
+	}
+	if len(buf) == 1 {
+		return ""
+	}
+	return string(append(buf, ']'))
+}
+
+// errorCauseJSON returns the JSON of the cause with its message truncated so
+// that it is at most room bytes long.  It returns false if the cause does not
+// fit even without a message.
+func errorCauseJSON(c ErrorCause, room int) ([]byte, bool) {
+	for {
+		js, err := json.Marshal(c)
+		if nil != err {
+			return nil, false
+		}
+		if len(js) <= room {
+			return js, true
+		}
+		excess := len(js) - room
+		if excess > len(c.Message) {
+			return nil, false
+		}
+		c.Message = stringLengthByteLimit(c.Message, len(c.Message)-excess)
+	}
+}
+
+// errorAgentAttributes returns the agent attributes created from the error
+// data which are added to error events and traced errors.  The error causes
+// are subject to the attribute configuration of the error collector.
+func (errData *errorData) errorAgentAttributes(a *attributes) map[string]string {
+	var attrs map[string]string
+	if errData.ErrorGroup != "" {
+		attrs = map[string]string{AttributeErrorGroupName: errData.ErrorGroup}
+	}
+	if nil == a || 0 == a.config.agentDests[AttributeErrorCauses]&destError {
+		return attrs
+	}
+	if js := errorCausesJSON(errData.Causes); js != "" {
+		if nil == attrs {
+			attrs = make(map[string]string, 1)
+		}
+		attrs[AttributeErrorCauses] = js
+	}
+	return attrs
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+type withAttributesAndCause struct {
+	attrs map[string]interface{}
+	cause error
+}
+
+func (e withAttributesAndCause) Error() string                           { return "attrs: " + e.cause.Error() }
+func (e withAttributesAndCause) Unwrap() error                           { return e.cause }
+func (e withAttributesAndCause) ErrorAttributes() map[string]interface{} { return e.attrs }
+
+func TestErrorTreeJoin(t *testing.T) {
+	input := fmt.Errorf("outer: %w", errors.Join(
+		Error{Message: "first", Class: "firstClass", Attributes: map[string]interface{}{"zip": "zap"}},
+		withStack{stack: beta()},
+	))
+	data, err := errDataFromError(input, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if data.Klass != "firstClass" {
+		t.Error(data.Klass)
+	}
+	if fn := topFrameFunction(data.Stack); !strings.Contains(fn, "beta") {
+		t.Error(fn)
+	}
+	if !reflect.DeepEqual(data.ExtraAttributes, map[string]interface{}{"zip": "zap"}) {
+		t.Error(data.ExtraAttributes)
+	}
+	expect := []ErrorCause{
+		{Type: "*fmt.wrapError", Message: "outer: first\nsomething went wrong"},
+		{Type: "*errors.joinError", Message: "first\nsomething went wrong"},
+		{Type: "newrelic.Error", Message: "first"},
+		{Type: "newrelic.withStack", Message: "something went wrong"},
+	}
+	if !reflect.DeepEqual(data.Causes, expect) {
+		t.Errorf("%#v", data.Causes)
+	}
+}
+
+func TestErrorTreeClassFallback(t *testing.T) {
+	// Without an ErrorClass, the type of the error's cause is used.  The
+	// errors combined by errors.Join are not causes.
+	for _, input := range []error{
+		errors.Join(wrapError(basicError{}), errWithClass("")),
+		fmt.Errorf("outer: %w", errors.Join(basicError{}, basicError{})),
+	} {
+		data, err := errDataFromError(input, false)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if data.Klass != "*errors.joinError" {
+			t.Error(data.Klass)
+		}
+		if len(data.Causes) < 3 || data.Causes[2].Type != "newrelic.basicError" {
+			t.Errorf("%#v", data.Causes)
+		}
+	}
+}
+
+func TestErrorTreeIntermediateLayers(t *testing.T) {
+	input := withAttributesAndCause{
+		attrs: map[string]interface{}{"zip": "outer", "a": 1},
+		cause: wrapError(wrapWithClass(withAttributesAndCause{
+			attrs: map[string]interface{}{"zip": "inner", "b": 2},
+			cause: basicError{},
+		}, "middleClass")),
+	}
+	data, err := errDataFromError(input, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if data.Klass != "middleClass" {
+		t.Error(data.Klass)
+	}
+	if !reflect.DeepEqual(data.ExtraAttributes, map[string]interface{}{"zip": "outer", "a": 1, "b": 2}) {
+		t.Error(data.ExtraAttributes)
+	}
+	if len(data.Causes) != 5 {
+		t.Error(data.Causes)
+	}
+}
+
+func TestErrorTreeDeepestStack(t *testing.T) {
+	data, err := errDataFromError(withStackAndCause{
+		stack: alpha(),
+		cause: wrapError(withStack{stack: beta()}),
+	}, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if fn := topFrameFunction(data.Stack); !strings.Contains(fn, "beta") {
+		t.Error(fn)
+	}
+}
+
+func TestErrorTreeLimits(t *testing.T) {
+	var input error = basicError{}
+	for i := 0; i < 2*errorCauseLimit; i++ {
+		input = wrapError(input)
+	}
+	if layers := errorTree(input); len(layers) != errorCauseLimit {
+		t.Error(len(layers))
+	}
+
+	attrs := func(prefix string) map[string]interface{} {
+		m := make(map[string]interface{})
+		for i := 0; i < attributeErrorLimit; i++ {
+			m[fmt.Sprintf("%s%d", prefix, i)] = i
+		}
+		return m
+	}
+	_, err := errDataFromError(withAttributesAndCause{
+		attrs: attrs("outer"),
+		cause: withAttributesAndCause{attrs: attrs("inner"), cause: basicError{}},
+	}, false)
+	if err != errTooManyErrorAttributes {
+		t.Error(err)
+	}
+}
+
+func TestErrorTreeNotWrapped(t *testing.T) {
+	data, err := errDataFromError(basicError{}, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != data.Causes {
+		t.Error(data.Causes)
+	}
+}
+
+func TestNoticeErrorCausesAttribute(t *testing.T) {
+	var causes []ErrorCause
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.ErrorCollector.ErrorGroupCallback = func(e ErrorInfo) string {
+			causes = e.Causes
+			return ""
+		}
+	}, t)
+	txn := app.StartTransaction("hello")
+	txn.NoticeError(fmt.Errorf("outer: %w", basicError{}))
+	txn.End()
+
+	expect := `[{"type":"*fmt.wrapError","message":"outer: something went wrong"},{"type":"newrelic.basicError","message":"something went wrong"}]`
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "outer: something went wrong",
+		Klass:   "newrelic.basicError",
+		AgentAttributes: map[string]interface{}{
+			AttributeErrorCauses: expect,
+		},
+	}})
+	app.ExpectErrorEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"error.class":     "newrelic.basicError",
+			"error.message":   "outer: something went wrong",
+			"transactionName": "OtherTransaction/Go/hello",
+		},
+		AgentAttributes: map[string]interface{}{
+			AttributeErrorCauses: expect,
+		},
+	}})
+	if len(causes) != 2 || causes[0].Type != "*fmt.wrapError" || causes[1].Message != "something went wrong" {
+		t.Error(causes)
+	}
+}
+
+func TestNoticeErrorCausesHighSecurity(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.HighSecurity = true
+	}, t)
+	txn := app.StartTransaction("hello")
+	txn.NoticeError(fmt.Errorf("outer: %w", basicError{}))
+	txn.End()
+
+	expect := `[{"type":"*fmt.wrapError"},{"type":"newrelic.basicError"}]`
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     highSecurityErrorMsg,
+		Klass:   "newrelic.basicError",
+		AgentAttributes: map[string]interface{}{
+			AttributeErrorCauses: expect,
+		},
+	}})
+}
+
+func TestNoticeErrorCausesExcluded(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.ErrorCollector.Attributes.Exclude = []string{AttributeErrorCauses}
+	}, t)
+	txn := app.StartTransaction("hello")
+	txn.NoticeError(fmt.Errorf("outer: %w", basicError{}))
+	txn.End()
+
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName:         "OtherTransaction/Go/hello",
+		Msg:             "outer: something went wrong",
+		Klass:           "newrelic.basicError",
+		AgentAttributes: map[string]interface{}{},
+	}})
+}
+
+func TestErrorCausesJSONLimit(t *testing.T) {
+	long := strings.Repeat("a", attributeValueLengthLimit)
+	causes := []ErrorCause{
+		{Type: "*fmt.wrapError", Message: "outer: " + long},
+		{Type: "*fmt.wrapError", Message: "middle: " + long},
+		{Type: "newrelic.basicError", Message: long},
+	}
+	js := errorCausesJSON(causes)
+	if len(js) != attributeValueLengthLimit {
+		t.Errorf("length %d: %s", len(js), js)
+	}
+	var decoded []ErrorCause
+	if err := json.Unmarshal([]byte(js), &decoded); nil != err {
+		t.Fatal(err, js)
+	}
+	if len(decoded) != 1 || decoded[0].Type != "*fmt.wrapError" ||
+		!strings.HasPrefix(decoded[0].Message, "outer: aaa") {
+		t.Error(decoded)
+	}
+
+	causes[0].Message = "outer"
+	decoded = nil
+	if err := json.Unmarshal([]byte(errorCausesJSON(causes)), &decoded); nil != err {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0].Message != "outer" || decoded[1].Type != "*fmt.wrapError" {
+		t.Error(decoded)
+	}
+}
@@ -43,11 +43,7 @@ func (e *errorEvent) WriteJSON(buf *bytes.Buffer) {
 	userAttributesJSON(e.Attrs, buf, destError, e.errorData.ExtraAttributes)
 	buf.WriteByte(',')
 
-	if e.ErrorGroup != "" {
-		agentAttributesJSON(e.Attrs, buf, destError, map[string]string{AttributeErrorGroupName: e.ErrorGroup})
-	} else {
-		agentAttributesJSON(e.Attrs, buf, destError)
-	}
+	agentAttributesJSON(e.Attrs, buf, destError, e.errorAgentAttributes(e.Attrs))
 
 	buf.WriteByte(']')
 }
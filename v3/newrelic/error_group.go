@@ -45,6 +45,12 @@ type ErrorInfo struct {
 
 	// Expected is true if the error was expected by the go agent
 	Expected bool
+
+	// Causes contains the type and message of the error and each of the
+	// errors it wraps, in depth first order, when the error was noticed
+	// with Transaction.NoticeError and wraps at least one other error.
+	// Errors joined with errors.Join are included.
+	Causes []ErrorCause
 }
 
 // GetTransactionUserAttribute safely looks up a user attribute by string key from the parent transaction
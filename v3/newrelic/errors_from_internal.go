@@ -59,6 +59,7 @@ type errorData struct {
 	Stack           stackTrace
 	RawError        error
 	ExtraAttributes map[string]interface{}
+	Causes          []ErrorCause
 	ErrorGroup      string
 	Msg             string
 	Klass           string
@@ -108,7 +109,7 @@ func (h *tracedError) WriteJSON(buf *bytes.Buffer) {
 	buf.WriteByte('{')
 	buf.WriteString(`"agentAttributes"`)
 	buf.WriteByte(':')
-	agentAttributesJSON(h.Attrs, buf, destError)
+	agentAttributesJSON(h.Attrs, buf, destError, h.errorAgentAttributes(h.Attrs))
 	buf.WriteByte(',')
 	buf.WriteString(`"userAttributes"`)
 	buf.WriteByte(':')
@@ -196,6 +197,7 @@ func (errData *errorData) applyErrorGroup(txnEvent *txnEvent) {
 		txnAttributes:   txnEvent.Attrs,
 		TransactionName: txnEvent.FinalName,
 		errAttributes:   errData.ExtraAttributes,
+		Causes:          append([]ErrorCause(nil), errData.Causes...),
 		stackTrace:      errData.Stack,
 		Error:           errData.RawError,
 		TimeOccured:     errData.When,
@@ -231,6 +233,14 @@ func (errData *errorData) scrubErrorForHighSecurity(hs *highSecuritySettings) {
 	if !hs.allowRawExceptionMessages {
 		errData.Msg = securityPolicyErrorMsg
 	}
+
+	if hs.enabled || !hs.allowRawExceptionMessages {
+		causes := make([]ErrorCause, len(errData.Causes))
+		for i, c := range errData.Causes {
+			causes[i] = ErrorCause{Type: c.Type}
+		}
+		errData.Causes = causes
+	}
 }
 
 func scrubbedErrorMessage(msg string, txn *txn) string {
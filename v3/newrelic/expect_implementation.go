@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/newrelic/go-agent/v3/internal"
@@ -414,8 +415,37 @@ func expectSpanEvents(v internal.Validator, events *spanEvents, expect []interna
 		"sampled":  true,
 		"priority": internal.MatchAnything,
 	}
-	expectEvents(v, events.analyticsEvents, expect, extraAttrs)
-	expectObserverEvents(v, events.analyticsEvents, expect, extraAttrs)
+	linkExtraAttrs := map[string]interface{}{
+		// The following intrinsics should always be present in
+		// span link events:
+		"type":      "SpanLink",
+		"timestamp": internal.MatchAnything,
+		"id":        internal.MatchAnything,
+		"trace.id":  internal.MatchAnything,
+	}
+	merged := make([]internal.WantEvent, len(expect))
+	for i, e := range expect {
+		if nil != e.Intrinsics {
+			if e.Intrinsics["type"] == "SpanLink" {
+				e.Intrinsics = mergeAttributes(linkExtraAttrs, e.Intrinsics)
+			} else {
+				e.Intrinsics = mergeAttributes(extraAttrs, e.Intrinsics)
+			}
+		}
+		merged[i] = e
+	}
+	// Span links are stored with their span, and are expected after it.
+	flattened := &analyticsEvents{}
+	for _, e := range events.events {
+		flattened.events = append(flattened.events, e)
+		if span, ok := e.jsonWriter.(*spanEvent); ok {
+			for _, link := range span.Links {
+				flattened.events = append(flattened.events, analyticsEvent{priority: e.priority, jsonWriter: link})
+			}
+		}
+	}
+	expectEvents(v, flattened, merged, nil)
+	expectObserverEvents(v, flattened, merged, nil)
 }
 
 // expectTxnEvents allows testing of txn events.
@@ -614,6 +644,15 @@ func expectSlowQuery(t internal.Validator, slowQuery *slowQuery, want internal.W
 	validateStringField(t, "Host", want.Host, slowQuery.Host)
 	validateStringField(t, "PortPathOrID", want.PortPathOrID, slowQuery.PortPathOrID)
 	expectAttributes(t, map[string]interface{}(slowQuery.QueryParameters), want.Params)
+	if nil != want.ExplainPlan {
+		var plan [][]string
+		if nil != slowQuery.ExplainPlan {
+			plan = append([][]string{slowQuery.ExplainPlan.Columns}, slowQuery.ExplainPlan.Rows...)
+		}
+		if !reflect.DeepEqual(plan, want.ExplainPlan) {
+			t.Error("wrong ExplainPlan field", plan, want.ExplainPlan)
+		}
+	}
 }
 
 // expectSlowQueries allows testing of slow queries.
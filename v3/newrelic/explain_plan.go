@@ -0,0 +1,425 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/jsonx"
+)
+
+const (
+	// maxExplainPlanRows limits the number of rows of an explain plan.
+	maxExplainPlanRows = 100
+	// maxExplainPlanQueries limits the number of queries whose last
+	// explain time is remembered by explainPlanLimiter.
+	maxExplainPlanQueries = 1000
+)
+
+var errExplainUnsupported = errors.New("statement does not support queries with a context")
+
+// explainPlan is the obfuscated result of explaining a slow query.
+type explainPlan struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// WriteJSON writes the plan as the columns followed by the rows, which is
+// the format expected within the slow query parameters.
+func (p *explainPlan) WriteJSON(buf *bytes.Buffer) {
+	buf.WriteByte('[')
+	jsonx.AppendStringArray(buf, p.Columns...)
+	buf.WriteByte(',')
+	buf.WriteByte('[')
+	for i, row := range p.Rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		jsonx.AppendStringArray(buf, row...)
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(']')
+}
+
+// obfuscateExplainValue replaces the value of every labelled line of a
+// Postgres plan, such as "Filter: (id = 1234)", with "?" since it may contain
+// literals from the query.  This is the default obfuscation of Postgres
+// explain plans used by every agent, which may also hide values that are not
+// literals.  A value continues onto the following lines while it is within a
+// string.
+func obfuscateExplainValue(value string) string {
+	lines := strings.Split(value, "\n")
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		label := strings.IndexByte(line, ':')
+		if label < 0 || strings.ContainsAny(line[:label], "(\"'") ||
+			strings.TrimSpace(line[label+1:]) == "" ||
+			(line[label+1] != ' ' && line[label+1] != '\t') {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, line[:label+1]+" ?")
+		inString := false
+		for {
+			inString = explainStringState(lines[i][label+1:], inString)
+			if !inString || i+1 >= len(lines) {
+				break
+			}
+			i++
+			label = -1
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// explainStringState returns whether the end of s is within a single quoted
+// string, given whether its start is.
+func explainStringState(s string, inString bool) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\'' {
+			inString = !inString
+		}
+	}
+	return inString
+}
+
+// explainPlanLimiter limits how often each query is explained.
+type explainPlanLimiter struct {
+	sync.Mutex
+	last map[uint32]time.Time
+}
+
+// allow returns true if the query has not been explained within the
+// interval.
+func (l *explainPlanLimiter) allow(queryID uint32, now time.Time, interval time.Duration) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if last, ok := l.last[queryID]; ok && now.Sub(last) < interval {
+		return false
+	}
+	if nil == l.last || len(l.last) >= maxExplainPlanQueries {
+		l.last = make(map[uint32]time.Time)
+	}
+	l.last[queryID] = now
+	return true
+}
+
+// needsExplainPlan returns true if the query has been recorded as a slow
+// query of the transaction without an explain plan, and the query may be
+// explained now.  The query must be the ParameterizedQuery of the ended
+// segment, which is empty if the query may not be recorded.
+func (thd *thread) needsExplainPlan(query string) bool {
+	txn := thd.txn
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished || query == "" || nil == txn.SlowQueries {
+		return false
+	}
+	cfg := txn.Config.DatastoreTracer.SlowQuery.Explain
+	if !cfg.Enabled || txn.Config.HighSecurity {
+		return false
+	}
+	idx, ok := txn.SlowQueries.lookup[query]
+	if !ok || nil != txn.SlowQueries.priorityQueue[idx].ExplainPlan {
+		return false
+	}
+	if nil == txn.app {
+		return true
+	}
+	return txn.app.explainPlans.allow(makeSlowQueryID(query), time.Now(), cfg.Interval)
+}
+
+// addExplainPlan attaches the plan to the slow query of the transaction.
+func (thd *thread) addExplainPlan(query string, plan *explainPlan) {
+	txn := thd.txn
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished || nil == txn.SlowQueries {
+		return
+	}
+	if idx, ok := txn.SlowQueries.lookup[query]; ok {
+		txn.SlowQueries.priorityQueue[idx].ExplainPlan = plan
+	}
+}
+
+// explainable returns true if the query of the segment is a single select,
+// insert, update, or delete statement.  The ParameterizedQuery is checked for
+// semicolons since it contains neither literals nor comments.
+func explainable(segment *DatastoreSegment) bool {
+	switch strings.ToLower(segment.Operation) {
+	case "select", "insert", "update", "delete":
+	default:
+		return false
+	}
+	query := strings.TrimSuffix(strings.TrimSpace(segment.ParameterizedQuery), ";")
+	return !strings.Contains(query, ";")
+}
+
+// explainStatement returns the statement which explains the query of the
+// ended segment, or "" if the query should not be explained.
+func (w *wrapConn) explainStatement(bld SQLDriverSegmentBuilder, segment *DatastoreSegment, query string) string {
+	if nil == bld.ExplainQuery || nil == segment.StartTime.thread || w.inTx || !explainable(segment) {
+		return ""
+	}
+	if !segment.StartTime.thread.needsExplainPlan(segment.ParameterizedQuery) {
+		return ""
+	}
+	return bld.ExplainQuery(query)
+}
+
+// explain runs the statement on the connection and attaches the resulting
+// plan to the slow query of the ended segment.
+func explain(ctx context.Context, conn driver.Conn, segment *DatastoreSegment, stmt string, args []driver.NamedValue) {
+	plan, err := runExplain(ctx, conn, stmt, args)
+	segment.addExplainPlan(plan, err)
+}
+
+// addExplainPlan attaches the plan to the slow query of the ended segment.
+// Failures are logged since they must not affect the application's query.
+func (s *DatastoreSegment) addExplainPlan(plan *explainPlan, err error) {
+	thd := s.StartTime.thread
+	if nil != err {
+		if lg := thd.txn.Config.Logger; nil != lg && lg.DebugEnabled() {
+			lg.Debug("unable to explain slow query", map[string]interface{}{
+				"query": s.ParameterizedQuery,
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+	thd.addExplainPlan(s.ParameterizedQuery, plan)
+}
+
+// CaptureExplainPlan captures the explain plan of the query of the ended
+// segment if the query was recorded as a slow query.  It is meant for the
+// instrumentation of database libraries which do not use database/sql, which
+// use SQLDriverSegmentBuilder.ExplainQuery instead.  explain is called only if
+// the plan should be captured, with the same restrictions as ExplainQuery,
+// and returns the columns and rows of the plan, whose values are obfuscated
+// using the rules for Postgres plans.  The caller must not explain queries
+// run within a transaction.
+func (s *DatastoreSegment) CaptureExplainPlan(explain func() (columns []string, rows [][]interface{}, err error)) {
+	if nil == s || nil == explain || nil == s.StartTime.thread || !explainable(s) {
+		return
+	}
+	if !s.StartTime.thread.needsExplainPlan(s.ParameterizedQuery) {
+		return
+	}
+	columns, rows, err := explain()
+	if nil != err {
+		s.addExplainPlan(nil, err)
+		return
+	}
+	plan := &explainPlan{Columns: columns}
+	for _, values := range rows {
+		if len(plan.Rows) >= maxExplainPlanRows {
+			break
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = explainValue(v)
+		}
+		plan.Rows = append(plan.Rows, row)
+	}
+	s.addExplainPlan(plan, nil)
+}
+
+// explainValue returns the obfuscated string value of a column of a plan.
+func explainValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return obfuscateExplainValue(string(v))
+	case string:
+		return obfuscateExplainValue(v)
+	default:
+		return obfuscateExplainValue(fmt.Sprint(v))
+	}
+}
+
+// explainOnClose returns rows which explain the query of the ended segment
+// once they are closed, if the query should be explained.
+func explainOnClose(ctx context.Context, bld SQLDriverSegmentBuilder, conn *wrapConn, segment *DatastoreSegment,
+	query string, args []driver.NamedValue, rows driver.Rows) driver.Rows {
+	stmt := conn.explainStatement(bld, segment, query)
+	if stmt == "" {
+		return rows
+	}
+	return &wrapRows{
+		original: rows,
+		explain:  func() { explain(ctx, conn.original, segment, stmt, args) },
+	}
+}
+
+func runExplain(ctx context.Context, conn driver.Conn, stmt string, args []driver.NamedValue) (*explainPlan, error) {
+	var rows driver.Rows
+	err := driver.ErrSkip
+	if q, ok := conn.(driver.QueryerContext); ok {
+		rows, err = q.QueryContext(ctx, stmt, args)
+	}
+	if err == driver.ErrSkip {
+		rows, err = queryPrepared(ctx, conn, stmt, args)
+	}
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plan := &explainPlan{Columns: rows.Columns()}
+	dest := make([]driver.Value, len(plan.Columns))
+	for len(plan.Rows) < maxExplainPlanRows {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if nil != err {
+			return nil, err
+		}
+		row := make([]string, len(dest))
+		for i, v := range dest {
+			row[i] = explainValue(v)
+		}
+		plan.Rows = append(plan.Rows, row)
+	}
+	return plan, nil
+}
+
+// queryPrepared runs the statement for connections which only support
+// queries using prepared statements.
+func queryPrepared(ctx context.Context, conn driver.Conn, stmt string, args []driver.NamedValue) (driver.Rows, error) {
+	var s driver.Stmt
+	var err error
+	if pc, ok := conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, stmt)
+	} else {
+		s, err = conn.Prepare(stmt)
+	}
+	if nil != err {
+		return nil, err
+	}
+	sq, ok := s.(driver.StmtQueryContext)
+	if !ok {
+		s.Close()
+		return nil, errExplainUnsupported
+	}
+	rows, err := sq.QueryContext(ctx, args)
+	if nil != err {
+		s.Close()
+		return nil, err
+	}
+	return &stmtRows{Rows: rows, stmt: s}, nil
+}
+
+// stmtRows closes the statement used for an explain plan with its rows.
+type stmtRows struct {
+	driver.Rows
+	stmt driver.Stmt
+}
+
+func (r *stmtRows) Close() error {
+	err := r.Rows.Close()
+	r.stmt.Close()
+	return err
+}
+
+// wrapRows explains the query which returned the rows once the rows are
+// closed, since most drivers cannot run another query on the connection
+// while the rows are open.  The optional methods of driver.Rows return the
+// same values as database/sql uses when the original rows do not implement
+// them.
+type wrapRows struct {
+	original driver.Rows
+	explain  func()
+}
+
+func (w *wrapRows) Columns() []string { return w.original.Columns() }
+
+func (w *wrapRows) Next(dest []driver.Value) error { return w.original.Next(dest) }
+
+func (w *wrapRows) Close() error {
+	err := w.original.Close()
+	if nil != w.explain {
+		w.explain()
+		w.explain = nil
+	}
+	return err
+}
+
+// HasNextResultSet implements RowsNextResultSet.
+func (w *wrapRows) HasNextResultSet() bool {
+	if r, ok := w.original.(driver.RowsNextResultSet); ok {
+		return r.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet implements RowsNextResultSet.
+func (w *wrapRows) NextResultSet() error {
+	if r, ok := w.original.(driver.RowsNextResultSet); ok {
+		return r.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType implements RowsColumnTypeScanType.
+func (w *wrapRows) ColumnTypeScanType(index int) reflect.Type {
+	if r, ok := w.original.(driver.RowsColumnTypeScanType); ok {
+		return r.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName implements RowsColumnTypeDatabaseTypeName.
+func (w *wrapRows) ColumnTypeDatabaseTypeName(index int) string {
+	if r, ok := w.original.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return r.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength implements RowsColumnTypeLength.
+func (w *wrapRows) ColumnTypeLength(index int) (int64, bool) {
+	if r, ok := w.original.(driver.RowsColumnTypeLength); ok {
+		return r.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements RowsColumnTypeNullable.
+func (w *wrapRows) ColumnTypeNullable(index int) (bool, bool) {
+	if r, ok := w.original.(driver.RowsColumnTypeNullable); ok {
+		return r.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale implements RowsColumnTypePrecisionScale.
+func (w *wrapRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if r, ok := w.original.(driver.RowsColumnTypePrecisionScale); ok {
+		return r.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+var _ interface {
+	driver.Rows
+	driver.RowsNextResultSet
+	driver.RowsColumnTypeScanType
+	driver.RowsColumnTypeDatabaseTypeName
+	driver.RowsColumnTypeLength
+	driver.RowsColumnTypeNullable
+	driver.RowsColumnTypePrecisionScale
+} = &wrapRows{}
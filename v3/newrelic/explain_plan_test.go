@@ -0,0 +1,353 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/crossagent"
+)
+
+func TestObfuscateExplainPlanCrossAgent(t *testing.T) {
+	dir := "postgres_explain_obfuscation"
+	files, err := crossagent.ReadDir(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var count int
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".explain.txt") {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".explain.txt")
+		plan, err := crossagent.ReadFile(filepath.Join(dir, name+".explain.txt"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		want, err := crossagent.ReadFile(filepath.Join(dir, name+".colon_obfuscated.txt"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		got := obfuscateExplainValue(strings.TrimRight(string(plan), "\n"))
+		if got != strings.TrimRight(string(want), "\n") {
+			t.Errorf("%s:\ngot:\n%s\nwanted:\n%s", name, got, want)
+		}
+		count++
+	}
+	if count == 0 {
+		t.Error("no test cases found")
+	}
+}
+
+func TestExplainPlanLimiter(t *testing.T) {
+	var l explainPlanLimiter
+	now := time.Now()
+	if !l.allow(1, now, time.Minute) {
+		t.Error("first explain not allowed")
+	}
+	if l.allow(1, now.Add(30*time.Second), time.Minute) {
+		t.Error("explain allowed within interval")
+	}
+	if !l.allow(2, now.Add(30*time.Second), time.Minute) {
+		t.Error("explain of other query not allowed")
+	}
+	if !l.allow(1, now.Add(time.Minute), time.Minute) {
+		t.Error("explain not allowed after interval")
+	}
+}
+
+type explainRows struct {
+	columns []string
+	values  [][]driver.Value
+	closed  bool
+}
+
+func (r *explainRows) Columns() []string { return r.columns }
+func (r *explainRows) Close() error      { r.closed = true; return nil }
+func (r *explainRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// explainConn records the statements it runs and explains them with a
+// Postgres plan.
+type explainConn struct {
+	testConn
+	queries *[]string
+	rows    **explainRows
+}
+
+func (c explainConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.queries = append(*c.queries, query)
+	if strings.HasPrefix(query, "EXPLAIN ") {
+		return &explainRows{
+			columns: []string{"QUERY PLAN"},
+			values: [][]driver.Value{
+				{[]byte("Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)")},
+				{[]byte("  Index Cond: (id = 1234)")},
+			},
+		}, nil
+	}
+	rows := &explainRows{columns: []string{"id"}}
+	*c.rows = rows
+	return rows, nil
+}
+
+func (c explainConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	*c.queries = append(*c.queries, query)
+	return nil, nil
+}
+
+var explainBuilder = SQLDriverSegmentBuilder{
+	BaseSegment: DatastoreSegment{Product: DatastorePostgres},
+	ParseQuery: func(segment *DatastoreSegment, query string) {
+		segment.Operation = "select"
+		segment.Collection = "users"
+		segment.ParameterizedQuery = "SELECT * FROM users WHERE id = ?"
+	},
+	ExplainQuery: func(query string) string { return "EXPLAIN " + query },
+}
+
+var wantExplainPlan = &explainPlan{
+	Columns: []string{"QUERY PLAN"},
+	Rows: [][]string{
+		{"Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)"},
+		{"  Index Cond: ?"},
+	},
+}
+
+func slowQueryExplainCfg(cfg *Config) {
+	cfg.DistributedTracer.Enabled = false
+	cfg.DatastoreTracer.SlowQuery.Threshold = 0
+}
+
+func harvestedExplainPlan(t *testing.T, app expectApp) *explainPlan {
+	t.Helper()
+	slows := app.Application.app.testHarvest.SlowSQLs
+	if len(slows.priorityQueue) != 1 {
+		t.Fatal(len(slows.priorityQueue))
+	}
+	return slows.priorityQueue[0].ExplainPlan
+}
+
+func TestExplainSlowQueryExec(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	var queries []string
+	var rows *explainRows
+	conn := optionalMethodsConn(&wrapConn{bld: explainBuilder, original: explainConn{queries: &queries, rows: &rows}})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	conn.(driver.ExecerContext).ExecContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+	txn.End()
+
+	if !reflect.DeepEqual(queries, []string{
+		"SELECT * FROM users WHERE id = 1234",
+		"EXPLAIN SELECT * FROM users WHERE id = 1234",
+	}) {
+		t.Error(queries)
+	}
+	if plan := harvestedExplainPlan(t, app); !reflect.DeepEqual(plan, wantExplainPlan) {
+		t.Error(plan)
+	}
+}
+
+func TestExplainSlowQueryOnRowsClose(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	var queries []string
+	var original *explainRows
+	conn := optionalMethodsConn(&wrapConn{bld: explainBuilder, original: explainConn{queries: &queries, rows: &original}})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	rows, err := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, ok := rows.(driver.RowsColumnTypeScanType); !ok {
+		t.Error("rows are not wrapped")
+	}
+	if len(queries) != 1 {
+		t.Error("query explained while rows are open", queries)
+	}
+	rows.Close()
+	if !original.closed {
+		t.Error("original rows not closed")
+	}
+	if len(queries) != 2 || queries[1] != "EXPLAIN SELECT * FROM users WHERE id = 1234" {
+		t.Error(queries)
+	}
+	// A second slow query within the interval is not explained.
+	rows, _ = conn.(driver.QueryerContext).QueryContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+	rows.Close()
+	if len(queries) != 3 {
+		t.Error(queries)
+	}
+	txn.End()
+
+	if plan := harvestedExplainPlan(t, app); !reflect.DeepEqual(plan, wantExplainPlan) {
+		t.Error(plan)
+	}
+	buf := &bytes.Buffer{}
+	app.Application.app.testHarvest.SlowSQLs.WriteJSON(buf)
+	if !strings.Contains(buf.String(), `"explain_plan":[["QUERY PLAN"],[["Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)"],["  Index Cond: ?"]]]`) {
+		t.Error(buf.String())
+	}
+}
+
+func TestExplainSlowQueryDisabled(t *testing.T) {
+	for name, cfgfn := range map[string]func(*Config){
+		"explain disabled": func(cfg *Config) {
+			slowQueryExplainCfg(cfg)
+			cfg.DatastoreTracer.SlowQuery.Explain.Enabled = false
+		},
+		"fast query": func(cfg *Config) {
+			slowQueryExplainCfg(cfg)
+			cfg.DatastoreTracer.SlowQuery.Threshold = time.Hour
+		},
+	} {
+		app := testApp(nil, cfgfn, t)
+		var queries []string
+		var rows *explainRows
+		conn := optionalMethodsConn(&wrapConn{bld: explainBuilder, original: explainConn{queries: &queries, rows: &rows}})
+		txn := app.StartTransaction("hello")
+		ctx := NewContext(context.Background(), txn)
+		conn.(driver.ExecerContext).ExecContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+		txn.End()
+		if len(queries) != 1 {
+			t.Error(name, queries)
+		}
+	}
+}
+
+func TestExplainSlowQueryPreparedStatement(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	var queries []string
+	var rows *explainRows
+	conn := optionalMethodsConn(&wrapConn{bld: explainBuilder, original: explainConn{queries: &queries, rows: &rows}})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	stmt, _ := conn.(driver.ConnPrepareContext).PrepareContext(ctx, "SELECT * FROM users WHERE id = $1")
+	stmt.(driver.StmtExecContext).ExecContext(ctx, []driver.NamedValue{{Ordinal: 1, Value: int64(1234)}})
+	txn.End()
+
+	if len(queries) != 1 || queries[0] != "EXPLAIN SELECT * FROM users WHERE id = $1" {
+		t.Error(queries)
+	}
+	if plan := harvestedExplainPlan(t, app); !reflect.DeepEqual(plan, wantExplainPlan) {
+		t.Error(plan)
+	}
+}
+
+type explainTx struct{}
+
+func (explainTx) Commit() error   { return nil }
+func (explainTx) Rollback() error { return nil }
+
+func (c explainConn) Begin() (driver.Tx, error) { return explainTx{}, nil }
+
+func TestExplainSlowQueryInTransaction(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	var queries []string
+	var rows *explainRows
+	conn := optionalMethodsConn(&wrapConn{bld: explainBuilder, original: explainConn{queries: &queries, rows: &rows}})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	tx, err := conn.Begin()
+	if nil != err {
+		t.Fatal(err)
+	}
+	conn.(driver.ExecerContext).ExecContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+	if len(queries) != 1 {
+		t.Error("query explained within transaction", queries)
+	}
+	tx.Commit()
+	conn.(driver.ExecerContext).ExecContext(ctx, "SELECT * FROM users WHERE id = 1234", nil)
+	txn.End()
+	if len(queries) != 3 || queries[2] != "EXPLAIN SELECT * FROM users WHERE id = 1234" {
+		t.Error(queries)
+	}
+}
+
+func TestExplainable(t *testing.T) {
+	for _, tc := range []struct {
+		operation string
+		query     string
+		want      bool
+	}{
+		{operation: "select", query: "SELECT * FROM users WHERE id = ?", want: true},
+		{operation: "insert", query: "INSERT INTO users VALUES (?);", want: true},
+		{operation: "update", query: "UPDATE users SET name = ?", want: true},
+		{operation: "delete", query: "DELETE FROM users", want: true},
+		{operation: "select", query: "SELECT * FROM users; DROP TABLE users", want: false},
+		{operation: "select", query: "SELECT ?; SELECT ?;", want: false},
+		{operation: "create", query: "CREATE TABLE users (id int)", want: false},
+		{operation: "", query: "VACUUM", want: false},
+	} {
+		segment := &DatastoreSegment{Operation: tc.operation, ParameterizedQuery: tc.query}
+		if got := explainable(segment); got != tc.want {
+			t.Error(tc.query, got)
+		}
+	}
+}
+
+func TestExplainSlowQueryNotExplainable(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	var queries []string
+	var rows *explainRows
+	bld := explainBuilder
+	bld.ParseQuery = func(segment *DatastoreSegment, query string) {
+		segment.Operation = "select"
+		segment.ParameterizedQuery = "SELECT ?; DELETE FROM users"
+	}
+	conn := optionalMethodsConn(&wrapConn{bld: bld, original: explainConn{queries: &queries, rows: &rows}})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	conn.(driver.ExecerContext).ExecContext(ctx, "SELECT 1; DELETE FROM users", nil)
+	txn.End()
+	if len(queries) != 1 {
+		t.Error(queries)
+	}
+}
+
+func TestCaptureExplainPlan(t *testing.T) {
+	app := testApp(nil, slowQueryExplainCfg, t)
+	txn := app.StartTransaction("hello")
+	segment := &DatastoreSegment{
+		StartTime:          txn.StartSegmentNow(),
+		Product:            DatastorePostgres,
+		Operation:          "select",
+		Collection:         "users",
+		ParameterizedQuery: "SELECT * FROM users WHERE id = ?",
+	}
+	segment.End()
+	var calls int
+	explain := func() ([]string, [][]interface{}, error) {
+		calls++
+		return []string{"QUERY PLAN"}, [][]interface{}{
+			{"Index Scan using users_pkey on users  (cost=0.00..8.27 rows=1 width=540)"},
+			{[]byte("  Index Cond: (id = 1234)")},
+		}, nil
+	}
+	segment.CaptureExplainPlan(explain)
+	// The plan of the query has been captured.
+	segment.CaptureExplainPlan(explain)
+	txn.End()
+	if calls != 1 {
+		t.Error(calls)
+	}
+	if plan := harvestedExplainPlan(t, app); !reflect.DeepEqual(plan, wantExplainPlan) {
+		t.Error(plan)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+const (
+	defaultFileExportMaxBytes = 100 * 1024 * 1024
+	defaultFileExportMaxFiles = 5
+
+	// fileExportRunID is the run ID of the synthetic connect reply used
+	// when harvest data is written locally.
+	fileExportRunID = "file_export"
+)
+
+// fileExportPayload is a single collector payload within a line of output.
+type fileExportPayload struct {
+	Command string          `json:"cmd"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// fileExportHarvest is written as a single line of output for each harvest.
+type fileExportHarvest struct {
+	HarvestStart  int64               `json:"harvest_start_ms"`
+	AppName       string              `json:"app_name"`
+	Host          string              `json:"host"`
+	AgentLanguage string              `json:"agent_language"`
+	AgentVersion  string              `json:"agent_version"`
+	AgentRunID    string              `json:"agent_run_id"`
+	Payloads      []fileExportPayload `json:"payloads"`
+}
+
+// fileExporter writes harvest data to a file or io.Writer as newline
+// delimited JSON instead of sending it to New Relic.
+type fileExporter struct {
+	appName  string
+	host     string
+	writer   io.Writer
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	// Harvests may be written by multiple goroutines.
+	sync.Mutex
+}
+
+func newFileExporter(c config) *fileExporter {
+	e := &fileExporter{
+		appName:  c.AppName,
+		host:     c.hostname,
+		writer:   c.FileExport.Writer,
+		path:     c.FileExport.Path,
+		maxBytes: c.FileExport.MaxBytes,
+		maxFiles: c.FileExport.MaxFiles,
+	}
+	if e.maxBytes <= 0 {
+		e.maxBytes = defaultFileExportMaxBytes
+	}
+	if e.maxFiles <= 0 {
+		e.maxFiles = defaultFileExportMaxFiles
+	}
+	return e
+}
+
+// newFileExportConnectReply creates the connect reply used in place of
+// connecting to New Relic when harvest data is written locally.
+func newFileExportConnectReply() *internal.ConnectReply {
+	reply := internal.ConnectReplyDefaults()
+	reply.RunID = fileExportRunID
+	return reply
+}
+
+// rotatedName returns the name of the nth oldest rotated file.
+func (e *fileExporter) rotatedName(n int) string {
+	return fmt.Sprintf("%s.%d", e.path, n)
+}
+
+// rotate renames the current file to path.1, path.1 to path.2, and so on,
+// removing the oldest file once there are maxFiles rotated files.  It must
+// be called with the lock held.
+func (e *fileExporter) rotate() error {
+	os.Remove(e.rotatedName(e.maxFiles))
+	for n := e.maxFiles - 1; n > 0; n-- {
+		os.Rename(e.rotatedName(n), e.rotatedName(n+1))
+	}
+	return os.Rename(e.path, e.rotatedName(1))
+}
+
+// writeFile appends a line to the file, rotating it first if the line would
+// take it past maxBytes.  It must be called with the lock held.
+func (e *fileExporter) writeFile(line []byte) error {
+	if info, err := os.Stat(e.path); nil == err && info.Size() > 0 && info.Size()+int64(len(line)) > e.maxBytes {
+		if err := e.rotate(); nil != err {
+			return err
+		}
+	}
+	f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if nil != err {
+		return err
+	}
+	if _, err := f.Write(line); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write writes a line of output.
+func (e *fileExporter) write(line []byte) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if nil != e.writer {
+		_, err := e.writer.Write(line)
+		return err
+	}
+	return e.writeFile(line)
+}
+
+// doFileExportHarvest writes the payloads of a harvest using the same
+// encoding used for the collector.
+func (app *app) doFileExportHarvest(payloads []payloadCreator, harvestStart time.Time, run *appRun) {
+	runID := run.Reply.RunID.String()
+	out := fileExportHarvest{
+		HarvestStart:  timeToIntMillis(harvestStart),
+		AppName:       app.fileExport.appName,
+		Host:          app.fileExport.host,
+		AgentLanguage: agentLanguage,
+		AgentVersion:  Version,
+		AgentRunID:    runID,
+	}
+	for _, p := range payloads {
+		cmd := p.EndpointMethod()
+		data, err := p.Data(runID, harvestStart)
+		if nil != err {
+			app.Warn("unable to create harvest data", map[string]interface{}{
+				"cmd":   cmd,
+				"error": err.Error(),
+			})
+			continue
+		}
+		if nil == data {
+			continue
+		}
+		out.Payloads = append(out.Payloads, fileExportPayload{Command: cmd, Data: data})
+	}
+	if len(out.Payloads) == 0 {
+		return
+	}
+
+	js, err := json.Marshal(out)
+	if nil != err {
+		app.Error("unable to marshal harvest data", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := app.fileExport.write(append(js, '\n')); nil != err {
+		app.Warn("unable to write harvest data", map[string]interface{}{
+			"path":  app.fileExport.path,
+			"error": err.Error(),
+		})
+	}
+}
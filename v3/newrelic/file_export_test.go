@@ -0,0 +1,110 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileExportApplication(t *testing.T) {
+	buf := &bytes.Buffer{}
+	app, err := NewApplication(
+		ConfigAppName("my app"),
+		ConfigWriterExport(buf),
+		ConfigCodeLevelMetricsEnabled(false),
+		func(cfg *Config) {
+			cfg.RuntimeSampler.Enabled = false
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.WaitForConnection(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("segment").End()
+	txn.End()
+	app.Shutdown(10 * time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatal(buf.String())
+	}
+	var out fileExportHarvest
+	if err := json.Unmarshal([]byte(lines[0]), &out); nil != err {
+		t.Fatal(err)
+	}
+	if out.AppName != "my app" || out.AgentRunID != fileExportRunID || out.AgentLanguage != "go" || out.AgentVersion != Version {
+		t.Error(out)
+	}
+	cmds := make(map[string]string)
+	for _, p := range out.Payloads {
+		cmds[p.Command] = string(p.Data)
+	}
+	if data := cmds[cmdMetrics]; !strings.HasPrefix(data, `["file_export",`) || !strings.Contains(data, `"OtherTransaction/Go/hello"`) {
+		t.Error(data)
+	}
+	if data := cmds[cmdTxnEvents]; !strings.Contains(data, `"name":"OtherTransaction/Go/hello"`) {
+		t.Error(data)
+	}
+}
+
+func TestFileExportRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newrelic.json")
+	e := newFileExporter(config{Config: func() Config {
+		c := defaultConfig()
+		c.FileExport.Path = path
+		c.FileExport.MaxBytes = 10
+		c.FileExport.MaxFiles = 2
+		return c
+	}()})
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if err := e.write([]byte(line)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	for name, expect := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		if data, err := os.ReadFile(name); nil != err || string(data) != expect {
+			t.Error(name, string(data), err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("too many rotated files", err)
+	}
+}
+
+func TestFileExportAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newrelic.json")
+	cfg := defaultConfig()
+	cfg.FileExport.Path = path
+	e := newFileExporter(config{Config: cfg})
+	e.write([]byte("first\n"))
+	e.write([]byte("second\n"))
+
+	f, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || lines[0] != "first" || lines[1] != "second" {
+		t.Error(lines)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// goroutineSegmentName returns the name of the function with its package
+// path removed, such as "main.processOrder.func1", to name the segment of a
+// task started without a name.
+func goroutineSegmentName(fn interface{}) string {
+	loc, err := FunctionLocation(fn)
+	if nil != err || loc.Function == "" {
+		return "goroutine"
+	}
+	name := loc.Function
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+// NewGoroutineTask prepares fn to be run in another goroutine.  If ctx
+// contains a Transaction, NewGoroutineTask calls Transaction.NewGoroutine and
+// fn receives a context containing the new Transaction reference.  When the
+// returned function runs, fn is timed by a segment with the given name, or
+// the name of fn if name is empty, and an error returned by fn is noticed.
+// If ctx does not contain a Transaction, the returned function simply calls
+// fn with ctx.
+//
+// NewGoroutineTask is used by Go and WorkerPool, and can be used to
+// instrument other ways of running goroutines:
+//
+//	task := newrelic.NewGoroutineTask(ctx, "resize", resize)
+//	go task()
+//
+// NewGoroutineTask must be called in the goroutine which owns ctx, before
+// the transaction ends.
+func NewGoroutineTask(ctx context.Context, name string, fn func(ctx context.Context) error) func() error {
+	txn := FromContext(ctx)
+	if nil == txn {
+		return func() error { return fn(ctx) }
+	}
+	if name == "" {
+		name = goroutineSegmentName(fn)
+	}
+	txn = txn.NewGoroutine()
+	ctx = NewContext(ctx, txn)
+	return func() error {
+		seg := txn.StartSegment(name)
+		err := fn(ctx)
+		if nil != err {
+			txn.NoticeError(err)
+		}
+		seg.End()
+		return err
+	}
+}
+
+// Go runs fn in a new goroutine.  If ctx contains a Transaction, fn receives
+// a context containing a new Transaction reference for the goroutine, fn is
+// timed by a segment named after the function, and an error returned by fn
+// is noticed.  This replaces calling Transaction.NewGoroutine by hand:
+//
+//	newrelic.Go(ctx, func(ctx context.Context) error {
+//		return sendReceipt(ctx, order)
+//	})
+//
+// Segments which end after the transaction has ended are not recorded, so
+// the transaction should wait for the goroutine if its segment is wanted.
+func Go(ctx context.Context, fn func(ctx context.Context) error) {
+	task := NewGoroutineTask(ctx, "", fn)
+	go task()
+}
+
+var errWorkerPoolClosed = errors.New("worker pool is closed")
+
+// WorkerPool runs tasks on a fixed number of goroutines.  Each task is run
+// with NewGoroutineTask, so that tasks submitted with a context containing a
+// Transaction record a segment in that transaction and notice the errors
+// they return.
+//
+//	pool := newrelic.NewWorkerPool(4)
+//	for _, img := range images {
+//		img := img
+//		pool.Submit(ctx, "resize", func(ctx context.Context) error {
+//			return resize(ctx, img)
+//		})
+//	}
+//	err := pool.Wait()
+type WorkerPool struct {
+	tasks chan func() error
+
+	mu      sync.Mutex
+	closed  bool
+	err     error
+	running sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+// NewWorkerPool starts a WorkerPool with the given number of workers.  At
+// least one worker is started.
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &WorkerPool{tasks: make(chan func() error)}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.workers.Done()
+	for task := range p.tasks {
+		if err := task(); nil != err {
+			p.mu.Lock()
+			if nil == p.err {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+		p.running.Done()
+	}
+}
+
+// Submit runs fn on the next free worker, waiting until a worker is free.
+// The segment of the task is named name, or after the function if name is
+// empty.  Submit returns an error if the pool has been closed.
+func (p *WorkerPool) Submit(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errWorkerPoolClosed
+	}
+	p.running.Add(1)
+	p.mu.Unlock()
+
+	p.tasks <- NewGoroutineTask(ctx, name, fn)
+	return nil
+}
+
+// Wait waits for the tasks submitted so far to finish and returns the first
+// error returned by a task.  Wait must not be called at the same time as
+// Submit.
+func (p *WorkerPool) Wait() error {
+	p.running.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close waits for the submitted tasks to finish and stops the workers.
+// Tasks cannot be submitted after Close is called.  Close returns the first
+// error returned by a task.
+func (p *WorkerPool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		p.mu.Unlock()
+		p.running.Wait()
+		close(p.tasks)
+	} else {
+		p.mu.Unlock()
+	}
+	p.workers.Wait()
+	return p.Wait()
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func processOrder(ctx context.Context) error { return nil }
+
+func TestGoroutineSegmentName(t *testing.T) {
+	if name := goroutineSegmentName(processOrder); name != "newrelic.processOrder" {
+		t.Error(name)
+	}
+	if name := goroutineSegmentName(nil); name != "goroutine" {
+		t.Error(name)
+	}
+}
+
+func TestGo(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("job")
+	ctx := NewContext(context.Background(), txn)
+	done := make(chan *Transaction)
+	Go(ctx, func(ctx context.Context) error {
+		done <- FromContext(ctx)
+		return nil
+	})
+	if got := <-done; got == nil || got == txn {
+		t.Error("task does not have a new goroutine transaction", got)
+	}
+	txn.End()
+}
+
+func TestNewGoroutineTask(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("job")
+	ctx := NewContext(context.Background(), txn)
+	task := NewGoroutineTask(ctx, "", func(ctx context.Context) error {
+		return errors.New("task failed")
+	})
+	done := make(chan error)
+	go func() { done <- task() }()
+	if err := <-done; nil == err {
+		t.Error("task error not returned")
+	}
+	txn.End()
+
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/newrelic.TestNewGoroutineTask.func1", Scope: "OtherTransaction/Go/job", Forced: false, Data: []float64{1}},
+	})
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/job",
+		Msg:     "task failed",
+		Klass:   "*errors.errorString",
+	}})
+}
+
+func TestGoNoTransaction(t *testing.T) {
+	ctx := context.Background()
+	done := make(chan context.Context)
+	Go(ctx, func(c context.Context) error {
+		done <- c
+		return nil
+	})
+	if got := <-done; got != ctx {
+		t.Error(got)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("job")
+	ctx := NewContext(context.Background(), txn)
+	pool := NewWorkerPool(3)
+	var count int32
+	errTask := errors.New("task failed")
+	for i := 0; i < 10; i++ {
+		i := i
+		err := pool.Submit(ctx, "resize", func(ctx context.Context) error {
+			if FromContext(ctx) == nil {
+				t.Error("task context does not contain a transaction")
+			}
+			atomic.AddInt32(&count, 1)
+			if i == 5 {
+				return errTask
+			}
+			return nil
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Wait(); err != errTask {
+		t.Error(err)
+	}
+	if err := pool.Close(); err != errTask {
+		t.Error(err)
+	}
+	if err := pool.Submit(ctx, "resize", func(ctx context.Context) error { return nil }); err != errWorkerPoolClosed {
+		t.Error(err)
+	}
+	// Close may be called more than once.
+	pool.Close()
+	txn.End()
+
+	if count != 10 {
+		t.Error(count)
+	}
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Custom/resize", Scope: "OtherTransaction/Go/job", Forced: false, Data: []float64{10}},
+	})
+	app.ExpectErrors(t, []internal.WantError{{
+		TxnName: "OtherTransaction/Go/job",
+		Msg:     "task failed",
+		Klass:   "*errors.errorString",
+	}})
+}
+
+func TestWorkerPoolNoTransaction(t *testing.T) {
+	pool := NewWorkerPool(0)
+	var count int32
+	for i := 0; i < 3; i++ {
+		pool.Submit(context.Background(), "", func(ctx context.Context) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+	}
+	if err := pool.Close(); nil != err || count != 3 {
+		t.Error(err, count)
+	}
+}
@@ -25,13 +25,15 @@ const (
 	harvestLogEvents
 	harvestTxnEvents
 	harvestErrorEvents
+	harvestProfiles
+	harvestDimensionalMetrics
 )
 
 const (
 	// harvestTypesEvents includes all Event types
 	harvestTypesEvents = harvestSpanEvents | harvestCustomEvents | harvestTxnEvents | harvestErrorEvents | harvestLogEvents
 	// harvestTypesAll includes all harvest types
-	harvestTypesAll = harvestMetricsTraces | harvestTypesEvents
+	harvestTypesAll = harvestMetricsTraces | harvestTypesEvents | harvestProfiles | harvestDimensionalMetrics
 )
 
 type harvestTimer struct {
@@ -70,6 +72,9 @@ type harvest struct {
 	LogEvents    *logEvents
 	TxnEvents    *txnEvents
 	ErrorEvents  *errorEvents
+	Profiles     profiles
+
+	DimensionalMetrics *dimensionalMetrics
 }
 
 const (
@@ -117,6 +122,15 @@ func (h *harvest) Ready(now time.Time) *harvest {
 		ready.SpanEvents = h.SpanEvents
 		h.SpanEvents = newSpanEvents(h.SpanEvents.capacity())
 	}
+	if 0 != types&harvestProfiles {
+		ready.Profiles = h.Profiles
+		h.Profiles = newProfiles(maxHarvestProfiles)
+	}
+	if 0 != types&harvestDimensionalMetrics {
+		h.DimensionalMetrics.RecordSupportabilityMetrics(h.Metrics)
+		ready.DimensionalMetrics = h.DimensionalMetrics
+		h.DimensionalMetrics = newDimensionalMetrics(h.DimensionalMetrics.maxSeries, h.DimensionalMetrics.boundaries, now)
+	}
 	// NOTE! Metrics must happen after the event harvest conditionals to
 	// ensure that the metrics contain the event supportability metrics.
 	if 0 != types&harvestMetricsTraces {
@@ -161,6 +175,12 @@ func (h *harvest) Payloads(splitLargeTxnEvents bool) (ps []payloadCreator) {
 	if nil != h.SlowSQLs {
 		ps = append(ps, h.SlowSQLs)
 	}
+	if nil != h.Profiles {
+		ps = append(ps, h.Profiles)
+	}
+	if nil != h.DimensionalMetrics {
+		ps = append(ps, h.DimensionalMetrics)
+	}
 	if nil != h.TxnEvents {
 		if splitLargeTxnEvents {
 			ps = append(ps, h.TxnEvents.payloads(txnEventPayloadlimit)...)
@@ -179,6 +199,9 @@ type harvestConfig struct {
 	MaxCustomEvents  int
 	MaxErrorEvents   int
 	MaxTxnEvents     int
+
+	MaxDimensionalMetricSeries  int
+	DimensionalMetricBoundaries []float64
 }
 
 // newHarvest returns a new Harvest.
@@ -194,6 +217,10 @@ func newHarvest(now time.Time, configurer harvestConfig) *harvest {
 		LogEvents:    newLogEvents(configurer.CommonAttributes, configurer.LoggingConfig),
 		TxnEvents:    newTxnEvents(configurer.MaxTxnEvents),
 		ErrorEvents:  newErrorEvents(configurer.MaxErrorEvents),
+		Profiles:     newProfiles(maxHarvestProfiles),
+
+		DimensionalMetrics: newDimensionalMetrics(configurer.MaxDimensionalMetricSeries,
+			configurer.DimensionalMetricBoundaries, now),
 	}
 }
 
@@ -361,5 +388,7 @@ var (
 			nil,
 			nil,
 		},
+		MaxDimensionalMetricSeries:  defaultDimensionalMetricsMaxTimeSeries,
+		DimensionalMetricBoundaries: defaultDimensionalMetricsHistogramBoundaries(),
 	}
 )
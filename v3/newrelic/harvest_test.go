@@ -50,8 +50,8 @@ func TestHarvestTimerAllConfigurable(t *testing.T) {
 	now := time.Now()
 	harvest := newHarvest(now, harvestConfig{
 		ReportPeriods: map[harvestTypes]time.Duration{
-			harvestMetricsTraces: fixedHarvestPeriod,
-			harvestTypesEvents:   time.Second * 30,
+			harvestMetricsTraces | harvestProfiles | harvestDimensionalMetrics: fixedHarvestPeriod,
+			harvestTypesEvents: time.Second * 30,
 		},
 		MaxTxnEvents:    1,
 		MaxCustomEvents: 2,
@@ -224,7 +224,7 @@ func TestCreateFinalMetricsTraceObserver(t *testing.T) {
 func TestEmptyPayloads(t *testing.T) {
 	h := newHarvest(time.Now(), testHarvestCfgr)
 	payloads := h.Payloads(true)
-	if len(payloads) != 9 {
+	if len(payloads) != 11 {
 		t.Error(len(payloads))
 	}
 	for _, p := range payloads {
@@ -894,10 +894,10 @@ func TestHarvestSplitTxnEvents(t *testing.T) {
 	payloadsWithSplit := h.Payloads(true)
 	payloadsWithoutSplit := h.Payloads(false)
 
-	if len(payloadsWithSplit) != 10 {
+	if len(payloadsWithSplit) != 12 {
 		t.Error(len(payloadsWithSplit))
 	}
-	if len(payloadsWithoutSplit) != 9 {
+	if len(payloadsWithoutSplit) != 11 {
 		t.Error(len(payloadsWithoutSplit))
 	}
 }
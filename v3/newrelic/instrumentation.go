@@ -161,6 +161,46 @@ func WrapHandleFunc(app *Application, pattern string, handler func(http.Response
 	return p, func(w http.ResponseWriter, r *http.Request) { h.ServeHTTP(w, r) }
 }
 
+// BrowserMonitoringHandler wraps an http.Handler to automatically insert the
+// JavaScript returned by Transaction.BrowserTimingHeader into HTML responses,
+// so that it does not need to be placed into each template by hand.  It
+// requires a Transaction in the request's context, so it must be used inside
+// of WrapHandle or an integration package middleware:
+//
+//	http.Handle(newrelic.WrapHandle(app, "/", newrelic.BrowserMonitoringHandler(myHandler)))
+//
+// Responses with a text/html content type are buffered until the handler
+// returns.  The loader is then inserted into the <head> element after any
+// position-sensitive X-UA-Compatible or charset <meta> tags, and the
+// configuration is inserted before the closing </body> tag.  Gzip encoded
+// responses are decompressed and recompressed as needed.  All other responses
+// are written through without buffering or modification, as are responses
+// that are flushed by the handler, responses larger than 2 MB, responses with
+// a status code other than 200, attachments, and pages which already contain
+// the browser JavaScript.
+//
+// Since buffered responses are only written to the http.ResponseWriter once
+// the handler returns, the Transaction records the response code and headers
+// through the http.ResponseWriter returned by Transaction.SetWebResponse,
+// which WrapHandle and the integration package middlewares pass to the
+// handler.  When the Transaction is started by hand, pass the result of
+// SetWebResponse to the handler returned by BrowserMonitoringHandler.
+func BrowserMonitoringHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txn := FromContext(r.Context())
+		if txn == nil || r.Method == http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		bw := &browserInjectionWriter{
+			original: w,
+			txn:      txn,
+		}
+		handler.ServeHTTP(upgradeBrowserInjectionWriter(bw), r)
+		bw.finish()
+	})
+}
+
 // WrapListen wraps an HTTP endpoint reference passed to functions like http.ListenAndServe,
 // which causes security scanning to be done for that incoming endpoint when vulnerability
 // scanning is enabled. It returns the endpoint string, so you can replace a call like
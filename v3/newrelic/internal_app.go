@@ -25,12 +25,23 @@ type appData struct {
 
 type app struct {
 	Logger
+	// config is the configuration the application was created with.
+	// Settings which can be changed by UpdateConfig must be read using
+	// getConfig.
 	config      config
 	rpmControls rpmControls
 	testHarvest *harvest
+	// testHarvestLock guards the test harvest, which transactions ending
+	// on different goroutines merge into.
+	testHarvestLock sync.Mutex
 
 	trObserver traceObserver
 
+	// explainPlans limits how often each slow query is explained.
+	explainPlans explainPlanLimiter
+	// datastorePools are sampled by runSampler.
+	datastorePools datastorePools
+
 	// placeholderRun is used when the application is not connected.
 	placeholderRun *appRun
 
@@ -52,6 +63,7 @@ type app struct {
 	dataChan           chan appData
 	collectorErrorChan chan rpmResponse
 	connectChan        chan *appRun
+	configChan         chan configUpdate
 
 	// This mutex protects both `run` and `err`, both of which should only
 	// be accessed using getState and setState.
@@ -62,6 +74,13 @@ type app struct {
 	// err is non-nil if the application will never be connected again
 	// (disconnect, license exception, shutdown).
 	err error
+	// updatedConfig is non-nil once the configuration has been changed by
+	// UpdateConfig.  It should only be accessed using getConfig.
+	updatedConfig *config
+
+	// updateConfigLock prevents concurrent calls to UpdateConfig from
+	// losing changes.
+	updateConfigLock sync.Mutex
 
 	// registered callback functions
 	llmTokenCountCallback func(string, string) int
@@ -70,13 +89,107 @@ type app struct {
 	heapHighWaterMarkAlarms heapHighWaterMarkAlarmSet
 
 	serverless *serverlessHarvest
+
+	// otlp is non-nil when data is sent to an OTLP endpoint instead of
+	// New Relic.
+	otlp *otlpExporter
+
+	// metricAPI sends dimensional metrics to the Metric API when data is
+	// sent to New Relic.
+	metricAPI *metricAPIExporter
+
+	// spool is non-nil when payloads which cannot be sent are written to
+	// disk.
+	spool *diskSpool
+
+	// fileExport is non-nil when data is written locally instead of sent
+	// to New Relic.
+	fileExport *fileExporter
+
+	// tailSampler is non-nil when span events are sampled after each
+	// transaction ends.
+	tailSampler *tailSampler
+}
+
+// shuttingDown returns true once shutdown has started.
+func (app *app) shuttingDown() bool {
+	select {
+	case <-app.shutdownStarted:
+		return true
+	default:
+		return false
+	}
+}
+
+// spoolHarvestData writes a payload which could not be sent to the disk
+// spool, if there is one.
+func (app *app) spoolHarvestData(run *appRun, cmd string, data []byte) {
+	if nil == app.spool {
+		return
+	}
+	if err := app.spool.write(cmd, run.Reply.RunID.String(), data, time.Now()); nil != err {
+		app.Warn("unable to spool harvest data", map[string]interface{}{
+			"cmd":   cmd,
+			"error": err.Error(),
+		})
+	}
+}
+
+// replaySpool sends the payloads in the disk spool after the application
+// connects.
+func (app *app) replaySpool(run *appRun) {
+	if nil == app.spool {
+		return
+	}
+	app.spool.replay(run.Reply.RunID.String(), time.Now(), func(cmd string, data []byte) (bool, error) {
+		if app.shuttingDown() {
+			return true, nil
+		}
+		call := rpmCmd{
+			Collector:         run.Reply.Collector,
+			RunID:             run.Reply.RunID.String(),
+			Name:              cmd,
+			Data:              data,
+			RequestHeadersMap: run.Reply.RequestHeadersMap,
+			MaxPayloadSize:    run.Reply.MaxPayloadSizeInBytes,
+		}
+		resp := collectorRequest(call, app.rpmControls)
+		if err := resp.GetError(); nil != err {
+			app.Warn("spooled harvest failure", map[string]interface{}{
+				"cmd":         cmd,
+				"error":       err.Error(),
+				"retain_data": resp.ShouldSpoolHarvestData(),
+			})
+		}
+		return resp.ShouldSpoolHarvestData(), resp.GetError()
+	})
 }
 
 func (app *app) doHarvest(h *harvest, harvestStart time.Time, run *appRun) {
+	createDiskSpoolMetrics(app.spool, h.Metrics)
+	createTailSamplingMetrics(app.tailSampler, h.Metrics)
 	h.CreateFinalMetrics(run, app.getObserver())
 
-	payloads := h.Payloads(app.config.DistributedTracer.Enabled)
+	payloads := h.Payloads(run.Config.DistributedTracer.Enabled)
+	if nil != app.otlp {
+		app.doOTLPHarvest(payloads, harvestStart, run)
+		return
+	}
+	if nil != app.fileExport {
+		app.doFileExportHarvest(payloads, harvestStart, run)
+		return
+	}
+	// spoolOnly is set after a restart exception so that the remaining
+	// payloads are spooled rather than sent using the expired run.
+	spoolOnly := false
 	for _, p := range payloads {
+		if dm, ok := p.(*dimensionalMetrics); ok {
+			// Dimensional metrics are not accepted by the collector.
+			if nil != app.metricAPI {
+				app.doMetricAPIHarvest(dm, harvestStart, run)
+			}
+			continue
+		}
 		cmd := p.EndpointMethod()
 		var data []byte
 
@@ -104,6 +217,10 @@ func (app *app) doHarvest(h *harvest, harvestStart time.Time, run *appRun) {
 		if data == nil {
 			continue
 		}
+		if spoolOnly {
+			app.spoolHarvestData(run, cmd, data)
+			continue
+		}
 
 		call := rpmCmd{
 			Collector:         run.Reply.Collector,
@@ -121,33 +238,61 @@ func (app *app) doHarvest(h *harvest, harvestStart time.Time, run *appRun) {
 			case app.collectorErrorChan <- *resp:
 			case <-app.shutdownStarted:
 			}
-			return
+			if resp.IsDisconnect() || nil == app.spool {
+				return
+			}
+			app.spoolHarvestData(run, cmd, data)
+			spoolOnly = true
+			continue
 		}
 
 		if resp.GetError() != nil {
 			app.Warn("harvest failure", map[string]interface{}{
 				"cmd":         cmd,
 				"error":       resp.GetError().Error(),
-				"retain_data": resp.ShouldSaveHarvestData(),
+				"retain_data": resp.ShouldSaveHarvestData() || (nil != app.spool && resp.ShouldSpoolHarvestData()),
 			})
 		}
 
-		if resp.ShouldSaveHarvestData() {
+		// Data saved for the next harvest is lost if the application is
+		// shutting down, so it is spooled instead.
+		if resp.ShouldSaveHarvestData() && !app.shuttingDown() {
 			app.Consume(run.Reply.RunID, p)
+		} else if resp.ShouldSpoolHarvestData() {
+			app.spoolHarvestData(run, cmd, data)
 		}
 	}
 }
 
 func (app *app) connectRoutine() {
+	if nil != app.otlp {
+		select {
+		case app.connectChan <- newAppRun(app.getConfig(), newOTLPConnectReply()):
+		case <-app.shutdownStarted:
+		}
+		return
+	}
+	if nil != app.fileExport {
+		select {
+		case app.connectChan <- newAppRun(app.getConfig(), newFileExportConnectReply()):
+		case <-app.shutdownStarted:
+		}
+		return
+	}
+
 	attempts := 0
 	for {
-		reply, resp := connectAttempt(app.config, app.rpmControls)
+		cfg := app.getConfig()
+		reply, resp := connectAttempt(cfg, app.rpmControls)
 
 		if reply != nil {
+			run := newAppRun(cfg, reply)
 			select {
-			case app.connectChan <- newAppRun(app.config, reply):
+			case app.connectChan <- run:
 			case <-app.shutdownStarted:
+				return
 			}
+			app.replaySpool(run)
 			return
 		}
 
@@ -237,6 +382,12 @@ func (app *app) process() {
 	var h *harvest
 	var run *appRun
 
+	// staleRun and staleConnect are set when the configuration is updated
+	// while the application is connecting, since the connection may be
+	// using the previous configuration.
+	staleRun := false
+	staleConnect := false
+
 	harvestTicker := time.NewTicker(time.Second)
 	defer harvestTicker.Stop()
 
@@ -303,7 +454,37 @@ func (app *app) process() {
 				})
 				go app.connectRoutine()
 			}
+		case u := <-app.configChan:
+			if nil == run {
+				staleRun = true
+				staleConnect = staleConnect || u.reconnect
+			} else if u.reconnect {
+				// Send the data collected so far using the
+				// current run before connecting again.
+				go app.doHarvest(h, time.Now(), run)
+				run = nil
+				h = nil
+				app.setState(nil, nil)
+				app.Info("application reconnecting to apply configuration", map[string]interface{}{
+					"app": app.getConfig().AppName,
+				})
+				go app.connectRoutine()
+			} else {
+				run = app.refreshRun(run)
+				app.setState(run, nil)
+			}
 		case run = <-app.connectChan:
+			if staleConnect {
+				run = nil
+				staleRun = false
+				staleConnect = false
+				go app.connectRoutine()
+				break
+			}
+			if staleRun {
+				run = newAppRun(app.getConfig(), run.Reply)
+				staleRun = false
+			}
 			if shouldUseTraceObserver(run.Config) {
 				app.connectTraceObserver(run.Reply)
 			} else if shouldUseTraceObserver(app.config) {
@@ -317,7 +498,7 @@ func (app *app) process() {
 
 			run.harvestConfig.CommonAttributes = commonAttributes{
 				hostname:   app.config.hostname,
-				entityName: app.config.AppName,
+				entityName: run.Config.AppName,
 				entityGUID: run.Reply.EntityGUID,
 			}
 
@@ -325,7 +506,7 @@ func (app *app) process() {
 			app.setState(run, nil)
 
 			app.Info("application connected", map[string]interface{}{
-				"app": app.config.AppName,
+				"app": run.Config.AppName,
 				"run": run.Reply.RunID.String(),
 			})
 			processConnectMessages(run, app)
@@ -363,19 +544,29 @@ func (app *app) Shutdown(timeout time.Duration) {
 	})
 }
 
+// runSampler samples the runtime statistics, if the runtime sampler is
+// enabled, and the registered datastore connection pools.
 func runSampler(app *app, period time.Duration) {
-	previous := getSystemSample(time.Now(), app)
+	var previous *systemSample
+	if app.config.RuntimeSampler.Enabled {
+		previous = getSystemSample(time.Now(), app)
+	}
 	t := time.NewTicker(period)
 	for {
 		select {
 		case now := <-t.C:
-			current := getSystemSample(now, app)
 			run, _ := app.getState()
-			app.Consume(run.Reply.RunID, getSystemStats(systemSamples{
-				Previous: previous,
-				Current:  current,
-			}))
-			previous = current
+			if nil != previous {
+				current := getSystemSample(now, app)
+				app.Consume(run.Reply.RunID, getSystemStats(systemSamples{
+					Previous: previous,
+					Current:  current,
+				}))
+				previous = current
+			}
+			if pools := app.datastorePools.sample(); nil != pools {
+				app.Consume(run.Reply.RunID, pools)
+			}
 		case <-app.shutdownStarted:
 			t.Stop()
 			return
@@ -418,6 +609,8 @@ func (app *app) WaitForConnection(timeout time.Duration) error {
 }
 
 func newApp(c config) *app {
+	// The Logger is wrapped so that it can be replaced by UpdateConfig.
+	c.Logger = newReloadableLogger(c.Logger)
 	transport := c.Transport
 	if nil == transport {
 		transport = collectorDefaultTransport
@@ -435,6 +628,7 @@ func newApp(c config) *app {
 		shutdownComplete:   make(chan struct{}),
 		connectChan:        make(chan *appRun, 1),
 		collectorErrorChan: make(chan rpmResponse, 1),
+		configChan:         make(chan configUpdate),
 		dataChan:           make(chan appData, appDataChanSize),
 		rpmControls: rpmControls{
 			License: c.License,
@@ -451,6 +645,10 @@ func newApp(c config) *app {
 		},
 	}
 
+	if app.config.SpanEvents.TailSampling.Enabled {
+		app.tailSampler = newTailSampler(c.Config)
+	}
+
 	app.Info("application created", map[string]interface{}{
 		"app":          app.config.AppName,
 		"version":      Version,
@@ -464,10 +662,31 @@ func newApp(c config) *app {
 			app.run = newAppRun(c, reply)
 			app.serverless = newServerlessHarvest(c.Logger, os.Getenv)
 		} else {
+			if app.config.OTLP.Enabled {
+				app.otlp = newOTLPExporter(c, app.rpmControls)
+			} else if app.config.FileExport.Enabled {
+				app.fileExport = newFileExporter(c)
+			} else {
+				app.metricAPI = newMetricAPIExporter(c, app.rpmControls)
+				if app.config.DiskSpool.Enabled {
+					spool, err := newDiskSpool(c)
+					if nil != err {
+						app.Error("unable to create disk spool", map[string]interface{}{
+							"dir":   c.DiskSpool.Directory,
+							"error": err.Error(),
+						})
+					} else {
+						app.spool = spool
+					}
+				}
+			}
 			go app.process()
 			go app.connectRoutine()
-			if app.config.RuntimeSampler.Enabled {
-				go runSampler(app, runtimeSamplerPeriod)
+			go runSampler(app, runtimeSamplerPeriod)
+			if app.config.Profiling.Enabled {
+				p := newProfiler(app)
+				app.heapHighWaterMarkAlarms.profiler = p.heapAlarm
+				go p.run()
 			}
 		}
 	}
@@ -480,8 +699,9 @@ func shouldUseTraceObserver(c config) bool {
 }
 
 var (
-	_ internal.HarvestTestinger = &app{}
-	_ internal.Expect           = &app{}
+	_ internal.HarvestTestinger     = &app{}
+	_ internal.HarvestTestPayloader = &app{}
+	_ internal.Expect               = &app{}
 )
 
 func (app *app) HarvestTesting(replyfn func(*internal.ConnectReply)) {
@@ -490,7 +710,31 @@ func (app *app) HarvestTesting(replyfn func(*internal.ConnectReply)) {
 		replyfn(reply)
 		app.placeholderRun = newAppRun(app.config, reply)
 	}
+	app.testHarvestLock.Lock()
 	app.testHarvest = newHarvest(time.Now(), app.placeholderRun.harvestConfig)
+	app.testHarvestLock.Unlock()
+}
+
+// HarvestTestPayloads returns the data in the test harvest encoded as it
+// would be sent to each collector endpoint, keyed by the endpoint method.
+// Empty data types are omitted.
+func (app *app) HarvestTestPayloads() map[string][]byte {
+	app.testHarvestLock.Lock()
+	defer app.testHarvestLock.Unlock()
+
+	payloads := make(map[string][]byte)
+	if nil == app.testHarvest {
+		return payloads
+	}
+	now := time.Now()
+	for _, p := range app.testHarvest.Payloads(false) {
+		data, err := p.Data("", now)
+		if nil != err || nil == data {
+			continue
+		}
+		payloads[p.EndpointMethod()] = data
+	}
+	return payloads
 }
 
 func (app *app) getState() (*appRun, error) {
@@ -556,11 +800,12 @@ func (app *app) RecordCustomEvent(eventType string, params map[string]interface{
 		return nil
 	}
 
-	if app.config.Config.HighSecurity {
+	run, _ := app.getState()
+	if run.Config.HighSecurity {
 		return errHighSecurityEnabled
 	}
 
-	if !app.config.CustomInsightsEvents.Enabled {
+	if !run.Config.CustomInsightsEvents.Enabled {
 		return errCustomEventsDisabled
 	}
 
@@ -573,7 +818,6 @@ func (app *app) RecordCustomEvent(eventType string, params map[string]interface{
 		return e
 	}
 
-	run, _ := app.getState()
 	if !run.Reply.CollectCustomEvents {
 		return errCustomEventsRemoteDisabled
 	}
@@ -619,13 +863,40 @@ func (app *app) RecordCustomMetric(name string, value float64) error {
 	return nil
 }
 
+var (
+	errDimensionalMetricsDisabled   = errors.New("dimensional metrics are disabled")
+	errDimensionalMetricsServerless = errors.New("dimensional metrics are not supported in serverless mode")
+)
+
+// recordDimensionalMetric implements newrelic.Application's RecordCounter,
+// RecordGauge, RecordSummary, and RecordHistogram.
+func (app *app) recordDimensionalMetric(tp dimensionalMetricType, name string, value float64, attributes map[string]interface{}) error {
+	if nil == app {
+		return nil
+	}
+	if app.config.ServerlessMode.Enabled {
+		return errDimensionalMetricsServerless
+	}
+	run, _ := app.getState()
+	if !run.Config.DimensionalMetrics.Enabled {
+		return errDimensionalMetricsDisabled
+	}
+	m, err := newDimensionalMetric(tp, name, value, attributes, time.Now())
+	if nil != err {
+		return err
+	}
+	app.Consume(run.Reply.RunID, m)
+	return nil
+}
+
 var (
 	errAppLoggingDisabled = errors.New("log data can not be recorded when application logging is disabled")
 )
 
 // RecordLog implements newrelic.Application's RecordLog.
 func (app *app) RecordLog(log *LogData) error {
-	if !app.config.ApplicationLogging.Enabled {
+	run, _ := app.getState()
+	if !run.Config.ApplicationLogging.Enabled {
 		return errAppLoggingDisabled
 	}
 
@@ -634,7 +905,6 @@ func (app *app) RecordLog(log *LogData) error {
 		return err
 	}
 
-	run, _ := app.getState()
 	app.Consume(run.Reply.RunID, &event)
 	return nil
 }
@@ -652,7 +922,9 @@ func (app *app) Consume(id internal.AgentRunID, data harvestable) {
 	app.serverless.Consume(data)
 
 	if nil != app.testHarvest {
+		app.testHarvestLock.Lock()
 		data.MergeIntoHarvest(app.testHarvest)
+		app.testHarvestLock.Unlock()
 		return
 	}
 
@@ -137,6 +137,9 @@ func newTxn(app *app, run *appRun, name string, opts ...TraceOption) *thread {
 		txn.BetterCAT.Priority = newPriorityFromRandom(txn.TraceIDGenerator.Float32)
 		txn.ShouldCollectSpanEvents = txn.shouldCollectSpanEvents
 		txn.ShouldCreateSpanGUID = txn.shouldCreateSpanGUID
+		if nil != app {
+			txn.tailSampler = app.tailSampler
+		}
 	}
 
 	txn.Attrs.Agent.Add(AttributeHostDisplayName, txn.Config.HostDisplayName, nil)
@@ -145,6 +148,13 @@ func newTxn(app *app, run *appRun, name string, opts ...TraceOption) *thread {
 	txn.TxnTrace.StackTraceThreshold = txn.Config.TransactionTracer.Segments.StackTraceThreshold
 	txn.SlowQueriesEnabled = txn.Config.DatastoreTracer.SlowQuery.Enabled
 	txn.SlowQueryThreshold = txn.Config.DatastoreTracer.SlowQuery.Threshold
+	// Distributions are sent with dimensional metrics, so they are not
+	// recorded when dimensional metrics are disabled.
+	if dd := txn.Config.DurationDistributions; dd.Enabled && txn.Config.DimensionalMetrics.Enabled && !txn.Config.ServerlessMode.Enabled {
+		txn.newDistribution = func() *ddSketch {
+			return newDDSketch(dd.RelativeAccuracy, dd.MaxBins)
+		}
+	}
 
 	// Synthetics support is tied up with a transaction's Old CAT field,
 	// CrossProcess. To support Synthetics with either BetterCAT or Old CAT,
@@ -181,6 +191,14 @@ func (txn *txn) shouldCollectSpanEvents() bool {
 	if !txn.Config.SpanEvents.Enabled {
 		return false
 	}
+	if nil != txn.tailSampler {
+		// Span events are collected for every transaction until the
+		// tail sampling decision is made when it ends.
+		if txn.tailSamplingDecided {
+			return len(txn.SpanEvents) > 0
+		}
+		return true
+	}
 	if shouldUseTraceObserver(txn.Config) {
 		return true
 	}
@@ -318,6 +336,7 @@ func (txn *txn) MergeIntoHarvest(h *harvest) {
 
 	createTxnMetrics(&txn.txnData, h.Metrics)
 	mergeBreakdownMetrics(&txn.txnData, h.Metrics)
+	mergeDurationDistributions(&txn.txnData, h.DimensionalMetrics)
 
 	// Dump log events into harvest
 	// Note: this will create a surge of log events that could affect sampling.
@@ -529,6 +548,8 @@ func (thd *thread) End(recovered interface{}) error {
 		root.AgentAttributes = txn.Attrs.filterSpanAttributes(root.AgentAttributes, destSpan)
 		txn.SpanEvents = append(txn.SpanEvents, root)
 
+		txn.applyTailSampling()
+
 		// Add transaction tracing fields to span events at the end of
 		// the transaction since we could accept payload after the early
 		// segments occur.
@@ -538,6 +559,7 @@ func (thd *thread) End(recovered interface{}) error {
 			evt.Sampled = txn.BetterCAT.Sampled
 			evt.Priority = txn.BetterCAT.Priority
 		}
+		txn.attachSpanLinks()
 	}
 
 	if !txn.ignore {
@@ -651,19 +673,6 @@ var (
 		attributeErrorLimit)
 )
 
-// errorCause returns the error's deepest wrapped ancestor.
-func errorCause(err error) error {
-	for {
-		if unwrapper, ok := err.(interface{ Unwrap() error }); ok {
-			if next := unwrapper.Unwrap(); nil != next {
-				err = next
-				continue
-			}
-		}
-		return err
-	}
-}
-
 func errorClassMethod(err error) string {
 	if ec, ok := err.(errorClasser); ok {
 		return ec.ErrorClass()
@@ -686,43 +695,54 @@ func errorAttributesMethod(err error) map[string]interface{} {
 }
 
 func errDataFromError(input error, expect bool) (data errorData, err error) {
+	layers := errorTree(input)
 	cause := errorCause(input)
 	validatedErrorMsg := truncateStringMessageIfLong(input.Error())
 	data = errorData{
 		When:   time.Now(),
 		Msg:    validatedErrorMsg,
 		Expect: expect,
+		Causes: errorCauses(layers),
 	}
 
-	if c := errorClassMethod(input); c != "" {
-		// If the error implements ErrorClasser, use that.
-		data.Klass = c
-	} else if c := errorClassMethod(cause); c != "" {
-		// Otherwise, if the error's cause implements ErrorClasser, use that.
-		data.Klass = c
-	} else {
-		// As a final fallback, use the type of the error's cause.
+	// Use the ErrorClass of the outermost error which implements
+	// ErrorClasser.  As a final fallback, use the type of the error's
+	// cause.  The errors combined by errors.Join are only recorded in
+	// the causes.
+	for _, l := range layers {
+		if c := errorClassMethod(l.err); c != "" {
+			data.Klass = c
+			break
+		}
+	}
+	if data.Klass == "" {
 		data.Klass = reflect.TypeOf(cause).String()
 	}
 
-	if st := errorStackTraceMethod(input); nil != st {
-		// If the error implements StackTracer, use that.
-		data.Stack = st
-	} else if st := errorStackTraceMethod(cause); nil != st {
-		// Otherwise, if the error's cause implements StackTracer, use that.
-		data.Stack = st
-	} else {
-		// As a final fallback, generate a StackTrace here.
+	// Use the StackTrace of the most deeply wrapped error which implements
+	// StackTracer, since it is closest to where the problem occurred.  As
+	// a final fallback, generate a StackTrace here.
+	depth := -1
+	for _, l := range layers {
+		if st := errorStackTraceMethod(l.err); nil != st && l.depth > depth {
+			data.Stack = st
+			depth = l.depth
+		}
+	}
+	if nil == data.Stack {
 		data.Stack = getStackTrace()
 	}
 
+	// Merge the ErrorAttributes of every error, with the attributes of
+	// outer errors replacing those of the errors they wrap.
 	var unvetted map[string]interface{}
-	if ats := errorAttributesMethod(input); nil != ats {
-		// If the error implements ErrorAttributer, use that.
-		unvetted = ats
-	} else {
-		// Otherwise, if the error's cause implements ErrorAttributer, use that.
-		unvetted = errorAttributesMethod(cause)
+	for i := len(layers) - 1; i >= 0; i-- {
+		for key, val := range errorAttributesMethod(layers[i].err) {
+			if nil == unvetted {
+				unvetted = make(map[string]interface{})
+			}
+			unvetted[key] = val
+		}
 	}
 	if unvetted != nil {
 		if len(unvetted) > attributeErrorLimit {
@@ -27,6 +27,10 @@ const (
 	maxTxnErrors      = 5
 	maxTxnSlowQueries = 10
 
+	// maxSpanLinks is the maximum number of span links captured per
+	// transaction.
+	maxSpanLinks = 100
+
 	startingTxnTraceNodes = 16
 	maxTxnTraceNodes      = 256
 
@@ -36,8 +40,12 @@ const (
 	maxSyntheticsTraces = 20
 	maxHarvestErrors    = 20
 	maxHarvestSlowSQLs  = 10
+	maxHarvestProfiles  = 20
 
 	errorEventMessageLengthLimit = 4096
+	// errorCauseLimit limits the number of wrapped errors visited when
+	// noticing an error.
+	errorCauseLimit = 20
 	// attributes
 	attributeKeyLengthLimit   = 255
 	attributeValueLengthLimit = 255
@@ -0,0 +1,107 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// cmdDimensionalMetrics names dimensional metric payloads in log
+	// messages and exported files.  The payloads are sent to the Metric API
+	// rather than to the collector.
+	cmdDimensionalMetrics = "dimensional_metric_data"
+
+	metricAPIEndpointDefault = "https://metric-api.newrelic.com/metric/v1"
+	metricAPIEndpointEU      = "https://metric-api.eu.newrelic.com/metric/v1"
+)
+
+// metricAPIEndpoint returns the URL which dimensional metrics are sent to.
+func (c config) metricAPIEndpoint() string {
+	if c.DimensionalMetrics.Endpoint != "" {
+		return c.DimensionalMetrics.Endpoint
+	}
+	m := preconnectRegionLicenseRegex.FindStringSubmatch(c.License)
+	if len(m) > 1 && strings.HasPrefix(m[1], "eu") {
+		return metricAPIEndpointEU
+	}
+	return metricAPIEndpointDefault
+}
+
+// metricAPIExporter sends dimensional metrics to the Metric API, which
+// accepts the payloads created by dimensionalMetrics.Data.
+type metricAPIExporter struct {
+	endpoint string
+	controls rpmControls
+}
+
+func newMetricAPIExporter(c config, controls rpmControls) *metricAPIExporter {
+	return &metricAPIExporter{
+		endpoint: c.metricAPIEndpoint(),
+		controls: controls,
+	}
+}
+
+// export sends the dimensional metrics to the Metric API.  The returned bool
+// indicates whether the metrics should be retained and merged into the next
+// harvest.
+func (e *metricAPIExporter) export(dm *dimensionalMetrics, harvestStart time.Time) (bool, error) {
+	data, err := dm.Data("", harvestStart)
+	if nil != err || nil == data {
+		return false, err
+	}
+	compressed, err := compress(data, e.controls.GzipWriterPool)
+	if nil != err {
+		return false, err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, compressed)
+	if nil != err {
+		return false, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Encoding", "gzip")
+	req.Header.Add("User-Agent", userAgentPrefix+Version)
+	req.Header.Add("Api-Key", e.controls.License)
+
+	resp, err := e.controls.Client.Do(req)
+	if nil != err {
+		return true, err
+	}
+	defer func() {
+		// Read the body to the end so that the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return false, nil
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, fmt.Errorf("Metric API returned status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("Metric API returned status %d", resp.StatusCode)
+	}
+}
+
+// doMetricAPIHarvest sends the dimensional metrics of a harvest to the
+// Metric API.
+func (app *app) doMetricAPIHarvest(dm *dimensionalMetrics, harvestStart time.Time, run *appRun) {
+	retain, err := app.metricAPI.export(dm, harvestStart)
+	if nil != err {
+		app.Warn("harvest failure", map[string]interface{}{
+			"cmd":         cmdDimensionalMetrics,
+			"error":       err.Error(),
+			"retain_data": retain && !app.shuttingDown(),
+		})
+	}
+	if retain && !app.shuttingDown() {
+		app.Consume(run.Reply.RunID, dm)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricAPIEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		license  string
+		endpoint string
+		want     string
+	}{
+		{license: "0123456789012345678901234567890123456789", want: metricAPIEndpointDefault},
+		{license: "eu01xx6789012345678901234567890123456789", want: metricAPIEndpointEU},
+		{license: "gov01x6789012345678901234567890123456789", want: metricAPIEndpointDefault},
+		{license: "eu01xx6789012345678901234567890123456789", endpoint: "http://localhost/metric/v1", want: "http://localhost/metric/v1"},
+	} {
+		c := config{Config: defaultConfig()}
+		c.License = tc.license
+		c.DimensionalMetrics.Endpoint = tc.endpoint
+		if got := c.metricAPIEndpoint(); got != tc.want {
+			t.Errorf("license=%s endpoint=%s got=%s want=%s", tc.license, tc.endpoint, got, tc.want)
+		}
+	}
+}
+
+func newTestMetricAPIExporter(endpoint string) *metricAPIExporter {
+	c := config{Config: defaultConfig()}
+	c.License = "0123456789012345678901234567890123456789"
+	c.DimensionalMetrics.Endpoint = endpoint
+	return newMetricAPIExporter(c, rpmControls{
+		License: c.License,
+		Client:  &http.Client{},
+		GzipWriterPool: &sync.Pool{
+			New: func() interface{} {
+				return gzip.NewWriter(io.Discard)
+			},
+		},
+	})
+}
+
+func TestMetricAPIExport(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	exp := newTestMetricAPIExporter(srv.URL + "/metric/v1")
+
+	start := time.Now()
+	dm := newDimensionalMetrics(10, nil, start)
+	recordDimensional(t, dm, dimensionalCount, "orders", 2, map[string]interface{}{"tenant": "acme"}, start)
+	sketch := newDDSketch(0.01, 2048)
+	sketch.add(0.5)
+	for i := 0; i < 9; i++ {
+		sketch.add(1)
+	}
+	dm.addDistribution("WebTransaction/Go/GET /users", sketch)
+
+	retain, err := exp.export(dm, start.Add(time.Minute))
+	if retain || nil != err {
+		t.Fatal(retain, err)
+	}
+	hdr := srv.headers["/metric/v1"]
+	if hdr.Get("Api-Key") != "0123456789012345678901234567890123456789" ||
+		hdr.Get("Content-Type") != "application/json" ||
+		hdr.Get("Content-Encoding") != "gzip" {
+		t.Error(hdr)
+	}
+
+	body := srv.body("/metric/v1")
+	expectMetricAPIPayload(t, body)
+	payload, metrics := decodeDimensionalMetrics(t, body)
+	if payload[0].Common.IntervalMS != 60000 {
+		t.Error(string(body))
+	}
+	if len(metrics) != 1+1+len(sketchQuantiles) {
+		t.Fatal(string(body))
+	}
+	if m := metrics[`count orders {"tenant":"acme"}`]; m.Value != 2.0 {
+		t.Error(m)
+	}
+	summary, _ := json.Marshal(metrics[`summary WebTransaction/Go/GET /users {}`].Value)
+	if string(summary) != `{"count":10,"max":1,"min":0.5,"sum":9.5}` {
+		t.Error(string(summary))
+	}
+	for _, q := range []string{"0.95", "0.99"} {
+		m := metrics[`gauge WebTransaction/Go/GET /users.quantile {"quantile":"`+q+`"}`]
+		if v, _ := m.Value.(float64); math.Abs(v-1) > 0.01 {
+			t.Error(q, m)
+		}
+	}
+
+	// Empty metrics are not sent.
+	if retain, err := exp.export(newDimensionalMetrics(10, nil, start), start); retain || nil != err {
+		t.Error(retain, err)
+	}
+	if srv.requests != 1 {
+		t.Error(srv.requests)
+	}
+}
+
+func TestMetricAPIExportFailure(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	exp := newTestMetricAPIExporter(srv.URL + "/metric/v1")
+	dm := newDimensionalMetrics(10, nil, time.Now())
+	recordDimensional(t, dm, dimensionalGauge, "queue.depth", 3, nil, time.Now())
+
+	for status, wantRetain := range map[int]bool{
+		http.StatusTooManyRequests:       true,
+		http.StatusServiceUnavailable:    true,
+		http.StatusForbidden:             false,
+		http.StatusRequestEntityTooLarge: false,
+	} {
+		srv.Lock()
+		srv.status = status
+		srv.Unlock()
+		retain, err := exp.export(dm, time.Now())
+		if retain != wantRetain || nil == err {
+			t.Error(status, retain, err)
+		}
+	}
+}
+
+func TestDurationDistributionsMetricAPI(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	exp := newTestMetricAPIExporter(srv.URL + "/metric/v1")
+
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DurationDistributions.Enabled = true
+		cfg.DimensionalMetrics.Enabled = true
+	}, t)
+	for i := 0; i < 3; i++ {
+		app.StartTransaction("job").End()
+	}
+	if retain, err := exp.export(app.app.testHarvest.DimensionalMetrics, time.Now()); retain || nil != err {
+		t.Fatal(retain, err)
+	}
+
+	body := srv.body("/metric/v1")
+	expectMetricAPIPayload(t, body)
+	_, metrics := decodeDimensionalMetrics(t, body)
+	summary, ok := metrics["summary OtherTransaction/Go/job {}"]
+	if v, _ := summary.Value.(map[string]interface{}); !ok || v["count"] != 3.0 {
+		t.Error(string(body))
+	}
+	for _, q := range []string{"0.95", "0.99"} {
+		m, ok := metrics[`gauge OtherTransaction/Go/job.quantile {"quantile":"`+q+`"}`]
+		if _, isNumber := m.Value.(float64); !ok || !isNumber {
+			t.Error(q, string(body))
+		}
+	}
+}
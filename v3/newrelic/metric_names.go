@@ -46,6 +46,9 @@ const (
 
 	supportabilityDropped = "Supportability/MetricsDropped"
 
+	dimensionalMetricsSeen    = "Supportability/DimensionalMetrics/Seen"
+	dimensionalMetricsDropped = "Supportability/DimensionalMetrics/Dropped"
+
 	// Runtime/System Metrics
 	memoryPhysical       = "Memory/Physical"
 	heapObjectsAllocated = "Memory/Heap/AllocatedObjects"
@@ -57,6 +60,15 @@ const (
 	gcPauseFraction      = "GC/System/Pause Fraction"
 	gcPauses             = "GC/System/Pauses"
 
+	// Runtime metrics gathered using the runtime/metrics package
+	runtimeSchedLatency = "Go/Runtime/Scheduler/Latency"
+	runtimeGCPauses     = "Go/Runtime/GC/Pauses"
+	runtimeMutexWait    = "Go/Runtime/Sync/Mutex/Wait"
+	runtimeGOMAXPROCS   = "Go/Runtime/GOMAXPROCS"
+	runtimeGOMEMLIMIT   = "Go/Runtime/GOMEMLIMIT"
+	runtimeCgoCalls     = "Go/Runtime/Cgo/Calls"
+	runtimeMemoryClass  = "Go/Runtime/Memory/Classes/"
+
 	// Configurable event harvest supportability metrics
 	supportReportPeriod     = "Supportability/EventHarvest/ReportPeriod"
 	supportTxnEventLimit    = "Supportability/EventHarvest/AnalyticEventData/HarvestLimit"
@@ -0,0 +1,309 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelictest
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Event is an event with its intrinsic, user, and agent attributes as they
+// are sent to New Relic.
+type Event struct {
+	Intrinsics      Attributes
+	UserAttributes  Attributes
+	AgentAttributes Attributes
+}
+
+// Transaction is a transaction which has ended.
+type Transaction struct {
+	Event
+	// Name is the full name of the transaction, such as
+	// "WebTransaction/Go/GET /users".
+	Name string
+	// GUID is the transaction ID which the spans of the transaction refer
+	// to.
+	GUID     string
+	TraceID  string
+	Duration time.Duration
+	// Error is true if the transaction noticed an error.
+	Error     bool
+	Timestamp time.Time
+	// Segments are the spans of the segments of the transaction, omitting
+	// the root span of the transaction.
+	Segments []Span
+}
+
+func newTransaction(e Event) Transaction {
+	return Transaction{
+		Event:     e,
+		Name:      e.Intrinsics.String("name"),
+		GUID:      e.Intrinsics.String("guid"),
+		TraceID:   e.Intrinsics.String("traceId"),
+		Duration:  e.Intrinsics.seconds("duration"),
+		Error:     e.Intrinsics["error"] == true,
+		Timestamp: e.Intrinsics.millis("timestamp"),
+	}
+}
+
+// FindSegment returns the first segment of the transaction with the given
+// name.
+func (txn Transaction) FindSegment(name string) (Span, bool) {
+	for _, s := range txn.Segments {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Span{}, false
+}
+
+// Span is a span event, which is recorded for each segment and for the root
+// of each transaction.
+type Span struct {
+	Event
+	Name string
+	// Category is "generic", "http", or "datastore".
+	Category      string
+	GUID          string
+	ParentID      string
+	TransactionID string
+	TraceID       string
+	Duration      time.Duration
+	Timestamp     time.Time
+	// EntryPoint is true for the root span of a transaction.
+	EntryPoint bool
+}
+
+func newSpan(e Event) Span {
+	return Span{
+		Event:         e,
+		Name:          e.Intrinsics.String("name"),
+		Category:      e.Intrinsics.String("category"),
+		GUID:          e.Intrinsics.String("guid"),
+		ParentID:      e.Intrinsics.String("parentId"),
+		TransactionID: e.Intrinsics.String("transactionId"),
+		TraceID:       e.Intrinsics.String("traceId"),
+		Duration:      e.Intrinsics.seconds("duration"),
+		Timestamp:     e.Intrinsics.millis("timestamp"),
+		EntryPoint:    e.Intrinsics["nr.entryPoint"] == true,
+	}
+}
+
+// Error is an error noticed by a transaction.
+type Error struct {
+	Event
+	// TxnName is the name of the transaction which noticed the error.
+	TxnName string
+	// TxnID is the GUID of the transaction which noticed the error.
+	TxnID   string
+	Message string
+	Class   string
+}
+
+// CustomEvent is an event recorded with RecordCustomEvent.
+type CustomEvent struct {
+	Type       string
+	Timestamp  time.Time
+	Attributes Attributes
+}
+
+func newCustomEvent(e Event) CustomEvent {
+	return CustomEvent{
+		Type:       e.Intrinsics.String("type"),
+		Timestamp:  e.Intrinsics.millis("timestamp"),
+		Attributes: e.UserAttributes,
+	}
+}
+
+// Log is a log event recorded with RecordLog.
+type Log struct {
+	Severity  string
+	Message   string
+	SpanID    string
+	TraceID   string
+	Timestamp time.Time
+	// Attributes are the attributes of the log event and the non-empty
+	// common attributes of all log events.
+	Attributes Attributes
+}
+
+// Metric is a metric.  For metrics recorded by segments and transactions,
+// Total and Exclusive are durations in seconds.
+type Metric struct {
+	Name string
+	// Scope is the name of the transaction which recorded the metric, or
+	// empty for unscoped metrics.
+	Scope      string
+	Count      float64
+	Total      float64
+	Exclusive  float64
+	Min        float64
+	Max        float64
+	SumSquares float64
+}
+
+func unmarshal(data []byte, v interface{}) bool {
+	if len(data) == 0 {
+		return false
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v) == nil
+}
+
+// decodeEvents decodes the payload of transaction, span, error, and custom
+// events: [runID, {reservoir}, [[intrinsics, user, agent], ...]].
+func decodeEvents(data []byte) []Event {
+	var payload []json.RawMessage
+	if !unmarshal(data, &payload) || len(payload) < 3 {
+		return nil
+	}
+	var raw [][]map[string]interface{}
+	if !unmarshal(payload[2], &raw) {
+		return nil
+	}
+	events := make([]Event, 0, len(raw))
+	for _, r := range raw {
+		var e Event
+		if len(r) > 0 {
+			e.Intrinsics = newAttributes(r[0])
+		}
+		if len(r) > 1 {
+			e.UserAttributes = newAttributes(r[1])
+		}
+		if len(r) > 2 {
+			e.AgentAttributes = newAttributes(r[2])
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func decodeSpans(data []byte) []Span {
+	events := decodeEvents(data)
+	spans := make([]Span, 0, len(events))
+	for _, e := range events {
+		spans = append(spans, newSpan(e))
+	}
+	return spans
+}
+
+// decodeErrors decodes the payload of traced errors: [runID, [[timestamp,
+// txnName, message, class, {attributes}, txnID], ...]].
+func decodeErrors(data []byte) []Error {
+	var payload []json.RawMessage
+	if !unmarshal(data, &payload) || len(payload) < 2 {
+		return nil
+	}
+	var raw [][]json.RawMessage
+	if !unmarshal(payload[1], &raw) {
+		return nil
+	}
+	errs := make([]Error, 0, len(raw))
+	for _, r := range raw {
+		if len(r) < 5 {
+			continue
+		}
+		var e Error
+		unmarshal(r[1], &e.TxnName)
+		unmarshal(r[2], &e.Message)
+		unmarshal(r[3], &e.Class)
+		var attrs struct {
+			AgentAttributes map[string]interface{} `json:"agentAttributes"`
+			UserAttributes  map[string]interface{} `json:"userAttributes"`
+			Intrinsics      map[string]interface{} `json:"intrinsics"`
+		}
+		unmarshal(r[4], &attrs)
+		e.Intrinsics = newAttributes(attrs.Intrinsics)
+		e.UserAttributes = newAttributes(attrs.UserAttributes)
+		e.AgentAttributes = newAttributes(attrs.AgentAttributes)
+		if len(r) > 5 {
+			unmarshal(r[5], &e.TxnID)
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// decodeLogs decodes the payload of log events: [{"common": {"attributes":
+// {...}}, "logs": [...]}].
+func decodeLogs(data []byte) []Log {
+	var payload []struct {
+		Common struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"common"`
+		Logs []struct {
+			Level      string                 `json:"level"`
+			Message    string                 `json:"message"`
+			SpanID     string                 `json:"span.id"`
+			TraceID    string                 `json:"trace.id"`
+			Timestamp  json.Number            `json:"timestamp"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"logs"`
+	}
+	if !unmarshal(data, &payload) {
+		return nil
+	}
+	var logs []Log
+	for _, p := range payload {
+		for _, l := range p.Logs {
+			attrs := newAttributes(l.Attributes)
+			for key, val := range newAttributes(p.Common.Attributes) {
+				if _, ok := attrs[key]; !ok && val != "" {
+					attrs[key] = val
+				}
+			}
+			ms, _ := l.Timestamp.Int64()
+			logs = append(logs, Log{
+				Severity:   l.Level,
+				Message:    l.Message,
+				SpanID:     l.SpanID,
+				TraceID:    l.TraceID,
+				Timestamp:  time.UnixMilli(ms),
+				Attributes: attrs,
+			})
+		}
+	}
+	return logs
+}
+
+// decodeMetrics decodes the payload of metrics: [runID, start, end,
+// [[{"name", "scope"}, [count, total, exclusive, min, max, sumSquares]],
+// ...]].
+func decodeMetrics(data []byte) []Metric {
+	var payload []json.RawMessage
+	if !unmarshal(data, &payload) || len(payload) < 4 {
+		return nil
+	}
+	var raw [][]json.RawMessage
+	if !unmarshal(payload[3], &raw) {
+		return nil
+	}
+	metrics := make([]Metric, 0, len(raw))
+	for _, r := range raw {
+		if len(r) < 2 {
+			continue
+		}
+		var id struct {
+			Name  string `json:"name"`
+			Scope string `json:"scope"`
+		}
+		var values [6]float64
+		if !unmarshal(r[0], &id) || !unmarshal(r[1], &values) {
+			continue
+		}
+		metrics = append(metrics, Metric{
+			Name:       id.Name,
+			Scope:      id.Scope,
+			Count:      values[0],
+			Total:      values[1],
+			Exclusive:  values[2],
+			Min:        values[3],
+			Max:        values[4],
+			SumSquares: values[5],
+		})
+	}
+	return metrics
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type anything struct{}
+
+func (anything) String() string   { return "<any>" }
+func (anything) GoString() string { return "<any>" }
+
+// Any matches any value of an attribute which is present.
+var Any interface{} = anything{}
+
+// Attributes are the attributes of an event.  Integers are int64, other
+// numbers are float64, and strings and booleans keep their types.
+type Attributes map[string]interface{}
+
+func newAttributes(m map[string]interface{}) Attributes {
+	attrs := make(Attributes, len(m))
+	for key, val := range m {
+		if n, ok := val.(json.Number); ok {
+			if i, err := n.Int64(); nil == err {
+				val = i
+			} else if f, err := n.Float64(); nil == err {
+				val = f
+			}
+		}
+		attrs[key] = val
+	}
+	return attrs
+}
+
+// Has returns true if the attribute is present.
+func (a Attributes) Has(key string) bool {
+	_, ok := a[key]
+	return ok
+}
+
+// String returns the value of a string attribute, or "" if the attribute
+// is missing or not a string.
+func (a Attributes) String(key string) string {
+	s, _ := a[key].(string)
+	return s
+}
+
+func (a Attributes) float(key string) float64 {
+	f, _ := toFloat(a[key])
+	return f
+}
+
+func (a Attributes) seconds(key string) time.Duration {
+	return time.Duration(a.float(key) * float64(time.Second))
+}
+
+func (a Attributes) millis(key string) time.Time {
+	return time.UnixMilli(int64(a.float(key)))
+}
+
+// Match returns an error describing each attribute in want which is missing
+// from a or has a different value, or nil if every attribute matches.
+// Numbers of any type match if they are equal, and Any matches any value.
+// Attributes which are not in want are ignored.
+func (a Attributes) Match(want map[string]interface{}) error {
+	var mismatches []string
+	for key, expect := range want {
+		actual, ok := a[key]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing, want %#v", key, expect))
+			continue
+		}
+		if !valuesMatch(expect, actual) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: got %#v, want %#v", key, actual, expect))
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	sort.Strings(mismatches)
+	return fmt.Errorf("attributes do not match: %s", strings.Join(mismatches, "; "))
+}
+
+func valuesMatch(expect, actual interface{}) bool {
+	if expect == Any {
+		return true
+	}
+	if e, ok := toFloat(expect); ok {
+		a, ok := toFloat(actual)
+		return ok && a == e
+	}
+	return reflect.DeepEqual(expect, actual)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package newrelictest records the telemetry of an in-memory
+// newrelic.Application so that tests can assert on it.
+//
+// The application created by NewApp never connects to New Relic.
+// Transactions, segments, errors, custom events, logs, and metrics are
+// recorded as they would be harvested, and are returned as plain structs:
+//
+//	app, err := newrelictest.NewApp()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	handler(app.Application).ServeHTTP(httptest.NewRecorder(), req)
+//
+//	txn, ok := app.FindTransaction("WebTransaction/Go/GET /users")
+//	if !ok {
+//		t.Fatal("transaction not recorded", app.Transactions())
+//	}
+//	if err := txn.AgentAttributes.Match(map[string]interface{}{
+//		"http.statusCode": 200,
+//		"request.uri":     newrelictest.Any,
+//	}); err != nil {
+//		t.Error(err)
+//	}
+//
+// Distributed tracing is enabled and every transaction is sampled, so each
+// segment is recorded as a span event.
+package newrelictest
+
+import (
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const (
+	// AppName is the name of applications created by NewApp.
+	AppName = "newrelictest"
+	// EntityGUID is the entity GUID of applications created by NewApp.
+	EntityGUID = "newrelictest-entity-guid"
+	// AccountID is the account ID and trusted account key of
+	// applications created by NewApp.
+	AccountID = "1"
+	// AppID is the application ID of applications created by NewApp.
+	AppID = "2"
+
+	testLicenseKey = "0123456789012345678901234567890123456789"
+)
+
+// App is an in-memory newrelic.Application which records its telemetry
+// instead of sending it to New Relic.
+type App struct {
+	*newrelic.Application
+}
+
+// NewApp creates an App.  Distributed tracing and log forwarding are
+// enabled, every transaction is sampled, and the options are applied after
+// these defaults.  Config.Enabled is always set to false so that the
+// application does not connect.
+func NewApp(options ...newrelic.ConfigOption) (*App, error) {
+	opts := []newrelic.ConfigOption{
+		newrelic.ConfigAppName(AppName),
+		newrelic.ConfigLicense(testLicenseKey),
+		newrelic.ConfigDistributedTracerEnabled(true),
+		newrelic.ConfigAppLogForwardingEnabled(true),
+	}
+	opts = append(opts, options...)
+	opts = append(opts, newrelic.ConfigEnabled(false))
+
+	app, err := newrelic.NewApplication(opts...)
+	if nil != err {
+		return nil, err
+	}
+	internal.HarvestTesting(app.Private, connectReply)
+	return &App{Application: app}, nil
+}
+
+func connectReply(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.EntityGUID = EntityGUID
+	reply.AccountID = AccountID
+	reply.TrustedAccountKey = AccountID
+	reply.PrimaryAppID = AppID
+}
+
+// Reset discards the telemetry recorded so far.  It must not be called while
+// transactions are running.
+func (app *App) Reset() {
+	internal.HarvestTesting(app.Private, connectReply)
+}
+
+func (app *App) payloads() map[string][]byte {
+	return internal.HarvestTestPayloads(app.Private)
+}
+
+// Transactions returns the transactions which have ended, with the segments
+// recorded by each.
+func (app *App) Transactions() []Transaction {
+	payloads := app.payloads()
+	events := decodeEvents(payloads["analytic_event_data"])
+	spans := decodeSpans(payloads["span_event_data"])
+	txns := make([]Transaction, 0, len(events))
+	for _, e := range events {
+		txn := newTransaction(e)
+		for _, s := range spans {
+			if s.TransactionID == txn.GUID && !s.EntryPoint {
+				txn.Segments = append(txn.Segments, s)
+			}
+		}
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+// FindTransaction returns the first transaction with the given name, such as
+// "WebTransaction/Go/GET /users" or "OtherTransaction/Go/job".
+func (app *App) FindTransaction(name string) (Transaction, bool) {
+	for _, txn := range app.Transactions() {
+		if txn.Name == name {
+			return txn, true
+		}
+	}
+	return Transaction{}, false
+}
+
+// Spans returns the span events of the transactions which have ended,
+// including the root span of each transaction.
+func (app *App) Spans() []Span {
+	return decodeSpans(app.payloads()["span_event_data"])
+}
+
+// Segments returns the spans of the segments of the transactions which have
+// ended, omitting the root span of each transaction.
+func (app *App) Segments() []Span {
+	var segments []Span
+	for _, s := range app.Spans() {
+		if !s.EntryPoint {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// FindSegment returns the first segment with the given name, such as
+// "Datastore/statement/Postgres/users/select" or "External/example.com/http/GET".
+func (app *App) FindSegment(name string) (Span, bool) {
+	for _, s := range app.Segments() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Span{}, false
+}
+
+// Errors returns the errors noticed by transactions which have ended.
+func (app *App) Errors() []Error {
+	return decodeErrors(app.payloads()["error_data"])
+}
+
+// ErrorEvents returns the error events of transactions which have ended.
+func (app *App) ErrorEvents() []Event {
+	return decodeEvents(app.payloads()["error_event_data"])
+}
+
+// CustomEvents returns the events recorded with RecordCustomEvent.
+func (app *App) CustomEvents() []CustomEvent {
+	events := decodeEvents(app.payloads()["custom_event_data"])
+	custom := make([]CustomEvent, 0, len(events))
+	for _, e := range events {
+		custom = append(custom, newCustomEvent(e))
+	}
+	return custom
+}
+
+// Logs returns the log events recorded with RecordLog, including those
+// recorded by transactions which have ended.
+func (app *App) Logs() []Log {
+	return decodeLogs(app.payloads()["log_event_data"])
+}
+
+// Metrics returns the metrics recorded so far.
+func (app *App) Metrics() []Metric {
+	return decodeMetrics(app.payloads()["metric_data"])
+}
+
+// FindMetric returns the metric with the given name and scope.  Unscoped
+// metrics have an empty scope.  The scope of a metric recorded by a segment
+// is the name of its transaction.
+func (app *App) FindMetric(name, scope string) (Metric, bool) {
+	for _, m := range app.Metrics() {
+		if m.Name == name && m.Scope == scope {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
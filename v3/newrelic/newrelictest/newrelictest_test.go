@@ -0,0 +1,222 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelictest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func newApp(t *testing.T, options ...newrelic.ConfigOption) *App {
+	t.Helper()
+	app, err := NewApp(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestTransactions(t *testing.T) {
+	app := newApp(t)
+	_, handler := newrelic.WrapHandleFunc(app.Application, "/users", func(w http.ResponseWriter, r *http.Request) {
+		txn := newrelic.FromContext(r.Context())
+		txn.AddAttribute("user", "alice")
+		txn.StartSegment("lookup").End()
+		s := newrelic.DatastoreSegment{
+			StartTime:  txn.StartSegmentNow(),
+			Product:    newrelic.DatastorePostgres,
+			Collection: "users",
+			Operation:  "select",
+		}
+		s.End()
+		w.WriteHeader(http.StatusCreated)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	txns := app.Transactions()
+	if len(txns) != 1 {
+		t.Fatal(txns)
+	}
+	txn, ok := app.FindTransaction("WebTransaction/Go/GET /users")
+	if !ok {
+		t.Fatal(txns)
+	}
+	if txn.GUID == "" || txn.TraceID == "" || txn.Error || txn.Duration <= 0 {
+		t.Error(txn)
+	}
+	if err := txn.UserAttributes.Match(map[string]interface{}{"user": "alice"}); err != nil {
+		t.Error(err)
+	}
+	if err := txn.AgentAttributes.Match(map[string]interface{}{
+		"http.statusCode": 201,
+		"request.method":  "GET",
+		"request.uri":     Any,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if len(txn.Segments) != 2 {
+		t.Fatal(txn.Segments)
+	}
+	lookup, ok := txn.FindSegment("Custom/lookup")
+	if !ok || lookup.Category != "generic" || lookup.TransactionID != txn.GUID || lookup.EntryPoint {
+		t.Error(lookup, ok)
+	}
+	db, ok := app.FindSegment("Datastore/statement/Postgres/users/select")
+	if !ok || db.Category != "datastore" || db.ParentID == "" {
+		t.Error(db, ok)
+	}
+	if err := db.AgentAttributes.Match(map[string]interface{}{"db.collection": "users"}); err != nil {
+		t.Error(err)
+	}
+	if spans := app.Spans(); len(spans) != 3 {
+		t.Error(spans)
+	}
+
+	m, ok := app.FindMetric("Datastore/statement/Postgres/users/select", "WebTransaction/Go/GET /users")
+	if !ok || m.Count != 1 {
+		t.Error(m, ok)
+	}
+	if _, ok := app.FindMetric("WebTransaction", ""); !ok {
+		t.Error(app.Metrics())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	app := newApp(t)
+	txn := app.StartTransaction("job")
+	txn.NoticeError(newrelic.Error{
+		Message:    "job failed",
+		Class:      "JobError",
+		Attributes: map[string]interface{}{"attempt": 3},
+	})
+	txn.End()
+
+	errs := app.Errors()
+	if len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	e := errs[0]
+	if e.TxnName != "OtherTransaction/Go/job" || e.Message != "job failed" || e.Class != "JobError" || e.TxnID == "" {
+		t.Error(e)
+	}
+	if err := e.UserAttributes.Match(map[string]interface{}{"attempt": 3}); err != nil {
+		t.Error(err)
+	}
+	if events := app.ErrorEvents(); len(events) != 1 || events[0].Intrinsics.String("error.class") != "JobError" {
+		t.Error(events)
+	}
+	if txn, ok := app.FindTransaction("OtherTransaction/Go/job"); !ok || !txn.Error {
+		t.Error(txn, ok)
+	}
+}
+
+func TestCustomEvents(t *testing.T) {
+	app := newApp(t)
+	app.RecordCustomEvent("Signup", map[string]interface{}{"plan": "pro", "seats": 5})
+	events := app.CustomEvents()
+	if len(events) != 1 || events[0].Type != "Signup" || events[0].Timestamp.IsZero() {
+		t.Fatal(events)
+	}
+	if err := events[0].Attributes.Match(map[string]interface{}{"plan": "pro", "seats": 5}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	app := newApp(t)
+	app.RecordLog(newrelic.LogData{Severity: "INFO", Message: "started"})
+	txn := app.StartTransaction("job")
+	txn.RecordLog(newrelic.LogData{Severity: "ERROR", Message: "failed"})
+	txn.End()
+
+	logs := app.Logs()
+	if len(logs) != 2 {
+		t.Fatal(logs)
+	}
+	byMessage := map[string]Log{}
+	for _, l := range logs {
+		byMessage[l.Message] = l
+	}
+	if l := byMessage["started"]; l.Severity != "INFO" || l.SpanID != "" || l.TraceID != "" {
+		t.Error(l)
+	}
+	if l := byMessage["failed"]; l.Severity != "ERROR" || l.SpanID == "" || l.TraceID == "" {
+		t.Error(l)
+	}
+}
+
+func TestReset(t *testing.T) {
+	app := newApp(t)
+	app.StartTransaction("job").End()
+	app.Reset()
+	if txns := app.Transactions(); len(txns) != 0 {
+		t.Error(txns)
+	}
+	if metrics := app.Metrics(); len(metrics) != 0 {
+		t.Error(metrics)
+	}
+	app.StartTransaction("job").End()
+	if txns := app.Transactions(); len(txns) != 1 {
+		t.Error(txns)
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	app := newApp(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.StartTransaction("job").End()
+		}()
+	}
+	wg.Wait()
+	if m, ok := app.FindMetric("OtherTransaction/Go/job", ""); !ok || m.Count != 10 {
+		t.Error(m, ok)
+	}
+}
+
+func TestNewAppOptions(t *testing.T) {
+	app := newApp(t, newrelic.ConfigDistributedTracerEnabled(false), newrelic.ConfigEnabled(true))
+	app.StartTransaction("job").End()
+	if spans := app.Spans(); len(spans) != 0 {
+		t.Error(spans)
+	}
+	if txns := app.Transactions(); len(txns) != 1 {
+		t.Error(txns)
+	}
+
+	errInvalid := errors.New("invalid config")
+	if _, err := NewApp(func(cfg *newrelic.Config) { cfg.Error = errInvalid }); err != errInvalid {
+		t.Error(err)
+	}
+}
+
+func TestAttributesMatch(t *testing.T) {
+	attrs := Attributes{"a": "x", "n": int64(3), "f": 1.5, "b": true}
+	if err := attrs.Match(map[string]interface{}{"a": "x", "n": 3, "f": float32(1.5), "b": Any}); err != nil {
+		t.Error(err)
+	}
+	err := attrs.Match(map[string]interface{}{"a": "y", "missing": Any, "n": "3"})
+	if err == nil {
+		t.Fatal("expected mismatch")
+	}
+	want := `attributes do not match: a: got "x", want "y"; missing: missing, want <any>; n: got 3, want "3"`
+	if err.Error() != want {
+		t.Error(err)
+	}
+	if !attrs.Has("b") || attrs.Has("c") || attrs.String("n") != "" {
+		t.Error(attrs)
+	}
+	if err := attrs.Match(nil); err != nil {
+		t.Error(err)
+	}
+}
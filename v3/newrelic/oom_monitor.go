@@ -14,6 +14,10 @@ type heapHighWaterMarkAlarmSet struct {
 	sampleTicker *time.Ticker // once made, only read by monitor goroutine
 	alarms       map[uint64]func(uint64, *runtime.MemStats)
 	done         chan byte
+	// profiler, if set, is called with the largest limit reached each time
+	// that alarms fire so that profiles may be captured.  It is set when
+	// the app is created and never modified.
+	profiler func(uint64, *runtime.MemStats)
 }
 
 // This is a gross, high-level whole-heap memory monitor which can be used to monitor, track,
@@ -58,15 +62,24 @@ func (as *heapHighWaterMarkAlarmSet) monitor() {
 		case <-as.sampleTicker.C:
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
+			var highest uint64
+			var fired bool
 			as.lock.RLock()
-			defer as.lock.RUnlock()
 			if as.alarms != nil {
 				for limit, callback := range as.alarms {
 					if m.HeapAlloc >= limit {
 						callback(limit, &m)
+						if !fired || limit > highest {
+							highest = limit
+						}
+						fired = true
 					}
 				}
 			}
+			as.lock.RUnlock()
+			if fired && as.profiler != nil {
+				as.profiler(highest, &m)
+			}
 		case <-as.done:
 			return
 		}
@@ -119,6 +132,10 @@ func (a *Application) HeapHighWaterMarkAlarmDisable() {
 // If HeapHighWaterMarkAlarmSet is called with the same memory limit as a previous call, the
 // supplied callback function will replace the one previously registered for that limit. If
 // the function is given as nil, then that memory limit alarm is removed from the list.
+//
+// If Config.Profiling is enabled, heap, goroutine, and mutex profiles are also captured
+// and written to the FileExport destination when an alarm fires, at most once per
+// Config.Profiling.Period.
 func (a *Application) HeapHighWaterMarkAlarmSet(limit uint64, f func(uint64, *runtime.MemStats)) {
 	if a == nil || a.app == nil {
 		return
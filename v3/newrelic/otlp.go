@@ -0,0 +1,547 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// These constants are the values accepted for Config.OTLP.Protocol.
+const (
+	// OTLPProtocolProtobuf sends OTLP/HTTP requests encoded as binary
+	// protocol buffers.
+	OTLPProtocolProtobuf = "http/protobuf"
+	// OTLPProtocolJSON sends OTLP/HTTP requests encoded as JSON.
+	OTLPProtocolJSON = "http/json"
+)
+
+const (
+	otlpTracesPath  = "/v1/traces"
+	otlpMetricsPath = "/v1/metrics"
+	otlpLogsPath    = "/v1/logs"
+
+	otlpScopeName = "github.com/newrelic/go-agent/v3/newrelic"
+
+	// otlpRunID is the run ID of the synthetic connect reply used when
+	// sending data to an OTLP endpoint.
+	otlpRunID = "otlp"
+)
+
+// otlpSignal identifies the OTLP data type of a request.
+type otlpSignal int
+
+const (
+	otlpSignalTraces otlpSignal = iota
+	otlpSignalMetrics
+	otlpSignalLogs
+)
+
+// Values of the OTLP Span.SpanKind enum.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+)
+
+// otlpStatusCodeError is the value of the OTLP Status.StatusCode enum
+// indicating an error.
+const otlpStatusCodeError = 2
+
+// otlpAttribute is an OTLP KeyValue.  The value is a string, bool, int64, or
+// float64.
+type otlpAttribute struct {
+	key   string
+	value interface{}
+}
+
+type otlpSpan struct {
+	traceID       []byte
+	spanID        []byte
+	parentSpanID  []byte
+	name          string
+	kind          int
+	start         time.Time
+	end           time.Time
+	attributes    []otlpAttribute
+	statusError   bool
+	statusMessage string
+	links         []otlpLink
+}
+
+// otlpLink is an OTLP span link created from a SpanLink event.
+type otlpLink struct {
+	traceID []byte
+	spanID  []byte
+}
+
+// otlpDataPoint is an OTLP SummaryDataPoint or NumberDataPoint.
+type otlpDataPoint struct {
+	attributes []otlpAttribute
+	start      time.Time
+	end        time.Time
+	// count, sum, min, and max are used by summaries.
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+	// value is used by gauges.
+	value float64
+}
+
+type otlpMetric struct {
+	name   string
+	gauge  bool
+	points []otlpDataPoint
+}
+
+type otlpLogRecord struct {
+	time           time.Time
+	severityText   string
+	severityNumber int
+	body           string
+	traceID        []byte
+	spanID         []byte
+	attributes     []otlpAttribute
+}
+
+// otlpRequest contains the data of a single OTLP export request.  Only the
+// slice matching the signal is used.
+type otlpRequest struct {
+	signal   otlpSignal
+	resource []otlpAttribute
+	spans    []otlpSpan
+	metrics  []otlpMetric
+	logs     []otlpLogRecord
+}
+
+func (r *otlpRequest) empty() bool {
+	return len(r.spans) == 0 && len(r.metrics) == 0 && len(r.logs) == 0
+}
+
+func (r *otlpRequest) path() string {
+	switch r.signal {
+	case otlpSignalMetrics:
+		return otlpMetricsPath
+	case otlpSignalLogs:
+		return otlpLogsPath
+	default:
+		return otlpTracesPath
+	}
+}
+
+// otlpID decodes a New Relic hex ID into an OTLP ID of the given length,
+// left-padding with zeros.  It returns nil if the ID is not valid hex.
+func otlpID(id string, length int) []byte {
+	if id == "" {
+		return nil
+	}
+	if pad := 2*length - len(id); pad > 0 {
+		id = strings.Repeat("0", pad) + id
+	}
+	b, err := hex.DecodeString(id)
+	if nil != err || len(b) != length {
+		return nil
+	}
+	return b
+}
+
+// otlpAttributeValue converts a span attribute value into a value accepted by
+// otlpAttribute.
+func otlpAttributeValue(w jsonWriter) interface{} {
+	switch v := w.(type) {
+	case stringJSONWriter:
+		return string(v)
+	case intJSONWriter:
+		return int64(v)
+	case floatJSONWriter:
+		return float64(v)
+	case boolJSONWriter:
+		return bool(v)
+	default:
+		buf := &bytes.Buffer{}
+		w.WriteJSON(buf)
+		return buf.String()
+	}
+}
+
+// otlpUserAttributeValue converts a log attribute value into a value
+// accepted by otlpAttribute.
+func otlpUserAttributeValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return v
+	case bool:
+		return v
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// appendSpanAttributes adds the attributes in m to attrs sorted by key so
+// that requests are deterministic.
+func appendSpanAttributes(attrs []otlpAttribute, m spanAttributeMap) []otlpAttribute {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpAttribute{key: k, value: otlpAttributeValue(m[k])})
+	}
+	return attrs
+}
+
+func otlpSpanKind(e *spanEvent) int {
+	kind := e.Kind
+	if kind == "" {
+		if w, ok := e.AgentAttributes[AttributeSpanKind].(stringJSONWriter); ok {
+			kind = string(w)
+		}
+	}
+	switch kind {
+	case "client":
+		return otlpSpanKindClient
+	case "server":
+		return otlpSpanKindServer
+	case "producer":
+		return otlpSpanKindProducer
+	case "consumer":
+		return otlpSpanKindConsumer
+	}
+	if e.IsEntrypoint {
+		if _, ok := e.AgentAttributes[AttributeRequestMethod]; ok {
+			return otlpSpanKindServer
+		}
+	}
+	return otlpSpanKindInternal
+}
+
+// otlpSpanFromEvent converts a span event into an OTLP span.  Transaction
+// information which has no OTLP equivalent is kept as span attributes using
+// the names of the New Relic span event fields.
+func otlpSpanFromEvent(e *spanEvent) otlpSpan {
+	s := otlpSpan{
+		traceID:      otlpID(e.TraceID, 16),
+		spanID:       otlpID(e.GUID, 8),
+		parentSpanID: otlpID(e.ParentID, 8),
+		name:         e.Name,
+		kind:         otlpSpanKind(e),
+		start:        e.Timestamp,
+		end:          e.Timestamp.Add(e.Duration),
+	}
+	s.attributes = append(s.attributes,
+		otlpAttribute{key: "transactionId", value: e.TransactionID},
+		otlpAttribute{key: "category", value: string(e.Category)},
+		otlpAttribute{key: "sampled", value: e.Sampled},
+		otlpAttribute{key: "priority", value: float64(e.Priority)},
+	)
+	if e.TxnName != "" {
+		s.attributes = append(s.attributes, otlpAttribute{key: "transaction.name", value: e.TxnName})
+	}
+	if e.IsEntrypoint {
+		s.attributes = append(s.attributes, otlpAttribute{key: "nr.entryPoint", value: true})
+	}
+	if e.Component != "" {
+		s.attributes = append(s.attributes, otlpAttribute{key: "component", value: e.Component})
+	}
+	if e.TrustedParentID != "" {
+		s.attributes = append(s.attributes, otlpAttribute{key: "trustedParentId", value: e.TrustedParentID})
+	}
+	if e.TracingVendors != "" {
+		s.attributes = append(s.attributes, otlpAttribute{key: "tracingVendors", value: e.TracingVendors})
+	}
+	s.attributes = appendSpanAttributes(s.attributes, e.AgentAttributes)
+	s.attributes = appendSpanAttributes(s.attributes, e.UserAttributes)
+
+	if _, ok := e.AgentAttributes[SpanAttributeErrorClass]; ok {
+		s.statusError = true
+		if msg, ok := e.AgentAttributes[SpanAttributeErrorMessage].(stringJSONWriter); ok {
+			s.statusMessage = string(msg)
+		}
+	}
+	for _, l := range e.Links {
+		s.links = append(s.links, otlpLink{
+			traceID: otlpID(l.LinkedTraceID, 16),
+			spanID:  otlpID(l.LinkedSpanID, 8),
+		})
+	}
+	return s
+}
+
+func otlpTracesRequest(events *spanEvents) *otlpRequest {
+	r := &otlpRequest{signal: otlpSignalTraces}
+	for _, evt := range events.events {
+		if e, ok := evt.jsonWriter.(*spanEvent); ok {
+			r.spans = append(r.spans, otlpSpanFromEvent(e))
+		}
+	}
+	return r
+}
+
+// otlpMetricsRequest converts the metric table into OTLP metrics.  Apdex
+// metrics become gauges with one data point per apdex zone, and all other
+// metrics become summaries whose 0 and 1 quantiles are the minimum and
+// maximum values.
+func otlpMetricsRequest(mt *metricTable, harvestStart time.Time) *otlpRequest {
+	r := &otlpRequest{signal: otlpSignalMetrics}
+	ids := make([]metricID, 0, len(mt.metrics))
+	for id := range mt.metrics {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Name != ids[j].Name {
+			return ids[i].Name < ids[j].Name
+		}
+		return ids[i].Scope < ids[j].Scope
+	})
+	for _, id := range ids {
+		data := mt.metrics[id].data
+		var attrs []otlpAttribute
+		if id.Scope != "" {
+			attrs = append(attrs, otlpAttribute{key: "scope", value: id.Scope})
+		}
+		m := otlpMetric{name: id.Name}
+		if strings.HasPrefix(id.Name, apdexRollup) {
+			m.gauge = true
+			for _, zone := range []struct {
+				name  string
+				value float64
+			}{
+				{name: "satisfied", value: data.countSatisfied},
+				{name: "tolerating", value: data.totalTolerated},
+				{name: "frustrating", value: data.exclusiveFailed},
+			} {
+				m.points = append(m.points, otlpDataPoint{
+					attributes: append(append([]otlpAttribute{}, attrs...), otlpAttribute{key: "apdex.zone", value: zone.name}),
+					start:      mt.metricPeriodStart,
+					end:        harvestStart,
+					value:      zone.value,
+				})
+			}
+		} else {
+			m.points = append(m.points, otlpDataPoint{
+				attributes: attrs,
+				start:      mt.metricPeriodStart,
+				end:        harvestStart,
+				count:      uint64(data.countSatisfied),
+				sum:        data.totalTolerated,
+				min:        data.min,
+				max:        data.max,
+			})
+		}
+		r.metrics = append(r.metrics, m)
+	}
+	return r
+}
+
+// otlpSeverityNumber returns the OTLP SeverityNumber for a log level name.
+func otlpSeverityNumber(severity string) int {
+	s := strings.ToUpper(severity)
+	switch {
+	case strings.HasPrefix(s, "TRACE"):
+		return 1
+	case strings.HasPrefix(s, "DEBUG"):
+		return 5
+	case strings.HasPrefix(s, "INFO"):
+		return 9
+	case strings.HasPrefix(s, "WARN"):
+		return 13
+	case strings.HasPrefix(s, "ERR"):
+		return 17
+	case strings.HasPrefix(s, "FATAL"), strings.HasPrefix(s, "PANIC"), strings.HasPrefix(s, "CRIT"):
+		return 21
+	default:
+		return 0
+	}
+}
+
+func otlpLogsRequest(events *logEvents) *otlpRequest {
+	r := &otlpRequest{signal: otlpSignalLogs}
+	for _, e := range events.logs {
+		rec := otlpLogRecord{
+			time:           time.UnixMilli(e.timestamp),
+			severityText:   e.severity,
+			severityNumber: otlpSeverityNumber(e.severity),
+			body:           e.message,
+			traceID:        otlpID(e.traceID, 16),
+			spanID:         otlpID(e.spanID, 8),
+		}
+		keys := make([]string, 0, len(e.attributes))
+		for k := range e.attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rec.attributes = append(rec.attributes, otlpAttribute{key: k, value: otlpUserAttributeValue(e.attributes[k])})
+		}
+		r.logs = append(r.logs, rec)
+	}
+	return r
+}
+
+// otlpExporter sends harvest data to an OTLP/HTTP endpoint.
+type otlpExporter struct {
+	endpoint string
+	protocol string
+	headers  map[string]string
+	resource []otlpAttribute
+	controls rpmControls
+}
+
+func newOTLPExporter(c config, controls rpmControls) *otlpExporter {
+	appName := strings.TrimSpace(strings.SplitN(c.AppName, ";", 2)[0])
+	resource := []otlpAttribute{
+		{key: "service.name", value: appName},
+		{key: "host.name", value: c.hostname},
+		{key: "telemetry.sdk.name", value: "newrelic-go-agent"},
+		{key: "telemetry.sdk.language", value: "go"},
+		{key: "telemetry.sdk.version", value: Version},
+	}
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resource = append(resource, otlpAttribute{key: k, value: c.Labels[k]})
+	}
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(c.OTLP.Endpoint, "/"),
+		protocol: c.OTLP.Protocol,
+		headers:  c.OTLP.Headers,
+		resource: resource,
+		controls: controls,
+	}
+}
+
+// newOTLPConnectReply creates the connect reply used in place of connecting
+// to New Relic when sending data to an OTLP endpoint.
+func newOTLPConnectReply() *internal.ConnectReply {
+	reply := internal.ConnectReplyDefaults()
+	reply.RunID = otlpRunID
+	return reply
+}
+
+// request converts a payload into an OTLP request.  It returns nil for data
+// types which have no OTLP equivalent.
+func (e *otlpExporter) request(p payloadCreator, harvestStart time.Time) *otlpRequest {
+	var r *otlpRequest
+	switch v := p.(type) {
+	case *spanEvents:
+		r = otlpTracesRequest(v)
+	case *metricTable:
+		r = otlpMetricsRequest(v, harvestStart)
+	case *logEvents:
+		r = otlpLogsRequest(v)
+	default:
+		return nil
+	}
+	r.resource = e.resource
+	return r
+}
+
+// export sends the payload to the OTLP endpoint.  The returned bool
+// indicates whether the payload should be retained and merged into the next
+// harvest.
+func (e *otlpExporter) export(p payloadCreator, harvestStart time.Time) (bool, error) {
+	r := e.request(p, harvestStart)
+	if nil == r || r.empty() {
+		return false, nil
+	}
+
+	var data []byte
+	contentType := "application/x-protobuf"
+	if e.protocol == OTLPProtocolJSON {
+		data = r.marshalJSON()
+		contentType = "application/json"
+	} else {
+		data = r.marshalProto()
+	}
+	compressed, err := compress(data, e.controls.GzipWriterPool)
+	if nil != err {
+		return false, err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint+r.path(), compressed)
+	if nil != err {
+		return false, err
+	}
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Content-Encoding", "gzip")
+	req.Header.Add("User-Agent", userAgentPrefix+Version)
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.controls.Client.Do(req)
+	if nil != err {
+		return true, err
+	}
+	defer func() {
+		// Read the body to the end so that the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return false, nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, fmt.Errorf("OTLP endpoint returned status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("OTLP endpoint returned status %d", resp.StatusCode)
+	}
+}
+
+// doOTLPHarvest sends the payloads of a harvest to the OTLP endpoint.
+func (app *app) doOTLPHarvest(payloads []payloadCreator, harvestStart time.Time, run *appRun) {
+	for _, p := range payloads {
+		retain, err := app.otlp.export(p, harvestStart)
+		if nil != err {
+			app.Warn("OTLP export failure", map[string]interface{}{
+				"cmd":         p.EndpointMethod(),
+				"error":       err.Error(),
+				"retain_data": retain,
+			})
+		}
+		if retain {
+			app.Consume(run.Reply.RunID, p)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/jsonx"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// This file encodes OTLP requests without depending on the generated
+// OpenTelemetry protocol packages.  Field numbers and JSON names follow
+// the opentelemetry-proto v1 definitions.
+
+func otlpUnixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// Protocol buffer encoding.
+
+func protoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func protoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func protoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func protoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func protoFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func protoDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// protoAnyValue encodes an AnyValue message.
+func protoAnyValue(value interface{}) []byte {
+	var b []byte
+	switch v := value.(type) {
+	case bool:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case int64:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case float64:
+		b = protoDouble(b, 4, v)
+	case string:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+func protoAttributes(b []byte, num protowire.Number, attrs []otlpAttribute) []byte {
+	for _, a := range attrs {
+		var kv []byte
+		kv = protoString(kv, 1, a.key)
+		kv = protoMessage(kv, 2, protoAnyValue(a.value))
+		b = protoMessage(b, num, kv)
+	}
+	return b
+}
+
+func protoScope() []byte {
+	var b []byte
+	b = protoString(b, 1, otlpScopeName)
+	b = protoString(b, 2, Version)
+	return b
+}
+
+func protoSpan(s *otlpSpan) []byte {
+	var b []byte
+	b = protoBytes(b, 1, s.traceID)
+	b = protoBytes(b, 2, s.spanID)
+	b = protoBytes(b, 4, s.parentSpanID)
+	b = protoString(b, 5, s.name)
+	b = protoVarint(b, 6, uint64(s.kind))
+	b = protoFixed64(b, 7, otlpUnixNano(s.start))
+	b = protoFixed64(b, 8, otlpUnixNano(s.end))
+	b = protoAttributes(b, 9, s.attributes)
+	for _, l := range s.links {
+		var link []byte
+		link = protoBytes(link, 1, l.traceID)
+		link = protoBytes(link, 2, l.spanID)
+		b = protoMessage(b, 13, link)
+	}
+	if s.statusError {
+		var status []byte
+		status = protoString(status, 2, s.statusMessage)
+		status = protoVarint(status, 3, otlpStatusCodeError)
+		b = protoMessage(b, 15, status)
+	}
+	return b
+}
+
+func protoDataPoint(p *otlpDataPoint, gauge bool) []byte {
+	var b []byte
+	b = protoFixed64(b, 2, otlpUnixNano(p.start))
+	b = protoFixed64(b, 3, otlpUnixNano(p.end))
+	if gauge {
+		b = protoDouble(b, 4, p.value)
+	} else {
+		b = protoFixed64(b, 4, p.count)
+		b = protoDouble(b, 5, p.sum)
+		for _, q := range []struct{ quantile, value float64 }{{0, p.min}, {1, p.max}} {
+			var qv []byte
+			qv = protoDouble(qv, 1, q.quantile)
+			qv = protoDouble(qv, 2, q.value)
+			b = protoMessage(b, 6, qv)
+		}
+	}
+	return protoAttributes(b, 7, p.attributes)
+}
+
+func protoMetric(m *otlpMetric) []byte {
+	var b []byte
+	b = protoString(b, 1, m.name)
+	var data []byte
+	for i := range m.points {
+		data = protoMessage(data, 1, protoDataPoint(&m.points[i], m.gauge))
+	}
+	if m.gauge {
+		b = protoMessage(b, 5, data)
+	} else {
+		b = protoMessage(b, 11, data)
+	}
+	return b
+}
+
+func protoLogRecord(l *otlpLogRecord) []byte {
+	var b []byte
+	b = protoFixed64(b, 1, otlpUnixNano(l.time))
+	b = protoVarint(b, 2, uint64(l.severityNumber))
+	b = protoString(b, 3, l.severityText)
+	b = protoMessage(b, 5, protoAnyValue(l.body))
+	b = protoAttributes(b, 6, l.attributes)
+	b = protoBytes(b, 9, l.traceID)
+	b = protoBytes(b, 10, l.spanID)
+	return b
+}
+
+// marshalProto encodes the request as an ExportTraceServiceRequest,
+// ExportMetricsServiceRequest, or ExportLogsServiceRequest.
+func (r *otlpRequest) marshalProto() []byte {
+	// The scope and resource messages of the three signals share field
+	// numbers, as do the repeated data fields.
+	var resource []byte
+	resource = protoAttributes(resource, 1, r.resource)
+
+	var scoped []byte
+	scoped = protoMessage(scoped, 1, protoScope())
+	switch r.signal {
+	case otlpSignalTraces:
+		for i := range r.spans {
+			scoped = protoMessage(scoped, 2, protoSpan(&r.spans[i]))
+		}
+	case otlpSignalMetrics:
+		for i := range r.metrics {
+			scoped = protoMessage(scoped, 2, protoMetric(&r.metrics[i]))
+		}
+	case otlpSignalLogs:
+		for i := range r.logs {
+			scoped = protoMessage(scoped, 2, protoLogRecord(&r.logs[i]))
+		}
+	}
+
+	var rs []byte
+	rs = protoMessage(rs, 1, resource)
+	rs = protoMessage(rs, 2, scoped)
+	return protoMessage(nil, 1, rs)
+}
+
+// JSON encoding.  The OTLP JSON mapping encodes IDs as hex strings and 64 bit
+// integers as decimal strings.
+
+type otlpAttributesJSON []otlpAttribute
+
+func writeAnyValueJSON(buf *bytes.Buffer, value interface{}) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	switch v := value.(type) {
+	case bool:
+		w.boolField("boolValue", v)
+	case int64:
+		w.stringField("intValue", strconv.FormatInt(v, 10))
+	case float64:
+		w.floatField("doubleValue", v)
+	case string:
+		w.stringField("stringValue", v)
+	}
+	buf.WriteByte('}')
+}
+
+func (attrs otlpAttributesJSON) WriteJSON(buf *bytes.Buffer) {
+	buf.WriteByte('[')
+	for i, a := range attrs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"key":`)
+		jsonx.AppendString(buf, a.key)
+		buf.WriteString(`,"value":`)
+		writeAnyValueJSON(buf, a.value)
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+}
+
+func (w *jsonFieldsWriter) otlpTimeField(key string, t time.Time) {
+	w.stringField(key, strconv.FormatUint(otlpUnixNano(t), 10))
+}
+
+func (w *jsonFieldsWriter) otlpIDField(key string, id []byte) {
+	if len(id) > 0 {
+		w.stringField(key, hex.EncodeToString(id))
+	}
+}
+
+func (s *otlpSpan) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.otlpIDField("traceId", s.traceID)
+	w.otlpIDField("spanId", s.spanID)
+	w.otlpIDField("parentSpanId", s.parentSpanID)
+	w.stringField("name", s.name)
+	w.intField("kind", int64(s.kind))
+	w.otlpTimeField("startTimeUnixNano", s.start)
+	w.otlpTimeField("endTimeUnixNano", s.end)
+	w.writerField("attributes", otlpAttributesJSON(s.attributes))
+	if len(s.links) > 0 {
+		w.addKey("links")
+		buf.WriteByte('[')
+		for i, l := range s.links {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			lw := jsonFieldsWriter{buf: buf}
+			buf.WriteByte('{')
+			lw.otlpIDField("traceId", l.traceID)
+			lw.otlpIDField("spanId", l.spanID)
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+	}
+	if s.statusError {
+		w.addKey("status")
+		sw := jsonFieldsWriter{buf: buf}
+		buf.WriteByte('{')
+		if s.statusMessage != "" {
+			sw.stringField("message", s.statusMessage)
+		}
+		sw.intField("code", otlpStatusCodeError)
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('}')
+}
+
+func writeDataPointJSON(buf *bytes.Buffer, p *otlpDataPoint, gauge bool) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.writerField("attributes", otlpAttributesJSON(p.attributes))
+	w.otlpTimeField("startTimeUnixNano", p.start)
+	w.otlpTimeField("timeUnixNano", p.end)
+	if gauge {
+		w.floatField("asDouble", p.value)
+	} else {
+		w.stringField("count", strconv.FormatUint(p.count, 10))
+		w.floatField("sum", p.sum)
+		w.addKey("quantileValues")
+		buf.WriteString(`[{"quantile":0,"value":`)
+		jsonx.AppendFloat(buf, p.min)
+		buf.WriteString(`},{"quantile":1,"value":`)
+		jsonx.AppendFloat(buf, p.max)
+		buf.WriteString(`}]`)
+	}
+	buf.WriteByte('}')
+}
+
+func (m *otlpMetric) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.stringField("name", m.name)
+	if m.gauge {
+		w.addKey("gauge")
+	} else {
+		w.addKey("summary")
+	}
+	buf.WriteString(`{"dataPoints":[`)
+	for i := range m.points {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeDataPointJSON(buf, &m.points[i], m.gauge)
+	}
+	buf.WriteString(`]}`)
+	buf.WriteByte('}')
+}
+
+func (l *otlpLogRecord) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.otlpTimeField("timeUnixNano", l.time)
+	w.intField("severityNumber", int64(l.severityNumber))
+	w.stringField("severityText", l.severityText)
+	w.addKey("body")
+	writeAnyValueJSON(buf, l.body)
+	w.writerField("attributes", otlpAttributesJSON(l.attributes))
+	w.otlpIDField("traceId", l.traceID)
+	w.otlpIDField("spanId", l.spanID)
+	buf.WriteByte('}')
+}
+
+// marshalJSON encodes the request using the OTLP JSON mapping.
+func (r *otlpRequest) marshalJSON() []byte {
+	var resourceKey, scopeKey, dataKey string
+	var data []jsonWriter
+	switch r.signal {
+	case otlpSignalTraces:
+		resourceKey, scopeKey, dataKey = "resourceSpans", "scopeSpans", "spans"
+		for i := range r.spans {
+			data = append(data, &r.spans[i])
+		}
+	case otlpSignalMetrics:
+		resourceKey, scopeKey, dataKey = "resourceMetrics", "scopeMetrics", "metrics"
+		for i := range r.metrics {
+			data = append(data, &r.metrics[i])
+		}
+	case otlpSignalLogs:
+		resourceKey, scopeKey, dataKey = "resourceLogs", "scopeLogs", "logRecords"
+		for i := range r.logs {
+			data = append(data, &r.logs[i])
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{`)
+	jsonx.AppendString(buf, resourceKey)
+	buf.WriteString(`:[{"resource":{"attributes":`)
+	otlpAttributesJSON(r.resource).WriteJSON(buf)
+	buf.WriteString(`},`)
+	jsonx.AppendString(buf, scopeKey)
+	buf.WriteString(`:[{"scope":{"name":`)
+	jsonx.AppendString(buf, otlpScopeName)
+	buf.WriteString(`,"version":`)
+	jsonx.AppendString(buf, Version)
+	buf.WriteString(`},`)
+	jsonx.AppendString(buf, dataKey)
+	buf.WriteString(`:[`)
+	for i, d := range data {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		d.WriteJSON(buf)
+	}
+	buf.WriteString(`]}]}]}`)
+	return buf.Bytes()
+}
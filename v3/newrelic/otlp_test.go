@@ -0,0 +1,404 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpTestServer is a stand-in for an OTLP/HTTP receiver which records the
+// decompressed request bodies by path.
+type otlpTestServer struct {
+	*httptest.Server
+	sync.Mutex
+	status   int
+	bodies   map[string][][]byte
+	headers  map[string]http.Header
+	requests int
+}
+
+func newOTLPTestServer(t *testing.T) *otlpTestServer {
+	s := &otlpTestServer{
+		status:  http.StatusOK,
+		bodies:  map[string][][]byte{},
+		headers: map[string]http.Header{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s.Lock()
+		defer s.Unlock()
+		s.requests++
+		s.bodies[r.URL.Path] = append(s.bodies[r.URL.Path], body)
+		s.headers[r.URL.Path] = r.Header
+		w.WriteHeader(s.status)
+	}))
+	return s
+}
+
+func (s *otlpTestServer) body(path string) []byte {
+	s.Lock()
+	defer s.Unlock()
+	if b := s.bodies[path]; len(b) > 0 {
+		return b[len(b)-1]
+	}
+	return nil
+}
+
+// protoField returns the first length delimited field with the given
+// number.
+func protoField(t *testing.T, b []byte, num protowire.Number) []byte {
+	t.Helper()
+	for len(b) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			t.Fatal(protowire.ParseError(tagLen))
+		}
+		b = b[tagLen:]
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatal(protowire.ParseError(l))
+			}
+			return v
+		}
+		l := protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+	}
+	t.Fatalf("field %d not found", num)
+	return nil
+}
+
+func TestOTLPID(t *testing.T) {
+	if id := otlpID("", 8); id != nil {
+		t.Error(id)
+	}
+	if id := otlpID("not hex", 8); id != nil {
+		t.Error(id)
+	}
+	if id := otlpID("1234567890abcdef1234567890abcdef0", 16); id != nil {
+		t.Error(id)
+	}
+	id := otlpID("abc", 8)
+	if len(id) != 8 || id[6] != 0x0a || id[7] != 0xbc {
+		t.Error(id)
+	}
+}
+
+func TestOTLPSpanFromEvent(t *testing.T) {
+	e := sampleSpanEvent
+	e.TraceID = "0af7651916cd43dd8448eb211c80319c"
+	e.GUID = "b7ad6b7169203331"
+	e.TxnName = "WebTransaction/Go/hello"
+	e.AgentAttributes.addString(AttributeRequestMethod, "GET")
+	e.AgentAttributes.addString(SpanAttributeErrorClass, "*errors.errorString")
+	e.AgentAttributes.addString(SpanAttributeErrorMessage, "oops")
+	e.UserAttributes = spanAttributeMap{"zip": intJSONWriter(1)}
+
+	s := otlpSpanFromEvent(&e)
+	if s.kind != otlpSpanKindServer {
+		t.Error(s.kind)
+	}
+	if !s.end.Equal(s.start.Add(2 * time.Second)) {
+		t.Error(s.start, s.end)
+	}
+	if len(s.traceID) != 16 || len(s.spanID) != 8 || s.parentSpanID != nil {
+		t.Error(s.traceID, s.spanID, s.parentSpanID)
+	}
+	if !s.statusError || s.statusMessage != "oops" {
+		t.Error(s.statusError, s.statusMessage)
+	}
+	attrs := map[string]interface{}{}
+	for _, a := range s.attributes {
+		attrs[a.key] = a.value
+	}
+	for k, v := range map[string]interface{}{
+		"transactionId":    "txn-id",
+		"transaction.name": "WebTransaction/Go/hello",
+		"nr.entryPoint":    true,
+		"category":         "generic",
+		"sampled":          true,
+		"priority":         0.5,
+		"request.method":   "GET",
+		"zip":              int64(1),
+	} {
+		if attrs[k] != v {
+			t.Errorf("attribute %s: got %#v want %#v", k, attrs[k], v)
+		}
+	}
+}
+
+func TestOTLPTracesJSON(t *testing.T) {
+	e := sampleSpanEvent
+	e.TraceID = "0af7651916cd43dd8448eb211c80319c"
+	e.GUID = "b7ad6b7169203331"
+	e.ParentID = "00f067aa0ba902b7"
+	e.IsEntrypoint = false
+	e.Kind = "client"
+	events := newSpanEvents(10)
+	events.addEventPopulated(&e)
+
+	r := otlpTracesRequest(events)
+	r.resource = []otlpAttribute{{key: "service.name", value: "my app"}}
+	expect := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"my app"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/newrelic/go-agent/v3/newrelic","version":"` + Version + `"},"spans":[{` +
+		`"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331","parentSpanId":"00f067aa0ba902b7",` +
+		`"name":"myName","kind":3,"startTimeUnixNano":"1488393111000000000","endTimeUnixNano":"1488393113000000000",` +
+		`"attributes":[{"key":"transactionId","value":{"stringValue":"txn-id"}},{"key":"category","value":{"stringValue":"generic"}},` +
+		`{"key":"sampled","value":{"boolValue":true}},{"key":"priority","value":{"doubleValue":0.5}}]}]}]}]}`
+	if js := string(r.marshalJSON()); js != expect {
+		t.Errorf("\n got: %s\nwant: %s", js, expect)
+	}
+	if !json.Valid(r.marshalJSON()) {
+		t.Error("invalid JSON")
+	}
+}
+
+func TestOTLPTracesProto(t *testing.T) {
+	e := sampleSpanEvent
+	e.TraceID = "0af7651916cd43dd8448eb211c80319c"
+	e.GUID = "b7ad6b7169203331"
+	events := newSpanEvents(10)
+	events.addEventPopulated(&e)
+
+	r := otlpTracesRequest(events)
+	r.resource = []otlpAttribute{{key: "service.name", value: "my app"}}
+	b := r.marshalProto()
+
+	rs := protoField(t, b, 1)
+	resource := protoField(t, rs, 1)
+	kv := protoField(t, resource, 1)
+	if key := string(protoField(t, kv, 1)); key != "service.name" {
+		t.Error(key)
+	}
+	if val := string(protoField(t, protoField(t, kv, 2), 1)); val != "my app" {
+		t.Error(val)
+	}
+	scopeSpans := protoField(t, rs, 2)
+	if name := string(protoField(t, protoField(t, scopeSpans, 1), 1)); name != otlpScopeName {
+		t.Error(name)
+	}
+	span := protoField(t, scopeSpans, 2)
+	if name := string(protoField(t, span, 5)); name != "myName" {
+		t.Error(name)
+	}
+	if id := protoField(t, span, 2); len(id) != 8 {
+		t.Error(id)
+	}
+}
+
+func TestOTLPMetricsRequest(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	mt := newMetricTable(100, start)
+	mt.addDuration("WebTransaction", "", 2*time.Second, time.Second, forced)
+	mt.addDuration("WebTransaction", "WebTransaction/Go/hello", 3*time.Second, 3*time.Second, forced)
+	mt.addApdex(apdexRollup, "", time.Second, apdexTolerating, forced)
+
+	r := otlpMetricsRequest(mt, end)
+	if len(r.metrics) != 3 {
+		t.Fatal(len(r.metrics))
+	}
+	apdex := r.metrics[0]
+	if apdex.name != apdexRollup || !apdex.gauge || len(apdex.points) != 3 {
+		t.Fatal(apdex)
+	}
+	if p := apdex.points[1]; p.value != 1 || p.attributes[0].value != "tolerating" {
+		t.Error(p)
+	}
+	summary := r.metrics[1]
+	if summary.gauge || len(summary.points) != 1 || summary.points[0].attributes != nil {
+		t.Fatal(summary)
+	}
+	if p := summary.points[0]; p.count != 1 || p.sum != 2 || p.min != 2 || p.max != 2 || !p.start.Equal(start) || !p.end.Equal(end) {
+		t.Error(p)
+	}
+	if p := r.metrics[2].points[0]; p.attributes[0].key != "scope" || p.attributes[0].value != "WebTransaction/Go/hello" {
+		t.Error(p.attributes)
+	}
+
+	expect := `{"name":"WebTransaction","summary":{"dataPoints":[{"attributes":[],` +
+		`"startTimeUnixNano":"1417136460000000000","timeUnixNano":"1417136520000000000",` +
+		`"count":"1","sum":2,"quantileValues":[{"quantile":0,"value":2},{"quantile":1,"value":2}]}]}}`
+	if js := string(r.marshalJSON()); !strings.Contains(js, expect) {
+		t.Errorf("\n got: %s\nwant: %s", js, expect)
+	}
+}
+
+func TestOTLPLogsRequest(t *testing.T) {
+	events := newLogEvents(testCommonAttributes, loggingConfigEnabled(10))
+	events.Add(&logEvent{
+		timestamp:  1417136460000,
+		severity:   "WARNING",
+		message:    "hello",
+		traceID:    "0af7651916cd43dd8448eb211c80319c",
+		spanID:     "b7ad6b7169203331",
+		attributes: map[string]any{"count": 3},
+	})
+	r := otlpLogsRequest(events)
+	expect := `"logRecords":[{"timeUnixNano":"1417136460000000000","severityNumber":13,"severityText":"WARNING",` +
+		`"body":{"stringValue":"hello"},"attributes":[{"key":"count","value":{"intValue":"3"}}],` +
+		`"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331"}]`
+	if js := string(r.marshalJSON()); !strings.Contains(js, expect) {
+		t.Errorf("\n got: %s\nwant: %s", js, expect)
+	}
+}
+
+func TestOTLPSeverityNumber(t *testing.T) {
+	for severity, number := range map[string]int{
+		"trace":   1,
+		"DEBUG":   5,
+		"info":    9,
+		"Warning": 13,
+		"ERROR":   17,
+		"panic":   21,
+		"unknown": 0,
+	} {
+		if n := otlpSeverityNumber(severity); n != number {
+			t.Errorf("%s: got %d want %d", severity, n, number)
+		}
+	}
+}
+
+func testOTLPExporter(endpoint string, protocol string) *otlpExporter {
+	cfg := config{Config: defaultConfig()}
+	cfg.AppName = "my app;rollup"
+	cfg.OTLP.Endpoint = endpoint + "/"
+	cfg.OTLP.Protocol = protocol
+	cfg.OTLP.Headers = map[string]string{"Api-Key": "secret"}
+	return newOTLPExporter(cfg, rpmControls{
+		Client: &http.Client{},
+		GzipWriterPool: &sync.Pool{
+			New: func() interface{} {
+				return gzip.NewWriter(io.Discard)
+			},
+		},
+	})
+}
+
+func TestOTLPExport(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	exp := testOTLPExporter(srv.URL, OTLPProtocolJSON)
+	if name := exp.resource[0]; name.value != "my app" {
+		t.Error(name)
+	}
+
+	events := newSpanEvents(10)
+	e := sampleSpanEvent
+	events.addEventPopulated(&e)
+	retain, err := exp.export(events, time.Now())
+	if retain || err != nil {
+		t.Fatal(retain, err)
+	}
+	h := srv.headers[otlpTracesPath]
+	if ct := h.Get("Content-Type"); ct != "application/json" {
+		t.Error(ct)
+	}
+	if key := h.Get("Api-Key"); key != "secret" {
+		t.Error(key)
+	}
+	if !json.Valid(srv.body(otlpTracesPath)) {
+		t.Error(string(srv.body(otlpTracesPath)))
+	}
+
+	// Data types without an OTLP equivalent and empty payloads are not
+	// sent.
+	retain, err = exp.export(newTxnEvents(10), time.Now())
+	if retain || err != nil {
+		t.Error(retain, err)
+	}
+	retain, err = exp.export(newSpanEvents(10), time.Now())
+	if retain || err != nil {
+		t.Error(retain, err)
+	}
+	if srv.requests != 1 {
+		t.Error(srv.requests)
+	}
+
+	srv.status = http.StatusServiceUnavailable
+	retain, err = exp.export(events, time.Now())
+	if !retain || err == nil {
+		t.Error(retain, err)
+	}
+	srv.status = http.StatusBadRequest
+	retain, err = exp.export(events, time.Now())
+	if retain || err == nil {
+		t.Error(retain, err)
+	}
+}
+
+func TestOTLPExportProtobuf(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	exp := testOTLPExporter(srv.URL, OTLPProtocolProtobuf)
+
+	mt := newMetricTable(100, time.Now())
+	mt.addCount("Custom/count", 1, forced)
+	if retain, err := exp.export(mt, time.Now()); retain || err != nil {
+		t.Fatal(retain, err)
+	}
+	if ct := srv.headers[otlpMetricsPath].Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Error(ct)
+	}
+	rm := protoField(t, srv.body(otlpMetricsPath), 1)
+	metric := protoField(t, protoField(t, rm, 2), 2)
+	if name := string(protoField(t, metric, 1)); name != "Custom/count" {
+		t.Error(name)
+	}
+}
+
+func TestOTLPApplication(t *testing.T) {
+	srv := newOTLPTestServer(t)
+	defer srv.Close()
+	app, err := NewApplication(
+		ConfigAppName("my app"),
+		ConfigOTLPEndpoint(srv.URL, OTLPProtocolJSON),
+		ConfigCodeLevelMetricsEnabled(false),
+		func(cfg *Config) {
+			cfg.RuntimeSampler.Enabled = false
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.WaitForConnection(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("segment").End()
+	txn.End()
+	app.Shutdown(10 * time.Second)
+
+	traces := string(srv.body(otlpTracesPath))
+	for _, s := range []string{`"name":"OtherTransaction/Go/hello"`, `"name":"Custom/segment"`, `"key":"transactionId"`, `"key":"service.name","value":{"stringValue":"my app"}`} {
+		if !strings.Contains(traces, s) {
+			t.Errorf("traces missing %s: %s", s, traces)
+		}
+	}
+	if metrics := string(srv.body(otlpMetricsPath)); !strings.Contains(metrics, `"name":"OtherTransaction/Go/hello"`) {
+		t.Error(metrics)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/jsonx"
+)
+
+// ProfileType is a bit-encoded value.  Each such value describes a kind of
+// runtime/pprof profile which is captured when Config.Profiling is enabled.
+type ProfileType uint32
+
+// These constants specify the kinds of profiles which may be captured.
+//
+//	ProfileCPU        CPU profile recorded for Config.Profiling.CPUDuration
+//	ProfileHeap       heap allocation profile
+//	ProfileGoroutine  stack traces of all current goroutines
+//	ProfileMutex      stack traces of holders of contended mutexes
+//	ProfileAll        all of the above (the default)
+//
+// The numeric values of these constants are not to be relied upon.  Only use
+// the named constant identifiers in your code.
+const (
+	ProfileCPU ProfileType = 1 << iota
+	ProfileHeap
+	ProfileGoroutine
+	ProfileMutex
+
+	ProfileAll = ProfileCPU | ProfileHeap | ProfileGoroutine | ProfileMutex
+)
+
+// String returns the name of the profile type as used in the profile payload.
+func (tp ProfileType) String() string {
+	switch tp {
+	case ProfileCPU:
+		return "cpu"
+	case ProfileHeap:
+		return "heap"
+	case ProfileGoroutine:
+		return "goroutine"
+	case ProfileMutex:
+		return "mutex"
+	default:
+		return ""
+	}
+}
+
+const (
+	defaultProfilingPeriod      = 60 * time.Second
+	defaultProfilingCPUDuration = 10 * time.Second
+
+	// profileTriggerPeriodic and profileTriggerHeapAlarm indicate why a
+	// profile was captured.
+	profileTriggerPeriodic  = "periodic"
+	profileTriggerHeapAlarm = "heap_alarm"
+
+	// profilingMutexFraction is the rate of mutex contention events
+	// reported when the mutex profile is enabled by the agent.
+	profilingMutexFraction = 5
+
+	// profilesExportCommand names the profiles payload in file export
+	// output.  The collector has no method for profiles, so they are only
+	// written locally.
+	profilesExportCommand = "pprof_data"
+)
+
+// profile is a single captured runtime/pprof profile.
+type profile struct {
+	Type       ProfileType
+	Trigger    string
+	Start      time.Time
+	Duration   time.Duration
+	EntityGUID string
+	RunID      string
+	// AlarmLimit and HeapAlloc are populated for profiles captured when a
+	// heap high water mark alarm fires.
+	AlarmLimit uint64
+	HeapAlloc  uint64
+	// Data is the gzip-compressed protocol buffer written by runtime/pprof.
+	Data []byte
+}
+
+// WriteJSON prepares JSON in the format expected by the collector.
+func (p *profile) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	w.stringField("type", p.Type.String())
+	w.stringField("trigger", p.Trigger)
+	w.intField("timestamp", timeToIntMillis(p.Start))
+	w.intField("duration", p.Duration.Milliseconds())
+	w.stringField("entity.guid", p.EntityGUID)
+	w.stringField("agent_run_id", p.RunID)
+	if p.Trigger == profileTriggerHeapAlarm {
+		w.intField("heap_alarm.limit", int64(p.AlarmLimit))
+		w.intField("heap_alarm.heap_alloc", int64(p.HeapAlloc))
+	}
+	w.stringField("format", "pprof")
+	w.stringField("data", base64.StdEncoding.EncodeToString(p.Data))
+	buf.WriteByte('}')
+}
+
+// MergeIntoHarvest implements harvestable.
+func (p *profile) MergeIntoHarvest(h *harvest) {
+	h.Profiles.add(p)
+}
+
+// profiles is the harvest data type containing captured profiles.
+type profiles []*profile
+
+func newProfiles(max int) profiles {
+	return make([]*profile, 0, max)
+}
+
+func (ps *profiles) add(p *profile) {
+	if len(*ps) < cap(*ps) {
+		*ps = append(*ps, p)
+	}
+}
+
+// Data implements payloadCreator.
+func (ps profiles) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	estimate := 0
+	for _, p := range ps {
+		estimate += 256 + base64.StdEncoding.EncodedLen(len(p.Data))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, estimate))
+	buf.WriteByte('[')
+	jsonx.AppendString(buf, agentRunID)
+	buf.WriteByte(',')
+	buf.WriteByte('[')
+	for i, p := range ps {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		p.WriteJSON(buf)
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// MergeIntoHarvest implements payloadCreator.  Profiles which could not be
+// written are carried forward to the next harvest up to the harvest limit.
+func (ps profiles) MergeIntoHarvest(h *harvest) {
+	for _, p := range ps {
+		h.Profiles.add(p)
+	}
+}
+
+// EndpointMethod implements payloadCreator.
+func (ps profiles) EndpointMethod() string {
+	return profilesExportCommand
+}
+
+// profileAlarm records the heap high water mark alarm which triggered a
+// capture.
+type profileAlarm struct {
+	limit     uint64
+	heapAlloc uint64
+}
+
+// profiler periodically captures profiles and sends them to the app.
+type profiler struct {
+	app         *app
+	period      time.Duration
+	cpuDuration time.Duration
+	types       ProfileType
+	alarms      chan profileAlarm
+	lastAlarm   time.Time
+}
+
+func newProfiler(app *app) *profiler {
+	cfg := app.config.Profiling
+	p := &profiler{
+		app:         app,
+		period:      cfg.Period,
+		cpuDuration: cfg.CPUDuration,
+		types:       cfg.Types & ProfileAll,
+		alarms:      make(chan profileAlarm, 1),
+	}
+	if p.period <= 0 {
+		p.period = defaultProfilingPeriod
+	}
+	if p.cpuDuration <= 0 {
+		p.cpuDuration = defaultProfilingCPUDuration
+	}
+	if p.cpuDuration > p.period/2 {
+		p.cpuDuration = p.period / 2
+	}
+	return p
+}
+
+// heapAlarm requests a capture of the non-CPU profiles.  It is called by
+// the heap monitor when a high water mark alarm fires and must not block.
+func (p *profiler) heapAlarm(limit uint64, m *runtime.MemStats) {
+	select {
+	case p.alarms <- profileAlarm{limit: limit, heapAlloc: m.HeapAlloc}:
+	default:
+	}
+}
+
+func (p *profiler) run() {
+	if 0 != p.types&ProfileMutex && runtime.SetMutexProfileFraction(-1) == 0 {
+		runtime.SetMutexProfileFraction(profilingMutexFraction)
+		defer runtime.SetMutexProfileFraction(0)
+	}
+	t := time.NewTicker(p.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.capture(profileTriggerPeriodic, p.types, nil)
+		case alarm := <-p.alarms:
+			// Limit alarm captures to one per period since an alarm
+			// fires on every heap sample while memory remains high.
+			if now := time.Now(); now.Sub(p.lastAlarm) >= p.period {
+				p.lastAlarm = now
+				p.capture(profileTriggerHeapAlarm, p.types&^ProfileCPU, &alarm)
+			}
+		case <-p.app.shutdownStarted:
+			return
+		}
+	}
+}
+
+// capture records each of the requested profiles and sends them to the app.
+func (p *profiler) capture(trigger string, types ProfileType, alarm *profileAlarm) {
+	for _, tp := range []ProfileType{ProfileHeap, ProfileGoroutine, ProfileMutex} {
+		if 0 == types&tp {
+			continue
+		}
+		start := time.Now()
+		buf := &bytes.Buffer{}
+		if err := pprof.Lookup(tp.String()).WriteTo(buf, 0); nil != err {
+			p.app.Debug("unable to capture profile", map[string]interface{}{
+				"type":  tp.String(),
+				"error": err.Error(),
+			})
+			continue
+		}
+		p.consume(&profile{
+			Type:    tp,
+			Trigger: trigger,
+			Start:   start,
+			Data:    buf.Bytes(),
+		}, alarm)
+	}
+	if 0 != types&ProfileCPU {
+		if pr := p.captureCPU(); nil != pr {
+			pr.Trigger = trigger
+			p.consume(pr, alarm)
+		}
+	}
+}
+
+// captureCPU records a CPU profile for the configured duration.  It returns
+// nil if a CPU profile is already being recorded by the application or if
+// the app shuts down while recording.
+func (p *profiler) captureCPU() *profile {
+	start := time.Now()
+	buf := &bytes.Buffer{}
+	if err := pprof.StartCPUProfile(buf); nil != err {
+		p.app.Debug("unable to capture profile", map[string]interface{}{
+			"type":  ProfileCPU.String(),
+			"error": err.Error(),
+		})
+		return nil
+	}
+	timer := time.NewTimer(p.cpuDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		pprof.StopCPUProfile()
+	case <-p.app.shutdownStarted:
+		pprof.StopCPUProfile()
+		return nil
+	}
+	return &profile{
+		Type:     ProfileCPU,
+		Start:    start,
+		Duration: time.Since(start),
+		Data:     buf.Bytes(),
+	}
+}
+
+func (p *profiler) consume(pr *profile, alarm *profileAlarm) {
+	run, _ := p.app.getState()
+	pr.EntityGUID = run.Reply.EntityGUID
+	pr.RunID = run.Reply.RunID.String()
+	if nil != alarm {
+		pr.AlarmLimit = alarm.limit
+		pr.HeapAlloc = alarm.heapAlloc
+	}
+	p.app.Consume(run.Reply.RunID, pr)
+}
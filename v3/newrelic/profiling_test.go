@@ -0,0 +1,218 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestProfilesEmptyPayload(t *testing.T) {
+	ps := newProfiles(maxHarvestProfiles)
+	data, err := ps.Data("agentRunID", time.Now())
+	if data != nil || err != nil {
+		t.Error(string(data), err)
+	}
+	if cmd := ps.EndpointMethod(); cmd != profilesExportCommand {
+		t.Error(cmd)
+	}
+}
+
+func TestProfilesLimit(t *testing.T) {
+	ps := newProfiles(2)
+	for i := 0; i < 3; i++ {
+		ps.add(&profile{Type: ProfileHeap})
+	}
+	if len(ps) != 2 {
+		t.Error(len(ps))
+	}
+}
+
+func TestProfilesMergeFailedHarvest(t *testing.T) {
+	h := newHarvest(time.Now(), testHarvestCfgr)
+	(&profile{Type: ProfileCPU}).MergeIntoHarvest(h)
+
+	failed := newProfiles(maxHarvestProfiles)
+	for i := 0; i < maxHarvestProfiles; i++ {
+		failed.add(&profile{Type: ProfileHeap})
+	}
+	failed.MergeIntoHarvest(h)
+	if len(h.Profiles) != maxHarvestProfiles {
+		t.Fatal(len(h.Profiles))
+	}
+	if h.Profiles[0].Type != ProfileCPU {
+		t.Error(h.Profiles[0].Type)
+	}
+}
+
+func TestProfilesData(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	ps := newProfiles(maxHarvestProfiles)
+	ps.add(&profile{
+		Type:       ProfileCPU,
+		Trigger:    profileTriggerPeriodic,
+		Start:      start,
+		Duration:   10 * time.Second,
+		EntityGUID: "entity-guid",
+		RunID:      "run-id",
+		Data:       []byte("cpu"),
+	})
+	ps.add(&profile{
+		Type:       ProfileHeap,
+		Trigger:    profileTriggerHeapAlarm,
+		Start:      start,
+		EntityGUID: "entity-guid",
+		RunID:      "run-id",
+		AlarmLimit: 100,
+		HeapAlloc:  123,
+		Data:       []byte("heap"),
+	})
+	data, err := ps.Data("run-id", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `["run-id",[` +
+		`{"type":"cpu","trigger":"periodic","timestamp":1417136460000,"duration":10000,"entity.guid":"entity-guid","agent_run_id":"run-id","format":"pprof","data":"Y3B1"},` +
+		`{"type":"heap","trigger":"heap_alarm","timestamp":1417136460000,"duration":0,"entity.guid":"entity-guid","agent_run_id":"run-id","heap_alarm.limit":100,"heap_alarm.heap_alloc":123,"format":"pprof","data":"aGVhcA=="}` +
+		`]]`
+	if string(data) != expect {
+		t.Errorf("\n got: %s\nwant: %s", data, expect)
+	}
+}
+
+func TestProfilesHarvestReady(t *testing.T) {
+	now := time.Now()
+	h := newHarvest(now, testHarvestCfgr)
+	(&profile{Type: ProfileGoroutine}).MergeIntoHarvest(h)
+	ready := h.Ready(now.Add(61 * time.Second))
+	if len(ready.Profiles) != 1 {
+		t.Fatal(len(ready.Profiles))
+	}
+	if len(h.Profiles) != 0 {
+		t.Error(len(h.Profiles))
+	}
+	found := false
+	for _, p := range ready.Payloads(false) {
+		if p.EndpointMethod() == profilesExportCommand {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("profiles payload missing")
+	}
+}
+
+func TestNewProfilerDefaults(t *testing.T) {
+	p := newProfiler(&app{config: config{Config: defaultConfig()}})
+	if p.period != defaultProfilingPeriod || p.cpuDuration != defaultProfilingCPUDuration || p.types != ProfileAll {
+		t.Error(p.period, p.cpuDuration, p.types)
+	}
+
+	cfg := defaultConfig()
+	cfg.Profiling.Period = 4 * time.Second
+	cfg.Profiling.CPUDuration = 0
+	cfg.Profiling.Types = ProfileHeap
+	p = newProfiler(&app{config: config{Config: cfg}})
+	if p.period != 4*time.Second || p.cpuDuration != 2*time.Second || p.types != ProfileHeap {
+		t.Error(p.period, p.cpuDuration, p.types)
+	}
+}
+
+func profilingTestApp(t *testing.T) expectApp {
+	replyfn := func(reply *internal.ConnectReply) {
+		reply.RunID = "run-id"
+		reply.EntityGUID = "entity-guid"
+	}
+	cfgfn := func(cfg *Config) {
+		cfg.Profiling.Enabled = true
+		cfg.FileExport.Enabled = true
+		cfg.FileExport.Writer = io.Discard
+	}
+	return testApp(replyfn, cfgfn, t)
+}
+
+func TestProfilerCapture(t *testing.T) {
+	app := profilingTestApp(t)
+	p := newProfiler(app.Application.app)
+	p.capture(profileTriggerPeriodic, ProfileHeap|ProfileGoroutine, nil)
+
+	captured := app.Application.app.testHarvest.Profiles
+	if len(captured) != 2 {
+		t.Fatal(len(captured))
+	}
+	for i, tp := range []ProfileType{ProfileHeap, ProfileGoroutine} {
+		pr := captured[i]
+		if pr.Type != tp || pr.Trigger != profileTriggerPeriodic || pr.EntityGUID != "entity-guid" || pr.RunID != "run-id" {
+			t.Errorf("%+v", pr)
+		}
+		// runtime/pprof profiles are gzip compressed.
+		if _, err := gzip.NewReader(bytes.NewReader(pr.Data)); err != nil {
+			t.Error(tp, err)
+		}
+	}
+	app.expectNoLoggedErrors(t)
+}
+
+func TestProfilerCaptureCPU(t *testing.T) {
+	app := profilingTestApp(t)
+	p := newProfiler(app.Application.app)
+	p.cpuDuration = 10 * time.Millisecond
+	p.capture(profileTriggerPeriodic, ProfileCPU, nil)
+
+	captured := app.Application.app.testHarvest.Profiles
+	if len(captured) != 1 {
+		t.Fatal(len(captured))
+	}
+	if pr := captured[0]; pr.Type != ProfileCPU || pr.Duration < p.cpuDuration || len(pr.Data) == 0 {
+		t.Errorf("%+v", pr)
+	}
+}
+
+func TestProfilerHeapAlarm(t *testing.T) {
+	app := profilingTestApp(t)
+	p := newProfiler(app.Application.app)
+	m := &runtime.MemStats{HeapAlloc: 2048}
+	p.heapAlarm(1024, m)
+	// A second alarm must not block while the first is pending.
+	p.heapAlarm(1024, m)
+
+	alarm := <-p.alarms
+	p.capture(profileTriggerHeapAlarm, ProfileHeap, &alarm)
+	captured := app.Application.app.testHarvest.Profiles
+	if len(captured) != 1 {
+		t.Fatal(len(captured))
+	}
+	if pr := captured[0]; pr.Trigger != profileTriggerHeapAlarm || pr.AlarmLimit != 1024 || pr.HeapAlloc != 2048 {
+		t.Errorf("%+v", pr)
+	}
+
+	data, err := captured.Data("run-id", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload []interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	encoded := payload[1].([]interface{})[0].(map[string]interface{})["data"].(string)
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); err != nil {
+		t.Error(err)
+	}
+}
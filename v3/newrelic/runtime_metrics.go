@@ -0,0 +1,296 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"math"
+	"runtime/metrics"
+	"strings"
+	"sync"
+)
+
+// Names of the runtime/metrics samples read by the runtime sampler.  Samples
+// which are not supported by the running version of Go are ignored.
+const (
+	rmHeapObjects     = "/gc/heap/objects:objects"
+	rmHeapObjectBytes = "/memory/classes/heap/objects:bytes"
+	rmGCCycles        = "/gc/cycles/total:gc-cycles"
+	rmGCPauseCPU      = "/cpu/classes/gc/pause:cpu-seconds"
+	rmGCPauses        = "/sched/pauses/total/gc:seconds"
+	rmGCPausesLegacy  = "/gc/pauses:seconds"
+	rmSchedLatencies  = "/sched/latencies:seconds"
+	rmMutexWait       = "/sync/mutex/wait/total:seconds"
+	rmGOMAXPROCS      = "/sched/gomaxprocs:threads"
+	rmGOMEMLIMIT      = "/gc/gomemlimit:bytes"
+	rmCgoCalls        = "/cgo/go-to-c-calls:calls"
+
+	rmMemoryClassPrefix = "/memory/classes/"
+	rmBytesSuffix       = ":bytes"
+)
+
+var (
+	runtimeMetricNamesOnce sync.Once
+	runtimeMetricNames     []string
+)
+
+// supportedRuntimeMetricNames returns the names of the samples to read, filtered
+// to those supported by the running version of Go.
+func supportedRuntimeMetricNames() []string {
+	runtimeMetricNamesOnce.Do(func() {
+		wanted := map[string]bool{
+			rmHeapObjects:     true,
+			rmHeapObjectBytes: true,
+			rmGCCycles:        true,
+			rmGCPauseCPU:      true,
+			rmGCPauses:        true,
+			rmSchedLatencies:  true,
+			rmMutexWait:       true,
+			rmGOMAXPROCS:      true,
+			rmGOMEMLIMIT:      true,
+			rmCgoCalls:        true,
+		}
+		all := metrics.All()
+		supported := make(map[string]bool, len(all))
+		for _, d := range all {
+			supported[d.Name] = true
+		}
+		if !supported[rmGCPauses] {
+			// Go 1.21 and earlier only provide the GC pause distribution
+			// under its original name.
+			wanted[rmGCPausesLegacy] = true
+		}
+		for _, d := range all {
+			if wanted[d.Name] || strings.HasPrefix(d.Name, rmMemoryClassPrefix) {
+				runtimeMetricNames = append(runtimeMetricNames, d.Name)
+			}
+		}
+	})
+	return runtimeMetricNames
+}
+
+// runtimeSample is a snapshot of the runtime/metrics values used by the runtime
+// sampler.  Unlike runtime.ReadMemStats, reading these values does not stop the
+// world.
+type runtimeSample struct {
+	heapObjects     uint64
+	heapObjectBytes uint64
+	numGC           uint64
+	gcPauseCPU      float64
+	gcPauses        *metrics.Float64Histogram
+	schedLatencies  *metrics.Float64Histogram
+	mutexWait       float64
+	gomaxprocs      uint64
+	gomemlimit      uint64
+	cgoCalls        uint64
+	// memoryClasses contains the bytes in each /memory/classes/ metric keyed
+	// by class name, for example "heap/objects".
+	memoryClasses map[string]uint64
+}
+
+// readRuntimeSample gathers a new runtimeSample.
+func readRuntimeSample() runtimeSample {
+	names := supportedRuntimeMetricNames()
+	samples := make([]metrics.Sample, len(names))
+	for i, name := range names {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	s := runtimeSample{
+		memoryClasses: make(map[string]uint64),
+	}
+	for _, sample := range samples {
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			v := sample.Value.Uint64()
+			switch sample.Name {
+			case rmHeapObjects:
+				s.heapObjects = v
+			case rmHeapObjectBytes:
+				s.heapObjectBytes = v
+			case rmGCCycles:
+				s.numGC = v
+			case rmGOMAXPROCS:
+				s.gomaxprocs = v
+			case rmGOMEMLIMIT:
+				s.gomemlimit = v
+			case rmCgoCalls:
+				s.cgoCalls = v
+			}
+			if strings.HasPrefix(sample.Name, rmMemoryClassPrefix) {
+				class := strings.TrimSuffix(strings.TrimPrefix(sample.Name, rmMemoryClassPrefix), rmBytesSuffix)
+				s.memoryClasses[class] = v
+			}
+		case metrics.KindFloat64:
+			v := sample.Value.Float64()
+			switch sample.Name {
+			case rmGCPauseCPU:
+				s.gcPauseCPU = v
+			case rmMutexWait:
+				s.mutexWait = v
+			}
+		case metrics.KindFloat64Histogram:
+			switch sample.Name {
+			case rmGCPauses, rmGCPausesLegacy:
+				s.gcPauses = sample.Value.Float64Histogram()
+			case rmSchedLatencies:
+				s.schedLatencies = sample.Value.Float64Histogram()
+			}
+		}
+	}
+	return s
+}
+
+// histogramStats summarizes the observations added to a cumulative
+// runtime/metrics histogram between two samples.
+type histogramStats struct {
+	count      uint64
+	total      float64
+	min        float64
+	max        float64
+	sumSquares float64
+	p50        float64
+	p95        float64
+	p99        float64
+}
+
+// histogramBucketValue returns the value used to represent observations in the
+// bucket bounded by lower and upper.  Each observation is approximated by the
+// midpoint of its bucket, or the finite boundary of an unbounded bucket.
+func histogramBucketValue(lower, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1) && math.IsInf(upper, 1):
+		return 0
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	default:
+		return (lower + upper) / 2
+	}
+}
+
+// getHistogramStats combines the previous and current values of a cumulative
+// histogram into a histogramStats.  The previous histogram may be nil.
+func getHistogramStats(prev, cur *metrics.Float64Histogram) histogramStats {
+	var s histogramStats
+	if nil == cur || len(cur.Buckets) != len(cur.Counts)+1 {
+		return s
+	}
+	if nil != prev && len(prev.Counts) != len(cur.Counts) {
+		prev = nil
+	}
+	deltas := make([]uint64, len(cur.Counts))
+	for i, c := range cur.Counts {
+		if nil != prev && c >= prev.Counts[i] {
+			c -= prev.Counts[i]
+		}
+		deltas[i] = c
+		s.count += c
+	}
+	if s.count == 0 {
+		return s
+	}
+
+	p50 := uint64(math.Ceil(float64(s.count) * 0.50))
+	p95 := uint64(math.Ceil(float64(s.count) * 0.95))
+	p99 := uint64(math.Ceil(float64(s.count) * 0.99))
+
+	first := true
+	var seen uint64
+	for i, c := range deltas {
+		if c == 0 {
+			continue
+		}
+		v := histogramBucketValue(cur.Buckets[i], cur.Buckets[i+1])
+		if first || v < s.min {
+			s.min = v
+		}
+		if first || v > s.max {
+			s.max = v
+		}
+		first = false
+		s.total += v * float64(c)
+		s.sumSquares += v * v * float64(c)
+
+		before := seen
+		seen += c
+		if before < p50 && seen >= p50 {
+			s.p50 = v
+		}
+		if before < p95 && seen >= p95 {
+			s.p95 = v
+		}
+		if before < p99 && seen >= p99 {
+			s.p99 = v
+		}
+	}
+	return s
+}
+
+// runtimeStats contains runtime/metrics information for a period of time.
+type runtimeStats struct {
+	schedLatency  histogramStats
+	gcPauses      histogramStats
+	mutexWait     float64
+	gomaxprocs    uint64
+	gomemlimit    uint64
+	cgoCalls      uint64
+	memoryClasses map[string]uint64
+}
+
+// getRuntimeStats combines two runtimeSamples into a runtimeStats.
+func getRuntimeStats(prev, cur runtimeSample) *runtimeStats {
+	s := &runtimeStats{
+		schedLatency:  getHistogramStats(prev.schedLatencies, cur.schedLatencies),
+		gcPauses:      getHistogramStats(prev.gcPauses, cur.gcPauses),
+		gomaxprocs:    cur.gomaxprocs,
+		memoryClasses: cur.memoryClasses,
+	}
+	if cur.mutexWait > prev.mutexWait {
+		s.mutexWait = cur.mutexWait - prev.mutexWait
+	}
+	if cur.cgoCalls > prev.cgoCalls {
+		s.cgoCalls = cur.cgoCalls - prev.cgoCalls
+	}
+	// A GOMEMLIMIT of math.MaxInt64 means that no limit has been set.
+	if cur.gomemlimit != math.MaxInt64 {
+		s.gomemlimit = cur.gomemlimit
+	}
+	return s
+}
+
+func addHistogramMetrics(h *harvest, name string, s histogramStats) {
+	if s.count == 0 {
+		return
+	}
+	h.Metrics.add(name, "", metricData{
+		countSatisfied:  float64(s.count),
+		totalTolerated:  s.total,
+		exclusiveFailed: 0,
+		min:             s.min,
+		max:             s.max,
+		sumSquares:      s.sumSquares,
+	}, forced)
+	h.Metrics.addValueExclusive(name+"/p50", "", s.p50, 0, forced)
+	h.Metrics.addValueExclusive(name+"/p95", "", s.p95, 0, forced)
+	h.Metrics.addValueExclusive(name+"/p99", "", s.p99, 0, forced)
+}
+
+// mergeIntoHarvest adds the runtime/metrics metrics to the harvest.
+func (s *runtimeStats) mergeIntoHarvest(h *harvest) {
+	addHistogramMetrics(h, runtimeSchedLatency, s.schedLatency)
+	addHistogramMetrics(h, runtimeGCPauses, s.gcPauses)
+	h.Metrics.addValue(runtimeMutexWait, "", s.mutexWait, forced)
+	h.Metrics.addCount(runtimeCgoCalls, float64(s.cgoCalls), forced)
+	if s.gomaxprocs > 0 {
+		h.Metrics.addValueExclusive(runtimeGOMAXPROCS, "", float64(s.gomaxprocs), 0, forced)
+	}
+	if s.gomemlimit > 0 {
+		h.Metrics.addValueExclusive(runtimeGOMEMLIMIT, "", bytesToMebibytesFloat(s.gomemlimit), 0, forced)
+	}
+	for class, bts := range s.memoryClasses {
+		h.Metrics.addValueExclusive(runtimeMemoryClass+class, "", bytesToMebibytesFloat(bts), 0, forced)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestGetHistogramStats(t *testing.T) {
+	prev := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 0, 0},
+		Buckets: []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)},
+	}
+	cur := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 10, 1, 1},
+		Buckets: []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)},
+	}
+	s := getHistogramStats(prev, cur)
+	expect := histogramStats{
+		count:      10,
+		total:      8*1.5 + 2.5 + 3,
+		min:        1.5,
+		max:        3,
+		sumSquares: 8*1.5*1.5 + 2.5*2.5 + 3*3,
+		p50:        1.5,
+		p95:        3,
+		p99:        3,
+	}
+	if s != expect {
+		t.Errorf("expected %+v, got %+v", expect, s)
+	}
+}
+
+func TestGetHistogramStatsNoPrevious(t *testing.T) {
+	cur := &metrics.Float64Histogram{
+		Counts:  []uint64{4},
+		Buckets: []float64{1, 3},
+	}
+	s := getHistogramStats(nil, cur)
+	if s.count != 4 || s.total != 8 || s.p99 != 2 {
+		t.Errorf("%+v", s)
+	}
+	if s := getHistogramStats(nil, nil); s.count != 0 {
+		t.Errorf("%+v", s)
+	}
+}
+
+func TestGetRuntimeStats(t *testing.T) {
+	prev := runtimeSample{
+		mutexWait: 1.5,
+		cgoCalls:  10,
+	}
+	cur := runtimeSample{
+		mutexWait:     2,
+		cgoCalls:      15,
+		gomaxprocs:    4,
+		gomemlimit:    math.MaxInt64,
+		memoryClasses: map[string]uint64{"heap/objects": 1024 * 1024},
+	}
+	s := getRuntimeStats(prev, cur)
+	if s.mutexWait != 0.5 || s.cgoCalls != 5 || s.gomaxprocs != 4 || s.gomemlimit != 0 {
+		t.Errorf("%+v", s)
+	}
+}
+
+func TestRuntimeMetricsCreated(t *testing.T) {
+	h := newHarvest(time.Now(), testHarvestCfgr)
+	stats := &runtimeStats{
+		schedLatency: histogramStats{
+			count:      2,
+			total:      0.003,
+			min:        0.001,
+			max:        0.002,
+			sumSquares: 0.000005,
+			p50:        0.001,
+			p95:        0.002,
+			p99:        0.002,
+		},
+		mutexWait:     0.25,
+		gomaxprocs:    8,
+		gomemlimit:    512 * 1024 * 1024,
+		cgoCalls:      3,
+		memoryClasses: map[string]uint64{"heap/objects": 2 * 1024 * 1024},
+	}
+	stats.mergeIntoHarvest(h)
+	expectMetrics(t, h.Metrics, []internal.WantMetric{
+		{Name: "Go/Runtime/Scheduler/Latency", Scope: "", Forced: true, Data: []float64{2, 0.003, 0, 0.001, 0.002, 0.000005}},
+		{Name: "Go/Runtime/Scheduler/Latency/p50", Scope: "", Forced: true, Data: []float64{1, 0.001, 0, 0.001, 0.001, 0.000001}},
+		{Name: "Go/Runtime/Scheduler/Latency/p95", Scope: "", Forced: true, Data: []float64{1, 0.002, 0, 0.002, 0.002, 0.000004}},
+		{Name: "Go/Runtime/Scheduler/Latency/p99", Scope: "", Forced: true, Data: []float64{1, 0.002, 0, 0.002, 0.002, 0.000004}},
+		{Name: "Go/Runtime/Sync/Mutex/Wait", Scope: "", Forced: true, Data: []float64{1, 0.25, 0.25, 0.25, 0.25, 0.0625}},
+		{Name: "Go/Runtime/Cgo/Calls", Scope: "", Forced: true, Data: []float64{3, 0, 0, 0, 0, 0}},
+		{Name: "Go/Runtime/GOMAXPROCS", Scope: "", Forced: true, Data: []float64{1, 8, 0, 8, 8, 64}},
+		{Name: "Go/Runtime/GOMEMLIMIT", Scope: "", Forced: true, Data: []float64{1, 512, 0, 512, 512, 262144}},
+		{Name: "Go/Runtime/Memory/Classes/heap/objects", Scope: "", Forced: true, Data: []float64{1, 2, 0, 2, 2, 4}},
+	})
+}
@@ -13,7 +13,7 @@ import (
 // systemSample is a system/runtime snapshot.
 type systemSample struct {
 	when         time.Time
-	memStats     runtime.MemStats
+	runtime      runtimeSample
 	usage        sysinfo.Usage
 	numGoroutine int
 	numCPU       int
@@ -39,7 +39,7 @@ func getSystemSample(now time.Time, lg Logger) *systemSample {
 		})
 	}
 
-	runtime.ReadMemStats(&s.memStats)
+	s.runtime = readRuntimeSample()
 
 	return &s
 }
@@ -61,6 +61,7 @@ type systemStats struct {
 	deltaPauseTotal time.Duration
 	minPause        time.Duration
 	maxPause        time.Duration
+	runtime         *runtimeStats
 }
 
 // systemSamples is used as the parameter to getSystemStats to avoid mixing up the previous
@@ -78,8 +79,9 @@ func getSystemStats(ss systemSamples) systemStats {
 
 	s := systemStats{
 		numGoroutine: cur.numGoroutine,
-		allocBytes:   cur.memStats.Alloc,
-		heapObjects:  cur.memStats.HeapObjects,
+		allocBytes:   cur.runtime.heapObjectBytes,
+		heapObjects:  cur.runtime.heapObjects,
+		runtime:      getRuntimeStats(prev.runtime, cur.runtime),
 	}
 
 	// CPU Utilization
@@ -93,37 +95,46 @@ func getSystemStats(ss systemSamples) systemStats {
 		s.system.fraction = s.system.used.Seconds() / totalCPUSeconds
 	}
 
-	// GC Pause Fraction
-	deltaPauseTotalNs := cur.memStats.PauseTotalNs - prev.memStats.PauseTotalNs
-	frac := float64(deltaPauseTotalNs) / float64(elapsed.Nanoseconds())
-	s.gcPauseFraction = frac
+	// GC Pause Fraction.  The runtime reports the time spent paused by the GC
+	// as CPU time, which is the pause latency multiplied by GOMAXPROCS.  If
+	// that value is unavailable, the pause distribution is used instead.
+	var deltaPauseTotal float64
+	if cur.runtime.gcPauseCPU > prev.runtime.gcPauseCPU && cur.runtime.gomaxprocs > 0 {
+		deltaPauseTotal = (cur.runtime.gcPauseCPU - prev.runtime.gcPauseCPU) / float64(cur.runtime.gomaxprocs)
+	} else {
+		deltaPauseTotal = s.runtime.gcPauses.total
+	}
+	s.gcPauseFraction = deltaPauseTotal / elapsed.Seconds()
 
 	// GC Pauses
-	if deltaNumGC := cur.memStats.NumGC - prev.memStats.NumGC; deltaNumGC > 0 {
-		// In case more than 256 pauses have happened between samples
-		// and we are examining a subset of the pauses, we ensure that
-		// the min and max are not on the same side of the average by
-		// using the average as the starting min and max.
-		maxPauseNs := deltaPauseTotalNs / uint64(deltaNumGC)
-		minPauseNs := deltaPauseTotalNs / uint64(deltaNumGC)
-		for i := prev.memStats.NumGC + 1; i <= cur.memStats.NumGC; i++ {
-			pause := cur.memStats.PauseNs[(i+255)%256]
-			if pause > maxPauseNs {
-				maxPauseNs = pause
+	if cur.runtime.numGC > prev.runtime.numGC {
+		deltaNumGC := cur.runtime.numGC - prev.runtime.numGC
+		// The pause distribution only approximates each pause, so we
+		// ensure that the min and max are not on the same side of the
+		// average by using the average as the starting min and max.
+		avgPause := deltaPauseTotal / float64(deltaNumGC)
+		minPause, maxPause := avgPause, avgPause
+		if pauses := s.runtime.gcPauses; pauses.count > 0 {
+			if pauses.min < minPause {
+				minPause = pauses.min
 			}
-			if pause < minPauseNs {
-				minPauseNs = pause
+			if pauses.max > maxPause {
+				maxPause = pauses.max
 			}
 		}
-		s.deltaPauseTotal = time.Duration(deltaPauseTotalNs) * time.Nanosecond
-		s.deltaNumGC = deltaNumGC
-		s.minPause = time.Duration(minPauseNs) * time.Nanosecond
-		s.maxPause = time.Duration(maxPauseNs) * time.Nanosecond
+		s.deltaPauseTotal = secondsToDuration(deltaPauseTotal)
+		s.deltaNumGC = uint32(deltaNumGC)
+		s.minPause = secondsToDuration(minPause)
+		s.maxPause = secondsToDuration(maxPause)
 	}
 
 	return s
 }
 
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // MergeIntoHarvest implements Harvestable.
 func (s systemStats) MergeIntoHarvest(h *harvest) {
 	h.Metrics.addValue(heapObjectsAllocated, "", float64(s.heapObjects), forced)
@@ -144,4 +155,7 @@ func (s systemStats) MergeIntoHarvest(h *harvest) {
 			sumSquares:      s.deltaPauseTotal.Seconds() * s.deltaPauseTotal.Seconds(),
 		}, forced)
 	}
+	if nil != s.runtime {
+		s.runtime.mergeIntoHarvest(h)
+	}
 }
@@ -4,6 +4,7 @@
 package newrelic
 
 import (
+	"runtime"
 	"testing"
 	"time"
 
@@ -26,8 +27,37 @@ func TestGetSample(t *testing.T) {
 	if sample.numCPU <= 0 {
 		t.Error(sample.numCPU)
 	}
-	if sample.memStats.HeapObjects == 0 {
-		t.Error(sample.memStats.HeapObjects)
+	if sample.runtime.heapObjects == 0 {
+		t.Error(sample.runtime.heapObjects)
+	}
+	if sample.runtime.heapObjectBytes == 0 {
+		t.Error(sample.runtime.heapObjectBytes)
+	}
+	if sample.runtime.gomaxprocs == 0 {
+		t.Error(sample.runtime.gomaxprocs)
+	}
+}
+
+func TestGetSystemStatsGC(t *testing.T) {
+	now := time.Now()
+	prev := getSystemSample(now, logger.ShimLogger{})
+	runtime.GC()
+	cur := getSystemSample(now.Add(time.Second), logger.ShimLogger{})
+	stats := getSystemStats(systemSamples{Previous: prev, Current: cur})
+	if stats.deltaNumGC == 0 {
+		t.Error("no GC recorded")
+	}
+	if stats.minPause > stats.maxPause {
+		t.Error(stats.minPause, stats.maxPause)
+	}
+	if nil == stats.runtime {
+		t.Fatal("runtime stats missing")
+	}
+	if stats.runtime.gcPauses.count == 0 {
+		t.Error("no GC pauses recorded")
+	}
+	if _, ok := stats.runtime.memoryClasses["heap/objects"]; !ok {
+		t.Error(stats.runtime.memoryClasses)
 	}
 }
 
@@ -0,0 +1,167 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"math"
+)
+
+const (
+	defaultDurationDistributionsRelativeAccuracy = 0.01
+	defaultDurationDistributionsMaxBins          = 1024
+
+	// sketchMinIndexableValue is the smallest value which is counted in a
+	// bin of a sketch.  Smaller values, such as durations of less than a
+	// nanosecond in seconds, are counted as zero.
+	sketchMinIndexableValue = 1e-9
+)
+
+// sketchQuantiles are the quantiles of a sketch which are reported.
+var sketchQuantiles = []float64{0.50, 0.75, 0.90, 0.95, 0.99}
+
+// ddSketch is a mergeable quantile sketch with relative error guarantees,
+// as described in "DDSketch: A Fast and Fully-Mergeable Quantile Sketch with
+// Relative-Error Guarantees".  Positive values are counted in bins whose
+// bounds grow geometrically by gamma, so any quantile is estimated within
+// the relative accuracy of the sketch.  At most maxBins bins are kept: once
+// the limit is reached the lowest bins are collapsed together, which keeps
+// the accuracy of the high quantiles used for latency.
+type ddSketch struct {
+	gamma    float64
+	logGamma float64
+	maxBins  int
+
+	// counts[i] is the number of values in the bin with index offset+i.
+	offset    int
+	counts    []uint64
+	zeroCount uint64
+
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func newDDSketch(relativeAccuracy float64, maxBins int) *ddSketch {
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &ddSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		maxBins:  maxBins,
+	}
+}
+
+func (s *ddSketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value returns the estimate of the values in the bin with the given index.
+func (s *ddSketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+// extendRange makes room for bins with indexes low through high, collapsing
+// the lowest bins if the range exceeds maxBins, and returns the lowest index
+// kept.
+func (s *ddSketch) extendRange(low, high int) int {
+	if len(s.counts) > 0 {
+		if s.offset < low {
+			low = s.offset
+		}
+		if top := s.offset + len(s.counts) - 1; top > high {
+			high = top
+		}
+	}
+	if high-low+1 > s.maxBins {
+		low = high - s.maxBins + 1
+	}
+	if len(s.counts) > 0 && low == s.offset && high == s.offset+len(s.counts)-1 {
+		return low
+	}
+	counts := make([]uint64, high-low+1)
+	for i, n := range s.counts {
+		idx := s.offset + i
+		if idx < low {
+			idx = low
+		}
+		counts[idx-low] += n
+	}
+	s.offset = low
+	s.counts = counts
+	return low
+}
+
+func (s *ddSketch) addToBin(index int, n uint64) {
+	if low := s.extendRange(index, index); index < low {
+		index = low
+	}
+	s.counts[index-s.offset] += n
+}
+
+// add records a value.
+func (s *ddSketch) add(value float64) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+	if value < sketchMinIndexableValue {
+		s.zeroCount++
+		return
+	}
+	s.addToBin(s.index(value), 1)
+}
+
+// merge adds the values of another sketch with the same relative accuracy.
+func (s *ddSketch) merge(from *ddSketch) {
+	if from.count == 0 {
+		return
+	}
+	if s.count == 0 || from.min < s.min {
+		s.min = from.min
+	}
+	if s.count == 0 || from.max > s.max {
+		s.max = from.max
+	}
+	s.count += from.count
+	s.sum += from.sum
+	s.zeroCount += from.zeroCount
+	if len(from.counts) == 0 {
+		return
+	}
+	s.extendRange(from.offset, from.offset+len(from.counts)-1)
+	for i, n := range from.counts {
+		if n > 0 {
+			s.addToBin(from.offset+i, n)
+		}
+	}
+}
+
+func (s *ddSketch) clone() *ddSketch {
+	cpy := *s
+	cpy.counts = append([]uint64(nil), s.counts...)
+	return &cpy
+}
+
+// quantile returns the estimate of the value at quantile q, between 0 and 1.
+func (s *ddSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := q * float64(s.count-1)
+	v := 0.0
+	if n := float64(s.zeroCount); rank >= n {
+		for i, c := range s.counts {
+			n += float64(c)
+			if n > rank {
+				v = s.value(s.offset + i)
+				break
+			}
+		}
+	}
+	return math.Max(s.min, math.Min(s.max, v))
+}
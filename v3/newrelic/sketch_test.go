@@ -0,0 +1,230 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func TestDDSketchQuantiles(t *testing.T) {
+	s := newDDSketch(0.01, 2048)
+	var values []float64
+	for i := 1; i <= 10000; i++ {
+		v := float64(i) * 0.001
+		values = append(values, v)
+		s.add(v)
+	}
+	sort.Float64s(values)
+	for _, q := range []float64{0, 0.5, 0.9, 0.95, 0.99, 1} {
+		want := exactQuantile(values, q)
+		got := s.quantile(q)
+		if math.Abs(got-want) > 0.01*want {
+			t.Errorf("quantile %v: got %v want %v", q, got, want)
+		}
+	}
+	if s.count != 10000 || s.min != 0.001 || s.max != 10 {
+		t.Error(s.count, s.min, s.max)
+	}
+}
+
+func TestDDSketchZero(t *testing.T) {
+	s := newDDSketch(0.01, 2048)
+	if q := s.quantile(0.5); q != 0 {
+		t.Error(q)
+	}
+	s.add(0)
+	s.add(0)
+	s.add(1)
+	if s.zeroCount != 2 || s.quantile(0.5) != 0 || math.Abs(s.quantile(1)-1) > 0.01 {
+		t.Error(s.zeroCount, s.quantile(0.5), s.quantile(1))
+	}
+}
+
+func TestDDSketchMaxBins(t *testing.T) {
+	s := newDDSketch(0.01, 100)
+	for i := 0; i < 1000; i++ {
+		s.add(math.Pow(1.1, float64(i%300)))
+	}
+	if len(s.counts) != 100 {
+		t.Fatal(len(s.counts))
+	}
+	var total uint64
+	for _, n := range s.counts {
+		total += n
+	}
+	if total != 1000 {
+		t.Error(total)
+	}
+	// The highest quantiles keep their accuracy after collapsing.
+	if want, got := math.Pow(1.1, 299), s.quantile(1); math.Abs(got-want) > 0.01*want {
+		t.Error(got, want)
+	}
+	// Values far below the kept bins go into the lowest bin.
+	low := s.offset
+	s.add(1e-6)
+	if s.offset != low || len(s.counts) != 100 || s.counts[0] == 0 {
+		t.Error(s.offset, low, len(s.counts))
+	}
+}
+
+func TestDDSketchMerge(t *testing.T) {
+	a := newDDSketch(0.01, 2048)
+	b := newDDSketch(0.01, 2048)
+	all := newDDSketch(0.01, 2048)
+	for i := 1; i <= 1000; i++ {
+		v := float64(i)
+		if i%2 == 0 {
+			a.add(v)
+		} else {
+			b.add(v * 100)
+			v *= 100
+		}
+		all.add(v)
+	}
+	merged := a.clone()
+	merged.merge(b)
+	merged.merge(newDDSketch(0.01, 2048))
+	if merged.count != all.count || merged.sum != all.sum || merged.min != all.min || merged.max != all.max {
+		t.Error(merged, all)
+	}
+	for _, q := range []float64{0.1, 0.5, 0.95, 0.99} {
+		if merged.quantile(q) != all.quantile(q) {
+			t.Error(q, merged.quantile(q), all.quantile(q))
+		}
+	}
+	if a.count != 500 {
+		t.Error("clone modified the original sketch", a.count)
+	}
+}
+
+func TestDimensionalMetricsDistribution(t *testing.T) {
+	now := time.Now()
+	dm := newDimensionalMetrics(10, nil, now)
+	sketch := newDDSketch(0.01, 2048)
+	sketch.add(0)
+	sketch.add(1)
+	sketch.add(1)
+	dm.addDistribution("OtherTransaction/Go/job", sketch)
+
+	data, _ := dm.Data("run", now)
+	expectMetricAPIPayload(t, data)
+	_, metrics := decodeDimensionalMetrics(t, data)
+	if len(metrics) != 1+len(sketchQuantiles) {
+		t.Fatal(string(data))
+	}
+	summary, _ := json.Marshal(metrics[`summary OtherTransaction/Go/job {}`].Value)
+	if string(summary) != `{"count":3,"max":1,"min":0,"sum":2}` {
+		t.Error(string(summary))
+	}
+	for _, q := range []string{"0.5", "0.75", "0.9", "0.95", "0.99"} {
+		m, ok := metrics[`gauge OtherTransaction/Go/job.quantile {"quantile":"`+q+`"}`]
+		if v, _ := m.Value.(float64); !ok || math.Abs(v-1) > 0.01 {
+			t.Error(q, m)
+		}
+	}
+}
+
+func TestDurationDistributions(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DurationDistributions.Enabled = true
+		cfg.DimensionalMetrics.Enabled = true
+	}, t)
+	for i := 1; i <= 2; i++ {
+		txn := app.StartTransaction("job")
+		ds := &DatastoreSegment{
+			StartTime:  txn.StartSegmentNow(),
+			Product:    DatastorePostgres,
+			Collection: "users",
+			Operation:  "select",
+		}
+		time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+		ds.End()
+		es := &ExternalSegment{
+			StartTime: txn.StartSegmentNow(),
+			Host:      "example.com",
+			Procedure: "GET",
+			Library:   "http",
+		}
+		es.End()
+		txn.End()
+	}
+	app.expectNoLoggedErrors(t)
+
+	payloads := internal.HarvestTestPayloads(app.Private)
+	expectMetricAPIPayload(t, payloads[cmdDimensionalMetrics])
+	_, metrics := decodeDimensionalMetrics(t, payloads[cmdDimensionalMetrics])
+	if len(metrics) != 3*(1+len(sketchQuantiles)) {
+		t.Fatal(string(payloads[cmdDimensionalMetrics]))
+	}
+	for _, name := range []string{
+		"OtherTransaction/Go/job",
+		"Datastore/statement/Postgres/users/select",
+		"External/example.com/http/GET",
+	} {
+		m, ok := metrics["summary "+name+" {}"]
+		if !ok {
+			t.Error(name, string(payloads[cmdDimensionalMetrics]))
+			continue
+		}
+		if v, _ := m.Value.(map[string]interface{}); v["count"] != 2.0 {
+			t.Error(name, m.Value)
+		}
+	}
+	db := metrics["summary Datastore/statement/Postgres/users/select {}"].Value.(map[string]interface{})
+	p50 := metrics[`gauge Datastore/statement/Postgres/users/select.quantile {"quantile":"0.5"}`].Value.(float64)
+	if min := db["min"].(float64); min < 0.01 || math.Abs(p50-min) > 0.01*min {
+		t.Error(db, p50)
+	}
+}
+
+func TestDurationDistributionsDimensionalMetricsDisabled(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DurationDistributions.Enabled = true
+		cfg.DimensionalMetrics.Enabled = false
+	}, t)
+	txn := app.StartTransaction("job")
+	txn.StartSegment("segment").End()
+	txn.End()
+	if data := internal.HarvestTestPayloads(app.Private)[cmdDimensionalMetrics]; nil != data {
+		t.Error(string(data))
+	}
+}
+
+func TestDurationDistributionsDisabled(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("job")
+	txn.StartSegment("segment").End()
+	txn.End()
+	if data := internal.HarvestTestPayloads(app.Private)[cmdDimensionalMetrics]; nil != data {
+		t.Error(string(data))
+	}
+}
+
+func TestDurationDistributionsConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Enabled = false
+	cfg.DurationDistributions.Enabled = true
+	if err := cfg.validate(); nil != err {
+		t.Error(err)
+	}
+	cfg.DurationDistributions.RelativeAccuracy = 1
+	if err := cfg.validate(); err != errDurationDistributionsAccuracy {
+		t.Error(err)
+	}
+	cfg.DurationDistributions.RelativeAccuracy = 0.05
+	cfg.DurationDistributions.MaxBins = 0
+	if err := cfg.validate(); err != errDurationDistributionsMaxBins {
+		t.Error(err)
+	}
+}
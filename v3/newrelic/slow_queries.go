@@ -56,6 +56,9 @@ type slowQueryInstance struct {
 	PortPathOrID       string
 	DatabaseName       string
 	StackTrace         stackTrace
+	// ExplainPlan is added after the segment ends by the database/sql
+	// driver instrumentation.
+	ExplainPlan *explainPlan
 
 	txnEvent
 }
@@ -125,7 +128,11 @@ func (slow *slowQuery) merge(other slowQuery) {
 		slow.Min = other.Min
 	}
 	if other.Duration > slow.Duration {
+		plan := slow.ExplainPlan
 		slow.slowQueryInstance = other.slowQueryInstance
+		if nil == slow.ExplainPlan {
+			slow.ExplainPlan = plan
+		}
 	}
 }
 
@@ -220,6 +227,9 @@ func (slow *slowQuery) WriteJSON(buf *bytes.Buffer) {
 	if nil != slow.QueryParameters {
 		w.writerField("query_parameters", slow.QueryParameters)
 	}
+	if nil != slow.ExplainPlan {
+		w.writerField("explain_plan", slow.ExplainPlan)
+	}
 
 	sharedBetterCATIntrinsics(&slow.txnEvent, &w)
 
@@ -39,10 +39,25 @@ type spanEvent struct {
 	TracingVendors  string
 	AgentAttributes spanAttributeMap
 	UserAttributes  spanAttributeMap
+	// Links are the span links added while this span was active.  They are
+	// sent as separate SpanLink events following the span, so that they are
+	// kept or dropped along with it and do not take up room in the span
+	// event reservoir.
+	Links []*spanLink
 }
 
-// WriteJSON prepares JSON in the format expected by the collector.
+// WriteJSON prepares JSON in the format expected by the collector.  The
+// SpanLink events of the span are written after it as further elements of
+// the events array.
 func (e *spanEvent) WriteJSON(buf *bytes.Buffer) {
+	e.writeSpanJSON(buf)
+	for _, link := range e.Links {
+		buf.WriteByte(',')
+		link.WriteJSON(buf)
+	}
+}
+
+func (e *spanEvent) writeSpanJSON(buf *bytes.Buffer) {
 	w := jsonFieldsWriter{buf: buf}
 	buf.WriteByte('[')
 	buf.WriteByte('{')
@@ -104,7 +119,7 @@ func writeAttrs(buf *bytes.Buffer, attrs spanAttributeMap) {
 func (e *spanEvent) MarshalJSON() ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 256))
 
-	e.WriteJSON(buf)
+	e.writeSpanJSON(buf)
 
 	return buf.Bytes(), nil
 }
@@ -0,0 +1,121 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// spanLink represents a SpanLink event.  A span link ties the span in which
+// it was added to a span of another trace, or another part of the same trace,
+// which is not its parent.  This is how transactions that merge the work of
+// many upstream requests keep each upstream lineage.
+type spanLink struct {
+	// ID is the GUID of the span the link belongs to.
+	ID            string
+	TraceID       string
+	LinkedSpanID  string
+	LinkedTraceID string
+	Timestamp     time.Time
+}
+
+// WriteJSON prepares JSON in the format expected by the collector.  Span
+// links are sent alongside span events and use the same three hash layout.
+func (l *spanLink) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('[')
+	buf.WriteByte('{')
+	w.stringField("type", "SpanLink")
+	w.stringField("id", l.ID)
+	w.stringField("trace.id", l.TraceID)
+	w.stringField("linkedSpanId", l.LinkedSpanID)
+	w.stringField("linkedTraceId", l.LinkedTraceID)
+	w.intField("timestamp", timeToIntMillis(l.Timestamp))
+	buf.WriteByte('}')
+	buf.WriteString(",{},{}")
+	buf.WriteByte(']')
+}
+
+// MarshalJSON is used for testing.
+func (l *spanLink) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 128))
+
+	l.WriteJSON(buf)
+
+	return buf.Bytes(), nil
+}
+
+var (
+	errSpanLinkMissingIDs = errors.New("span link requires a trace ID and a span ID")
+	errSpanLinkLimit      = errors.New("span link limit reached")
+	errSpanLinkDisabled   = errors.New("span links require distributed tracing and span events")
+)
+
+// spanLinkFromHeaders reads the trace and span identifiers of the link from
+// distributed trace headers.  As with AcceptDistributedTraceHeaders, the W3C
+// trace context headers are preferred over the New Relic header.  The
+// trusted account key is not checked since the headers do not change the
+// trace of the transaction.
+func spanLinkFromHeaders(hdrs http.Header) (spanLink, error) {
+	if nil == hdrs {
+		return spanLink{}, errSpanLinkMissingIDs
+	}
+	p, err := acceptPayload(hdrs, "", &distributedTracingSupport{})
+	if nil != err {
+		return spanLink{}, err
+	}
+	if nil == p {
+		return spanLink{}, errSpanLinkMissingIDs
+	}
+	return spanLink{LinkedTraceID: p.TracedID, LinkedSpanID: p.ID}, nil
+}
+
+func (thd *thread) AddSpanLink(link spanLink) error {
+	txn := thd.txn
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished {
+		return errAlreadyEnded
+	}
+	if !txn.BetterCAT.Enabled || !txn.Config.SpanEvents.Enabled {
+		return errSpanLinkDisabled
+	}
+	if link.LinkedTraceID == "" || link.LinkedSpanID == "" {
+		return errSpanLinkMissingIDs
+	}
+	if len(txn.spanLinks) >= maxSpanLinks {
+		return errSpanLinkLimit
+	}
+	link.ID = txn.CurrentSpanIdentifier(thd.thread)
+	link.Timestamp = time.Now()
+	txn.spanLinks = append(txn.spanLinks, link)
+	return nil
+}
+
+// attachSpanLinks moves the links added during the transaction to the span
+// events they belong to.  Links whose span was not recorded are dropped.  It
+// must be called once the span events have their trace fields set.
+func (t *txnData) attachSpanLinks() {
+	if len(t.spanLinks) == 0 {
+		return
+	}
+	spans := make(map[string]*spanEvent, len(t.SpanEvents))
+	for _, evt := range t.SpanEvents {
+		spans[evt.GUID] = evt
+	}
+	for i := range t.spanLinks {
+		link := &t.spanLinks[i]
+		evt, ok := spans[link.ID]
+		if !ok {
+			continue
+		}
+		link.TraceID = evt.TraceID
+		evt.Links = append(evt.Links, link)
+	}
+	t.spanLinks = nil
+}
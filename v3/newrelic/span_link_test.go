@@ -0,0 +1,275 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+var spanLinkReplyFn = func(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+	reply.TraceIDGenerator = internal.NewTraceIDGenerator(12345)
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+func spanLinkCfgFn(cfg *Config) {
+	cfg.DistributedTracer.Enabled = true
+}
+
+func traceParentHeaders(traceparent string) http.Header {
+	hdrs := http.Header{}
+	hdrs.Set(DistributedTraceW3CTraceParentHeader, traceparent)
+	return hdrs
+}
+
+func TestAddSpanLinkFromHeaders(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	seg := txn.StartSegment("batch")
+	txn.AddSpanLink(traceParentHeaders("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	txn.AddSpanLink(traceParentHeaders("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"))
+	seg.End()
+	txn.End()
+	app.expectNoLoggedErrors(t)
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":     "Custom/batch",
+				"category": "generic",
+				"guid":     "e71870997d57214c",
+				"traceId":  "1ae969564b34a33ecd1af05fe6923d6d",
+				"parentId": "4259d74b863e2fba",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"type":          "SpanLink",
+				"id":            "e71870997d57214c",
+				"trace.id":      "1ae969564b34a33ecd1af05fe6923d6d",
+				"linkedTraceId": "0af7651916cd43dd8448eb211c80319c",
+				"linkedSpanId":  "b7ad6b7169203331",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"type":          "SpanLink",
+				"id":            "e71870997d57214c",
+				"trace.id":      "1ae969564b34a33ecd1af05fe6923d6d",
+				"linkedTraceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"linkedSpanId":  "00f067aa0ba902b7",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"guid":             "4259d74b863e2fba",
+				"nr.entryPoint":    true,
+				"traceId":          "1ae969564b34a33ecd1af05fe6923d6d",
+			},
+		},
+	})
+}
+
+func TestAddSpanLinkFromTraceMetadata(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	txn.AddSpanLinkFromTraceMetadata(TraceMetadata{
+		TraceID: "0af7651916cd43dd8448eb211c80319c",
+		SpanID:  "b7ad6b7169203331",
+	})
+	txn.End()
+	app.expectNoLoggedErrors(t)
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"guid":             "e71870997d57214c",
+				"nr.entryPoint":    true,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"type":          "SpanLink",
+				"id":            "e71870997d57214c",
+				"linkedTraceId": "0af7651916cd43dd8448eb211c80319c",
+				"linkedSpanId":  "b7ad6b7169203331",
+			},
+		},
+	})
+}
+
+func TestAddSpanLinkDoesNotChangeTrace(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	txn.AddSpanLink(traceParentHeaders("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	txn.AcceptDistributedTraceHeaders(TransportHTTP, traceParentHeaders("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	if id := txn.GetTraceMetadata().TraceID; id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error(id)
+	}
+	txn.End()
+	app.expectNoLoggedErrors(t)
+}
+
+func TestAddSpanLinkInvalid(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	txn.AddSpanLink(nil)
+	app.expectSingleLoggedError(t, "unable to add span link", map[string]interface{}{
+		"reason": errSpanLinkMissingIDs.Error(),
+	})
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+		},
+	})
+}
+
+func TestAddSpanLinkDistributedTracingDisabled(t *testing.T) {
+	app := testApp(spanLinkReplyFn, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+	}, t)
+	txn := app.StartTransaction("hello")
+	txn.AddSpanLinkFromTraceMetadata(TraceMetadata{TraceID: "trace", SpanID: "span"})
+	app.expectSingleLoggedError(t, "unable to add span link", map[string]interface{}{
+		"reason": errSpanLinkDisabled.Error(),
+	})
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantEvent{})
+}
+
+func TestAddSpanLinkAfterEnd(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	txn.End()
+	txn.AddSpanLinkFromTraceMetadata(TraceMetadata{TraceID: "trace", SpanID: "span"})
+	app.expectSingleLoggedError(t, "unable to add span link", map[string]interface{}{
+		"reason": errAlreadyEnded.Error(),
+	})
+}
+
+func TestAddSpanLinkLimit(t *testing.T) {
+	app := testApp(spanLinkReplyFn, spanLinkCfgFn, t)
+	txn := app.StartTransaction("hello")
+	for i := 0; i < maxSpanLinks+1; i++ {
+		txn.AddSpanLinkFromTraceMetadata(TraceMetadata{TraceID: "trace", SpanID: "span"})
+	}
+	app.expectSingleLoggedError(t, "unable to add span link", map[string]interface{}{
+		"reason": errSpanLinkLimit.Error(),
+	})
+	txn.End()
+	if n := app.app.testHarvest.SpanEvents.NumSaved(); n != 1 {
+		t.Error(n)
+	}
+	if evt := app.app.testHarvest.SpanEvents.events[0].jsonWriter.(*spanEvent); len(evt.Links) != maxSpanLinks {
+		t.Error(len(evt.Links))
+	}
+}
+
+func TestSpanLinksReservoir(t *testing.T) {
+	events := newSpanEvents(2)
+	spans := make([]*spanEvent, 3)
+	for i := range spans {
+		e := sampleSpanEvent
+		e.GUID = strings.Repeat(string(rune('a'+i)), 16)
+		e.Priority = priority(0.5 + float32(i)/10)
+		spans[i] = &e
+	}
+	spans[0].Links = []*spanLink{
+		{ID: spans[0].GUID, LinkedTraceID: "trace", LinkedSpanID: "low"},
+		{ID: spans[0].GUID, LinkedTraceID: "trace", LinkedSpanID: "low"},
+	}
+	spans[1].Links = []*spanLink{
+		{ID: spans[1].GUID, LinkedTraceID: "trace", LinkedSpanID: "kept"},
+	}
+
+	events.MergeSpanEvents(spans[:2])
+	if events.NumSaved() != 2 || events.NumSeen() != 2 {
+		t.Error(events.NumSaved(), events.NumSeen())
+	}
+	js, err := events.CollectorJSON("run")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(js), `"type":"SpanLink"`); n != 3 {
+		t.Error(n, string(js))
+	}
+
+	// The span with the lowest priority is dropped from the full reservoir
+	// along with its links.
+	events.MergeSpanEvents(spans[2:])
+	if events.NumSaved() != 2 || events.NumSeen() != 3 {
+		t.Error(events.NumSaved(), events.NumSeen())
+	}
+	js, err = events.CollectorJSON("run")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), `"linkedSpanId":"low"`) ||
+		strings.Count(string(js), `"linkedSpanId":"kept"`) != 1 {
+		t.Error(string(js))
+	}
+	var payload []interface{}
+	if err := json.Unmarshal(js, &payload); nil != err {
+		t.Fatal(err, string(js))
+	}
+	if n := len(payload[2].([]interface{})); n != 3 {
+		t.Error(n)
+	}
+}
+
+func TestSpanLinkWriteJSON(t *testing.T) {
+	l := &spanLink{
+		ID:            "e71870997d57214c",
+		TraceID:       "1ae969564b34a33ecd1af05fe6923d6d",
+		LinkedSpanID:  "b7ad6b7169203331",
+		LinkedTraceID: "0af7651916cd43dd8448eb211c80319c",
+		Timestamp:     time.Unix(1488393111, 0),
+	}
+	js, _ := l.MarshalJSON()
+	expect := `[{"type":"SpanLink","id":"e71870997d57214c","trace.id":"1ae969564b34a33ecd1af05fe6923d6d",` +
+		`"linkedSpanId":"b7ad6b7169203331","linkedTraceId":"0af7651916cd43dd8448eb211c80319c","timestamp":1488393111000},{},{}]`
+	if string(js) != expect {
+		t.Errorf("\n got: %s\nwant: %s", js, expect)
+	}
+}
+
+func TestOTLPSpanLinks(t *testing.T) {
+	e := sampleSpanEvent
+	e.Links = []*spanLink{{
+		LinkedSpanID:  "b7ad6b7169203331",
+		LinkedTraceID: "0af7651916cd43dd8448eb211c80319c",
+	}}
+	events := newSpanEvents(10)
+	events.MergeSpanEvents([]*spanEvent{&e})
+	r := otlpTracesRequest(events)
+	if len(r.spans) != 1 || len(r.spans[0].links) != 1 {
+		t.Fatal(r.spans)
+	}
+	js := string(r.marshalJSON())
+	if !strings.Contains(js, `"links":[{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331"}]`) {
+		t.Error(js)
+	}
+	span := protoField(t, protoField(t, protoField(t, r.marshalProto(), 1), 2), 2)
+	if id := protoField(t, protoField(t, span, 13), 2); len(id) != 8 {
+		t.Error(id)
+	}
+}
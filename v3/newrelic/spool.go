@@ -0,0 +1,276 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiskSpoolMaxBytes = 10 * 1024 * 1024
+	defaultDiskSpoolMaxAge   = 2 * time.Hour
+
+	diskSpoolFileSuffix = ".spool"
+
+	diskSpoolSpooled  = "Supportability/Go/DiskSpool/Spooled/Bytes"
+	diskSpoolReplayed = "Supportability/Go/DiskSpool/Replayed/Bytes"
+	diskSpoolDropped  = "Supportability/Go/DiskSpool/Dropped/Bytes"
+)
+
+var errDiskSpoolPayloadTooLarge = errors.New("payload larger than DiskSpool.MaxBytes")
+
+// diskSpoolHeader is the first line of a spool file.  The payload follows it
+// unchanged.
+type diskSpoolHeader struct {
+	Command string `json:"cmd"`
+	RunID   string `json:"run_id"`
+}
+
+// diskSpoolFile is a spool file in the spool directory.  Files are named
+// using the time they were written so that sorting by name sorts by age.
+type diskSpoolFile struct {
+	name    string
+	size    int64
+	written time.Time
+}
+
+// diskSpool stores harvest payloads on disk while the collector is
+// unavailable so that they can be sent after the application reconnects.
+type diskSpool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// This mutex protects the spool directory and the counters below.
+	sync.Mutex
+	seq           uint64
+	spooledBytes  int64
+	replayedBytes int64
+	droppedBytes  int64
+}
+
+func newDiskSpool(c config) (*diskSpool, error) {
+	s := &diskSpool{
+		dir:      c.DiskSpool.Directory,
+		maxBytes: c.DiskSpool.MaxBytes,
+		maxAge:   c.DiskSpool.MaxAge,
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultDiskSpoolMaxBytes
+	}
+	if s.maxAge <= 0 {
+		s.maxAge = defaultDiskSpoolMaxAge
+	}
+	if err := os.MkdirAll(s.dir, 0700); nil != err {
+		return nil, err
+	}
+	return s, nil
+}
+
+// files returns the spool files sorted from oldest to newest.  It must be
+// called with the lock held.
+func (s *diskSpool) files() ([]diskSpoolFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if nil != err {
+		return nil, err
+	}
+	var files []diskSpoolFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, diskSpoolFileSuffix) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+		if nil != err {
+			continue
+		}
+		info, err := e.Info()
+		if nil != err {
+			continue
+		}
+		files = append(files, diskSpoolFile{
+			name:    name,
+			size:    info.Size(),
+			written: time.Unix(0, nanos),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// remove deletes a spool file and counts its size as dropped if the payload
+// was not sent.  It must be called with the lock held.
+func (s *diskSpool) remove(f diskSpoolFile, dropped bool) {
+	if err := os.Remove(filepath.Join(s.dir, f.name)); nil != err {
+		return
+	}
+	if dropped {
+		s.droppedBytes += f.size
+	}
+}
+
+// write spools a payload.  Expired payloads are removed and the oldest
+// payloads are dropped to keep the spool within its size limit.
+func (s *diskSpool) write(cmd string, runID string, data []byte, now time.Time) error {
+	hdr, err := json.Marshal(diskSpoolHeader{Command: cmd, RunID: runID})
+	if nil != err {
+		return err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(hdr)+1+len(data)))
+	buf.Write(hdr)
+	buf.WriteByte('\n')
+	buf.Write(data)
+	size := int64(buf.Len())
+
+	s.Lock()
+	defer s.Unlock()
+
+	if size > s.maxBytes {
+		s.droppedBytes += size
+		return errDiskSpoolPayloadTooLarge
+	}
+
+	files, err := s.files()
+	if nil != err {
+		return err
+	}
+	var total int64
+	var kept []diskSpoolFile
+	for _, f := range files {
+		if now.Sub(f.written) > s.maxAge {
+			s.remove(f, true)
+			continue
+		}
+		total += f.size
+		kept = append(kept, f)
+	}
+	for len(kept) > 0 && total+size > s.maxBytes {
+		s.remove(kept[0], true)
+		total -= kept[0].size
+		kept = kept[1:]
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d-%s%s", now.UnixNano(), s.seq, cmd, diskSpoolFileSuffix)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); nil != err {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); nil != err {
+		os.Remove(tmp)
+		return err
+	}
+	s.spooledBytes += size
+	return nil
+}
+
+// read returns the header and payload of a spool file.
+func (s *diskSpool) read(f diskSpoolFile) (diskSpoolHeader, []byte, error) {
+	var hdr diskSpoolHeader
+	contents, err := os.ReadFile(filepath.Join(s.dir, f.name))
+	if nil != err {
+		return hdr, nil, err
+	}
+	idx := bytes.IndexByte(contents, '\n')
+	if idx < 0 {
+		return hdr, nil, fmt.Errorf("invalid spool file %s", f.name)
+	}
+	if err := json.Unmarshal(contents[:idx], &hdr); nil != err {
+		return hdr, nil, err
+	}
+	return hdr, contents[idx+1:], nil
+}
+
+// replaceRunID replaces the run ID which begins most payloads with the run
+// ID of the current connection.
+func replaceRunID(data []byte, oldRunID, newRunID string) []byte {
+	if oldRunID == "" || oldRunID == newRunID {
+		return data
+	}
+	oldPrefix, _ := json.Marshal([]string{oldRunID})
+	oldPrefix = oldPrefix[:len(oldPrefix)-1]
+	if !bytes.HasPrefix(data, oldPrefix) {
+		return data
+	}
+	newPrefix, _ := json.Marshal([]string{newRunID})
+	newPrefix = newPrefix[:len(newPrefix)-1]
+	return append(newPrefix, data[len(oldPrefix):]...)
+}
+
+// replay sends the spooled payloads from oldest to newest using send.  send
+// returns retry true if the collector is unavailable, in which case replay
+// stops and the remaining payloads stay spooled.  Payloads which are sent
+// or rejected are removed.
+func (s *diskSpool) replay(runID string, now time.Time, send func(cmd string, data []byte) (retry bool, err error)) {
+	s.Lock()
+	files, err := s.files()
+	s.Unlock()
+	if nil != err {
+		return
+	}
+
+	for _, f := range files {
+		if now.Sub(f.written) > s.maxAge {
+			s.Lock()
+			s.remove(f, true)
+			s.Unlock()
+			continue
+		}
+		hdr, data, err := s.read(f)
+		if nil != err {
+			s.Lock()
+			s.remove(f, true)
+			s.Unlock()
+			continue
+		}
+		retry, err := send(hdr.Command, replaceRunID(data, hdr.RunID, runID))
+		if retry {
+			return
+		}
+		s.Lock()
+		s.remove(f, nil != err)
+		if nil == err {
+			s.replayedBytes += f.size
+		}
+		s.Unlock()
+	}
+}
+
+// dumpSupportabilityMetrics returns the bytes spooled, replayed, and dropped
+// since the last call and resets the counts.
+func (s *diskSpool) dumpSupportabilityMetrics() map[string]float64 {
+	s.Lock()
+	defer s.Unlock()
+	metrics := map[string]float64{
+		diskSpoolSpooled:  float64(s.spooledBytes),
+		diskSpoolReplayed: float64(s.replayedBytes),
+		diskSpoolDropped:  float64(s.droppedBytes),
+	}
+	s.spooledBytes = 0
+	s.replayedBytes = 0
+	s.droppedBytes = 0
+	return metrics
+}
+
+func createDiskSpoolMetrics(s *diskSpool, metrics *metricTable) {
+	if nil == s || nil == metrics {
+		return
+	}
+	for name, val := range s.dumpSupportabilityMetrics() {
+		if val > 0 {
+			metrics.addCount(name, val, forced)
+		}
+	}
+}
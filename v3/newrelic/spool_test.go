@@ -0,0 +1,183 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func testDiskSpool(t *testing.T, maxBytes int64) *diskSpool {
+	c := config{Config: defaultConfig()}
+	c.DiskSpool.Enabled = true
+	c.DiskSpool.Directory = t.TempDir()
+	c.DiskSpool.MaxBytes = maxBytes
+	s, err := newDiskSpool(c)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return s
+}
+
+type spooledPayload struct {
+	cmd  string
+	data string
+}
+
+func replayAll(s *diskSpool, runID string, now time.Time) []spooledPayload {
+	var sent []spooledPayload
+	s.replay(runID, now, func(cmd string, data []byte) (bool, error) {
+		sent = append(sent, spooledPayload{cmd: cmd, data: string(data)})
+		return false, nil
+	})
+	return sent
+}
+
+func TestDiskSpoolReplay(t *testing.T) {
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	now := time.Now()
+	if err := s.write(cmdMetrics, "old-run", []byte(`["old-run",1,2,[]]`), now); nil != err {
+		t.Fatal(err)
+	}
+	if err := s.write(cmdTxnEvents, "old-run", []byte(`["old-run",{},[]]`), now); nil != err {
+		t.Fatal(err)
+	}
+	sent := replayAll(s, "new-run", now.Add(time.Minute))
+	if len(sent) != 2 {
+		t.Fatal(sent)
+	}
+	if sent[0].cmd != cmdMetrics || sent[0].data != `["new-run",1,2,[]]` {
+		t.Error(sent[0])
+	}
+	if sent[1].cmd != cmdTxnEvents || sent[1].data != `["new-run",{},[]]` {
+		t.Error(sent[1])
+	}
+	if sent := replayAll(s, "new-run", now.Add(time.Minute)); len(sent) != 0 {
+		t.Error("payloads replayed twice", sent)
+	}
+	m := s.dumpSupportabilityMetrics()
+	if m[diskSpoolSpooled] == 0 || m[diskSpoolSpooled] != m[diskSpoolReplayed] || m[diskSpoolDropped] != 0 {
+		t.Error(m)
+	}
+}
+
+func TestDiskSpoolReplayRetry(t *testing.T) {
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	now := time.Now()
+	s.write(cmdMetrics, "run", []byte(`["run"]`), now)
+	s.write(cmdErrorData, "run", []byte(`["run"]`), now)
+
+	calls := 0
+	s.replay("run", now, func(cmd string, data []byte) (bool, error) {
+		calls++
+		return true, errors.New("unavailable")
+	})
+	if calls != 1 {
+		t.Error(calls)
+	}
+	if sent := replayAll(s, "run", now); len(sent) != 2 {
+		t.Error(sent)
+	}
+}
+
+func TestDiskSpoolReplayRejected(t *testing.T) {
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	now := time.Now()
+	s.write(cmdMetrics, "run", []byte(`["run"]`), now)
+	s.replay("run", now, func(cmd string, data []byte) (bool, error) {
+		return false, errors.New("rejected")
+	})
+	if sent := replayAll(s, "run", now); len(sent) != 0 {
+		t.Error(sent)
+	}
+	m := s.dumpSupportabilityMetrics()
+	if m[diskSpoolDropped] == 0 || m[diskSpoolReplayed] != 0 {
+		t.Error(m)
+	}
+}
+
+func TestDiskSpoolMaxBytes(t *testing.T) {
+	s := testDiskSpool(t, 100)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := s.write(cmdMetrics, "run", []byte(`["run","payload"]`), now.Add(time.Duration(i))); nil != err {
+			t.Fatal(err)
+		}
+	}
+	files, err := s.files()
+	if nil != err {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	if len(files) == 0 || total > 100 {
+		t.Error(len(files), total)
+	}
+	if err := s.write(cmdMetrics, "run", make([]byte, 200), now); err != errDiskSpoolPayloadTooLarge {
+		t.Error(err)
+	}
+	if m := s.dumpSupportabilityMetrics(); m[diskSpoolDropped] == 0 {
+		t.Error(m)
+	}
+}
+
+func TestDiskSpoolMaxAge(t *testing.T) {
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	now := time.Now()
+	s.write(cmdMetrics, "run", []byte(`["run","expired"]`), now.Add(-3*time.Hour))
+	s.write(cmdMetrics, "run", []byte(`["run","current"]`), now)
+	sent := replayAll(s, "run", now)
+	if len(sent) != 1 || sent[0].data != `["run","current"]` {
+		t.Error(sent)
+	}
+	if m := s.dumpSupportabilityMetrics(); m[diskSpoolDropped] == 0 {
+		t.Error(m)
+	}
+}
+
+func TestDiskSpoolIgnoresOtherFiles(t *testing.T) {
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	os.WriteFile(s.dir+"/README", []byte("hello"), 0600)
+	os.WriteFile(s.dir+"/invalid.spool", []byte("hello"), 0600)
+	if files, err := s.files(); nil != err || len(files) != 0 {
+		t.Error(files, err)
+	}
+}
+
+func TestReplaceRunID(t *testing.T) {
+	testcases := []struct {
+		data, oldRunID, newRunID, expect string
+	}{
+		{data: `["old",1]`, oldRunID: "old", newRunID: "new", expect: `["new",1]`},
+		{data: `["old",1]`, oldRunID: "old", newRunID: "old", expect: `["old",1]`},
+		{data: `{"old":1}`, oldRunID: "old", newRunID: "new", expect: `{"old":1}`},
+		{data: `["older",1]`, oldRunID: "old", newRunID: "new", expect: `["older",1]`},
+		{data: `["old",1]`, oldRunID: "", newRunID: "new", expect: `["old",1]`},
+	}
+	for _, tc := range testcases {
+		if out := string(replaceRunID([]byte(tc.data), tc.oldRunID, tc.newRunID)); out != tc.expect {
+			t.Error(tc, out)
+		}
+	}
+}
+
+func TestCreateDiskSpoolMetrics(t *testing.T) {
+	createDiskSpoolMetrics(nil, newMetricTable(100, time.Now()))
+
+	s := testDiskSpool(t, defaultDiskSpoolMaxBytes)
+	s.spooledBytes = 10
+	s.droppedBytes = 5
+	mt := newMetricTable(100, time.Now())
+	createDiskSpoolMetrics(s, mt)
+	expectMetrics(t, mt, []internal.WantMetric{
+		{Name: diskSpoolSpooled, Scope: "", Forced: true, Data: []float64{10, 0, 0, 0, 0, 0}},
+		{Name: diskSpoolDropped, Scope: "", Forced: true, Data: []float64{5, 0, 0, 0, 0, 0}},
+	})
+}
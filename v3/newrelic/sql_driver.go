@@ -19,6 +19,18 @@ type SQLDriverSegmentBuilder struct {
 	BaseSegment DatastoreSegment
 	ParseQuery  func(segment *DatastoreSegment, query string)
 	ParseDSN    func(segment *DatastoreSegment, dataSourceName string)
+	// ExplainQuery enables the capture of explain plans for queries slower
+	// than Config.DatastoreTracer.SlowQuery.Threshold.  It returns the
+	// statement which explains the query, such as "EXPLAIN " + query, or ""
+	// if the query should not be explained.  The statement is run on the
+	// same connection with the same arguments as the query, once any rows
+	// returned by the query are closed.  Plans are only captured for
+	// queries whose ParameterizedQuery is set by ParseQuery, and their
+	// values are obfuscated using the rules for Postgres plans.  Only
+	// queries holding a single select, insert, update, or delete statement,
+	// as given by the Operation set by ParseQuery, are explained, and
+	// queries run within a transaction are never explained.
+	ExplainQuery func(query string) string
 }
 
 // InstrumentSQLDriver wraps a driver.Driver, adding instrumentation for exec
@@ -90,11 +102,24 @@ type wrapConnector struct {
 type wrapConn struct {
 	bld      SQLDriverSegmentBuilder
 	original driver.Conn
+	// inTx is true while a transaction begun on the connection is open.
+	// Slow queries are not explained within transactions since a failed
+	// explain would abort the transaction on some databases.
+	inTx bool
 }
 
 type wrapStmt struct {
 	bld      SQLDriverSegmentBuilder
 	original driver.Stmt
+	// conn and query are used to explain slow queries.
+	conn  *wrapConn
+	query string
+}
+
+// wrapTx records when the transaction of a connection ends.
+type wrapTx struct {
+	original driver.Tx
+	conn     *wrapConn
 }
 
 func (w *wrapDriver) Open(name string) (driver.Conn, error) {
@@ -138,13 +163,15 @@ func (w *wrapConnector) Driver() driver.Driver {
 	})
 }
 
-func prepare(original driver.Stmt, err error, bld SQLDriverSegmentBuilder, query string) (driver.Stmt, error) {
+func prepare(original driver.Stmt, err error, conn *wrapConn, bld SQLDriverSegmentBuilder, query string) (driver.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
 	return optionalMethodsStmt(&wrapStmt{
 		bld:      bld.useQuery(query),
 		original: original,
+		conn:     conn,
+		query:    query,
 	}), nil
 }
 
@@ -153,7 +180,7 @@ func (w *wrapConn) Prepare(query string) (driver.Stmt, error) {
 	if IsSecurityAgentPresent() {
 		sendSecureEventSQLPrepare(query, original)
 	}
-	return prepare(original, err, w.bld, query)
+	return prepare(original, err, w, w.bld, query)
 }
 
 // PrepareContext implements ConnPrepareContext.
@@ -162,20 +189,38 @@ func (w *wrapConn) PrepareContext(ctx context.Context, query string) (driver.Stm
 	if IsSecurityAgentPresent() {
 		sendSecureEventSQLPrepare(query, original)
 	}
-	return prepare(original, err, w.bld, query)
+	return prepare(original, err, w, w.bld, query)
 }
 
 func (w *wrapConn) Close() error {
 	return w.original.Close()
 }
 
+func (w *wrapConn) begin(original driver.Tx, err error) (driver.Tx, error) {
+	if err != nil {
+		return nil, err
+	}
+	w.inTx = true
+	return &wrapTx{original: original, conn: w}, nil
+}
+
 func (w *wrapConn) Begin() (driver.Tx, error) {
-	return w.original.Begin()
+	return w.begin(w.original.Begin())
 }
 
 // BeginTx implements ConnBeginTx.
 func (w *wrapConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	return w.original.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	return w.begin(w.original.(driver.ConnBeginTx).BeginTx(ctx, opts))
+}
+
+func (w *wrapTx) Commit() error {
+	w.conn.inTx = false
+	return w.original.Commit()
+}
+
+func (w *wrapTx) Rollback() error {
+	w.conn.inTx = false
+	return w.original.Rollback()
 }
 
 // Exec implements Execer.
@@ -209,6 +254,11 @@ func (w *wrapConn) ExecContext(ctx context.Context, query string, args []driver.
 	if err != driver.ErrSkip {
 		seg := w.bld.useQuery(query).startSegmentAt(ctx, startTime)
 		seg.End()
+		if err == nil {
+			if stmt := w.explainStatement(w.bld, &seg, query); stmt != "" {
+				explain(ctx, w.original, &seg, stmt, args)
+			}
+		}
 	}
 	return result, err
 }
@@ -253,6 +303,9 @@ func (w *wrapConn) QueryContext(ctx context.Context, query string, args []driver
 	if err != driver.ErrSkip {
 		seg := w.bld.useQuery(query).startSegmentAt(ctx, startTime)
 		seg.End()
+		if err == nil && rows != nil {
+			rows = explainOnClose(ctx, w.bld, w, &seg, query, args, rows)
+		}
 	}
 	return rows, err
 }
@@ -322,6 +375,11 @@ func (w *wrapStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (d
 	segment := w.bld.startSegment(ctx)
 	result, err = w.original.(driver.StmtExecContext).ExecContext(ctx, args)
 	segment.End()
+	if err == nil {
+		if stmt := w.conn.explainStatement(w.bld, &segment, w.query); stmt != "" {
+			explain(ctx, w.conn.original, &segment, stmt, args)
+		}
+	}
 	return result, err
 }
 
@@ -339,6 +397,9 @@ func (w *wrapStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (
 	segment := w.bld.startSegment(ctx)
 	rows, err = w.original.(driver.StmtQueryContext).QueryContext(ctx, args)
 	segment.End()
+	if err == nil && rows != nil {
+		rows = explainOnClose(ctx, w.bld, w.conn, &segment, w.query, args, rows)
+	}
 	return rows, err
 }
 
@@ -350,6 +411,9 @@ var (
 	_ interface {
 		driver.Connector
 	} = &wrapConnector{}
+	_ interface {
+		driver.Tx
+	} = &wrapTx{}
 	_ interface {
 		driver.Conn
 		driver.ConnBeginTx
@@ -0,0 +1,359 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package sqlparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenComment
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenQuotedIdent
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// dialectRules are the lexical rules of a Dialect.
+type dialectRules struct {
+	// doubleQuoteStrings is true if "..." is a string rather than a quoted
+	// identifier.
+	doubleQuoteStrings bool
+	backtickIdents     bool
+	bracketIdents      bool
+	hashComments       bool
+	slashComments      bool
+	dollarQuotes       bool
+	// escapeStrings is true if E'...' is a string in which backslashes
+	// escape, as in Postgres.
+	escapeStrings bool
+	// identStart holds the punctuation which may start an identifier, such
+	// as the "#" of MSSQL temporary tables.
+	identStart string
+}
+
+// backslashRule is how a backslash within a string is treated.
+type backslashRule int
+
+const (
+	// backslashLiteral is used when a backslash is an ordinary character,
+	// as in standard SQL.
+	backslashLiteral backslashRule = iota
+	// backslashEscape is used when a backslash escapes the character
+	// following it.
+	backslashEscape
+	// backslashUnknown is used when whether a backslash escapes is not
+	// known.  A backslash followed by the quote extends the string to the
+	// end of the query so that no part of the string is left unobfuscated.
+	backslashUnknown
+)
+
+var rules = map[Dialect]dialectRules{
+	DialectGeneric: {
+		backtickIdents: true,
+		bracketIdents:  true,
+		hashComments:   true,
+	},
+	DialectPostgres: {
+		hashComments:  true,
+		dollarQuotes:  true,
+		escapeStrings: true,
+	},
+	DialectMySQL: {
+		doubleQuoteStrings: true,
+		backtickIdents:     true,
+		hashComments:       true,
+	},
+	DialectMSSQL: {
+		doubleQuoteStrings: true,
+		bracketIdents:      true,
+		identStart:         "@#",
+	},
+	DialectSQLite: {
+		backtickIdents: true,
+		bracketIdents:  true,
+		hashComments:   true,
+	},
+	DialectSnowflake: {
+		slashComments: true,
+		dollarQuotes:  true,
+	},
+}
+
+var uuidRegex = regexp.MustCompile(`^\{?(?:[0-9a-fA-F]-*){32}\}?`)
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentStart(c byte, r dialectRules) bool {
+	return c != '$' && !isDigit(c) && isIdentChar(c) || strings.IndexByte(r.identStart, c) >= 0
+}
+
+// tokenizeQuery splits query into tokens.  It returns false if the query
+// contains an unterminated string or quoted identifier, in which case the
+// last token extends to the end of the query.
+//
+// Whether a backslash escapes a quote depends on server settings as well as
+// the dialect, such as NO_BACKSLASH_ESCAPES in MySQL and
+// standard_conforming_strings in Postgres, so a query containing a backslash
+// is tokenized both ways.  If only one of them is well formed, it is the one
+// the server can have accepted.  If both are well formed but differ, a
+// backslash followed by the quote extends the string to the end of the
+// query, so that no part of the string is left unobfuscated.
+func tokenizeQuery(query string, d Dialect) ([]token, bool) {
+	if strings.IndexByte(query, '\\') < 0 {
+		return tokenize(query, d, backslashLiteral)
+	}
+	escaped, escapedOK := tokenize(query, d, backslashEscape)
+	literal, literalOK := tokenize(query, d, backslashLiteral)
+	switch {
+	case escapedOK && literalOK && !equalTokens(escaped, literal):
+		return tokenize(query, d, backslashUnknown)
+	case escapedOK:
+		return escaped, true
+	case literalOK:
+		return literal, true
+	}
+	return escaped, false
+}
+
+func equalTokens(a, b []token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits query into tokens, treating backslashes in strings
+// according to backslashes.  It returns false if the query contains an
+// unterminated string or quoted identifier, in which case the last token
+// extends to the end of the query.
+func tokenize(query string, d Dialect, backslashes backslashRule) ([]token, bool) {
+	r := rules[d]
+	tokens := make([]token, 0, len(query)/4)
+	wellFormed := true
+	// prev is the previous token which is not whitespace or a comment.
+	var prev *token
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		kind := tokenPunct
+		end := i + 1
+		var next byte
+		if i+1 < len(query) {
+			next = query[i+1]
+		}
+
+		switch {
+		case isSpace(c):
+			kind = tokenSpace
+			for end < len(query) && isSpace(query[end]) {
+				end++
+			}
+		case c == '-' && next == '-',
+			c == '/' && next == '/' && r.slashComments,
+			c == '#' && r.hashComments && !(d == DialectPostgres && (next == '>' || next == '-')):
+			kind = tokenComment
+			end = strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i
+			}
+		case c == '/' && next == '*':
+			kind = tokenComment
+			if idx := strings.Index(query[i+2:], "*/"); idx >= 0 {
+				end = i + 2 + idx + 2
+			} else {
+				end = len(query)
+			}
+		case c == '\'' || (c == '"' && r.doubleQuoteStrings):
+			kind = tokenString
+			rule := backslashes
+			if c == '\'' && r.escapeStrings && isEscapeStringPrefix(query, i) {
+				rule = backslashEscape
+			}
+			end, wellFormed = scanString(query, i, rule, wellFormed)
+		case c == '"' || (c == '`' && r.backtickIdents):
+			kind = tokenQuotedIdent
+			end, wellFormed = scanQuotedIdent(query, i, c, wellFormed)
+		case c == '[' && r.bracketIdents:
+			kind = tokenQuotedIdent
+			end, wellFormed = scanQuotedIdent(query, i, ']', wellFormed)
+		case c == '$' && r.dollarQuotes && dollarQuoteEnd(query, i) > 0:
+			kind = tokenString
+			end = dollarQuoteEnd(query, i)
+		case c == '$' && isIdentChar(next) && !isDigit(next) && d == DialectGeneric:
+			kind = tokenIdent
+			end = scanIdent(query, i+1)
+		case (c == '{' || isDigit(c)) && uuidRegex.MatchString(query[i:]):
+			kind = tokenNumber
+			end = i + len(uuidRegex.FindString(query[i:]))
+			end = scanIdent(query, end)
+		case isDigit(c) || (c == '.' && isDigit(next) && (i == 0 || !isIdentChar(query[i-1]))):
+			kind = tokenNumber
+			end = scanNumber(query, i)
+		case c == '-' && (isDigit(next) || next == '.') && isNumberSign(prev):
+			kind = tokenNumber
+			end = scanNumber(query, i+1)
+		case isIdentStart(c, r):
+			kind = tokenIdent
+			end = scanIdent(query, i+1)
+		}
+
+		t := token{kind: kind, text: query[i:end]}
+		tokens = append(tokens, t)
+		if kind != tokenSpace && kind != tokenComment {
+			prev = &t
+		}
+		i = end
+	}
+	return tokens, wellFormed
+}
+
+// isEscapeStringPrefix returns true if the string starting at query[start]
+// is prefixed by E, as in E'...'.
+func isEscapeStringPrefix(query string, start int) bool {
+	if start < 1 || (query[start-1] != 'E' && query[start-1] != 'e') {
+		return false
+	}
+	return start < 2 || !isIdentChar(query[start-2])
+}
+
+// scanString returns the end of the string starting at query[start].
+// Quotes within the string may be escaped by doubling them, and backslashes
+// are treated according to rule.
+func scanString(query string, start int, rule backslashRule, wellFormed bool) (int, bool) {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			switch {
+			case rule == backslashEscape:
+				i++
+			case rule == backslashLiteral:
+			case i+1 < len(query) && query[i+1] == '\\':
+				i++
+			case i+1 < len(query) && query[i+1] == quote:
+				return len(query), wellFormed
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, wellFormed
+		}
+	}
+	return len(query), false
+}
+
+// scanQuotedIdent returns the end of the quoted identifier starting at
+// query[start] and ending with the closing quote.
+func scanQuotedIdent(query string, start int, closing byte, wellFormed bool) (int, bool) {
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != closing {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == closing {
+			i++
+			continue
+		}
+		return i + 1, wellFormed
+	}
+	return len(query), false
+}
+
+// dollarQuoteEnd returns the end of the Postgres dollar quoted string, such
+// as $$text$$ or $tag$text$tag$, starting at query[start], or 0 if there is
+// none.
+func dollarQuoteEnd(query string, start int) int {
+	end := start + 1
+	if end < len(query) && !isDigit(query[end]) {
+		for end < len(query) && isIdentChar(query[end]) && query[end] != '$' {
+			end++
+		}
+	}
+	if end >= len(query) || query[end] != '$' {
+		return 0
+	}
+	tag := query[start : end+1]
+	idx := strings.Index(query[end+1:], tag)
+	if idx < 0 {
+		return 0
+	}
+	return end + 1 + idx + len(tag)
+}
+
+func scanIdent(query string, i int) int {
+	for i < len(query) && isIdentChar(query[i]) {
+		i++
+	}
+	return i
+}
+
+// scanNumber returns the end of the number starting at query[start],
+// including hexadecimal numbers and exponents.
+func scanNumber(query string, start int) int {
+	i := start
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case isIdentChar(c) || c == '.':
+			i++
+		case (c == '+' || c == '-') && (query[i-1] == 'e' || query[i-1] == 'E') &&
+			!strings.ContainsAny(query[start:i], "xX"):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// isNumberSign returns true if a minus sign following prev is the sign of a
+// number rather than a subtraction.
+func isNumberSign(prev *token) bool {
+	if prev == nil {
+		return true
+	}
+	return prev.kind == tokenPunct && prev.text != ")" && prev.text != "]"
+}
+
+// obfuscate replaces the literals and comments within tokens with "?".
+func obfuscate(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		switch {
+		case t.kind == tokenString, t.kind == tokenNumber, t.kind == tokenComment,
+			t.kind == tokenIdent && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false")):
+			b.WriteByte('?')
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,400 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package sqlparse
+
+import (
+	"strings"
+)
+
+// operations are the statement types reported as the operation.  Other
+// statements are reported with an empty operation.
+var operations = map[string]bool{
+	"select":   true,
+	"delete":   true,
+	"insert":   true,
+	"update":   true,
+	"merge":    true,
+	"upsert":   true,
+	"replace":  true,
+	"call":     true,
+	"create":   true,
+	"drop":     true,
+	"truncate": true,
+	"show":     true,
+	"set":      true,
+	"exec":     true,
+	"execute":  true,
+	"alter":    true,
+	"commit":   true,
+	"rollback": true,
+}
+
+// cteOperations are the statement types which may follow the common table
+// expressions of a WITH clause.
+var cteOperations = map[string]bool{
+	"select":  true,
+	"delete":  true,
+	"insert":  true,
+	"update":  true,
+	"merge":   true,
+	"upsert":  true,
+	"replace": true,
+}
+
+// subqueryStart are the words which may begin a parenthesized subquery.
+var subqueryStart = map[string]bool{
+	"select": true,
+	"with":   true,
+	"values": true,
+	"insert": true,
+	"update": true,
+	"delete": true,
+}
+
+// modifiers are the words which may appear between the operation and the
+// table of UPDATE, INSERT, REPLACE, UPSERT, MERGE, and TRUNCATE statements
+// in the supported dialects.
+var modifiers = map[string]bool{
+	"low_priority":  true,
+	"high_priority": true,
+	"delayed":       true,
+	"ignore":        true,
+	"or":            true,
+	"replace":       true,
+	"rollback":      true,
+	"abort":         true,
+	"fail":          true,
+	"only":          true,
+	"overwrite":     true,
+	"all":           true,
+	"first":         true,
+	"into":          true,
+	"table":         true,
+	"top":           true,
+}
+
+// keywords are the words which end a list of tables, and so are never
+// table names or aliases.
+var keywords = map[string]bool{
+	"and": true, "as": true, "connect": true, "cross": true, "default": true,
+	"delete": true, "do": true, "else": true, "end": true, "except": true,
+	"fetch": true, "for": true, "force": true, "from": true, "full": true,
+	"group": true, "having": true, "ignore": true, "inner": true, "insert": true,
+	"intersect": true, "into": true, "join": true, "lateral": true, "left": true,
+	"limit": true, "lock": true, "matched": true, "merge": true, "minus": true,
+	"natural": true, "not": true, "offset": true, "on": true, "option": true,
+	"or": true, "order": true, "outer": true, "output": true, "partition": true,
+	"pivot": true, "qualify": true, "returning": true, "right": true,
+	"select": true, "set": true, "start": true, "straight_join": true,
+	"tablesample": true, "then": true, "union": true, "unpivot": true,
+	"update": true, "use": true, "using": true, "values": true, "when": true,
+	"where": true, "window": true, "with": true,
+}
+
+type parenKind int
+
+const (
+	// parenExpr is an expression or function call.  Table keywords within
+	// it, as in "substring(x FROM 2)", are ignored.
+	parenExpr parenKind = iota
+	// parenSubquery is a subquery.
+	parenSubquery
+	// parenTables is a parenthesized list of tables.
+	parenTables
+)
+
+// statementParser finds the operation and collections of a single
+// statement.
+type statementParser struct {
+	tokens      []token
+	parens      []parenKind
+	collections []string
+	ctes        []string
+}
+
+func word(t token) string {
+	if t.kind != tokenIdent {
+		return ""
+	}
+	return strings.ToLower(t.text)
+}
+
+func isPunct(t token, text string) bool {
+	return t.kind == tokenPunct && t.text == text
+}
+
+func (p *statementParser) word(i int) string {
+	if i < 0 || i >= len(p.tokens) {
+		return ""
+	}
+	return word(p.tokens[i])
+}
+
+func (p *statementParser) isPunct(i int, text string) bool {
+	return i >= 0 && i < len(p.tokens) && isPunct(p.tokens[i], text)
+}
+
+// operation returns the operation of the statement and the index of its
+// token.  The operation of a statement with common table expressions is
+// that of the statement following them.
+func (p *statementParser) operation() (string, int) {
+	i := 0
+	for p.isPunct(i, "(") {
+		i++
+	}
+	op := p.word(i)
+	if op != "with" {
+		if operations[op] {
+			return op, i
+		}
+		return "", -1
+	}
+
+	depth := 0
+	var name string
+	for i++; i < len(p.tokens); i++ {
+		t := p.tokens[i]
+		switch {
+		case isPunct(t, "("):
+			depth++
+		case isPunct(t, ")"):
+			depth--
+		case depth != 0:
+		case cteOperations[word(t)]:
+			return word(t), i
+		case word(t) == "as":
+			p.ctes = append(p.ctes, name)
+		case t.kind == tokenIdent || t.kind == tokenQuotedIdent:
+			switch word(t) {
+			case "recursive", "not", "materialized":
+			default:
+				name = unquote(t)
+			}
+		}
+	}
+	return "", -1
+}
+
+// parse returns the operation of the statement and records the
+// collections it references.
+func (p *statementParser) parse() string {
+	op, opIndex := p.operation()
+
+	for i := 0; i < len(p.tokens); {
+		t := p.tokens[i]
+		if isPunct(t, "(") {
+			kind := parenExpr
+			if subqueryStart[p.word(i+1)] {
+				kind = parenSubquery
+			}
+			p.parens = append(p.parens, kind)
+			i++
+			continue
+		}
+		if isPunct(t, ")") {
+			if len(p.parens) > 0 {
+				p.parens = p.parens[:len(p.parens)-1]
+			} else {
+				// Everything before an unmatched closing parenthesis
+				// is treated as an expression.
+				p.collections = p.collections[:0]
+			}
+			i++
+			continue
+		}
+		if len(p.parens) > 0 && p.parens[len(p.parens)-1] == parenExpr {
+			i++
+			continue
+		}
+
+		if i == opIndex {
+			switch op {
+			case "update", "insert", "replace", "upsert", "merge", "truncate":
+				j := i + 1
+				for modifiers[p.word(j)] {
+					j++
+				}
+				i = p.readTables(j, op == "update")
+				continue
+			}
+		}
+
+		switch w := word(t); {
+		case w == "from" && p.word(i-1) != "distinct",
+			w == "join",
+			w == "using" && (op == "merge" || op == "delete"):
+			i = p.readTables(i+1, true)
+		case w == "into":
+			i = p.readTables(i+1, false)
+		case w == "table" && (op == "create" || op == "alter" || op == "drop"):
+			j := i + 1
+			for w := p.word(j); w == "if" || w == "not" || w == "exists"; w = p.word(j) {
+				j++
+			}
+			i = p.readTables(j, false)
+		default:
+			i++
+		}
+	}
+	return op
+}
+
+// readTables records the tables starting at tokens[i] and returns the index
+// of the token following them.  If list is true, the tables may have
+// aliases and be separated by commas, and a name followed by a parenthesis
+// is a table function rather than a table.
+func (p *statementParser) readTables(i int, list bool) int {
+	for {
+		for ; i < len(p.tokens); i++ {
+			if p.isPunct(i, "(") {
+				if subqueryStart[p.word(i+1)] {
+					p.parens = append(p.parens, parenSubquery)
+					return i + 1
+				}
+				p.parens = append(p.parens, parenTables)
+				continue
+			}
+			if w := p.word(i); w == "only" || w == "lateral" || p.isPunct(i, "{") {
+				continue
+			}
+			break
+		}
+
+		name, next, ok := p.readName(i)
+		if !ok {
+			return i
+		}
+		if list && p.isPunct(next, "(") {
+			return next
+		}
+		p.collections = append(p.collections, name)
+		i = next
+		if !list {
+			return i
+		}
+
+		if p.word(i) == "as" {
+			i += 2
+		} else if t := p.tokenAt(i); t.kind == tokenQuotedIdent || t.kind == tokenIdent && !keywords[word(t)] {
+			i++
+		}
+		if !p.isPunct(i, ",") {
+			return i
+		}
+		i++
+	}
+}
+
+func (p *statementParser) tokenAt(i int) token {
+	if i < 0 || i >= len(p.tokens) {
+		return token{kind: tokenSpace}
+	}
+	return p.tokens[i]
+}
+
+// readName reads a possibly qualified name such as schema.table starting at
+// tokens[i], and returns its last part.
+func (p *statementParser) readName(i int) (string, int, bool) {
+	var name string
+	for {
+		t := p.tokenAt(i)
+		switch {
+		case t.kind == tokenIdent && !keywords[word(t)],
+			t.kind == tokenQuotedIdent,
+			t.kind == tokenString:
+			name = unquote(t)
+		default:
+			return "", i, false
+		}
+		i++
+		if !p.isPunct(i, ".") {
+			break
+		}
+		i++
+	}
+	for p.isPunct(i, "}") {
+		i++
+	}
+	return name, i, name != ""
+}
+
+// unquote returns the name held by an identifier, quoted identifier, or
+// string token.
+func unquote(t token) string {
+	if t.kind == tokenIdent || len(t.text) < 2 {
+		return t.text
+	}
+	quote := t.text[0]
+	content := t.text[1 : len(t.text)-1]
+	if quote == '[' {
+		content = strings.TrimSpace(content)
+		if strings.ContainsAny(content, "`\"'.") {
+			return extractTable(content)
+		}
+		return strings.ReplaceAll(content, "]]", "]")
+	}
+	return strings.ReplaceAll(content, string([]byte{quote, quote}), string(quote))
+}
+
+// parseStatements returns the operation of the first statement in tokens
+// and the collections referenced by all of them.
+func parseStatements(tokens []token) (string, []string) {
+	var op string
+	var collections []string
+	var significant []token
+	first := true
+	depth := 0
+	flush := func() {
+		if len(significant) == 0 {
+			return
+		}
+		p := statementParser{tokens: significant}
+		stmtOp := p.parse()
+		if first {
+			op = stmtOp
+			first = false
+		}
+		for _, c := range p.collections {
+			if !containsFold(p.ctes, c) && !contains(collections, c) {
+				collections = append(collections, c)
+			}
+		}
+		significant = nil
+	}
+	for _, t := range tokens {
+		switch {
+		case t.kind == tokenSpace || t.kind == tokenComment:
+			continue
+		case isPunct(t, "("):
+			depth++
+		case isPunct(t, ")"):
+			depth--
+		case isPunct(t, ";") && depth <= 0:
+			flush()
+			depth = 0
+			continue
+		}
+		significant = append(significant, t)
+	}
+	flush()
+	return op, collections
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
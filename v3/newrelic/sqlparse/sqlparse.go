@@ -10,62 +10,109 @@ import (
 	newrelic "github.com/newrelic/go-agent/v3/newrelic"
 )
 
+// Dialect determines the lexical rules used to parse a query, such as
+// whether "text" is a string or a quoted identifier.
+type Dialect int
+
+const (
+	// DialectGeneric accepts the syntax common to MySQL, Postgres, and
+	// SQLite.  It is used for products without a dialect of their own.
+	DialectGeneric Dialect = iota
+	// DialectPostgres is the dialect of Postgres.
+	DialectPostgres
+	// DialectMySQL is the dialect of MySQL and MariaDB.
+	DialectMySQL
+	// DialectMSSQL is the dialect of Microsoft SQL Server.
+	DialectMSSQL
+	// DialectSQLite is the dialect of SQLite.
+	DialectSQLite
+	// DialectSnowflake is the dialect of Snowflake.
+	DialectSnowflake
+)
+
+// DialectForProduct returns the dialect of the datastore product.
+func DialectForProduct(product newrelic.DatastoreProduct) Dialect {
+	switch product {
+	case newrelic.DatastorePostgres:
+		return DialectPostgres
+	case newrelic.DatastoreMySQL:
+		return DialectMySQL
+	case newrelic.DatastoreMSSQL:
+		return DialectMSSQL
+	case newrelic.DatastoreSQLite:
+		return DialectSQLite
+	case newrelic.DatastoreSnowflake:
+		return DialectSnowflake
+	default:
+		return DialectGeneric
+	}
+}
+
+// Statement is the result of parsing a query.
+type Statement struct {
+	// Operation is the lower case operation of the first statement in the
+	// query, such as "select", or "" if it is not recognized.  The
+	// operation of a statement beginning with common table expressions is
+	// that of the statement following them.
+	Operation string
+	// Collections holds every table referenced by the query in the order
+	// in which they appear, excluding common table expressions.
+	Collections []string
+	// ObfuscatedQuery is the query with every literal and comment
+	// replaced by "?".  It is "?" if the query is malformed, such as when
+	// it contains an unterminated string, since no part of it can then be
+	// known not to contain a literal.
+	ObfuscatedQuery string
+}
+
+// Parse parses the operation and collections of the query, and obfuscates
+// it.  Queries containing several statements separated by semicolons are
+// supported.
+func Parse(query string, dialect Dialect) Statement {
+	tokens, wellFormed := tokenizeQuery(query, dialect)
+	op, collections := parseStatements(tokens)
+	stmt := Statement{
+		Operation:       op,
+		Collections:     collections,
+		ObfuscatedQuery: "?",
+	}
+	if wellFormed {
+		stmt.ObfuscatedQuery = obfuscate(tokens)
+	}
+	return stmt
+}
+
+var extractTableRegex = regexp.MustCompile(`[\s` + "`" + `"'\(\)\{\}\[\]]*`)
+
+// extractTable removes quotes, brackets, and whitespace from the table name
+// and returns its last dot separated part.
 func extractTable(s string) string {
 	s = extractTableRegex.ReplaceAllString(s, "")
-	if idx := strings.Index(s, "."); idx > 0 {
+	if idx := strings.LastIndex(s, "."); idx >= 0 {
 		s = s[idx+1:]
 	}
 	return s
 }
 
-var (
-	basicTable        = `[^)(\]\[\}\{\s,;]+`
-	enclosedTable     = `[\[\(\{]` + `\s*` + basicTable + `\s*` + `[\]\)\}]`
-	tablePattern      = `(` + `\s+` + basicTable + `|` + `\s*` + enclosedTable + `)`
-	extractTableRegex = regexp.MustCompile(`[\s` + "`" + `"'\(\)\{\}\[\]]*`)
-	updateRegex       = regexp.MustCompile(`(?is)^update(?:\s+(?:low_priority|ignore|or|rollback|abort|replace|fail|only))*` + tablePattern)
-	sqlOperations     = map[string]*regexp.Regexp{
-		"select":   regexp.MustCompile(`(?is)^.*\sfrom` + tablePattern),
-		"delete":   regexp.MustCompile(`(?is)^.*\sfrom` + tablePattern),
-		"insert":   regexp.MustCompile(`(?is)^.*\sinto?` + tablePattern),
-		"update":   updateRegex,
-		"call":     nil,
-		"create":   nil,
-		"drop":     nil,
-		"show":     nil,
-		"set":      nil,
-		"exec":     nil,
-		"execute":  nil,
-		"alter":    nil,
-		"commit":   nil,
-		"rollback": nil,
-	}
-	firstWordRegex   = regexp.MustCompile(`^\w+`)
-	cCommentRegex    = regexp.MustCompile(`(?is)/\*.*?\*/`)
-	lineCommentRegex = regexp.MustCompile(`(?im)(?:--|#).*?$`)
-	sqlPrefixRegex   = regexp.MustCompile(`^[\s;]*`)
-)
-
 // ParseQuery parses table and operation from the SQL query string.  It is
 // a helper meant to be used when writing database/sql driver instrumentation.
 // Check out full example usage here:
 // https://github.com/newrelic/go-agent/blob/master/v3/integrations/nrmysql/nrmysql.go
 //
-// ParseQuery is designed to work with MySQL, Postgres, and SQLite drivers.
-// Ability to correctly parse queries for other SQL databases is not
-// guaranteed.
+// The query is parsed using the dialect of the segment's Product.  The
+// first table referenced by the query is used as the Collection, and the
+// obfuscated query as the ParameterizedQuery.
 func ParseQuery(segment *newrelic.DatastoreSegment, query string) {
-	s := cCommentRegex.ReplaceAllString(query, "")
-	s = lineCommentRegex.ReplaceAllString(s, "")
-	s = sqlPrefixRegex.ReplaceAllString(s, "")
-	op := strings.ToLower(firstWordRegex.FindString(s))
-	if rg, ok := sqlOperations[op]; ok {
-		segment.Operation = op
-		segment.RawQuery = query
-		if nil != rg {
-			if m := rg.FindStringSubmatch(s); len(m) > 1 {
-				segment.Collection = extractTable(m[1])
-			}
-		}
+	stmt := Parse(query, DialectForProduct(segment.Product))
+	if stmt.Operation == "" {
+		return
+	}
+	segment.Operation = stmt.Operation
+	segment.RawQuery = query
+	if len(stmt.Collections) > 0 {
+		segment.Collection = stmt.Collections[0]
+	}
+	if stmt.ObfuscatedQuery != "?" {
+		segment.ParameterizedQuery = stmt.ObfuscatedQuery
 	}
 }
@@ -4,6 +4,8 @@
 package sqlparse
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/newrelic/go-agent/v3/internal/crossagent"
@@ -196,3 +198,312 @@ func TestExtractTable(t *testing.T) {
 		}
 	}
 }
+
+type sqlObfuscationTestcase struct {
+	Name       string   `json:"name"`
+	SQL        string   `json:"sql"`
+	Obfuscated []string `json:"obfuscated"`
+	Dialects   []string `json:"dialects"`
+	Malformed  bool     `json:"malformed"`
+}
+
+var crossAgentDialects = map[string]Dialect{
+	"mysql":    DialectMySQL,
+	"postgres": DialectPostgres,
+	"mssql":    DialectMSSQL,
+	"sqlite":   DialectSQLite,
+}
+
+func TestObfuscateSQLCrossAgent(t *testing.T) {
+	var tcs []sqlObfuscationTestcase
+	err := crossagent.ReadJSON("sql_obfuscation/sql_obfuscation.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		for _, name := range tc.Dialects {
+			dialect, ok := crossAgentDialects[name]
+			if !ok {
+				continue
+			}
+			got := Parse(tc.SQL, dialect).ObfuscatedQuery
+			if tc.Malformed {
+				if got != "?" {
+					t.Errorf("%s (%s): malformed query obfuscated to %q", tc.Name, name, got)
+				}
+				continue
+			}
+			matched := false
+			for _, want := range tc.Obfuscated {
+				if got == want {
+					matched = true
+				}
+			}
+			if !matched {
+				t.Errorf("%s (%s): got=%q wanted one of %q", tc.Name, name, got, tc.Obfuscated)
+			}
+		}
+	}
+}
+
+type datastoreAPITestcase struct {
+	TestName string `json:"test_name"`
+	Input    struct {
+		Parameters struct {
+			Product    string `json:"product"`
+			Collection string `json:"collection"`
+			Operation  string `json:"operation"`
+		} `json:"parameters"`
+	} `json:"input"`
+	Expectation struct {
+		Trace struct {
+			MetricName string `json:"metric_name"`
+		} `json:"transaction_segment_and_slow_query_trace"`
+	} `json:"expectation"`
+}
+
+func TestDatastoreAPICrossAgent(t *testing.T) {
+	var tcs []datastoreAPITestcase
+	err := crossagent.ReadJSON("datastores/datastore_api.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		params := tc.Input.Parameters
+		if params.Collection == "" || params.Operation == "" {
+			continue
+		}
+		segment := newrelic.DatastoreSegment{
+			Product: newrelic.DatastoreProduct(params.Product),
+		}
+		ParseQuery(&segment, params.Operation+" INTO "+params.Collection+" VALUES (?)")
+		got := "Datastore/statement/" + string(segment.Product) + "/" +
+			segment.Collection + "/" + segment.Operation
+		if !strings.EqualFold(got, tc.Expectation.Trace.MetricName) {
+			t.Errorf("%s: got=%q wanted=%q", tc.TestName, got, tc.Expectation.Trace.MetricName)
+		}
+	}
+}
+
+func TestParseCollections(t *testing.T) {
+	for _, tc := range []struct {
+		Input       string
+		Dialect     Dialect
+		Operation   string
+		Collections []string
+	}{
+		{
+			Input:       "SELECT * FROM users u JOIN orders o ON u.id = o.user_id WHERE o.total > 5",
+			Operation:   "select",
+			Collections: []string{"users", "orders"},
+		},
+		{
+			Input:       "SELECT * FROM a, b AS bee, c WHERE a.x IN (SELECT x FROM d)",
+			Operation:   "select",
+			Collections: []string{"a", "b", "c", "d"},
+		},
+		{
+			Input:       "SELECT count(*), EXTRACT(YEAR FROM created) FROM public.events",
+			Operation:   "select",
+			Collections: []string{"events"},
+		},
+		{
+			Input:       "WITH recent AS (SELECT * FROM orders WHERE created > now()) SELECT * FROM recent JOIN users ON users.id = recent.user_id",
+			Operation:   "select",
+			Collections: []string{"orders", "users"},
+		},
+		{
+			Input:       "WITH moved AS (DELETE FROM queue RETURNING *) INSERT INTO archive SELECT * FROM moved",
+			Dialect:     DialectPostgres,
+			Operation:   "insert",
+			Collections: []string{"queue", "archive"},
+		},
+		{
+			Input:       "MERGE INTO target t USING source s ON t.id = s.id WHEN MATCHED THEN UPDATE SET t.v = s.v WHEN NOT MATCHED THEN INSERT (id, v) VALUES (s.id, s.v)",
+			Dialect:     DialectSnowflake,
+			Operation:   "merge",
+			Collections: []string{"target", "source"},
+		},
+		{
+			Input:       "MERGE [dbo].[Target] AS tgt USING [dbo].[Source] AS src ON tgt.id = src.id WHEN MATCHED THEN DELETE;",
+			Dialect:     DialectMSSQL,
+			Operation:   "merge",
+			Collections: []string{"Target", "Source"},
+		},
+		{
+			Input:       `INSERT INTO "Order Items" (id, qty) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET qty = excluded.qty`,
+			Dialect:     DialectPostgres,
+			Operation:   "insert",
+			Collections: []string{"Order Items"},
+		},
+		{
+			Input:       "INSERT INTO counters (id, n) VALUES (?, 1) ON DUPLICATE KEY UPDATE n = n + 1",
+			Dialect:     DialectMySQL,
+			Operation:   "insert",
+			Collections: []string{"counters"},
+		},
+		{
+			Input:       "UPSERT INTO kv (k, v) VALUES ('a', 1)",
+			Operation:   "upsert",
+			Collections: []string{"kv"},
+		},
+		{
+			Input:       "REPLACE INTO kv (k, v) VALUES ('a', 1)",
+			Dialect:     DialectSQLite,
+			Operation:   "replace",
+			Collections: []string{"kv"},
+		},
+		{
+			Input:       "UPDATE accounts SET balance = balance - 1 WHERE id = 2; INSERT INTO ledger VALUES (2, -1); COMMIT;",
+			Operation:   "update",
+			Collections: []string{"accounts", "ledger"},
+		},
+		{
+			Input:       "SELECT * FROM jobs WHERE state = 'new' LIMIT 1 FOR UPDATE",
+			Dialect:     DialectPostgres,
+			Operation:   "select",
+			Collections: []string{"jobs"},
+		},
+		{
+			Input:       "DELETE FROM sessions USING users WHERE sessions.user_id = users.id",
+			Dialect:     DialectPostgres,
+			Operation:   "delete",
+			Collections: []string{"sessions", "users"},
+		},
+		{
+			Input:       "CREATE TABLE IF NOT EXISTS snapshot AS SELECT * FROM live",
+			Operation:   "create",
+			Collections: []string{"snapshot", "live"},
+		},
+		{
+			Input:       "TRUNCATE TABLE staging",
+			Operation:   "truncate",
+			Collections: []string{"staging"},
+		},
+		{
+			Input:       "SELECT * FROM generate_series(1, 10)",
+			Dialect:     DialectPostgres,
+			Operation:   "select",
+			Collections: nil,
+		},
+		{
+			Input:       "SELECT * FROM #temp WHERE name = @name",
+			Dialect:     DialectMSSQL,
+			Operation:   "select",
+			Collections: []string{"#temp"},
+		},
+		{
+			Input:       "EXPLAIN SELECT * FROM users",
+			Operation:   "",
+			Collections: []string{"users"},
+		},
+	} {
+		stmt := Parse(tc.Input, tc.Dialect)
+		if stmt.Operation != tc.Operation {
+			t.Errorf("operation mismatch query='%s' wanted='%s' got='%s'",
+				tc.Input, tc.Operation, stmt.Operation)
+		}
+		if !reflect.DeepEqual(stmt.Collections, tc.Collections) {
+			t.Errorf("collections mismatch query='%s' wanted=%q got=%q",
+				tc.Input, tc.Collections, stmt.Collections)
+		}
+	}
+}
+
+func TestParseObfuscation(t *testing.T) {
+	for _, tc := range []struct {
+		Input      string
+		Dialect    Dialect
+		Obfuscated string
+	}{
+		{
+			Input:      "SELECT * FROM t WHERE a = 'x' AND b = -1.5e3 AND c = TRUE -- note",
+			Obfuscated: "SELECT * FROM t WHERE a = ? AND b = ? AND c = ? ?",
+		},
+		{
+			Input:      "SELECT a - 1 FROM t",
+			Obfuscated: "SELECT a - ? FROM t",
+		},
+		{
+			Input:      `SELECT "name" FROM t WHERE id = $1 AND body = $tag$it's$tag$`,
+			Dialect:    DialectPostgres,
+			Obfuscated: `SELECT "name" FROM t WHERE id = $? AND body = ?`,
+		},
+		{
+			Input:      `SELECT * FROM t WHERE name = "bob"`,
+			Dialect:    DialectMySQL,
+			Obfuscated: `SELECT * FROM t WHERE name = ?`,
+		},
+		{
+			Input:      "SELECT * FROM t WHERE a = 1 // trailing",
+			Dialect:    DialectSnowflake,
+			Obfuscated: "SELECT * FROM t WHERE a = ? ?",
+		},
+		{
+			Input:      "SELECT * FROM t WHERE name = 'unterminated",
+			Obfuscated: "?",
+		},
+		{
+			Input:      `SELECT 'a\'b' FROM t WHERE x='s'`,
+			Dialect:    DialectMySQL,
+			Obfuscated: "SELECT ? FROM t WHERE x=?",
+		},
+		{
+			Input:      `SELECT E'a\'b', e'c\\' FROM t WHERE x='s'`,
+			Dialect:    DialectPostgres,
+			Obfuscated: "SELECT E?, e? FROM t WHERE x=?",
+		},
+		{
+			Input:      `SELECT 'a\' FROM t WHERE x='s'`,
+			Dialect:    DialectPostgres,
+			Obfuscated: "SELECT ? FROM t WHERE x=?",
+		},
+		{
+			Input:      `SELECT 'a\' FROM t WHERE x='s'`,
+			Obfuscated: "SELECT ? FROM t WHERE x=?",
+		},
+		{
+			Input:      `SELECT 'a\' -- ' FROM t WHERE x='s'`,
+			Dialect:    DialectMySQL,
+			Obfuscated: "SELECT ?",
+		},
+	} {
+		if got := Parse(tc.Input, tc.Dialect).ObfuscatedQuery; got != tc.Obfuscated {
+			t.Errorf("obfuscation mismatch query='%s' wanted='%s' got='%s'",
+				tc.Input, tc.Obfuscated, got)
+		}
+	}
+}
+
+func TestParseQueryDialect(t *testing.T) {
+	segment := newrelic.DatastoreSegment{Product: newrelic.DatastoreMySQL}
+	ParseQuery(&segment, `SELECT * FROM users WHERE name = "bob"`)
+	if segment.Operation != "select" || segment.Collection != "users" ||
+		segment.ParameterizedQuery != "SELECT * FROM users WHERE name = ?" {
+		t.Error(segment.Operation, segment.Collection, segment.ParameterizedQuery)
+	}
+
+	segment = newrelic.DatastoreSegment{Product: newrelic.DatastorePostgres}
+	ParseQuery(&segment, `SELECT * FROM "users" WHERE name = 'bob`)
+	if segment.Operation != "select" || segment.Collection != "users" || segment.ParameterizedQuery != "" {
+		t.Error(segment.Operation, segment.Collection, segment.ParameterizedQuery)
+	}
+}
+
+func TestDialectForProduct(t *testing.T) {
+	for product, want := range map[newrelic.DatastoreProduct]Dialect{
+		newrelic.DatastorePostgres:  DialectPostgres,
+		newrelic.DatastoreMySQL:     DialectMySQL,
+		newrelic.DatastoreMSSQL:     DialectMSSQL,
+		newrelic.DatastoreSQLite:    DialectSQLite,
+		newrelic.DatastoreSnowflake: DialectSnowflake,
+		newrelic.DatastoreOracle:    DialectGeneric,
+		"":                          DialectGeneric,
+	} {
+		if got := DialectForProduct(product); got != want {
+			t.Error(product, got, want)
+		}
+	}
+}
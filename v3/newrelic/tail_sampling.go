@@ -0,0 +1,172 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	defaultTailSamplingMaxBufferedSpans = 10000
+
+	tailSamplingSeen         = "Supportability/Go/TailSampling/Seen"
+	tailSamplingKept         = "Supportability/Go/TailSampling/Kept"
+	tailSamplingDropped      = "Supportability/Go/TailSampling/Dropped"
+	tailSamplingKeptSampled  = "Supportability/Go/TailSampling/Kept/Sampled"
+	tailSamplingKeptError    = "Supportability/Go/TailSampling/Kept/Error"
+	tailSamplingKeptDuration = "Supportability/Go/TailSampling/Kept/Duration"
+	tailSamplingKeptAttr     = "Supportability/Go/TailSampling/Kept/Attribute"
+	tailSamplingSpansDropped = "Supportability/Go/TailSampling/SpanEvents/Dropped"
+)
+
+// tailSamplingReason describes why a trace was kept.
+type tailSamplingReason int
+
+const (
+	tailSamplingReasonNone tailSamplingReason = iota
+	tailSamplingReasonSampled
+	tailSamplingReasonError
+	tailSamplingReasonDuration
+	tailSamplingReasonAttribute
+)
+
+// tailSampler limits the span events held in memory by transactions which
+// have not yet ended, and records the sampling decisions made when they end.
+// It is shared by all of the transactions of an application.
+type tailSampler struct {
+	maxBuffered int64
+	buffered    int64
+
+	seen         uint64
+	dropped      uint64
+	spansDropped uint64
+	kept         [tailSamplingReasonAttribute + 1]uint64
+}
+
+func newTailSampler(c Config) *tailSampler {
+	max := c.SpanEvents.TailSampling.MaxBufferedSpans
+	if max <= 0 {
+		max = defaultTailSamplingMaxBufferedSpans
+	}
+	return &tailSampler{maxBuffered: int64(max)}
+}
+
+// reserve returns true if another span event may be held in memory.
+func (ts *tailSampler) reserve() bool {
+	if atomic.AddInt64(&ts.buffered, 1) > ts.maxBuffered {
+		atomic.AddInt64(&ts.buffered, -1)
+		atomic.AddUint64(&ts.spansDropped, 1)
+		return false
+	}
+	return true
+}
+
+// release is called when a transaction ends with the number of span events
+// it reserved.
+func (ts *tailSampler) release(n int) {
+	if n > 0 {
+		atomic.AddInt64(&ts.buffered, -int64(n))
+	}
+}
+
+// record counts the decision made for a transaction.
+func (ts *tailSampler) record(reason tailSamplingReason) {
+	atomic.AddUint64(&ts.seen, 1)
+	if reason == tailSamplingReasonNone {
+		atomic.AddUint64(&ts.dropped, 1)
+	} else {
+		atomic.AddUint64(&ts.kept[reason], 1)
+	}
+}
+
+// tailSamplingDecision returns the reason the trace of the ended transaction
+// should be kept, or tailSamplingReasonNone if it should be discarded.  It
+// must be called with the transaction locked.
+func (txn *txn) tailSamplingDecision() tailSamplingReason {
+	rules := txn.Config.SpanEvents.TailSampling
+	if txn.BetterCAT.Sampled {
+		return tailSamplingReasonSampled
+	}
+	if rules.Errors && txn.HasErrors() && txn.NoticeErrors() {
+		return tailSamplingReasonError
+	}
+	if rules.DurationThreshold > 0 && txn.Duration >= rules.DurationThreshold {
+		return tailSamplingReasonDuration
+	}
+	for name, want := range rules.Attributes {
+		if val, ok := txn.attributeValue(name); ok && (want == "" || fmt.Sprint(val) == want) {
+			return tailSamplingReasonAttribute
+		}
+	}
+	return tailSamplingReasonNone
+}
+
+// attributeValue returns the value of a user or agent attribute.
+func (txn *txn) attributeValue(name string) (interface{}, bool) {
+	if nil == txn.Attrs {
+		return nil, false
+	}
+	if a, ok := txn.Attrs.user[name]; ok {
+		return a.value, true
+	}
+	if a, ok := txn.Attrs.Agent[name]; ok {
+		if nil != a.otherVal {
+			return a.otherVal, true
+		}
+		return a.stringVal, true
+	}
+	return nil, false
+}
+
+// applyTailSampling decides whether the span events held by the ended
+// transaction are kept.  Transactions kept by a rule are marked as sampled so
+// that their events are given priority during harvest.  It must be called
+// with the transaction locked.
+func (txn *txn) applyTailSampling() {
+	ts := txn.tailSampler
+	if nil == ts || txn.tailSamplingDecided {
+		return
+	}
+	reason := txn.tailSamplingDecision()
+	if reason != tailSamplingReasonNone && !txn.BetterCAT.Sampled {
+		txn.BetterCAT.Sampled = true
+		txn.BetterCAT.Priority += 1.0
+	}
+	ts.record(reason)
+	ts.release(txn.tailSamplingBuffered)
+	txn.tailSamplingBuffered = 0
+	txn.tailSamplingDecided = true
+	if reason == tailSamplingReasonNone {
+		txn.SpanEvents = nil
+	}
+}
+
+// dumpSupportabilityMetrics returns the decisions made since the last call
+// and resets the counts.
+func (ts *tailSampler) dumpSupportabilityMetrics() map[string]float64 {
+	metrics := map[string]float64{
+		tailSamplingSeen:         float64(atomic.SwapUint64(&ts.seen, 0)),
+		tailSamplingDropped:      float64(atomic.SwapUint64(&ts.dropped, 0)),
+		tailSamplingSpansDropped: float64(atomic.SwapUint64(&ts.spansDropped, 0)),
+		tailSamplingKeptSampled:  float64(atomic.SwapUint64(&ts.kept[tailSamplingReasonSampled], 0)),
+		tailSamplingKeptError:    float64(atomic.SwapUint64(&ts.kept[tailSamplingReasonError], 0)),
+		tailSamplingKeptDuration: float64(atomic.SwapUint64(&ts.kept[tailSamplingReasonDuration], 0)),
+		tailSamplingKeptAttr:     float64(atomic.SwapUint64(&ts.kept[tailSamplingReasonAttribute], 0)),
+	}
+	metrics[tailSamplingKept] = metrics[tailSamplingKeptSampled] + metrics[tailSamplingKeptError] +
+		metrics[tailSamplingKeptDuration] + metrics[tailSamplingKeptAttr]
+	return metrics
+}
+
+func createTailSamplingMetrics(ts *tailSampler, metrics *metricTable) {
+	if nil == ts || nil == metrics {
+		return
+	}
+	for name, val := range ts.dumpSupportabilityMetrics() {
+		if val > 0 {
+			metrics.addCount(name, val, forced)
+		}
+	}
+}
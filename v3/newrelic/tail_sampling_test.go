@@ -0,0 +1,225 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func tailSamplingReplyFields(reply *internal.ConnectReply) {
+	reply.SetSampleNothing()
+	reply.TraceIDGenerator = internal.NewTraceIDGenerator(12345)
+}
+
+func tailSamplingApp(t *testing.T, cfgfn func(*Config)) expectApp {
+	return testApp(tailSamplingReplyFields, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = true
+		cfg.SpanEvents.TailSampling.Enabled = true
+		if nil != cfgfn {
+			cfgfn(cfg)
+		}
+	}, t)
+}
+
+func wantTailSampledSpans(segments ...string) []internal.WantEvent {
+	var want []internal.WantEvent
+	for _, name := range segments {
+		want = append(want, internal.WantEvent{
+			Intrinsics: map[string]interface{}{
+				"name":          name,
+				"sampled":       true,
+				"category":      "generic",
+				"priority":      internal.MatchAnything,
+				"guid":          internal.MatchAnything,
+				"transactionId": internal.MatchAnything,
+				"traceId":       internal.MatchAnything,
+				"parentId":      internal.MatchAnything,
+			},
+		})
+	}
+	return append(want, internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"name":             "OtherTransaction/Go/hello",
+			"transaction.name": "OtherTransaction/Go/hello",
+			"sampled":          true,
+			"category":         "generic",
+			"priority":         internal.MatchAnything,
+			"guid":             internal.MatchAnything,
+			"transactionId":    internal.MatchAnything,
+			"nr.entryPoint":    true,
+			"traceId":          internal.MatchAnything,
+		},
+	})
+}
+
+func expectTailSamplingMetrics(t *testing.T, app expectApp, want map[string]float64) {
+	t.Helper()
+	got := app.Application.app.tailSampler.dumpSupportabilityMetrics()
+	for name, val := range got {
+		if want[name] != val {
+			t.Errorf("metric %s: got %v, want %v", name, val, want[name])
+		}
+	}
+	if buffered := app.Application.app.tailSampler.buffered; buffered != 0 {
+		t.Errorf("span events still buffered: %d", buffered)
+	}
+}
+
+func TestTailSamplingDropped(t *testing.T) {
+	app := tailSamplingApp(t, nil)
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("mySegment").End()
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantEvent{})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/hello",
+			"sampled":  false,
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+		},
+	}})
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:    1,
+		tailSamplingDropped: 1,
+	})
+}
+
+func TestTailSamplingKeepsErrors(t *testing.T) {
+	app := tailSamplingApp(t, nil)
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("mySegment").End()
+	txn.NoticeError(errors.New("oops"))
+	txn.End()
+	want := wantTailSampledSpans("Custom/mySegment")
+	want[1].AgentAttributes = map[string]interface{}{
+		"error.class":   "*errors.errorString",
+		"error.message": "oops",
+	}
+	app.ExpectSpanEvents(t, want)
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:      1,
+		tailSamplingKept:      1,
+		tailSamplingKeptError: 1,
+	})
+}
+
+func TestTailSamplingIgnoresExpectedErrors(t *testing.T) {
+	app := tailSamplingApp(t, nil)
+	txn := app.StartTransaction("hello")
+	txn.NoticeExpectedError(errors.New("oops"))
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantEvent{})
+}
+
+func TestTailSamplingErrorsDisabled(t *testing.T) {
+	app := tailSamplingApp(t, func(cfg *Config) {
+		cfg.SpanEvents.TailSampling.Errors = false
+	})
+	txn := app.StartTransaction("hello")
+	txn.NoticeError(errors.New("oops"))
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantEvent{})
+}
+
+func TestTailSamplingKeepsSlowTransactions(t *testing.T) {
+	app := tailSamplingApp(t, func(cfg *Config) {
+		cfg.SpanEvents.TailSampling.DurationThreshold = 1
+	})
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("mySegment").End()
+	txn.End()
+	app.ExpectSpanEvents(t, wantTailSampledSpans("Custom/mySegment"))
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:         1,
+		tailSamplingKept:         1,
+		tailSamplingKeptDuration: 1,
+	})
+}
+
+func TestTailSamplingKeepsAttributeMatches(t *testing.T) {
+	app := tailSamplingApp(t, func(cfg *Config) {
+		cfg.SpanEvents.TailSampling.Attributes = map[string]string{
+			"customer": "gold",
+			"debug":    "",
+		}
+	})
+	for _, attrs := range []map[string]interface{}{
+		{"customer": "gold"},
+		{"customer": "silver"},
+		{"debug": true},
+	} {
+		txn := app.StartTransaction("hello")
+		for k, v := range attrs {
+			txn.AddAttribute(k, v)
+		}
+		txn.End()
+	}
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:     3,
+		tailSamplingKept:     2,
+		tailSamplingKeptAttr: 2,
+		tailSamplingDropped:  1,
+	})
+}
+
+func TestTailSamplingKeepsSampledTransactions(t *testing.T) {
+	app := testApp(func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	}, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = true
+		cfg.SpanEvents.TailSampling.Enabled = true
+	}, t)
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("mySegment").End()
+	txn.End()
+	app.ExpectSpanEvents(t, wantTailSampledSpans("Custom/mySegment"))
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:        1,
+		tailSamplingKept:        1,
+		tailSamplingKeptSampled: 1,
+	})
+}
+
+func TestTailSamplingBufferLimit(t *testing.T) {
+	app := tailSamplingApp(t, func(cfg *Config) {
+		cfg.SpanEvents.TailSampling.MaxBufferedSpans = 1
+		cfg.SpanEvents.TailSampling.DurationThreshold = 1
+	})
+	txn := app.StartTransaction("hello")
+	txn.StartSegment("first").End()
+	txn.StartSegment("second").End()
+	txn.End()
+	app.ExpectSpanEvents(t, wantTailSampledSpans("Custom/first"))
+	expectTailSamplingMetrics(t, app, map[string]float64{
+		tailSamplingSeen:         1,
+		tailSamplingKept:         1,
+		tailSamplingKeptDuration: 1,
+		tailSamplingSpansDropped: 1,
+	})
+}
+
+func TestConfigSpanEventsTailSampling(t *testing.T) {
+	cfg := defaultConfig()
+	configFromEnvironment(func(s string) string {
+		if s == "NEW_RELIC_SPAN_EVENTS_TAIL_SAMPLING_ENABLED" {
+			return "true"
+		}
+		return ""
+	})(&cfg)
+	if nil != cfg.Error || !cfg.SpanEvents.TailSampling.Enabled {
+		t.Error(cfg.Error, cfg.SpanEvents.TailSampling.Enabled)
+	}
+
+	cfg = defaultConfig()
+	ConfigSpanEventsTailSampling(time.Second)(&cfg)
+	if ts := cfg.SpanEvents.TailSampling; !ts.Enabled || ts.DurationThreshold != time.Second || !ts.Errors || ts.MaxBufferedSpans != defaultTailSamplingMaxBufferedSpans {
+		t.Error(ts)
+	}
+}
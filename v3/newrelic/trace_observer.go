@@ -398,6 +398,18 @@ func errShouldBackoff(err error) bool {
 func (to *gRPCtraceObserver) sendSpan(spanClient v1.IngestService_RecordSpanClient, msg *spanEvent) error {
 	span := transformEvent(msg)
 	to.supportability.increment <- observerSent
+	if err := to.send(spanClient, span); err != nil {
+		return err
+	}
+	for _, link := range msg.Links {
+		if err := to.send(spanClient, transformSpanLink(link)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (to *gRPCtraceObserver) send(spanClient v1.IngestService_RecordSpanClient, span *v1.Span) error {
 	if err := spanClient.Send(span); err != nil {
 		to.log.Error("trace observer send error", map[string]interface{}{
 			"err": err.Error(),
@@ -528,6 +540,26 @@ func transformEvent(e *spanEvent) *v1.Span {
 	return span
 }
 
+// transformSpanLink converts a span link into the SpanLink event sent to the
+// trace observer after the span it belongs to.
+func transformSpanLink(l *spanLink) *v1.Span {
+	span := &v1.Span{
+		TraceId:         l.TraceID,
+		Intrinsics:      make(map[string]*v1.AttributeValue),
+		UserAttributes:  make(map[string]*v1.AttributeValue),
+		AgentAttributes: make(map[string]*v1.AttributeValue),
+	}
+
+	span.Intrinsics["type"] = obsvString("SpanLink")
+	span.Intrinsics["id"] = obsvString(l.ID)
+	span.Intrinsics["trace.id"] = obsvString(l.TraceID)
+	span.Intrinsics["linkedSpanId"] = obsvString(l.LinkedSpanID)
+	span.Intrinsics["linkedTraceId"] = obsvString(l.LinkedTraceID)
+	span.Intrinsics["timestamp"] = obsvInt(timeToIntMillis(l.Timestamp))
+
+	return span
+}
+
 func copyAttrs(source spanAttributeMap, dest map[string]*v1.AttributeValue) {
 	for key, val := range source {
 		switch v := val.(type) {
@@ -607,13 +639,21 @@ func expectObserverEvents(v internal.Validator, events *analyticsEvents, expect
 		if nil != e.Intrinsics {
 			e.Intrinsics = mergeAttributes(extraAttributes, e.Intrinsics)
 		}
-		event := events.events[i].jsonWriter.(*spanEvent)
-		expectObserverEvent(v, event, e)
+		var span *v1.Span
+		switch event := events.events[i].jsonWriter.(type) {
+		case *spanEvent:
+			span = transformEvent(event)
+		case *spanLink:
+			span = transformSpanLink(event)
+		default:
+			v.Error("unexpected event type in trace observer", event)
+			continue
+		}
+		expectObserverEvent(v, span, e)
 	}
 }
 
-func expectObserverEvent(v internal.Validator, e *spanEvent, expect internal.WantEvent) {
-	span := transformEvent(e)
+func expectObserverEvent(v internal.Validator, span *v1.Span, expect internal.WantEvent) {
 	if nil != expect.Intrinsics {
 		expectObserverAttributes(v, span.Intrinsics, expect.Intrinsics)
 	}
@@ -107,10 +107,41 @@ type txnData struct {
 	SpanEvents              []*spanEvent
 	logs                    logEventHeap
 
+	// spanLinks holds the links added to the transaction until they are
+	// attached to their span events when the transaction ends.
+	spanLinks []spanLink
+
+	// tailSampler is non-nil when the span events are held until the
+	// transaction ends to decide whether they are kept.
+	tailSampler          *tailSampler
+	tailSamplingBuffered int
+	tailSamplingDecided  bool
+
 	customSegments    map[string]*metricData
 	datastoreSegments map[datastoreMetricKey]*metricData
 	externalSegments  map[externalMetricKey]*metricData
 	messageSegments   map[internal.MessageMetricKey]*metricData
+
+	// newDistribution is non-nil when Config.DurationDistributions is
+	// enabled, in which case the durations of datastore and external
+	// segments are recorded in segmentDistributions by metric name.
+	newDistribution      func() *ddSketch
+	segmentDistributions map[string]*ddSketch
+}
+
+func (t *txnData) recordSegmentDistribution(name string, duration time.Duration) {
+	if nil == t.newDistribution {
+		return
+	}
+	if nil == t.segmentDistributions {
+		t.segmentDistributions = make(map[string]*ddSketch)
+	}
+	s, ok := t.segmentDistributions[name]
+	if !ok {
+		s = t.newDistribution()
+		t.segmentDistributions[name] = s
+	}
+	s.add(duration.Seconds())
 }
 
 func (t *txnData) saveTraceSegment(end segmentEnd, name string, attrs spanAttributeMap, externalGUID string) {
@@ -426,6 +457,12 @@ func (t *txnData) CurrentSpanIdentifier(thread *tracingThread) string {
 func (t *txnData) saveSpanEvent(e *spanEvent) {
 	e.AgentAttributes = t.Attrs.filterSpanAttributes(e.AgentAttributes, destSpan)
 	if len(t.SpanEvents) < internal.MaxSpanEvents {
+		if nil != t.tailSampler && !t.tailSamplingDecided {
+			if !t.tailSampler.reserve() {
+				return
+			}
+			t.tailSamplingBuffered++
+		}
 		t.SpanEvents = append(t.SpanEvents, e)
 	}
 }
@@ -615,6 +652,7 @@ func endExternalSegment(p endExternalParams) error {
 		*cpy = m
 		t.externalSegments[key] = cpy
 	}
+	t.recordSegmentDistribution(key.scopedMetric(), end.duration)
 
 	if t.TxnTrace.considerNode(end) {
 		attributes := end.agentAttributes.copy()
@@ -806,6 +844,7 @@ func endDatastoreSegment(p endDatastoreParams) error {
 	}
 
 	scopedMetric := datastoreScopedMetric(key)
+	p.TxnData.recordSegmentDistribution(scopedMetric, end.duration)
 	// errors in QueryParameters must not stop the recording of the segment
 	queryParams, err := vetQueryParameters(p.QueryParameters)
 
@@ -918,3 +957,17 @@ func mergeBreakdownMetrics(t *txnData, metrics *metricTable) {
 		metrics.add(metric, "", *data, unforced)
 	}
 }
+
+// mergeDurationDistributions merges the duration sketches of the transaction
+// and its datastore and external segments into the harvest.
+func mergeDurationDistributions(t *txnData, dm *dimensionalMetrics) {
+	if nil == t.newDistribution {
+		return
+	}
+	txnDistribution := t.newDistribution()
+	txnDistribution.add(t.Duration.Seconds())
+	dm.addDistribution(t.FinalName, txnDistribution)
+	for name, s := range t.segmentDistributions {
+		dm.addDistribution(name, s)
+	}
+}
@@ -410,6 +410,43 @@ func (txn *Transaction) AcceptDistributedTraceHeadersFromJSON(t TransportType, j
 	return nil
 }
 
+// AddSpanLink links the currently active span to the span described by the
+// distributed trace headers of another request.  Unlike
+// AcceptDistributedTraceHeaders, AddSpanLink does not change the trace or the
+// parent of the transaction and may be called many times, which makes it
+// useful for batch consumers and aggregators that merge the work of many
+// upstream requests:
+//
+//	for _, msg := range batch {
+//		txn.AddSpanLink(msg.Headers)
+//	}
+//
+// Span links are recorded as SpanLink events which are sent with the span
+// events.  They require distributed tracing and span events to be enabled,
+// and are only sent if the transaction is sampled.  Up to 100 span links are
+// recorded for each transaction.
+func (txn *Transaction) AddSpanLink(hdrs http.Header) {
+	if nilTransaction(txn) {
+		return
+	}
+	link, err := spanLinkFromHeaders(hdrs)
+	if nil == err {
+		err = txn.thread.AddSpanLink(link)
+	}
+	txn.thread.logAPIError(err, "add span link", nil)
+}
+
+// AddSpanLinkFromTraceMetadata works just like AddSpanLink, except that the
+// linked span is identified by TraceMetadata, such as the value returned by
+// GetTraceMetadata in another transaction.
+func (txn *Transaction) AddSpanLinkFromTraceMetadata(md TraceMetadata) {
+	if nilTransaction(txn) {
+		return
+	}
+	err := txn.thread.AddSpanLink(spanLink{LinkedTraceID: md.TraceID, LinkedSpanID: md.SpanID})
+	txn.thread.logAPIError(err, "add span link", nil)
+}
+
 // DistributedTraceHeadersFromJSON takes a set of distributed trace headers as a JSON-encoded string
 // and emits a http.Header value suitable for passing on to the
 // txn.AcceptDistributedTraceHeaders() function.